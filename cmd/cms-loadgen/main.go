@@ -0,0 +1,404 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command cms-loadgen generates load for a running configmapsecret-controller
+// and reports how long it takes to converge.
+//
+// It creates ConfigMapSecrets that share a set of source ConfigMaps, mutates
+// the sources at a fixed rate, and measures the latency between each mutation
+// and the time at which each dependent ConfigMapSecret's status reports having
+// read it. Status timestamps have a resolution of one second.
+//
+// Every object is labeled and named with a unique run ID, so multiple instances
+// may run concurrently in the same namespace without interfering.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+// runLabel is the label containing the run ID of generated objects.
+const runLabel = "loadgen.secrets.mz.com/run"
+
+type options struct {
+	namespace        string
+	runID            string
+	configMapSecrets int
+	sources          int
+	refs             int
+	rate             float64
+	duration         time.Duration
+	timeout          time.Duration
+	pollInterval     time.Duration
+	cleanup          bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.namespace, "namespace", "default", "Namespace in which objects are created.")
+	flag.StringVar(&opts.runID, "run-id", "", "Unique ID of the run. Defaults to a random ID.")
+	flag.IntVar(&opts.configMapSecrets, "configmapsecrets", 100, "Number of ConfigMapSecrets to create.")
+	flag.IntVar(&opts.sources, "sources", 10, "Number of source ConfigMaps shared by the ConfigMapSecrets.")
+	flag.IntVar(&opts.refs, "refs", 3, "Number of sources referenced by each ConfigMapSecret.")
+	flag.Float64Var(&opts.rate, "rate", 1, "Number of source mutations per second.")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "Duration for which sources are mutated.")
+	flag.DurationVar(&opts.timeout, "timeout", time.Minute,
+		"Maximum time to wait for convergence before and after mutating sources.")
+	flag.DurationVar(&opts.pollInterval, "poll-interval", time.Second, "Interval at which statuses are polled.")
+	flag.BoolVar(&opts.cleanup, "cleanup", true, "Delete generated objects on exit.")
+	flag.Parse()
+
+	if err := run(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "cms-loadgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options) error {
+	if opts.configMapSecrets <= 0 || opts.sources <= 0 || opts.refs <= 0 || opts.rate <= 0 {
+		return fmt.Errorf("configmapsecrets, sources, refs, and rate must be positive")
+	}
+	if opts.refs > opts.sources {
+		return fmt.Errorf("refs (%d) must not exceed sources (%d)", opts.refs, opts.sources)
+	}
+	if opts.runID == "" {
+		opts.runID = strconv.FormatInt(rand.New(rand.NewSource(time.Now().UnixNano())).Int63(), 36)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	g := newGenerator(c, opts)
+	if opts.cleanup {
+		defer func() {
+			if err := g.cleanup(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "cms-loadgen: cleanup: %v\n", err)
+			}
+		}()
+	}
+	fmt.Printf("run %s: creating %d ConfigMapSecrets with %d sources\n", opts.runID, opts.configMapSecrets, opts.sources)
+	if err := g.create(ctx); err != nil {
+		return err
+	}
+	if err := g.await(ctx); err != nil {
+		return fmt.Errorf("initial convergence: %w", err)
+	}
+	g.reset()
+
+	fmt.Printf("run %s: mutating sources at %g/s for %v\n", opts.runID, opts.rate, opts.duration)
+	if err := g.mutate(ctx); err != nil {
+		return err
+	}
+	if err := g.await(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "cms-loadgen: %v\n", err)
+	}
+	g.report()
+	return nil
+}
+
+// A mutation is a write to a source.
+type mutation struct {
+	resourceVersion string
+	time            time.Time
+}
+
+type observationKey struct {
+	configMapSecret string
+	source          string
+}
+
+type generator struct {
+	client client.Client
+	opts   options
+
+	mu        sync.Mutex
+	mutations map[string][]mutation  // source -> mutations in order
+	observed  map[observationKey]int // number of mutations observed
+	latencies []time.Duration
+}
+
+func newGenerator(c client.Client, opts options) *generator {
+	return &generator{
+		client:    c,
+		opts:      opts,
+		mutations: make(map[string][]mutation),
+		observed:  make(map[observationKey]int),
+	}
+}
+
+func (g *generator) labels() map[string]string {
+	return map[string]string{runLabel: g.opts.runID}
+}
+
+func (g *generator) sourceName(i int) string {
+	return fmt.Sprintf("loadgen-%s-src-%d", g.opts.runID, i)
+}
+
+func (g *generator) configMapSecretName(i int) string {
+	return fmt.Sprintf("loadgen-%s-%d", g.opts.runID, i)
+}
+
+// sourcesOf returns the indexes of the sources referenced by the i'th ConfigMapSecret.
+func (g *generator) sourcesOf(i int) []int {
+	idxs := make([]int, g.opts.refs)
+	for j := range idxs {
+		idxs[j] = (i + j) % g.opts.sources
+	}
+	return idxs
+}
+
+func (g *generator) create(ctx context.Context) error {
+	for i := 0; i < g.opts.sources; i++ {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: g.opts.namespace,
+				Name:      g.sourceName(i),
+				Labels:    g.labels(),
+			},
+			Data: map[string]string{"value": "0"},
+		}
+		if err := g.client.Create(ctx, cm); err != nil {
+			return err
+		}
+		g.record(cm)
+	}
+	for i := 0; i < g.opts.configMapSecrets; i++ {
+		cms := &v1alpha1.ConfigMapSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: g.opts.namespace,
+				Name:      g.configMapSecretName(i),
+				Labels:    g.labels(),
+			},
+			Spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{
+					Metadata: v1alpha1.EmbeddedObjectMeta{
+						Name:   g.configMapSecretName(i),
+						Labels: g.labels(),
+					},
+					Data: make(map[string]string),
+				},
+			},
+		}
+		for _, j := range g.sourcesOf(i) {
+			name := "SRC_" + strconv.Itoa(j)
+			cms.Spec.Vars = append(cms.Spec.Vars, v1alpha1.Var{
+				Name: name,
+				ConfigMapValue: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: g.sourceName(j)},
+					Key:                  "value",
+				},
+			})
+			cms.Spec.Template.Data[name] = "$(" + name + ")"
+		}
+		if err := g.client.Create(ctx, cms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutate updates random sources at the configured rate for the configured duration.
+func (g *generator) mutate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, g.opts.duration)
+	defer cancel()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / g.opts.rate))
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		cm := &corev1.ConfigMap{}
+		key := client.ObjectKey{Namespace: g.opts.namespace, Name: g.sourceName(rnd.Intn(g.opts.sources))}
+		if err := g.client.Get(ctx, key, cm); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		cm.Data["value"] = strconv.Itoa(n)
+		if err := g.client.Update(ctx, cm); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		g.record(cm)
+	}
+}
+
+func (g *generator) record(cm *corev1.ConfigMap) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.mutations[cm.Name] = append(g.mutations[cm.Name], mutation{
+		resourceVersion: cm.ResourceVersion,
+		time:            time.Now(),
+	})
+}
+
+// reset marks every mutation as observed and discards latencies.
+func (g *generator) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := 0; i < g.opts.configMapSecrets; i++ {
+		for _, j := range g.sourcesOf(i) {
+			key := observationKey{configMapSecret: g.configMapSecretName(i), source: g.sourceName(j)}
+			g.observed[key] = len(g.mutations[key.source])
+		}
+	}
+	g.latencies = nil
+}
+
+// await polls the statuses of the ConfigMapSecrets until every mutation
+// has been observed or the timeout is exceeded.
+func (g *generator) await(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, g.opts.timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(g.opts.pollInterval)
+	defer ticker.Stop()
+	for {
+		pending, err := g.poll(ctx)
+		if err != nil {
+			return err
+		}
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d mutation(s) not observed: %w", pending, ctx.Err())
+		}
+	}
+}
+
+// poll records the latencies of newly observed mutations and returns the
+// number of mutations that haven't been observed.
+func (g *generator) poll(ctx context.Context) (int, error) {
+	list := &v1alpha1.ConfigMapSecretList{}
+	if err := g.client.List(ctx, list, client.InNamespace(g.opts.namespace), client.MatchingLabels(g.labels())); err != nil {
+		return 0, err
+	}
+	status := make(map[observationKey]v1alpha1.ConfigMapSecretSource)
+	for _, cms := range list.Items {
+		for _, src := range cms.Status.Sources {
+			if src.Kind == "ConfigMap" {
+				status[observationKey{configMapSecret: cms.Name, source: src.Name}] = src
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pending := 0
+	for i := 0; i < g.opts.configMapSecrets; i++ {
+		for _, j := range g.sourcesOf(i) {
+			key := observationKey{configMapSecret: g.configMapSecretName(i), source: g.sourceName(j)}
+			muts := g.mutations[key.source]
+			if src, ok := status[key]; ok {
+				// Every mutation up to the one that was read has been observed.
+				for k := g.observed[key]; k < len(muts); k++ {
+					if muts[k].resourceVersion != src.ResourceVersion {
+						continue
+					}
+					for _, m := range muts[g.observed[key] : k+1] {
+						// Status timestamps are truncated to seconds.
+						latency := src.LastReadTime.Sub(m.time.Truncate(time.Second))
+						if latency < 0 {
+							latency = 0
+						}
+						g.latencies = append(g.latencies, latency)
+					}
+					g.observed[key] = k + 1
+					break
+				}
+			}
+			pending += len(muts) - g.observed[key]
+		}
+	}
+	return pending, nil
+}
+
+func (g *generator) report() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	mutations := 0
+	for _, muts := range g.mutations {
+		mutations += len(muts) - 1 // Exclude creation.
+	}
+	fmt.Printf("mutations: %d\n", mutations)
+	fmt.Printf("observations: %d\n", len(g.latencies))
+	if len(g.latencies) == 0 {
+		return
+	}
+	sort.Slice(g.latencies, func(i, j int) bool { return g.latencies[i] < g.latencies[j] })
+	for _, p := range []float64{50, 90, 99, 100} {
+		fmt.Printf("p%g: %v\n", p, percentile(g.latencies, p))
+	}
+}
+
+// percentile returns the p'th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// cleanup deletes the objects generated by the run.
+func (g *generator) cleanup(ctx context.Context) error {
+	opts := []client.DeleteAllOfOption{client.InNamespace(g.opts.namespace), client.MatchingLabels(g.labels())}
+	if err := g.client.DeleteAllOf(ctx, &v1alpha1.ConfigMapSecret{}, opts...); err != nil {
+		return err
+	}
+	return g.client.DeleteAllOf(ctx, &corev1.ConfigMap{}, opts...)
+}
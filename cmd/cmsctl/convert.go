@@ -0,0 +1,288 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/convert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const convertUsage = `Usage: cmsctl convert <kind> [flags]
+
+Kinds:
+  configmap       Convert a ConfigMap to a ConfigMapSecret, extracting its credentials to a Secret.
+  externalsecret  Convert a ConfigMapSecret to an ExternalSecret of the External Secrets Operator.
+  sealedsecret    Convert a rendered Secret to a Bitnami SealedSecret.
+
+Run "cmsctl convert <kind> -h" for the flags of a kind.
+`
+
+func convertMain(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, convertUsage)
+		return 2
+	}
+	switch kind, args := args[0], args[1:]; kind {
+	case "configmap":
+		return convertConfigMapMain(args)
+	case "externalsecret":
+		return convertExternalSecretMain(args)
+	case "sealedsecret":
+		return convertSealedSecretMain(args)
+	default:
+		fmt.Fprintf(os.Stderr, "convert: unknown kind %q\n\n%s", kind, convertUsage)
+		return 2
+	}
+}
+
+func convertConfigMapMain(args []string) int {
+	var (
+		namespace string
+		inPath    string
+		outPath   string
+	)
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.StringVar(&namespace, "namespace", "default", "Namespace of the ConfigMap.")
+	fs.StringVar(&inPath, "f", "", "Path of a ConfigMap manifest to convert instead of reading it from the cluster.")
+	fs.StringVar(&outPath, "o", "", "Path of the manifests to write. If empty, they're written to stdout.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cmsctl convert configmap [<name>] [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	name, ok := parseConvertArgs(fs, args)
+	if !ok || (name == "") == (inPath == "") {
+		fs.Usage()
+		return 2
+	}
+
+	if err := runConvert(name, namespace, inPath, outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runConvert(name, namespace, inPath, outPath string) error {
+	cm, err := readConfigMap(name, namespace, inPath)
+	if err != nil {
+		return err
+	}
+	res := convert.ConfigMap(cm)
+
+	var buf bytes.Buffer
+	if res.Secret != nil {
+		b, err := yaml.Marshal(res.Secret)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("---\n")
+		buf.Write(b)
+	}
+	b, err := yaml.Marshal(res.ConfigMapSecret)
+	if err != nil {
+		return err
+	}
+	buf.WriteString("---\n")
+	buf.Write(b)
+
+	if err := writeOutput(outPath, buf.Bytes()); err != nil {
+		return err
+	}
+	if res.Secret == nil {
+		fmt.Fprintf(os.Stderr, "Converted ConfigMap %s; no credentials were found\n", cm.Name)
+	} else {
+		fmt.Fprintf(os.Stderr, "Converted ConfigMap %s; extracted %d credentials to Secret %s, which should be reviewed before it's applied\n",
+			cm.Name, len(res.Secret.StringData), res.Secret.Name)
+	}
+	return nil
+}
+
+func readConfigMap(name, namespace, path string) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{}
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+		if _, _, err := decoder.Decode(buf, nil, cm); err != nil {
+			return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+		}
+		if cm.Namespace == "" {
+			cm.Namespace = namespace
+		}
+		return cm, nil
+	}
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, cm); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+func convertExternalSecretMain(args []string) int {
+	var (
+		inPath  string
+		outPath string
+		store   convert.SecretStoreRef
+	)
+	fs := flag.NewFlagSet("convert externalsecret", flag.ExitOnError)
+	fs.StringVar(&inPath, "f", "", "Path of a ConfigMapSecret manifest to convert instead of reading it from the cluster.")
+	fs.StringVar(&outPath, "o", "", "Path of the manifest to write. If empty, it's written to stdout.")
+	fs.StringVar(&store.Name, "secret-store", "", "Name of the store, using the kubernetes provider, from which the ExternalSecret reads Secrets.")
+	fs.StringVar(&store.Kind, "secret-store-kind", "SecretStore", "Kind of the store: SecretStore or ClusterSecretStore.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cmsctl convert externalsecret [<namespace>/<name>] -secret-store <name> [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	key, ok := parseConvertArgs(fs, args)
+	if !ok || (key == "") == (inPath == "") || store.Name == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if err := runConvertExternalSecret(key, inPath, outPath, store); err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runConvertExternalSecret(key, inPath, outPath string, store convert.SecretStoreRef) error {
+	cms := &v1alpha1.ConfigMapSecret{}
+	if err := readObject(key, inPath, cms); err != nil {
+		return err
+	}
+	es, warnings := convert.ExternalSecret(cms, store)
+	b, err := yaml.Marshal(es.Object)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(outPath, append([]byte("---\n"), b...)); err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	fmt.Fprintf(os.Stderr, "Converted ConfigMapSecret %s with %d warnings\n", cms.Name, len(warnings))
+	return nil
+}
+
+func convertSealedSecretMain(args []string) int {
+	var (
+		inPath   string
+		outPath  string
+		certPath string
+		scope    string
+	)
+	fs := flag.NewFlagSet("convert sealedsecret", flag.ExitOnError)
+	fs.StringVar(&inPath, "f", "", "Path of a Secret manifest to convert instead of reading it from the cluster.")
+	fs.StringVar(&outPath, "o", "", "Path of the manifest to write. If empty, it's written to stdout.")
+	fs.StringVar(&certPath, "cert", "", "Path of the PEM-encoded certificate of the Sealed Secrets controller, e.g. from kubeseal --fetch-cert.")
+	fs.StringVar(&scope, "scope", string(convert.StrictScope), "Scope in which the SealedSecret can be unsealed: strict, namespace-wide, or cluster-wide.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cmsctl convert sealedsecret [<namespace>/<secret-name>] -cert cert.pem [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	key, ok := parseConvertArgs(fs, args)
+	if !ok || (key == "") == (inPath == "") || certPath == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if err := runConvertSealedSecret(key, inPath, outPath, certPath, convert.SealingScope(scope)); err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runConvertSealedSecret(key, inPath, outPath, certPath string, scope convert.SealingScope) error {
+	buf, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+	pub, err := convert.ParseSealingCert(buf)
+	if err != nil {
+		return fmt.Errorf("invalid certificate %s: %w", certPath, err)
+	}
+	secret := &corev1.Secret{}
+	if err := readObject(key, inPath, secret); err != nil {
+		return err
+	}
+	ss, err := convert.SealedSecret(secret, pub, scope, rand.Reader)
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(ss.Object)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(outPath, append([]byte("---\n"), b...)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Sealed Secret %s; it doesn't follow changes of its ConfigMapSecret's sources\n", secret.Name)
+	return nil
+}
+
+// parseConvertArgs parses the flags of a convert command, before and after
+// its optional argument, which it returns.
+func parseConvertArgs(fs *flag.FlagSet, args []string) (arg string, ok bool) {
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		arg = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	return arg, fs.NArg() == 0
+}
+
+// readObject reads the object of the key, of the form <namespace>/<name>,
+// from the cluster, or from the manifest at path if it's set.
+func readObject(key, path string, obj client.Object) error {
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+		if _, _, err := decoder.Decode(buf, nil, obj); err != nil {
+			return fmt.Errorf("unable to decode %s: %w", path, err)
+		}
+		return nil
+	}
+	namespace, name, ok := strings.Cut(key, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("invalid name %q; want <namespace>/<name>", key)
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	return c.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, obj)
+}
+
+func writeOutput(path string, buf []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(buf)
+		return err
+	}
+	return os.WriteFile(path, buf, 0o600)
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/preview"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+// diffMain prints a redacted diff of the output rendered from two versions of
+// a ConfigMapSecret using live sources.
+func diffMain(args []string) int {
+	var (
+		oldPath   string
+		newPath   string
+		namespace string
+		markdown  bool
+	)
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(&oldPath, "old", "", "Path to the old ConfigMapSecret manifest. If empty, it's being created.")
+	fs.StringVar(&newPath, "new", "", "Path to the new ConfigMapSecret manifest. If empty, it's being deleted.")
+	fs.StringVar(&namespace, "namespace", "default", "Namespace of manifests that don't specify one.")
+	fs.BoolVar(&markdown, "markdown", false, "Format the diff as a markdown code block, e.g. for a PR comment.")
+	fs.Parse(args)
+
+	if err := runDiff(oldPath, newPath, namespace, markdown); err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runDiff(oldPath, newPath, namespace string, markdown bool) error {
+	old, err := readConfigMapSecret(oldPath, namespace)
+	if err != nil {
+		return err
+	}
+	new, err := readConfigMapSecret(newPath, namespace)
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	diff, err := preview.Diff(context.Background(), preview.NewRenderer(c), old, new)
+	if err != nil {
+		return err
+	}
+	if diff != "" && markdown {
+		diff = "```diff\n" + diff + "```\n"
+	}
+	_, err = fmt.Print(diff)
+	return err
+}
+
+func readConfigMapSecret(path, namespace string) (*v1alpha1.ConfigMapSecret, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cms := &v1alpha1.ConfigMapSecret{}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	if _, _, err := decoder.Decode(buf, nil, cms); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+	}
+	if cms.Namespace == "" {
+		cms.Namespace = namespace
+	}
+	return cms, nil
+}
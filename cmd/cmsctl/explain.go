@@ -0,0 +1,84 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/preview"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func explainMain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cmsctl explain <namespace>/<name>\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	namespace, name, ok := strings.Cut(fs.Arg(0), "/")
+	if !ok || namespace == "" || name == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if err := runExplain(namespace, name); err != nil {
+		fmt.Fprintf(os.Stderr, "explain: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runExplain(namespace, name string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cms := &v1alpha1.ConfigMapSecret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, cms); err != nil {
+		return err
+	}
+	keys, err := preview.Explain(ctx, preview.NewRenderer(c), cms)
+	if err != nil {
+		return err
+	}
+	return printExplanation(os.Stdout, keys)
+}
+
+// printExplanation writes the variables of each key as a tree. Values are
+// never printed, only their lengths.
+func printExplanation(out io.Writer, keys []preview.KeyExplanation) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, k := range keys {
+		switch {
+		case k.AllVars:
+			fmt.Fprintf(w, "%s\t(all variables)\t\n", k.Key)
+		case len(k.Vars) == 0:
+			fmt.Fprintf(w, "%s\t(no variables)\t\n", k.Key)
+		default:
+			fmt.Fprintf(w, "%s\t\t\n", k.Key)
+		}
+		for _, v := range k.Vars {
+			if v.Source == "" {
+				fmt.Fprintf(w, "  %s\tunresolved\t\n", v.Name)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%d bytes\n", v.Name, v.Source, v.Length)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command cmsctl manages ConfigMapSecrets for operators, e.g. backing them up
+// and restoring them during disaster recovery, or previewing changes.
+//
+// Usage:
+//
+//	cmsctl backup -o backup.tar.gz [-namespace ns] [-include-secrets -key-file key]
+//	cmsctl restore -i backup.tar.gz [-key-file key]
+//	cmsctl convert configmap <name> [-namespace ns] [-f configmap.yaml] [-o out.yaml]
+//	cmsctl convert externalsecret <namespace>/<name> -secret-store name [-f cms.yaml] [-o out.yaml]
+//	cmsctl convert sealedsecret <namespace>/<secret-name> -cert cert.pem [-f secret.yaml] [-o out.yaml]
+//	cmsctl explain <namespace>/<name>
+//	cmsctl diff [-old old.yaml] [-new new.yaml] [-namespace ns] [-markdown]
+//
+// The key file contains a base64-encoded 32-byte key, e.g. generated with:
+//
+//	head -c 32 /dev/urandom | base64 > key
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/backup"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+const usage = `Usage: cmsctl <command> [flags]
+
+Commands:
+  backup   Export ConfigMapSecrets, their status, and optionally their Secrets to an archive.
+  restore  Apply the ConfigMapSecrets and Secrets of an archive.
+  convert  Convert a ConfigMap to a ConfigMapSecret, or a ConfigMapSecret to an ExternalSecret or SealedSecret.
+  explain  Show where the variables of each template key resolve from, without their values.
+  diff     Show a redacted diff of the Secrets rendered from two versions of a ConfigMapSecret.
+
+Run "cmsctl <command> -h" for the flags of a command.
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	check(clientscheme.AddToScheme(scheme), "Unable to add kubernetes client set to scheme")
+	check(v1alpha1.AddToScheme(scheme), "Unable to add secrets.mz.com/v1alpha1 to scheme")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "backup":
+		os.Exit(backupMain(args))
+	case "restore":
+		os.Exit(restoreMain(args))
+	case "convert":
+		os.Exit(convertMain(args))
+	case "explain":
+		os.Exit(explainMain(args))
+	case "diff":
+		os.Exit(diffMain(args))
+	default:
+		fmt.Fprintf(os.Stderr, "cmsctl: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+func backupMain(args []string) int {
+	var (
+		outPath        string
+		namespace      string
+		includeSecrets bool
+		keyFile        string
+	)
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.StringVar(&outPath, "o", "", "Path of the archive to write.")
+	fs.StringVar(&namespace, "namespace", "", "Namespace of the ConfigMapSecrets to back up. If empty, all namespaces are backed up.")
+	fs.BoolVar(&includeSecrets, "include-secrets", false, "Include the rendered Secrets, encrypted with the key in -key-file.")
+	fs.StringVar(&keyFile, "key-file", "", "Path to a file containing a base64-encoded 32-byte encryption key.")
+	fs.Parse(args)
+
+	if err := runBackup(outPath, namespace, includeSecrets, keyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runBackup(outPath, namespace string, includeSecrets bool, keyFile string) error {
+	if outPath == "" {
+		return fmt.Errorf("-o is required")
+	}
+	var key []byte
+	if includeSecrets {
+		if keyFile == "" {
+			return fmt.Errorf("-key-file is required with -include-secrets")
+		}
+		var err error
+		if key, err = readKey(keyFile); err != nil {
+			return err
+		}
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	a, err := backup.Backup(context.Background(), c, namespace, includeSecrets)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := a.Write(&buf, key); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %d ConfigMapSecrets and %d Secrets to %s\n", len(a.ConfigMapSecrets), len(a.Secrets), outPath)
+	return nil
+}
+
+func restoreMain(args []string) int {
+	var (
+		inPath  string
+		keyFile string
+	)
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVar(&inPath, "i", "", "Path of the archive to restore.")
+	fs.StringVar(&keyFile, "key-file", "", "Path to a file containing the base64-encoded 32-byte encryption key. If empty, Secrets aren't restored.")
+	fs.Parse(args)
+
+	if err := runRestore(inPath, keyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runRestore(inPath, keyFile string) error {
+	if inPath == "" {
+		return fmt.Errorf("-i is required")
+	}
+	var key []byte
+	if keyFile != "" {
+		var err error
+		if key, err = readKey(keyFile); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a, err := backup.Read(f, key)
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	res, err := backup.Restore(context.Background(), c, a)
+	fmt.Printf("Restored backup from %v: created %d and updated %d ConfigMapSecrets, created %d Secrets\n",
+		a.Created, res.Created, res.Updated, res.Secrets)
+	return err
+}
+
+func readKey(path string) ([]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	if len(key) != backup.KeySize {
+		return nil, fmt.Errorf("invalid key file %s: key is %d bytes; want %d", path, len(key), backup.KeySize)
+	}
+	return key, nil
+}
+
+func newClient() (client.Client, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+func check(err error, msg string) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// A degradation records the optional subsystems that failed, e.g. to register
+// metrics or to serve debug endpoints. If it's enabled by --degraded-ok, their
+// failures are logged and served as a detail of the health endpoint, instead
+// of being fatal.
+type degradation struct {
+	enabled bool
+
+	mu     sync.Mutex
+	errors map[string]string // By subsystem.
+}
+
+// check is like the package-level check, but for an optional subsystem.
+func (d *degradation) check(err error, subsystem, msg string) {
+	if err == nil {
+		return
+	}
+	if !d.enabled {
+		sink.WithCallDepth(1).Error(err, "Fatal error", "subsystem", subsystem)
+		sink.Flush()
+		os.Exit(1)
+	}
+	logger.Error(err, msg+"; continuing degraded", "subsystem", subsystem)
+	d.add(subsystem, err)
+}
+
+func (d *degradation) add(subsystem string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.errors == nil {
+		d.errors = make(map[string]string)
+	}
+	d.errors[subsystem] = err.Error()
+}
+
+// ServeHTTP serves the errors of the failed subsystems. It always succeeds,
+// because a degraded controller is still healthy.
+func (d *degradation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	v := struct {
+		Degraded bool              `json:"degraded"`
+		Errors   map[string]string `json:"errors,omitempty"`
+	}{len(d.errors) > 0, d.errors}
+	buf, err := json.Marshal(v)
+	d.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(buf, '\n'))
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// devEnv is the local control plane started for dev-envtest, if any.
+var devEnv struct {
+	env        *envtest.Environment
+	kubeconfig string
+}
+
+// startDevEnv starts a local control plane with envtest and writes a
+// kubeconfig for it to a temporary file, so that it can be used with kubectl.
+// The control plane's binaries are found in $KUBEBUILDER_ASSETS, as in tests.
+// It returns the config of an admin.
+func startDevEnv() (*rest.Config, error) {
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		return nil, err
+	}
+	devEnv.env = env
+	user, err := env.AddUser(envtest.User{Name: "developer", Groups: []string{"system:masters"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := user.KubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "configmapsecret-envtest-*.kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+	devEnv.kubeconfig = f.Name()
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	logger.Info("Started envtest control plane", "host", cfg.Host, "kubeconfig", devEnv.kubeconfig)
+	return cfg, nil
+}
+
+// stopDevEnv stops the local control plane, if it was started, and removes
+// its kubeconfig. It's called on every exit, including fatal errors, so that
+// the control plane's processes aren't orphaned.
+func stopDevEnv() {
+	if devEnv.kubeconfig != "" {
+		os.Remove(devEnv.kubeconfig)
+		devEnv.kubeconfig = ""
+	}
+	if devEnv.env != nil {
+		if err := devEnv.env.Stop(); err != nil {
+			logger.Error(err, "Unable to stop envtest control plane")
+		}
+		devEnv.env = nil
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ballast is allocated but never touched, so it's mostly not resident, but
+// it counts towards the heap size, so it makes the garbage collector run less
+// often while the live heap is small.
+var ballast []byte
+
+// gcSettings are the garbage collector settings applied at startup, which
+// override the GOGC and GOMEMLIMIT environment variables. Empty settings are
+// unchanged.
+type gcSettings struct {
+	percent  string // A percentage or "off".
+	memLimit string // A quantity, e.g. "1Gi".
+	ballast  string // A quantity, e.g. "256Mi".
+}
+
+// apply applies the settings and returns a collector of their effective values.
+func (s *gcSettings) apply() (prometheus.Collector, error) {
+	if s.percent != "" {
+		percent := -1
+		if s.percent != "off" {
+			n, err := strconv.Atoi(s.percent)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid gogc %q: must be a non-negative percentage or \"off\"", s.percent)
+			}
+			percent = n
+		}
+		debug.SetGCPercent(percent)
+	}
+	if s.memLimit != "" {
+		limit, err := parseBytes("gomemlimit", s.memLimit)
+		if err != nil {
+			return nil, err
+		}
+		if err := setMemoryLimit(limit); err != nil {
+			return nil, err
+		}
+	}
+	if s.ballast != "" {
+		size, err := parseBytes("gc-ballast", s.ballast)
+		if err != nil {
+			return nil, err
+		}
+		ballast = make([]byte, size)
+	}
+
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	gauge := func(name, help string, value float64) prometheus.Gauge {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+		g.Set(value)
+		return g
+	}
+	cs := collectors{
+		gauge("configmapsecret_controller_gogc", "Garbage collector target percentage, or -1 if it's off.", float64(percent)),
+		gauge("configmapsecret_controller_gc_ballast_bytes", "Size of the garbage collector ballast.", float64(len(ballast))),
+	}
+	if limit, ok := memoryLimit(); ok {
+		cs = append(cs, gauge("configmapsecret_controller_gomemlimit_bytes", "Soft memory limit of the runtime.", float64(limit)))
+	}
+	return cs, nil
+}
+
+// parseBytes parses the quantity of the named flag as a number of bytes.
+func parseBytes(name, s string) (int64, error) {
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, s, err)
+	}
+	n, ok := q.AsInt64()
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative number of bytes", name, s)
+	}
+	return n, nil
+}
+
+type collectors []prometheus.Collector
+
+func (s collectors) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range s {
+		c.Describe(ch)
+	}
+}
+
+func (s collectors) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range s {
+		c.Collect(ch)
+	}
+}
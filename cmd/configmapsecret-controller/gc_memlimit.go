@@ -0,0 +1,21 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import "runtime/debug"
+
+// setMemoryLimit sets the soft memory limit of the runtime.
+func setMemoryLimit(limit int64) error {
+	debug.SetMemoryLimit(limit)
+	return nil
+}
+
+// memoryLimit returns the soft memory limit of the runtime.
+func memoryLimit() (int64, bool) {
+	return debug.SetMemoryLimit(-1), true // A negative limit is unchanged.
+}
@@ -0,0 +1,21 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import "errors"
+
+// setMemoryLimit returns an error before Go 1.19, which introduced the soft
+// memory limit.
+func setMemoryLimit(limit int64) error {
+	return errors.New("gomemlimit requires Go 1.19")
+}
+
+// memoryLimit reports that there's no soft memory limit before Go 1.19.
+func memoryLimit() (int64, bool) {
+	return 0, false
+}
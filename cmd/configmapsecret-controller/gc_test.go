@@ -0,0 +1,38 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestGCSettings(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer func() { ballast = nil }()
+
+	for _, s := range []gcSettings{
+		{percent: "-1"},
+		{percent: "fifty"},
+		{memLimit: "lots"},
+		{ballast: "-1Mi"},
+		{ballast: "0.5"},
+	} {
+		if _, err := s.apply(); err == nil {
+			t.Errorf("expected error for invalid settings: %+v", s)
+		}
+	}
+
+	s := gcSettings{percent: "off", ballast: "1Mi"}
+	if _, err := s.apply(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := debug.SetGCPercent(100); got != -1 {
+		t.Errorf("unexpected gc percent: got %d; want -1", got)
+	}
+	if got := len(ballast); got != 1<<20 {
+		t.Errorf("unexpected ballast size: got %d; want %d", got, 1<<20)
+	}
+}
@@ -17,17 +17,43 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
-	"io/ioutil"
+	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"bursavich.dev/zapr"
 	"bursavich.dev/zapr/zaprprom"
 	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/apiwarnings"
+	"github.com/machinezone/configmapsecrets/pkg/audit"
+	"github.com/machinezone/configmapsecrets/pkg/blackout"
 	"github.com/machinezone/configmapsecrets/pkg/buildinfo"
 	"github.com/machinezone/configmapsecrets/pkg/controllers"
+	"github.com/machinezone/configmapsecrets/pkg/crds"
+	"github.com/machinezone/configmapsecrets/pkg/genflags"
+	"github.com/machinezone/configmapsecrets/pkg/hooks"
+	"github.com/machinezone/configmapsecrets/pkg/mzlog"
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+	"github.com/machinezone/configmapsecrets/pkg/preflight"
+	"github.com/machinezone/configmapsecrets/pkg/preview"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
+	"github.com/machinezone/configmapsecrets/pkg/validation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
 	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -42,11 +68,13 @@ import (
 var (
 	logger, sink = zapr.NewLogger()
 	scheme       = runtime.NewScheme()
+	degraded     = &degradation{}
 )
 
 func init() {
 	check(clientscheme.AddToScheme(scheme), "Unable to add kubernetes client set to scheme")
 	check(v1alpha1.AddToScheme(scheme), "Unable to add secrets.mz.com/v1alpha1 to scheme")
+	check(apiextensionsv1.AddToScheme(scheme), "Unable to add apiextensions.k8s.io/v1 to scheme")
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -64,75 +92,552 @@ func main() {
 	var (
 		healthAddr              string
 		metricsAddr             string
+		renderAddr              string
+		renderCertDir           string
 		allNamespaces           bool
+		namespaceOverride       string
+		tenantMode              bool
 		leaderElection          bool
 		leaderElectionNamespace string
+		logSampleLevels         = mzlog.DefaultSampleLevels
+		auditSink               string
+		postRenderHooks         stringsFlag
+		renderFailureThreshold  int
+		degradedRetryInterval   time.Duration
+		snapshotPath            string
+		snapshotInterval        time.Duration
+		reconcileTimeout        time.Duration
+		defaultsConfigMap       string
+		reportConfigMap         string
+		reportInterval          time.Duration
+		reportWindow            time.Duration
+		gitProtocols            string
+		disableSecretWatch      bool
+		disableConfigMapWatch   bool
+		pollInterval            time.Duration
+		blackoutWindows         stringsFlag
+		policyFile              string
+		statusFlushInterval     time.Duration
+		disableTrustBundles     bool
+		tenantLabel             string
+		installCRDs             bool
+		webhookPort             int
+		webhookCertDir          string
+		limits                  = validation.DefaultLimits
+		renderLimits            = render.DefaultLimits
+		maxEventsPerMinute      int
+		syncAnnotations         string
+		objectMetricsLimit      int
+		clusterName             string
+		secretNamePrefix        string
+		secretNameSuffix        string
+		resyncNamespace         string
+		resyncQPS               float64
+		once                    bool
+		devEnvtest              bool
+		gc                      gcSettings
+		backupExclusionLabel    string
 	)
-	flag.StringVar(&healthAddr, "health-addr", ":9090", "The address to which the health endpoint binds.")
-	flag.StringVar(&metricsAddr, "metrics-addr", ":9091", "The address to which the metric endpoint binds.")
+	flag.BoolVar(&degraded.enabled, "degraded-ok", false,
+		"Continue running if optional subsystems fail, i.e. registering logging and build metrics, and serving "+
+			"the metrics and render endpoints. Failures are logged and reported at /healthz/degraded.")
+	flag.StringVar(&healthAddr, "health-addr", ":9090",
+		"The address to which the health endpoint binds, e.g. \":9090\", \"[::1]:9090\", \"unix:///run/health.sock\", "+
+			"or \"systemd:health\" for a socket passed by systemd. \"0\" disables the endpoint.")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":9091",
+		"The address to which the metric endpoint binds, in the same formats as health-addr. \"0\" disables the endpoint.")
+	flag.StringVar(&renderAddr, "render-addr", "0",
+		"The address to which the render API binds, in the same formats as health-addr. \"0\" disables the API. "+
+			"It renders POSTed ConfigMapSecrets with their sensitive values redacted, for callers whose bearer token "+
+			"is allowed to get Secrets in their namespace. It's only served with TLS.")
+	flag.StringVar(&renderCertDir, "render-cert-dir", "",
+		"Directory containing the render API's tls.crt and tls.key. Defaults to webhook-cert-dir, and is required "+
+			"if that's empty and the render API is enabled.")
 	flag.BoolVar(&allNamespaces, "all-namespaces", true,
 		"Enable the contoller to manage all namespaces, instead of only its own namespace.")
+	flag.StringVar(&namespaceOverride, "namespace", "",
+		"The namespace managed by the controller when all-namespaces is disabled. Defaults to the POD_NAMESPACE "+
+			"environment variable, or else the namespace of its service account, so it must be set outside of a pod.")
+	flag.BoolVar(&tenantMode, "tenant-mode", false,
+		"Run fully namespaced, managing only the controller's own namespace with namespaced RBAC. "+
+			"Flags that require cluster-wide access are refused.")
 	flag.BoolVar(&leaderElection, "enable-leader-election", false,
 		"Enable leader election, which will ensure there is only one active controller.")
 	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
-		"Namespace of leader election object. Defaults to `kube-system` when all-namespaces is enabled "+
+		"Namespace of leader election object. Defaults to \"kube-system\" when all-namespaces is enabled "+
 			"and to the controller's own namespace when all-namespaces is disabled.")
+	flag.StringVar(&auditSink, "audit-sink", "",
+		"Optional http(s) URL or file path to which a record of every Secret write is sent.")
+	flag.Var(&postRenderHooks, "post-render-hook",
+		"Post-render hook applied to rendered Secrets before they're written, either the name of a "+
+			"registered hook or \"exec:\" followed by a plugin path. May be repeated.")
+	flag.IntVar(&renderFailureThreshold, "render-failure-threshold", 10,
+		"Number of consecutive render failures with the same reason after which a ConfigMapSecret is degraded "+
+			"and retried at the degraded-retry-interval until one of its sources changes. Zero disables the limit.")
+	flag.DurationVar(&degradedRetryInterval, "degraded-retry-interval", time.Hour,
+		"The interval at which degraded ConfigMapSecrets are retried.")
+	flag.StringVar(&snapshotPath, "snapshot-path", "",
+		"Optional file path to which the controller's state is persisted, such that on restart "+
+			"ConfigMapSecrets that haven't changed aren't reconciled again.")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", time.Minute,
+		"The interval at which the snapshot is written.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 3*time.Minute,
+		"The maximum duration of a reconciliation, after which it's canceled and retried. Zero disables the limit.")
+	flag.StringVar(&policyFile, "policy-file", "",
+		"Optional path of a YAML policy against which rendered Secrets are checked before they're written, "+
+			"e.g. for required labels, forbidden annotations, and size limits. A Secret that violates it isn't written.")
+	flag.DurationVar(&statusFlushInterval, "status-flush-interval", 0,
+		"The interval at which ConfigMapSecret statuses are written in batches, coalescing statuses of the same "+
+			"ConfigMapSecret set within an interval. Zero writes each status when it's set.")
+	flag.BoolVar(&installCRDs, "install-crds", false,
+		"Create or upgrade the bundled ConfigMapSecret CRD at startup. Requires permission to get, create, and update CRDs.")
+	flag.StringVar(&defaultsConfigMap, "defaults-configmap", "",
+		"The name of the ConfigMap in each namespace with the default labels, annotations, and type of its Secrets. Empty disables defaults.")
+	flag.StringVar(&reportConfigMap, "report-configmap", "",
+		"The name of the ConfigMap in each namespace to which a report of its ConfigMapSecrets, their failures, "+
+			"and drift corrections of their Secrets is written. Empty disables reports.")
+	flag.DurationVar(&reportInterval, "report-interval", time.Minute,
+		"The interval at which reports are written.")
+	flag.DurationVar(&reportWindow, "report-window", time.Hour,
+		"The rolling window over which failures and drift corrections are reported.")
+	flag.StringVar(&gitProtocols, "git-protocols", "",
+		"Comma-separated list of the transport protocols with which the git command may read GitSources: "+
+			"\"https\", \"ssh\", \"http\", \"git\", or \"file\". Empty disables GitSources.")
+	flag.BoolVar(&disableSecretWatch, "disable-secret-watch", false,
+		"Don't watch or cache Secrets, e.g. in clusters with very many of them. Drift of rendered Secrets and changes "+
+			"of source Secrets are corrected every poll-interval instead of when they're observed.")
+	flag.BoolVar(&disableConfigMapWatch, "disable-configmap-watch", false,
+		"Don't watch or cache ConfigMaps. Changes of source ConfigMaps are rendered every poll-interval instead of when "+
+			"they're observed.")
+	flag.BoolVar(&disableTrustBundles, "disable-cluster-trust-bundles", false,
+		"Don't read ClusterTrustBundles for clusterTrustBundleValue vars, which are otherwise read if the "+
+			"certificates.k8s.io/v1alpha1 API is available. Implied by tenant-mode.")
+	flag.StringVar(&tenantLabel, "tenant-label", "",
+		"A label whose value must be equal on ConfigMapSecrets and the Secrets and ConfigMaps they read. Sources of "+
+			"other tenants are rejected by the webhook and the controller, and aren't selected by label.")
+	flag.DurationVar(&pollInterval, "poll-interval", 10*time.Minute,
+		"The interval at which ConfigMapSecrets are reconciled if disable-secret-watch or disable-configmap-watch is set. "+
+			"Zero disables polling.")
+	flag.Var(&blackoutWindows, "blackout-window",
+		"Recurring window during which updates and deletions of existing Secrets are deferred until it ends, "+
+			"as a cron schedule of its start in UTC and a duration, e.g. \"0 18 * * FRI 63h\". The schedule may be "+
+			"prefixed with a time zone, e.g. \"CRON_TZ=America/New_York\". ConfigMapSecrets annotated as critical aren't "+
+			"deferred. May be repeated.")
+	flag.IntVar(&webhookPort, "webhook-port", 0,
+		"The port at which the validating admission webhook is served. Zero disables the webhook.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "",
+		"Directory containing the webhook's tls.crt and tls.key. Defaults to the controller-runtime default.")
+	flag.IntVar(&limits.MaxVars, "max-vars", limits.MaxVars,
+		"Maximum number of vars of a ConfigMapSecret enforced by the webhook. Zero disables the limit.")
+	flag.IntVar(&limits.MaxVarValueSize, "max-var-value-size", limits.MaxVarValueSize,
+		"Maximum size in bytes of a var value enforced by the webhook. Zero disables the limit.")
+	flag.IntVar(&limits.MaxSpecSize, "max-spec-size", limits.MaxSpecSize,
+		"Maximum size in bytes of a ConfigMapSecret's spec enforced by the webhook. Zero disables the limit.")
+	flag.DurationVar(&renderLimits.Timeout, "render-timeout", renderLimits.Timeout,
+		"Maximum duration of rendering a Go template, after which rendering fails. Zero disables the limit.")
+	flag.IntVar(&renderLimits.MaxOutputSize, "max-render-output-size", renderLimits.MaxOutputSize,
+		"Maximum size in bytes of the rendered value of a key, after which rendering fails. Zero disables the limit.")
+	flag.IntVar(&renderLimits.MaxDecompressedSize, "max-render-decompressed-size", renderLimits.MaxDecompressedSize,
+		"Maximum size in bytes of the rendered value of a compressed key, before it's compressed, after which rendering fails. Zero uses --max-render-output-size.")
+	flag.IntVar(&maxEventsPerMinute, "max-events-per-minute", 10,
+		"Maximum number of events recorded per minute for each ConfigMapSecret. Identical events are aggregated regardless. Zero disables the limit.")
+	flag.StringVar(&syncAnnotations, "sync-annotations", "",
+		"Comma-separated list of annotations set on written Secrets: \"last-applied-hash\", the hash of their data, "+
+			"and \"last-sync-time\", the time at which their data was last written. Empty sets neither.")
+	flag.IntVar(&objectMetricsLimit, "object-metrics-limit", 0,
+		"Maximum number of ConfigMapSecrets whose phase, render duration, and output size are served as OpenMetrics "+
+			"at /metrics/objects on the metrics endpoint. Zero disables the endpoint.")
+	flag.StringVar(&clusterName, "cluster-name", "",
+		"The name of the cluster, which is the value of the built-in $(__CLUSTER) template variable. Empty leaves it unset.")
+	flag.StringVar(&secretNamePrefix, "secret-name-prefix", "",
+		"Prefix added to the name of every rendered Secret.")
+	flag.StringVar(&secretNameSuffix, "secret-name-suffix", "",
+		"Suffix added to the name of every rendered Secret.")
+	flag.StringVar(&backupExclusionLabel, "backup-exclusion-label", v1alpha1.VeleroExcludeFromBackupLabel+"=true",
+		"The label, as \"key=value\", set on the Secrets of ConfigMapSecrets whose backupPolicy is Exclude, and removed "+
+			"from those whose backupPolicy is Include.")
+	flag.StringVar(&resyncNamespace, "resync-namespace", "",
+		"If set, reconcile every ConfigMapSecret in the namespace once, print a summary, and exit instead of running "+
+			"the controller, e.g. in a Job during a migration. Exits non-zero if any of them failed.")
+	flag.Float64Var(&resyncQPS, "resync-qps", 5,
+		"Maximum number of ConfigMapSecrets reconciled per second by resync-namespace and once. Zero disables the limit.")
+	flag.BoolVar(&once, "once", false,
+		"Reconcile every ConfigMapSecret managed by the controller once, print a JSON summary, and exit instead of "+
+			"running the controller, e.g. in CI or bootstrap scripts. Exits non-zero if any of them failed.")
+	flag.BoolVar(&devEnvtest, "dev-envtest", false,
+		"For development, run against a local envtest control plane, started with the binaries in "+
+			"$KUBEBUILDER_ASSETS and with the CRD installed, instead of a cluster. A kubeconfig for it is logged.")
+	flag.StringVar(&gc.percent, "gogc", "",
+		"Garbage collector target percentage, or \"off\", overriding GOGC.")
+	flag.StringVar(&gc.memLimit, "gomemlimit", "",
+		"Soft memory limit of the runtime as a quantity, e.g. \"1Gi\", overriding GOMEMLIMIT. Requires Go 1.19.")
+	flag.StringVar(&gc.ballast, "gc-ballast", "",
+		"Size of an untouched heap allocation as a quantity, e.g. \"256Mi\", which makes the garbage collector "+
+			"run less often while the live heap is small.")
+	flag.Var(&logSampleLevels, "log-sample-levels",
+		"Comma-separated list of log levels subject to sampling (e.g. \"info\" or \"info,error\").")
 	zaprObserver := zaprprom.NewObserver()
 	zaprOptions := zapr.AllOptions(zapr.WithObserver(zaprObserver))
 	zapr.RegisterFlags(flag.CommandLine, zaprOptions...)
+	if len(os.Args) > 1 && os.Args[1] == "flags" {
+		// Generates docs/flags.md.
+		check(genflags.WriteMarkdown(os.Stdout, "configmapsecret-controller", flag.CommandLine), "Unable to write flags")
+		return
+	}
 	flag.Parse()
 
-	logger, sink = zapr.NewLogger(zaprOptions...)
+	logger, sink = mzlog.NewLogger(logSampleLevels, zaprOptions...)
 	defer sink.Flush()
 
 	buildinfo.Log(logger)
 	log.SetLogger(logger)
+	mzlog.SetDefault(sink)
 
-	check(metrics.Registry.Register(zaprObserver), "Unable to register logging metrics")
-	check(metrics.Registry.Register(buildinfo.Collector()), "Unable to register build metrics")
+	degraded.check(metrics.Registry.Register(zaprObserver), "logging metrics", "Unable to register logging metrics")
+	degraded.check(metrics.Registry.Register(buildinfo.Collector()), "build metrics", "Unable to register build metrics")
+	gcCollector, err := gc.apply()
+	check(err, "Invalid garbage collector settings")
+	degraded.check(metrics.Registry.Register(gcCollector), "gc metrics", "Unable to register garbage collector metrics")
 
-	cfg, err := config.GetConfig()
-	check(err, "Unable to load kubeconfig")
+	var cfg *rest.Config
+	if devEnvtest {
+		cfg, err = startDevEnv()
+		check(err, "Unable to start envtest control plane")
+		defer stopDevEnv()
+		installCRDs = true
+		if namespaceOverride == "" && os.Getenv("POD_NAMESPACE") == "" {
+			namespaceOverride = "default" // There's no service account.
+		}
+	} else {
+		cfg, err = config.GetConfig()
+		check(err, "Unable to load kubeconfig")
+	}
+	// Takes precedence over the default handler that controller-runtime sets.
+	cfg.WarningHandler = apiwarnings.NewHandler(logger.WithName("apiwarnings"))
 
+	check(checkSecretNameAffixes(secretNamePrefix, secretNameSuffix), "Invalid secret name prefix or suffix")
+	if tenantMode {
+		check(checkTenantFlags(allNamespaces), "Invalid flags for tenant mode")
+		allNamespaces = false
+		disableTrustBundles = true // A Role can't grant access to cluster-scoped ClusterTrustBundles.
+	}
+	if renderAddr != "0" && renderCertDir == "" {
+		// Callers send bearer tokens, so they must not be sent in plaintext.
+		renderCertDir = webhookCertDir
+		if renderCertDir == "" {
+			check(errors.New("render-addr requires render-cert-dir or webhook-cert-dir"), "Invalid flags")
+		}
+	}
 	namespace := ""
 	electionNamespace := "kube-system" // Default to cluster-wide leader election.
 	if !allNamespaces {
-		namespace, err = currentNamespace()
+		namespace, err = currentNamespace(os.DirFS("/"), os.Getenv, namespaceOverride)
 		check(err, "Unable to detect namespace")
 		electionNamespace = namespace // Default to namespace-wide leader election.
 	}
 	if leaderElectionNamespace != "" {
+		if tenantMode && leaderElectionNamespace != namespace {
+			check(fmt.Errorf("leader-election-namespace must be %q in tenant mode", namespace), "Invalid flags for tenant mode")
+		}
 		electionNamespace = leaderElectionNamespace // Override leader election namespace.
 	}
+	if once && resyncNamespace != "" {
+		check(errors.New("once and resync-namespace are mutually exclusive"), "Invalid flags")
+	}
+	if tenantMode && resyncNamespace != "" && resyncNamespace != namespace {
+		check(fmt.Errorf("resync-namespace must be %q in tenant mode", namespace), "Invalid flags for tenant mode")
+	}
+	if installCRDs {
+		c, err := client.New(cfg, client.Options{Scheme: scheme})
+		check(err, "Unable to create client")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = crds.Install(ctx, c, logger)
+		cancel()
+		check(err, "Unable to install CRDs")
+	}
+	if !tenantMode { // A Role can't grant access to the cluster-scoped CRD.
+		c, err := client.New(cfg, client.Options{Scheme: scheme})
+		check(err, "Unable to create client")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := crds.Check(ctx, c, logger); err != nil {
+			logger.Error(err, "Unable to check installed CRD")
+		}
+		cancel()
+	}
+	required := preflight.Options{Namespace: namespace}
+	if leaderElection {
+		required.LeaderElectionNamespace = electionNamespace
+		required.LeaderElectionID = "configmapsecret-controller-leader"
+	}
+	if !tenantMode {
+		required.CRDName = "configmapsecrets.secrets.mz.com"
+	}
+	required.Render = renderAddr != "0"
+	permissions := &preflight.Checker{Permissions: preflight.Required(required), Log: logger.WithName("preflight")}
+	permissions.Client, err = client.New(cfg, client.Options{Scheme: scheme})
+	check(err, "Unable to create client")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	if _, err := permissions.Run(ctx); err != nil {
+		logger.Error(err, "Unable to check permissions")
+	}
+	cancel()
 	opts := manager.Options{
 		Scheme:                  scheme,
-		HealthProbeBindAddress:  healthAddr,
-		MetricsBindAddress:      metricsAddr,
+		HealthProbeBindAddress:  "0", // Served by httpServers.
+		MetricsBindAddress:      "0", // Served by httpServers.
 		Namespace:               namespace,
 		LeaderElection:          leaderElection,
 		LeaderElectionID:        "configmapsecret-controller-leader",
 		LeaderElectionNamespace: electionNamespace,
+		Port:                    webhookPort,
+		CertDir:                 webhookCertDir,
+	}
+	// Without a watch, a cached read would start an informer for the kind anyway.
+	if disableSecretWatch {
+		opts.ClientDisableCacheFor = append(opts.ClientDisableCacheFor, &corev1.Secret{})
+	}
+	if disableConfigMapWatch {
+		opts.ClientDisableCacheFor = append(opts.ClientDisableCacheFor, &corev1.ConfigMap{})
 	}
 
 	mgr, err := manager.New(cfg, opts)
 	check(err, "Unable to create manager")
-	check(mgr.AddHealthzCheck("ping", healthz.Ping), "Unable to install healthz check")
 
-	rec := controllers.ConfigMapSecret{}
+	// Servers only read, so they run on every replica regardless of leader
+	// election, and replicas that aren't the leader serve them too.
+	if healthAddr != "0" {
+		health := &healthz.Handler{Checks: map[string]healthz.Checker{"ping": healthz.Ping}}
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", http.StripPrefix("/healthz", health))
+		mux.Handle("/healthz/", http.StripPrefix("/healthz", health))
+		mux.Handle("/healthz/degraded", degraded)
+		ready := &healthz.Handler{Checks: map[string]healthz.Checker{"ping": healthz.Ping, "permissions": permissions.Healthz}}
+		mux.Handle("/readyz", http.StripPrefix("/readyz", ready))
+		mux.Handle("/readyz/", http.StripPrefix("/readyz", ready))
+		check(mgr.Add(&httpServer{name: "health probe", addr: healthAddr, handler: mux, log: logger}), "Unable to install health server")
+	}
+	var metricsMux *http.ServeMux
+	if metricsAddr != "0" {
+		metricsMux = http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{
+			ErrorHandling: promhttp.HTTPErrorOnError,
+		}))
+		metricsMux.Handle("/debug/schema/openapi.json", schema.OpenAPIHandler())
+		metricsMux.Handle("/debug/schema/jsonschema.json", schema.JSONSchemaHandler())
+		srv := &httpServer{name: "metrics", addr: metricsAddr, handler: metricsMux, log: logger, degraded: degraded}
+		degraded.check(mgr.Add(srv), "metrics server", "Unable to install metrics server")
+	}
+	if webhookPort != 0 {
+		validator := &validation.Validator{Limits: limits, TenantLabel: tenantLabel, Reader: mgr.GetAPIReader()}
+		check(validator.SetupWebhookWithManager(mgr), "Unable to create webhook")
+	}
+
+	// The controller writes, so it only runs on the leader.
+	rec := controllers.ConfigMapSecret{
+		RenderFailureThreshold: renderFailureThreshold,
+		DegradedRetryInterval:  degradedRetryInterval,
+		SnapshotPath:           snapshotPath,
+		SnapshotInterval:       snapshotInterval,
+		ReconcileTimeout:       reconcileTimeout,
+		DefaultsConfigMap:      defaultsConfigMap,
+		ReportConfigMap:        reportConfigMap,
+		ReportInterval:         reportInterval,
+		ReportWindow:           reportWindow,
+		RenderLimits:           renderLimits,
+		MaxEventsPerMinute:     maxEventsPerMinute,
+		ClusterName:            clusterName,
+		SecretNamePrefix:       secretNamePrefix,
+		SecretNameSuffix:       secretNameSuffix,
+		DisableSecretWatch:     disableSecretWatch,
+		DisableConfigMapWatch:  disableConfigMapWatch,
+		PollInterval:           pollInterval,
+		StatusFlushInterval:    statusFlushInterval,
+	}
+	rec.SyncAnnotations, err = parseSyncAnnotations(syncAnnotations)
+	check(err, "Invalid sync-annotations")
+	rec.BackupExclusionLabel, rec.BackupExclusionValue, err = parseLabel(backupExclusionLabel)
+	check(err, "Invalid backup-exclusion-label")
+	rec.GitProtocols, err = parseGitProtocols(gitProtocols)
+	check(err, "Invalid git-protocols")
+	if auditSink != "" {
+		rec.AuditSink, err = audit.NewSink(auditSink)
+		check(err, "Unable to create audit sink")
+	}
+	rec.DisableClusterTrustBundles = disableTrustBundles
+	rec.TenantLabel = tenantLabel
+	if policyFile != "" {
+		rec.Policy, err = policy.Load(policyFile)
+		check(err, "Unable to load policy")
+	}
+	for _, spec := range blackoutWindows {
+		window, err := blackout.Parse(spec)
+		check(err, "Invalid blackout-window")
+		rec.BlackoutWindows = append(rec.BlackoutWindows, window)
+	}
+	for _, spec := range postRenderHooks {
+		hook, err := hooks.Load(spec)
+		check(err, "Unable to load post-render hook")
+		rec.Hooks = append(rec.Hooks, hook)
+	}
+	if resyncNamespace != "" {
+		// Run to completion without starting the manager, so the controller
+		// isn't affected and leader election isn't needed.
+		logger.Info("Resyncing namespace", "namespace", resyncNamespace, "qps", resyncQPS)
+		check(runResync(signals.SetupSignalHandler(), cfg, &rec, resyncNamespace, resyncQPS, textSummary(os.Stdout, resyncNamespace)), "Resync failed")
+		return
+	}
+	if once {
+		logger.Info("Reconciling once", "namespace", namespace, "qps", resyncQPS)
+		check(runResync(signals.SetupSignalHandler(), cfg, &rec, namespace, resyncQPS, jsonSummary(os.Stdout)), "Reconcile once failed")
+		return
+	}
 	check(rec.SetupWithManager(mgr), "Unable to create controller")
+	if renderAddr != "0" {
+		// Rendered with the controller's configuration, so that the render is
+		// the same as the Secret it writes.
+		mux := http.NewServeMux()
+		mux.Handle("/render", preview.Handler(rec.PreviewRenderer(mgr.GetAPIReader()), mgr.GetClient(), namespace))
+		srv := &httpServer{name: "render", addr: renderAddr, handler: mux, certDir: renderCertDir, log: logger, degraded: degraded}
+		degraded.check(mgr.Add(srv), "render server", "Unable to install render server")
+	}
+	if metricsMux != nil && objectMetricsLimit > 0 {
+		reg := prometheus.NewRegistry()
+		check(reg.Register(rec.ObjectCollector(objectMetricsLimit)), "Unable to register object metrics")
+		metricsMux.Handle("/metrics/objects", promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+			ErrorHandling:     promhttp.HTTPErrorOnError,
+			EnableOpenMetrics: true,
+		}))
+	}
 	// +kubebuilder:scaffold:builder
 
 	logger.Info("Starting manager")
 	stopCh := signals.SetupSignalHandler()
-	check(mgr.Start(stopCh), "Problem running manager")
+	err = mgr.Start(stopCh)
+	stopDevEnv()
+	check(err, "Problem running manager")
 }
 
-func currentNamespace() (string, error) {
-	buf, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+// serviceAccountNamespace is the path of the file with the namespace of the
+// pod's service account, relative to the root of the file system.
+const serviceAccountNamespace = "var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// currentNamespace returns the override, if it's set, or the POD_NAMESPACE
+// environment variable, if it's set, or the namespace of the controller's
+// service account, read from fsys.
+func currentNamespace(fsys fs.FS, getenv func(string) string, override string) (string, error) {
+	for _, src := range []struct{ name, namespace string }{
+		{"namespace", override},
+		{"POD_NAMESPACE", getenv("POD_NAMESPACE")},
+	} {
+		if src.namespace == "" {
+			continue
+		}
+		if errs := k8svalidation.IsDNS1123Label(src.namespace); len(errs) > 0 {
+			return "", fmt.Errorf("invalid %s %q: %s", src.name, src.namespace, strings.Join(errs, "; "))
+		}
+		return src.namespace, nil
+	}
+	buf, err := fs.ReadFile(fsys, serviceAccountNamespace)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", errors.New("service account namespace not found; set namespace or POD_NAMESPACE when not running in a pod")
+	}
 	if err != nil {
 		return "", err
 	}
-	return string(bytes.TrimSpace(buf)), nil
+	namespace := string(bytes.TrimSpace(buf))
+	if namespace == "" {
+		return "", errors.New("service account namespace is empty")
+	}
+	return namespace, nil
+}
+
+// checkTenantFlags returns an error if any explicitly set flag requires
+// cluster-wide access, which isn't granted in tenant mode.
+func checkTenantFlags(allNamespaces bool) (err error) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "all-namespaces" && allNamespaces {
+			err = errors.New("all-namespaces is not allowed in tenant mode")
+		}
+		if f.Name == "install-crds" && f.Value.String() == "true" {
+			err = errors.New("install-crds is not allowed in tenant mode")
+		}
+		if f.Name == "render-addr" && f.Value.String() != "0" {
+			// A Role can't grant creating TokenReviews and SubjectAccessReviews.
+			err = errors.New("render-addr is not allowed in tenant mode")
+		}
+	})
+	return err
+}
+
+// checkSecretNameAffixes returns an error if names with the prefix and suffix
+// may not be valid DNS-1123 subdomains, as Secret names must be.
+func checkSecretNameAffixes(prefix, suffix string) error {
+	if prefix == "" && suffix == "" {
+		return nil
+	}
+	if errs := k8svalidation.IsDNS1123Subdomain(prefix + "a" + suffix); len(errs) > 0 {
+		return fmt.Errorf("secret names %q: %s", prefix+"<name>"+suffix, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parseSyncAnnotations parses a comma-separated list of the names of sync
+// annotations without their prefix.
+func parseSyncAnnotations(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []string
+	for _, name := range strings.Split(s, ",") {
+		switch key := v1alpha1.Prefix + strings.TrimSpace(name); key {
+		case v1alpha1.LastAppliedHashAnnotation, v1alpha1.LastSyncTimeAnnotation:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unknown sync annotation: %q", name)
+		}
+	}
+	return keys, nil
+}
+
+// parseGitProtocols parses a comma-separated list of Git transport protocols.
+func parseGitProtocols(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var protocols []string
+	for _, name := range strings.Split(s, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "https", "ssh", "http", "git", "file":
+			protocols = append(protocols, name)
+		default:
+			return nil, fmt.Errorf("unknown git protocol: %q", name)
+		}
+	}
+	return protocols, nil
+}
+
+// parseLabel parses a label as "key=value".
+func parseLabel(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("label %q must be \"key=value\"", s)
+	}
+	if errs := k8svalidation.IsQualifiedName(key); len(errs) > 0 {
+		return "", "", fmt.Errorf("label key %q: %s", key, strings.Join(errs, "; "))
+	}
+	if errs := k8svalidation.IsValidLabelValue(value); len(errs) > 0 {
+		return "", "", fmt.Errorf("label value %q: %s", value, strings.Join(errs, "; "))
+	}
+	return key, value, nil
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
 func check(err error, msg string) {
@@ -140,6 +645,7 @@ func check(err error, msg string) {
 		return
 	}
 	sink.WithCallDepth(1).Error(err, "Fatal error")
+	stopDevEnv()
 	sink.Flush()
 	os.Exit(1)
 }
@@ -0,0 +1,56 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCurrentNamespace(t *testing.T) {
+	pod := fstest.MapFS{serviceAccountNamespace: {Data: []byte("tenant\n")}}
+	tests := []struct {
+		desc     string
+		fsys     fstest.MapFS
+		env      string
+		override string
+		want     string
+		err      bool
+	}{
+		{desc: "service account", fsys: pod, want: "tenant"},
+		{desc: "override", fsys: pod, override: "other", want: "other"},
+		{desc: "override outside of a pod", fsys: fstest.MapFS{}, override: "other", want: "other"},
+		{desc: "env", fsys: pod, env: "env", want: "env"},
+		{desc: "env outside of a pod", fsys: fstest.MapFS{}, env: "env", want: "env"},
+		{desc: "override and env", fsys: pod, env: "env", override: "other", want: "other"},
+		{desc: "invalid env", fsys: pod, env: "-env", err: true},
+		{desc: "invalid override", fsys: pod, override: "Not_A_Namespace", err: true},
+		{desc: "outside of a pod", fsys: fstest.MapFS{}, err: true},
+		{desc: "empty", fsys: fstest.MapFS{serviceAccountNamespace: {Data: []byte(" \n")}}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			getenv := func(key string) string {
+				if key == "POD_NAMESPACE" {
+					return tt.env
+				}
+				return ""
+			}
+			got, err := currentNamespace(tt.fsys, getenv, tt.override)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got namespace %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected namespace: got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/machinezone/configmapsecrets/pkg/controllers"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// runResync reconciles every ConfigMapSecret in the namespace, or in every
+// namespace if it's empty, once with rec, at most qps per second, and writes
+// a summary with write. It returns an error if any of them failed.
+func runResync(
+	ctx context.Context,
+	cfg *rest.Config,
+	rec *controllers.ConfigMapSecret,
+	namespace string,
+	qps float64,
+	write func(*controllers.ResyncSummary) error,
+) error {
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	defer broadcaster.Shutdown() // Delivers queued events.
+	recorder := broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "configmapsecret-controller"})
+
+	var limiter *rate.Limiter
+	if qps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(qps), 1)
+	}
+	summary, err := rec.Resync(ctx, c, scheme, logger, recorder, namespace, limiter)
+	if summary != nil {
+		if writeErr := write(summary); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if n := len(summary.Failed); n > 0 {
+		return fmt.Errorf("%d of %d ConfigMapSecrets failed", n, n+len(summary.Synced))
+	}
+	return nil
+}
+
+// textSummary returns a func that writes a summary of resyncing the namespace
+// as text to w.
+func textSummary(w io.Writer, namespace string) func(*controllers.ResyncSummary) error {
+	return func(summary *controllers.ResyncSummary) error {
+		if _, err := fmt.Fprintf(w, "Resynced %d ConfigMapSecrets in namespace %s: %d synced, %d failed\n",
+			len(summary.Synced)+len(summary.Failed), namespace, len(summary.Synced), len(summary.Failed)); err != nil {
+			return err
+		}
+		names := make([]string, 0, len(summary.Failed))
+		for name := range summary.Failed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "  %s: %s\n", name, summary.Failed[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// jsonSummary returns a func that writes a summary as JSON to w.
+func jsonSummary(w io.Writer) func(*controllers.ResyncSummary) error {
+	return func(summary *controllers.ResyncSummary) error {
+		return json.NewEncoder(w).Encode(summary)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/listen"
+)
+
+// An httpServer is a manager runnable that serves HTTP on every replica,
+// regardless of leader election.
+//
+// The manager's own metrics and health servers only listen on TCP, so they're
+// disabled in favor of httpServers listening on any address supported by the
+// listen package.
+type httpServer struct {
+	name    string
+	addr    string
+	handler http.Handler
+	log     logr.Logger
+
+	// If set, the server is only served with TLS, using the tls.crt and
+	// tls.key in the directory.
+	certDir string
+
+	// If set, the server is optional, and its failure is recorded in the
+	// degradation, rather than returned, if it's enabled.
+	degraded *degradation
+}
+
+func (s *httpServer) NeedLeaderElection() bool { return false }
+
+func (s *httpServer) Start(ctx context.Context) error {
+	err := s.serve(ctx)
+	if err == nil || s.degraded == nil || !s.degraded.enabled {
+		return err
+	}
+	s.log.Error(err, "Server failed; continuing degraded", "kind", s.name)
+	s.degraded.add(s.name+" server", err)
+	return nil
+}
+
+func (s *httpServer) serve(ctx context.Context) error {
+	ln, err := listen.Listen(s.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.log.Info("Starting server", "kind", s.name, "addr", ln.Addr())
+	if s.certDir != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		err = srv.ServeTLS(ln, filepath.Join(s.certDir, "tls.crt"), filepath.Join(s.certDir, "tls.key"))
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-done
+	return nil
+}
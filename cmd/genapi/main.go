@@ -0,0 +1,177 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command genapi generates the documentation of an API package from its code
+// and comments, and verifies that committed documentation is up to date.
+//
+// Usage:
+//
+//	genapi markdown -pkg path [-group-version group/version] [-o api.md]
+//	genapi json -pkg path [-group-version group/version] [-o api.json]
+//	genapi verify -pkg path -f api.md [-format markdown|json] [-group-version group/version]
+//
+// The verify command prints the drift as JSON and exits with status 1 if the
+// file is stale.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/genapi"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const usage = `Usage: genapi <command> [flags]
+
+Commands:
+  markdown  Write the API of a package as markdown.
+  json      Write the API of a package as JSON.
+  verify    Check that a file matches the generated API of a package.
+
+Run "genapi <command> -h" for the flags of a command.
+`
+
+// schemes registers the types of the known API packages, whose kinds and
+// group versions are documented.
+var schemes = map[string]func(*runtime.Scheme) error{
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1": v1alpha1.AddToScheme,
+}
+
+var writers = map[string]func(io.Writer, *genapi.Package, ...genapi.Option) error{
+	"markdown": genapi.WriteMarkdown,
+	"json":     genapi.WriteJSON,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "markdown", "json":
+		os.Exit(generateMain(cmd, args))
+	case "verify":
+		os.Exit(verifyMain(args))
+	default:
+		fmt.Fprintf(os.Stderr, "genapi: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+func generateMain(format string, args []string) int {
+	var (
+		pkgPath      string
+		groupVersion string
+		outPath      string
+	)
+	fs := flag.NewFlagSet(format, flag.ExitOnError)
+	fs.StringVar(&pkgPath, "pkg", "", "Import path of the API package.")
+	fs.StringVar(&groupVersion, "group-version", "", "Group version of the API, e.g. secrets.mz.com/v1alpha1. If empty, it's that of a known package.")
+	fs.StringVar(&outPath, "o", "", "Path of the file to write. If empty, it's written to stdout.")
+	fs.Parse(args)
+
+	out, err := generate(format, pkgPath, groupVersion)
+	if err == nil {
+		if outPath == "" {
+			_, err = os.Stdout.Write(out)
+		} else {
+			err = os.WriteFile(outPath, out, 0o644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", format, err)
+		return 1
+	}
+	return 0
+}
+
+func verifyMain(args []string) int {
+	var (
+		pkgPath      string
+		groupVersion string
+		path         string
+		format       string
+	)
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&pkgPath, "pkg", "", "Import path of the API package.")
+	fs.StringVar(&groupVersion, "group-version", "", "Group version of the API, e.g. secrets.mz.com/v1alpha1. If empty, it's that of a known package.")
+	fs.StringVar(&path, "f", "", "Path of the committed file.")
+	fs.StringVar(&format, "format", "markdown", "Format of the committed file: markdown or json.")
+	fs.Parse(args)
+
+	drift, err := verify(path, format, pkgPath, groupVersion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 1
+	}
+	if drift == nil {
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(drift); err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+	}
+	return 1
+}
+
+func verify(path, format, pkgPath, groupVersion string) (*genapi.Drift, error) {
+	if path == "" {
+		return nil, fmt.Errorf("-f is required")
+	}
+	out, err := generate(format, pkgPath, groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	return genapi.Verify(path, out)
+}
+
+func generate(format, pkgPath, groupVersion string) ([]byte, error) {
+	write, ok := writers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if pkgPath == "" {
+		return nil, fmt.Errorf("-pkg is required")
+	}
+	opts, err := options(pkgPath, groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := genapi.ParsePackage(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, pkg, opts...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func options(pkgPath, groupVersion string) ([]genapi.Option, error) {
+	var opts []genapi.Option
+	if addToScheme, ok := schemes[pkgPath]; ok {
+		scheme := runtime.NewScheme()
+		if err := addToScheme(scheme); err != nil {
+			return nil, err
+		}
+		opts = append(opts, genapi.WithScheme(scheme))
+	}
+	if groupVersion != "" {
+		gv, err := schema.ParseGroupVersion(groupVersion)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, genapi.WithGroupVersion(gv))
+	}
+	return opts, nil
+}
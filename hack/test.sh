@@ -20,12 +20,19 @@ set -o pipefail
 
 export CGO_ENABLED=0
 export GO111MODULE=on
-export GOFLAGS="-mod=vendor"
+# The faultinject tag builds the fault injection client and the resilience tests.
+export GOFLAGS="-mod=vendor -tags=faultinject"
 
 TARGETS=$(for d in "$@"; do echo ./$d/...; done)
 
 echo "Running tests:"
-go test ${TARGETS}
+if [ -n "${TEST_RESULTS:-}" ]; then
+    # The results are reported by mage, including the output of failed tests.
+    mkdir -p "${TEST_RESULTS}"
+    go test -json -covermode=atomic -coverprofile="${TEST_RESULTS}/coverage.out" ${TARGETS} > "${TEST_RESULTS}/test.json"
+else
+    go test ${TARGETS}
+fi
 echo
 
 echo -n "Checking gofmt: "
@@ -6,26 +6,34 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
-	"hash/fnv"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/machinezone/configmapsecrets/pkg/genapi"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
 	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/sh"
 	magetarget "github.com/magefile/mage/target"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -36,27 +44,101 @@ const (
 	k8sVersion = "1.24.2"
 	buildImage = "golang:" + goVersion + "-alpine"
 	testImage  = "kubebuilder-tools-" + k8sVersion + "-go" + goVersion + "-alpine"
-	baseImage  = "gcr.io/distroless/static:latest"
 )
 
 var (
 	arches     = []string{"amd64", "arm", "arm64"}
-	baseImages map[string]string
+	baseImages = make(map[string]map[string]string) // By base image and arch.
 )
 
-func archBaseImage(arch string) (string, error) {
-	if err := initBaseImages(); err != nil {
+// A variant is a flavor of the container image, built from a different base
+// image or run as a different user.
+type variant struct {
+	name      string // Suffix of the image's tag, or empty for the default.
+	baseImage string
+	user      string
+	run       string // Command run on the base image, e.g. to install packages.
+}
+
+// variants are the image variants, which are all built unless VARIANTS
+// selects a comma-separated list of them by name, e.g. VARIANTS=default,debug.
+var variants = []variant{
+	{
+		name:      "",
+		baseImage: "gcr.io/distroless/static:latest",
+		user:      "65535:65535", // distroless doesn't have "nobody"
+	},
+	{
+		// Runs as distroless' nonroot user. It's numeric, so that the kubelet
+		// can verify runAsNonRoot without a runAsUser.
+		name:      "nonroot",
+		baseImage: "gcr.io/distroless/static:nonroot",
+		user:      "65532:65532",
+	},
+	{
+		// Has a busybox shell, so that it can be exec'd into when debugging.
+		name:      "debug",
+		baseImage: "gcr.io/distroless/static:debug",
+		user:      "65535:65535",
+	},
+	{
+		// Has the git and ssh commands, which Git sources are read with.
+		name:      "git",
+		baseImage: "alpine:3.16",
+		user:      "65534:65534", // alpine's "nobody"
+		run:       "apk add --no-cache git openssh-client",
+	},
+}
+
+func (v variant) String() string {
+	if v.name == "" {
+		return "default"
+	}
+	return v.name
+}
+
+// tag returns the tag suffixed with the variant's name.
+func (v variant) tag(tag string) string {
+	if v.name == "" {
+		return tag
+	}
+	return tag + "-" + v.name
+}
+
+// buildVariants returns the variants selected by VARIANTS.
+func buildVariants() ([]variant, error) {
+	s := os.Getenv("VARIANTS")
+	if s == "" {
+		return variants, nil
+	}
+	var vs []variant
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		i := 0
+		for i < len(variants) && variants[i].String() != name {
+			i++
+		}
+		if i == len(variants) {
+			return nil, fmt.Errorf("unknown image variant: %q", name)
+		}
+		vs = append(vs, variants[i])
+	}
+	return vs, nil
+}
+
+func archBaseImage(v variant, arch string) (string, error) {
+	if err := initBaseImages(v.baseImage); err != nil {
 		return "", err
 	}
-	img, ok := baseImages[arch]
+	img, ok := baseImages[v.baseImage][arch]
 	if !ok {
-		return "", fmt.Errorf("architecture %s not found in base image", arch)
+		return "", fmt.Errorf("architecture %s not found in base image %s", arch, v.baseImage)
 	}
 	return img, nil
 }
 
-func initBaseImages() error {
-	if baseImages != nil {
+func initBaseImages(baseImage string) error {
+	if baseImages[baseImage] != nil {
 		return nil
 	}
 	out, err := sh.Output("docker", "manifest", "inspect", baseImage)
@@ -76,13 +158,14 @@ func initBaseImages() error {
 		return err
 	}
 	name := strings.Split(baseImage, ":")[0]
-	baseImages = make(map[string]string)
+	images := make(map[string]string)
 	for _, m := range v.Manifests {
 		if m.Platform.OS != "linux" {
 			continue
 		}
-		baseImages[m.Platform.Arch] = fmt.Sprintf("%s@%s", name, m.Digest)
+		images[m.Platform.Arch] = fmt.Sprintf("%s@%s", name, m.Digest)
 	}
+	baseImages[baseImage] = images
 	return nil
 }
 
@@ -183,7 +266,8 @@ func Shell() error {
 	return cmd.Run()
 }
 
-// Runs tests.
+// Runs tests in a containerized test environment, and writes JUnit XML and
+// coverage reports to .mage/test-results.
 func Test() error {
 	testPath := cachePath("test")
 	if ok, err := shouldDo(testPath); !ok {
@@ -191,6 +275,14 @@ func Test() error {
 	}
 	mg.Deps(buildTestImg, mkBuildDirs)
 
+	dir := cachePath("test-results")
+	if err := rmDir(dir); err != nil {
+		return err
+	}
+	if err := mkDir(dir); err != nil {
+		return err
+	}
+
 	pwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -215,15 +307,321 @@ func Test() error {
 		"--env", "GOCACHE=/go/cache",
 		"--env", "HTTP_PROXY="+os.Getenv("HTTP_PROXY"),
 		"--env", "HTTPS_PROXY="+os.Getenv("HTTPS_PROXY"),
+		"--env", "TEST_RESULTS=/src/.mage/test-results",
 		testImage,
 		"/bin/sh", "-c", "/src/hack/test.sh cmd pkg",
 	)
-	if err := sh.RunV("docker", args...); err != nil {
+	testErr := sh.RunV("docker", args...)
+	if err := reportTests(dir, testErr); err != nil {
 		return err
 	}
 	return touchFile(testPath)
 }
 
+// Runs the tests of packages that don't need envtest natively, for quick
+// feedback, and writes JUnit XML and coverage reports to .mage/test-results/unit.
+func TestUnit() error {
+	pkgs, err := unitTestPackages()
+	if err != nil {
+		return err
+	}
+	dir := cachePath("test-results", "unit")
+	if err := rmDir(dir); err != nil {
+		return err
+	}
+	if err := mkDir(dir); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, "test.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	args := append([]string{"test", "-json", "-covermode=atomic", "-coverprofile=" + filepath.Join(dir, "coverage.out")}, pkgs...)
+	cmd := exec.Command("go", args...)
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=vendor")
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	testErr := cmd.Run()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return reportTests(dir, testErr)
+}
+
+// coverageThresholds are the minimum statement coverage, in percent, of
+// packages, which Test and TestUnit enforce when they're tested.
+var coverageThresholds = map[string]float64{
+	"pkg/audit":         85,
+	"pkg/backup":        70,
+	"pkg/convert":       90,
+	"pkg/crds":          85,
+	"pkg/genflags":      90,
+	"pkg/gitsource":     70,
+	"pkg/hooks":         70,
+	"pkg/internal/diff": 90,
+	"pkg/listen":        80,
+	"pkg/mzlog":         60,
+	"pkg/preflight":     90,
+	"pkg/preview":       60,
+	"pkg/render":        80,
+	"pkg/schema":        70,
+	"pkg/validation":    90,
+}
+
+// unitTestPackages returns the packages whose tests don't import envtest.
+func unitTestPackages() ([]string, error) {
+	out, err := sh.Output("go", "list", "-mod=vendor",
+		"-f", "{{.ImportPath}}{{range .TestImports}} {{.}}{{end}}{{range .XTestImports}} {{.}}{{end}}",
+		"./cmd/...", "./pkg/...")
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		envtest := false
+		for _, imp := range fields[1:] {
+			envtest = envtest || imp == "sigs.k8s.io/controller-runtime/pkg/envtest"
+		}
+		if !envtest {
+			pkgs = append(pkgs, fields[0])
+		}
+	}
+	return pkgs, nil
+}
+
+// reportTests writes a JUnit XML report of the test.json output of go test in
+// dir, prints the output of failed tests and the coverage of coverage.out, and
+// checks the coverageThresholds. It returns testErr, the error of running the
+// tests, if it isn't nil.
+func reportTests(dir string, testErr error) error {
+	buf, err := os.ReadFile(filepath.Join(dir, "test.json"))
+	if err != nil {
+		if testErr != nil {
+			return testErr
+		}
+		return err
+	}
+	suites, failures, err := junitReport(buf)
+	if err != nil {
+		return err
+	}
+	out, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, "junit.xml"), xml.Header+string(out)+"\n"); err != nil {
+		return err
+	}
+	for _, output := range failures {
+		fmt.Print(output)
+	}
+
+	coverage, err := packageCoverage(filepath.Join(dir, "coverage.out"))
+	if err != nil {
+		return err
+	}
+	var below []string
+	pkgs := make([]string, 0, len(coverage))
+	for pkg := range coverage {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		pct := coverage[pkg]
+		rel := strings.TrimPrefix(strings.TrimPrefix(pkg, repo), "/")
+		min, ok := coverageThresholds[rel]
+		status := ""
+		if ok && pct < min {
+			status = fmt.Sprintf(" (below %.1f%%)", min)
+			below = append(below, rel)
+		}
+		fmt.Printf("coverage: %5.1f%% %s%s\n", pct, rel, status)
+	}
+	if testErr != nil {
+		return testErr
+	}
+	if len(below) > 0 {
+		return fmt.Errorf("coverage below threshold: %s", strings.Join(below, ", "))
+	}
+	return nil
+}
+
+// A testEvent is an event of the JSON output of go test.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// junitReport returns the JUnit report of the JSON output of go test, and
+// the output of each failed test or package, in order.
+func junitReport(buf []byte) (*junitTestSuites, []string, error) {
+	type key struct{ pkg, test string }
+	var (
+		order   []key
+		events  = make(map[key]*testEvent)
+		outputs = make(map[key]*strings.Builder)
+	)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	for dec.More() {
+		var e testEvent
+		if err := dec.Decode(&e); err != nil {
+			return nil, nil, err
+		}
+		k := key{e.Package, e.Test}
+		if _, ok := outputs[k]; !ok {
+			order = append(order, k)
+			outputs[k] = &strings.Builder{}
+		}
+		switch e.Action {
+		case "output":
+			outputs[k].WriteString(e.Output)
+		case "pass", "fail", "skip":
+			e := e
+			events[k] = &e
+		}
+	}
+
+	seconds := func(s float64) string { return strconv.FormatFloat(s, 'f', 3, 64) }
+	suites := &junitTestSuites{}
+	index := make(map[string]int)
+	var failures []string
+	for _, k := range order {
+		e := events[k]
+		if k.test == "" {
+			if e != nil && e.Action == "fail" {
+				failures = append(failures, outputs[k].String())
+			}
+			continue
+		}
+		i, ok := index[k.pkg]
+		if !ok {
+			i = len(suites.Suites)
+			index[k.pkg] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: k.pkg})
+			if pe := events[key{k.pkg, ""}]; pe != nil {
+				suites.Suites[i].Time = seconds(pe.Elapsed)
+			}
+		}
+		suite := &suites.Suites[i]
+		tc := junitTestCase{Name: k.test, ClassName: k.pkg}
+		switch {
+		case e == nil: // E.g. a panic or timeout of the test binary.
+			tc.Failure = &junitMessage{Message: "Incomplete", Output: outputs[k].String()}
+			suite.Failures++
+		case e.Action == "fail":
+			tc.Failure = &junitMessage{Message: "Failed", Output: outputs[k].String()}
+			suite.Failures++
+			failures = append(failures, outputs[k].String())
+		case e.Action == "skip":
+			tc.Skipped = &junitMessage{Message: "Skipped", Output: outputs[k].String()}
+			suite.Skipped++
+		}
+		if e != nil {
+			tc.Time = seconds(e.Elapsed)
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+	}
+	return suites, failures, nil
+}
+
+// packageCoverage returns the statement coverage, in percent, of each
+// package of the coverage profile.
+func packageCoverage(profile string) (map[string]float64, error) {
+	f, err := os.Open(profile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Each block is "file:start,end statements count". Blocks may repeat
+	// if packages are tested together, so they're covered if any is.
+	type block struct {
+		stmts   int
+		covered bool
+	}
+	blocks := make(map[string]block)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid coverage profile line: %q", line)
+		}
+		stmts, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid coverage profile line: %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid coverage profile line: %q", line)
+		}
+		b := blocks[fields[0]]
+		b.stmts = stmts
+		b.covered = b.covered || count > 0
+		blocks[fields[0]] = b
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	total := make(map[string]int)
+	covered := make(map[string]int)
+	for pos, b := range blocks {
+		file := pos[:strings.LastIndex(pos, ":")]
+		pkg := path.Dir(file)
+		total[pkg] += b.stmts
+		if b.covered {
+			covered[pkg] += b.stmts
+		}
+	}
+	coverage := make(map[string]float64, len(total))
+	for pkg, n := range total {
+		if n > 0 {
+			coverage[pkg] = 100 * float64(covered[pkg]) / float64(n)
+		}
+	}
+	return coverage, nil
+}
+
 func mkBuildDirs() error {
 	if err := mkDir(cachePath("bin")); err != nil {
 		return err
@@ -242,7 +640,7 @@ func buildTestImg() error {
 	fmt.Printf("building kubebuilder test image\n")
 	mg.Deps(pullBuildImage)
 
-	dir, err := ioutil.TempDir("", "kubebuilder")
+	dir, err := os.MkdirTemp("", "kubebuilder")
 	if err != nil {
 		return err
 	}
@@ -303,6 +701,20 @@ func Bins() error {
 			),
 		)
 	}
+	if err := runBuildCmds(cmds); err != nil {
+		return err
+	}
+	for _, arch := range arches {
+		if err := os.Chtimes(binPath(arch), now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBuildCmds runs the shell commands in the build image, with the source
+// mounted at /src and the bin cache mounted at /go/bin.
+func runBuildCmds(cmds []string) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -332,15 +744,8 @@ func Bins() error {
 		"/bin/sh", "-c", strings.Join(cmds, " && "),
 	)
 	env := map[string]string{"$": "$"} // Escape hack: "$$LDFLAGS" becomes "$LDFLAGS"
-	if _, err := sh.Exec(env, os.Stdout, os.Stderr, "docker", args...); err != nil {
-		return err
-	}
-	for _, arch := range arches {
-		if err := os.Chtimes(binPath(arch), now, now); err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err = sh.Exec(env, os.Stdout, os.Stderr, "docker", args...)
+	return err
 }
 
 func buildinfoLDFlags(namesAndValues ...string) string {
@@ -359,27 +764,33 @@ func Imgs() error {
 		return err
 	}
 	mg.Deps(Bins, pullBaseImage)
-	fmt.Printf("building %s images from %s\n", manifest(), baseImage)
 
-	for _, arch := range arches {
-		if err := buildImg(arch); err != nil {
-			return err
+	vs, err := buildVariants()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		fmt.Printf("building %s images from %s\n", manifest(v), v.baseImage)
+		for _, arch := range arches {
+			if err := buildImg(v, arch); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func buildImg(arch string) error {
-	fmt.Printf("building image for linux/%s\n", arch)
+func buildImg(v variant, arch string) error {
+	fmt.Printf("building %s image for linux/%s\n", v, arch)
 
 	// Get architecture-specific base image
-	baseImage, err := archBaseImage(arch)
+	baseImage, err := archBaseImage(v, arch)
 	if err != nil {
 		return err
 	}
 
 	// Write temporary dockerfile
-	tmp, err := ioutil.TempFile("", arch)
+	tmp, err := os.CreateTemp("", v.tag(arch))
 	if err != nil {
 		return err
 	}
@@ -394,9 +805,13 @@ func buildImg(arch string) error {
 	fmt.Fprintf(buf, " version=%s", trg.Version())
 	fmt.Fprintf(buf, " revision=%s", trg.Revision())
 	fmt.Fprintf(buf, " branch=%s", trg.Branch())
+	fmt.Fprintf(buf, " variant=%s", v)
 	fmt.Fprintf(buf, "\n")
+	if v.run != "" {
+		fmt.Fprintf(buf, "RUN %s\n", v.run)
+	}
 	fmt.Fprintf(buf, "ADD %s /%s\n", trg.Name(), trg.Name())
-	fmt.Fprintf(buf, "USER 65535:65535\n") // distroless doesn't have "nobody"
+	fmt.Fprintf(buf, "USER %s\n", v.user)
 	fmt.Fprintf(buf, "ENTRYPOINT [%q]\n", "/"+trg.Name())
 	if err := buf.Flush(); err != nil {
 		return err
@@ -413,7 +828,7 @@ func buildImg(arch string) error {
 		return err
 	}
 
-	tag := image(arch)
+	tag := image(v, arch)
 	err = sh.Run(
 		"docker",
 		"build",
@@ -439,15 +854,31 @@ func Push() error {
 	}
 	mg.Deps(Imgs)
 
-	base := manifest()
+	vs, err := buildVariants()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if err := pushImg(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pushImg(v variant) error {
+	base := manifest(v)
+	if ok, err := shouldDo(imagePushPath(base)); !ok {
+		return err
+	}
 	fmt.Printf("pushing %s images\n", base)
 
 	// push images
 	var tags []string
 	for _, arch := range arches {
-		fmt.Printf("pushing image for linux/%s\n", arch)
-		src := image(arch)
-		tag := archTag(arch)
+		fmt.Printf("pushing %s image for linux/%s\n", v, arch)
+		src := image(v, arch)
+		tag := archTag(v, arch)
 		if err := sh.Run("docker", "tag", src, tag); err != nil {
 			return err
 		}
@@ -494,8 +925,209 @@ func Push() error {
 	return writeFile(imagePushPath(base), out)
 }
 
+// CLI binaries released for each platform.
+var releasePlatforms = []string{
+	"darwin/amd64",
+	"darwin/arm64",
+	"linux/amd64",
+	"linux/arm64",
+	"windows/amd64",
+}
+
+var (
+	releaseVersion   = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?$`)
+	conventionalType = regexp.MustCompile(`^([a-z]+)(\([^)]*\))?(!)?: (.+)$`)
+)
+
+// Tags, builds, pushes, and signs a release, e.g. VERSION=v1.2.3 mage release.
+func Release() error {
+	version := os.Getenv("VERSION")
+	if !releaseVersion.MatchString(version) {
+		return fmt.Errorf("VERSION must be a semantic version, e.g. v1.2.3: %q", version)
+	}
+	// Tag before anything reads the version from the repo.
+	if err := tagRelease(version); err != nil {
+		return err
+	}
+	mg.Deps(Push)
+	mg.SerialDeps(signImgs, releaseBins, releaseNotes)
+	fmt.Printf("release %s artifacts are in %s\n", version, releaseDir())
+	return nil
+}
+
+// tagRelease tags HEAD with the version, unless it's already tagged,
+// e.g. when run by CI for a pushed tag.
+func tagRelease(version string) error {
+	head, err := sh.Output("git", "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return err
+	}
+	if rev, err := sh.Output("git", "rev-parse", "--verify", "--quiet", version+"^{commit}"); err == nil {
+		if rev != head {
+			return fmt.Errorf("tag %s exists at %s, not HEAD", version, rev)
+		}
+		return nil
+	}
+	if out, err := sh.Output("git", "status", "--porcelain"); err != nil || out != "" {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unable to tag %s: working tree is dirty", version)
+	}
+	fmt.Printf("tagging %s\n", version)
+	return sh.Run("git", "tag", "--annotate", "--message", version, version)
+}
+
+// signImgs signs the pushed images with cosign, with the key in COSIGN_KEY,
+// or keyless if it's unset, e.g. with CI's OIDC identity.
+func signImgs() error {
+	vs, err := buildVariants()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		img := manifest(v)
+		fmt.Printf("signing %s\n", img)
+		args := []string{"sign", "--yes"}
+		if key := os.Getenv("COSIGN_KEY"); key != "" {
+			args = append(args, "--key", key)
+		}
+		if err := sh.RunV("cosign", append(args, img)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseBins builds the CLI binaries for each platform and writes their
+// checksums, which are signed with cosign.
+func releaseBins() error {
+	dir := releaseDir()
+	if err := mkDir(dir); err != nil {
+		return err
+	}
+	mg.Deps(pullBuildImage, mkBuildDirs)
+
+	cmds := []string{
+		fmt.Sprintf("export LDFLAGS=%q", buildinfoLDFlags(
+			"binary", "cmsctl",
+			"version", trg.Version(),
+			"repo", trg.Repo(),
+			"branch", trg.Branch(),
+			"revision", trg.Revision(),
+			"buildUnix", strconv.FormatInt(time.Now().Unix(), 10),
+		)),
+	}
+	var names []string
+	for _, platform := range releasePlatforms {
+		goos, goarch, _ := strings.Cut(platform, "/")
+		name := fmt.Sprintf("cmsctl_%s_%s_%s", trg.Version(), goos, goarch)
+		if goos == "windows" {
+			name += ".exe"
+		}
+		names = append(names, name)
+		cmds = append(cmds,
+			fmt.Sprintf(`echo "building cmsctl for %s"`, platform),
+			fmt.Sprintf(
+				`GOOS=%s GOARCH=%s go build -mod vendor -ldflags "$${LDFLAGS}" -o %q ./cmd/cmsctl`,
+				goos, goarch, "/go/bin/release/"+trg.Version()+"/"+name,
+			),
+		)
+	}
+	if err := runBuildCmds(cmds); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%x  %s\n", sha256.Sum256(b), name)
+	}
+	sums := filepath.Join(dir, "checksums.txt")
+	if err := os.WriteFile(sums, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	args := []string{"sign-blob", "--yes", "--output-signature", sums + ".sig", "--output-certificate", sums + ".pem"}
+	if key := os.Getenv("COSIGN_KEY"); key != "" {
+		args = append(args, "--key", key)
+	}
+	return sh.RunV("cosign", append(args, sums)...)
+}
+
+// releaseNotes drafts the release notes from the conventional commits since
+// the previous release.
+func releaseNotes() error {
+	version := trg.Version()
+	rng := version
+	if prev, err := sh.Output("git", "describe", "--tags", "--abbrev=0", "--match", "v*", version+"^"); err == nil {
+		rng = prev + ".." + version
+	}
+	out, err := sh.Output("git", "log", "--no-merges", "--format=%s%x00%b%x00", rng)
+	if err != nil {
+		return err
+	}
+	notes := formatReleaseNotes(version, strings.Split(out, "\x00"))
+	return os.WriteFile(filepath.Join(releaseDir(), "notes.md"), []byte(notes), 0o644)
+}
+
+// formatReleaseNotes formats the notes from commits' alternating subjects
+// and bodies. Commits other than features, fixes, and performance
+// improvements are omitted, unless they're breaking changes.
+func formatReleaseNotes(version string, log []string) string {
+	sections := []struct {
+		title string
+		types []string
+		items []string
+	}{
+		{title: "Breaking Changes"},
+		{title: "Features", types: []string{"feat"}},
+		{title: "Bug Fixes", types: []string{"fix"}},
+		{title: "Performance", types: []string{"perf"}},
+	}
+	for i := 0; i+1 < len(log); i += 2 {
+		subject, body := strings.TrimSpace(log[i]), log[i+1]
+		m := conventionalType.FindStringSubmatch(subject)
+		if m == nil {
+			continue
+		}
+		item := m[4]
+		if scope := strings.Trim(m[2], "()"); scope != "" {
+			item = "**" + scope + ":** " + item
+		}
+		if m[3] != "" || strings.Contains(body, "BREAKING CHANGE:") {
+			sections[0].items = append(sections[0].items, item)
+			continue
+		}
+		for j := range sections {
+			for _, typ := range sections[j].types {
+				if typ == m[1] {
+					sections[j].items = append(sections[j].items, item)
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", version)
+	for _, s := range sections {
+		if len(s.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", s.title)
+		for _, item := range s.items {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+	}
+	return b.String()
+}
+
+func releaseDir() string { return cachePath("bin", "release", trg.Version()) }
+
 func Generate() error {
-	mg.Deps(generateCode, generateCDRs, generateRBAC, generateDocs)
+	mg.Deps(generateCode, generateCDRs, generateSchema, generateRBAC, generateDocs, generateDeployment, generateTenant)
 	return nil
 }
 
@@ -503,87 +1135,385 @@ func generateCode() error {
 	return sh.Run("controller-gen", "object:headerFile=./hack/boilerplate.go.txt", "paths=./pkg/api/...")
 }
 
+// Verifies that generated artifacts are up to date, printing any drift as JSON.
+func Verify() error {
+	var drifts []*genapi.Drift
+	for _, a := range []struct {
+		path string
+		gen  func() (string, error)
+	}{
+		{"manifest/customresourcedefinition.yaml", crdManifest},
+		{"docs/configmapsecret.schema.json", jsonSchema},
+		{"manifest/roles.yaml", rbacManifest},
+		{"manifest/deployment.yaml", deploymentManifest},
+		{"manifest/tenant/roles.yaml", tenantRBACManifest},
+		{"manifest/tenant/deployment.yaml", tenantDeploymentManifest},
+		{"docs/api.md", apiDocs},
+		{"docs/flags.md", flagDocs},
+	} {
+		out, err := a.gen()
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(out, "\n") { // Match writeLine.
+			out += "\n"
+		}
+		drift, err := genapi.Verify(a.path, []byte(out))
+		if err != nil {
+			return err
+		}
+		if drift != nil {
+			drifts = append(drifts, drift)
+		}
+	}
+	if len(drifts) == 0 {
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(drifts); err != nil {
+		return err
+	}
+	return mg.Fatalf(1, "%d generated artifacts are stale, run: mage generate", len(drifts))
+}
+
 func generateCDRs() error {
-	out, err := sh.Output("controller-gen", "crd:crdVersions=v1", "paths=./pkg/...", "output:stdout")
+	out, err := crdManifest()
 	if err != nil {
 		return err
 	}
 	return writeFile("manifest/customresourcedefinition.yaml", out)
 }
 
-func generateRBAC() error {
-	out, err := sh.Output("controller-gen", "rbac:roleName=configmapsecret-controller", "paths=./cmd/...;./pkg/...", "output:stdout")
+func generateSchema() error {
+	out, err := jsonSchema()
 	if err != nil {
 		return err
 	}
-	return writeFile("manifest/roles.yaml", out)
+	return writeFile("docs/configmapsecret.schema.json", out)
 }
 
-func generateDocs() error {
-	path, err := genapiCode("github.com/machinezone/configmapsecrets/pkg/api/v1alpha1")
+func jsonSchema() (string, error) {
+	crd, err := crdManifest()
+	if err != nil {
+		return "", err
+	}
+	buf, err := schema.FromCRD([]byte(crd), "v1alpha1")
+	return string(buf), err
+}
+
+func crdManifest() (string, error) {
+	return sh.Output("controller-gen", "crd:crdVersions=v1", "paths=./pkg/...", "output:stdout")
+}
+
+func generateRBAC() error {
+	out, err := rbacManifest()
 	if err != nil {
 		return err
 	}
+	return writeFile("manifest/roles.yaml", out)
+}
 
+func rbacManifest() (string, error) {
+	return sh.Output("controller-gen", "rbac:roleName=configmapsecret-controller", "paths=./cmd/...;./pkg/...", "output:stdout")
+}
+
+func generateDocs() error {
 	mg.Deps(generateCode)
-	out, err := sh.Output(mg.GoCmd(), "run", path)
+	out, err := apiDocs()
 	if err != nil {
 		return err
 	}
-	return writeFile("docs/api.md", out)
+	if err := writeFile("docs/api.md", out); err != nil {
+		return err
+	}
+	if out, err = flagDocs(); err != nil {
+		return err
+	}
+	return writeFile("docs/flags.md", out)
 }
 
-func genapiCode(pkg string) (string, error) {
-	hash := fnv.New64a()
-	hash.Write([]byte(pkg))
-	base := fmt.Sprintf("main_%0X.go", hash.Sum64())
-	path := cachePath("docs", base)
-	if ok, err := shouldDo(path); !ok {
-		return path, err
-	}
+func flagDocs() (string, error) {
+	return sh.Output(mg.GoCmd(), "run", "./cmd/configmapsecret-controller", "flags")
+}
 
-	buf := bytes.NewBuffer(nil)
-	if err := genapiTmpl.Execute(buf, pkg); err != nil {
-		return "", err
-	}
-	return path, writeFile(path, buf.String())
+func apiDocs() (string, error) {
+	return sh.Output(mg.GoCmd(), "run", "./cmd/genapi", "markdown", "-pkg", "github.com/machinezone/configmapsecrets/pkg/api/v1alpha1")
 }
 
-var genapiTmpl = template.Must(template.New("name").Parse(`
-package main
+// deploymentConfig configures the generated Deployment manifest.
+type deploymentConfig struct {
+	Name      string
+	Namespace string
+	Image     string
+	Replicas  int
+	Args      []string
+
+	HealthPort  int
+	MetricsPort int
+
+	// PodDisruptionBudget
+	MaxUnavailable int
+
+	// Resources
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+
+	// Security
+	RunAsUser              int
+	ReadOnlyRootFilesystem bool
+	SeccompProfile         string
+
+	// Topology spread across nodes
+	TopologyKey       string
+	MaxSkew           int
+	WhenUnsatisfiable string
+}
 
-import (
-	"bytes"
-	"fmt"
-	"io"
-	"os"
+var deployment = deploymentConfig{
+	Name:      name,
+	Namespace: "kube-system",
+	Image:     "mzinc/" + name + ":v0.5.1",
+	Replicas:  1,
+	Args:      []string{"--enable-leader-election"},
 
-	"github.com/machinezone/configmapsecrets/pkg/genapi"
-	"k8s.io/apimachinery/pkg/runtime"
+	HealthPort:  9090,
+	MetricsPort: 9091,
 
-	api "{{ . }}"
-)
+	MaxUnavailable: 1,
+
+	CPURequest:    "100m",
+	MemoryRequest: "50Mi",
+	CPULimit:      "100m",
+	MemoryLimit:   "50Mi",
+
+	RunAsUser:              65534,
+	ReadOnlyRootFilesystem: true,
+	SeccompProfile:         "RuntimeDefault",
 
-func main() {
-	pkg, err := genapi.ParsePackage("{{ . }}")
-	check(err)
+	TopologyKey:       "kubernetes.io/hostname",
+	MaxSkew:           1,
+	WhenUnsatisfiable: "ScheduleAnyway",
+}
+
+func generateDeployment() error {
+	out, err := deploymentManifest()
+	if err != nil {
+		return err
+	}
+	return writeFile("manifest/deployment.yaml", out)
+}
 
-	scheme := runtime.NewScheme()
-	check(api.AddToScheme(scheme))
+func deploymentManifest() (string, error) {
+	return renderDeployment(deployment)
+}
 
+func renderDeployment(cfg deploymentConfig) (string, error) {
 	buf := bytes.NewBuffer(nil)
-	check(genapi.WriteMarkdown(buf, pkg, genapi.WithScheme(scheme)))
+	if err := deploymentTmpl.Execute(buf, cfg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
 
-	_, err = io.Copy(os.Stdout, buf)
-	check(err)
+// tenantDeployment configures the Deployment of tenant mode, in which the
+// controller manages only its own namespace and has no cluster roles.
+var tenantDeployment = func() deploymentConfig {
+	cfg := deployment
+	cfg.Namespace = "default"
+	cfg.Args = []string{"--tenant-mode", "--enable-leader-election"}
+	return cfg
+}()
+
+func generateTenant() error {
+	out, err := tenantRBACManifest()
+	if err != nil {
+		return err
+	}
+	if err := writeFile("manifest/tenant/roles.yaml", out); err != nil {
+		return err
+	}
+	out, err = tenantDeploymentManifest()
+	if err != nil {
+		return err
+	}
+	return writeFile("manifest/tenant/deployment.yaml", out)
+}
+
+func tenantDeploymentManifest() (string, error) {
+	return renderDeployment(tenantDeployment)
 }
 
-func check(err error) {
+func tenantRBACManifest() (string, error) {
+	out, err := rbacManifest()
 	if err != nil {
-		fmt.Fprint(os.Stderr, err)
-		os.Exit(1)
+		return "", err
+	}
+	return tenantRBAC(out, tenantDeployment.Namespace)
+}
+
+// clusterScopedGroups are the API groups of the cluster-scoped resources used
+// by the controller outside of tenant mode.
+var clusterScopedGroups = map[string]bool{
+	"apiextensions.k8s.io":  true,
+	"authentication.k8s.io": true,
+	"authorization.k8s.io":  true,
+	"certificates.k8s.io":   true,
+}
+
+// tenantRBAC merges the rules of the ClusterRole and Roles in the generated
+// RBAC manifest into a single Role in the namespace, and returns a manifest
+// containing it and the ServiceAccount and RoleBinding to which it's bound.
+func tenantRBAC(manifest, namespace string) (string, error) {
+	var rules []rbacv1.PolicyRule
+	for _, doc := range strings.Split(manifest, "---\n") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var role rbacv1.ClusterRole // Roles have the same rules.
+		if err := yaml.Unmarshal([]byte(doc), &role); err != nil {
+			return "", err
+		}
+		for _, rule := range role.Rules {
+			if len(rule.APIGroups) == 1 && clusterScopedGroups[rule.APIGroups[0]] {
+				continue // Cluster-scoped, so a Role can't grant it.
+			}
+			rules = append(rules, rule)
+		}
 	}
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    map[string]string{"control-plane": name},
+	}
+	objs := []interface{}{
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: meta,
+		},
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: meta,
+			Rules:      rules,
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: meta,
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     name,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      name,
+				Namespace: namespace,
+			}},
+		},
+	}
+	var sb strings.Builder
+	for _, obj := range objs {
+		buf, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString("---\n")
+		sb.Write(buf)
+	}
+	return sb.String(), nil
 }
+
+var deploymentTmpl = template.Must(template.New("deployment").Parse(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    control-plane: {{ .Name }}
+spec:
+  replicas: {{ .Replicas }}
+  selector:
+    matchLabels:
+      control-plane: {{ .Name }}
+  template:
+    metadata:
+      labels:
+        control-plane: {{ .Name }}
+    spec:
+      containers:
+        - name: controller
+          image: {{ .Image }}
+          imagePullPolicy: Always
+          command:
+            - /{{ .Name }}
+            - --health-addr=:{{ .HealthPort }}
+            - --metrics-addr=:{{ .MetricsPort }}
+{{- range .Args }}
+            - {{ . }}
+{{- end }}
+          ports:
+            - name: http-health
+              containerPort: {{ .HealthPort }}
+            - name: http-metrics
+              containerPort: {{ .MetricsPort }}
+          livenessProbe:
+            httpGet:
+              path: /healthz
+              port: http-health
+          readinessProbe:
+            httpGet:
+              path: /readyz
+              port: http-health
+          resources:
+            limits:
+              cpu: {{ .CPULimit }}
+              memory: {{ .MemoryLimit }}
+            requests:
+              cpu: {{ .CPURequest }}
+              memory: {{ .MemoryRequest }}
+          securityContext:
+            allowPrivilegeEscalation: false
+            readOnlyRootFilesystem: {{ .ReadOnlyRootFilesystem }}
+            capabilities:
+              drop:
+                - ALL
+{{- if .ReadOnlyRootFilesystem }}
+          volumeMounts:
+            # Git sources are fetched to temporary directories.
+            - name: tmp
+              mountPath: /tmp
+      volumes:
+        - name: tmp
+          emptyDir: {}
+{{- end }}
+      securityContext:
+        runAsNonRoot: true
+        runAsUser: {{ .RunAsUser }}
+        seccompProfile:
+          type: {{ .SeccompProfile }}
+      topologySpreadConstraints:
+        - maxSkew: {{ .MaxSkew }}
+          topologyKey: {{ .TopologyKey }}
+          whenUnsatisfiable: {{ .WhenUnsatisfiable }}
+          labelSelector:
+            matchLabels:
+              control-plane: {{ .Name }}
+      serviceAccountName: {{ .Name }}
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    control-plane: {{ .Name }}
+spec:
+  maxUnavailable: {{ .MaxUnavailable }}
+  selector:
+    matchLabels:
+      control-plane: {{ .Name }}
 `))
 
 // Removes build artifacts.
@@ -603,7 +1533,7 @@ func Clean() error {
 
 func imageIDs() ([]string, error) {
 	dir := imageBuildPath("")
-	infos, err := ioutil.ReadDir(dir)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -611,11 +1541,11 @@ func imageIDs() ([]string, error) {
 		return nil, err
 	}
 	set := make(map[string]bool)
-	for _, info := range infos {
-		if info.IsDir() {
+	for _, entry := range entries {
+		if entry.IsDir() {
 			continue
 		}
-		buf, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		buf, err := os.ReadFile(filepath.Join(dir, entry.Name()))
 		if err != nil {
 			return nil, err
 		}
@@ -632,35 +1562,47 @@ func imageIDs() ([]string, error) {
 	return ids, nil
 }
 
-// Prints image.
+// Prints images.
 func Image() error {
-	fmt.Println(manifest())
+	vs, err := buildVariants()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		fmt.Println(manifest(v))
+	}
 	return nil
 }
 
-func manifest() string {
-	return fmt.Sprintf("%s/%s:%s", trg.Registry(), trg.Name(), trg.Version())
+func manifest(v variant) string {
+	return fmt.Sprintf("%s/%s:%s", trg.Registry(), trg.Name(), v.tag(trg.Version()))
 }
 
-func archTag(arch string) string {
-	return fmt.Sprintf("%s/%s:__unstable__linux_%s", trg.Registry(), trg.Name(), arch)
+func archTag(v variant, arch string) string {
+	return fmt.Sprintf("%s/%s:__unstable__linux_%s", trg.Registry(), trg.Name(), v.tag(arch))
 }
 
-func image(arch string) string {
-	return manifest() + "__linux_" + arch
+func image(v variant, arch string) string {
+	return manifest(v) + "__linux_" + arch
 }
 
 func pullBuildImage() error { return pullImage(buildImage) }
 
 func pullBaseImage() error {
-	for _, arch := range arches {
-		// Get architecture-specific base image
-		baseImage, err := archBaseImage(arch)
-		if err != nil {
-			return err
-		}
-		if err := pullImage(baseImage); err != nil {
-			return err
+	vs, err := buildVariants()
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		for _, arch := range arches {
+			// Get architecture-specific base image
+			baseImage, err := archBaseImage(v, arch)
+			if err != nil {
+				return err
+			}
+			if err := pullImage(baseImage); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -707,15 +1649,29 @@ func shouldDoBins() (bool, error) {
 }
 
 func shouldDoImgs() (bool, error) {
+	vs, err := buildVariants()
+	if err != nil {
+		return false, err
+	}
 	var dsts []string
-	for _, arch := range arches {
-		dsts = append(dsts, imageBuildPath(arch))
+	for _, v := range vs {
+		for _, arch := range arches {
+			dsts = append(dsts, imageBuildPath(image(v, arch)))
+		}
 	}
 	return shouldDo(dsts...)
 }
 
 func shouldDoPush() (bool, error) {
-	return shouldDo(imagePushPath(manifest()))
+	vs, err := buildVariants()
+	if err != nil {
+		return false, err
+	}
+	var dsts []string
+	for _, v := range vs {
+		dsts = append(dsts, imagePushPath(manifest(v)))
+	}
+	return shouldDo(dsts...)
 }
 
 func shouldDo(dsts ...string) (bool, error) {
@@ -0,0 +1,49 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package manifest embeds the generated manifests with which the controller
+// is installed, so a build carries the CRD and RBAC it was generated with.
+package manifest
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"path"
+)
+
+// CRD is the YAML-encoded ConfigMapSecret CustomResourceDefinition.
+//
+//go:embed customresourcedefinition.yaml
+var CRD []byte
+
+// FS contains the manifests of the default install in its root directory
+// and those of tenant mode in the tenant directory.
+//
+//go:embed *.yaml tenant/*.yaml
+var FS embed.FS
+
+// Bundle returns the manifests in the given directory of FS as a single
+// multi-document YAML stream, in the order applied by `kubectl apply -f dir/*.yaml`.
+func Bundle(dir string) ([]byte, error) {
+	entries, err := fs.ReadDir(FS, dir)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".yaml" {
+			continue
+		}
+		b, err := fs.ReadFile(FS, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(b, []byte("---\n")) {
+			buf.WriteString("---\n")
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestBundle(t *testing.T) {
+	for _, tt := range []struct {
+		dir   string
+		kinds []string
+	}{
+		{".", []string{"CustomResourceDefinition", "Deployment", "ClusterRoleBinding", "ClusterRole", "Role", "Service", "ServiceAccount"}},
+		{"tenant", []string{"Deployment", "ServiceAccount", "Role", "RoleBinding"}},
+	} {
+		buf, err := Bundle(tt.dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var kinds []string
+		for _, doc := range bytes.Split(buf, []byte("---\n")) {
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			var obj struct{ Kind string }
+			if err := yaml.Unmarshal(doc, &obj); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			kinds = append(kinds, obj.Kind)
+		}
+		for _, kind := range tt.kinds {
+			if !strings.Contains(" "+strings.Join(kinds, " ")+" ", " "+kind+" ") {
+				t.Errorf("bundle %q is missing a %s; got: %v", tt.dir, kind, kinds)
+			}
+		}
+	}
+}
+
+func TestWritableTmp(t *testing.T) {
+	for _, dir := range []string{".", "tenant"} {
+		buf, err := fs.ReadFile(FS, path.Join(dir, "deployment.yaml"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var deploy appsv1.Deployment
+		if err := yaml.Unmarshal(bytes.Split(buf, []byte("---\n"))[0], &deploy); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		spec := deploy.Spec.Template.Spec
+		emptyDirs := make(map[string]bool)
+		for _, v := range spec.Volumes {
+			emptyDirs[v.Name] = v.EmptyDir != nil
+		}
+		for _, c := range spec.Containers {
+			if sc := c.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+				continue
+			}
+			// Git sources are fetched to temporary directories.
+			writable := false
+			for _, m := range c.VolumeMounts {
+				writable = writable || m.MountPath == "/tmp" && emptyDirs[m.Name] && !m.ReadOnly
+			}
+			if !writable {
+				t.Errorf("deployment %q: container %s has a read-only root without a writable /tmp", dir, c.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v1alpha1
+
+// Prefix is the prefix of the annotations and labels of the controller.
+const Prefix = "secrets.mz.com/"
+
+// Annotations of ConfigMapSecrets, which configure how the controller
+// reconciles them.
+const (
+	// DebugAnnotation is the annotation on a ConfigMapSecret that, when set to
+	// "true", enables render traces for it. Traces are logged and recorded as events.
+	// They contain the names and sources of variables, but never their values.
+	DebugAnnotation = Prefix + "debug"
+
+	// TakeoverAnnotation is the annotation on a ConfigMapSecret that, when set to
+	// "true", allows it to take ownership of a Secret of the same name owned by
+	// another ConfigMapSecret, unless the other also has the annotation. The
+	// previous owner stops writing the Secret and reports that it's blocked.
+	TakeoverAnnotation = Prefix + "takeover"
+
+	// CriticalAnnotation is the annotation on a ConfigMapSecret that, when set to
+	// "true", exempts it from the controller's blackout windows, so that its
+	// Secret is updated during them, e.g. to rotate a compromised credential.
+	CriticalAnnotation = Prefix + "critical"
+)
+
+// Annotations and labels of Secrets and events, which are set by the
+// controller.
+const (
+	// KeyOptionsAnnotation is the annotation on a generated Secret whose value
+	// is a JSON object mapping keys to their KeyOptions.
+	KeyOptionsAnnotation = Prefix + "key-options"
+
+	// LastAppliedHashAnnotation is the annotation on a generated Secret whose
+	// value is the hex-encoded SHA-256 hash of its data, if the controller is
+	// configured to set it.
+	LastAppliedHashAnnotation = Prefix + "last-applied-hash"
+
+	// LastSyncTimeAnnotation is the annotation on a generated Secret whose value
+	// is the RFC 3339 time at which its data was last written, if the controller
+	// is configured to set it.
+	LastSyncTimeAnnotation = Prefix + "last-sync-time"
+
+	// SupersededTimeAnnotation is the annotation on a Secret whose value is the
+	// RFC 3339 time at which the ConfigMapSecret that rendered it started rendering
+	// a Secret with another name. It's kept until its migration grace period ends.
+	SupersededTimeAnnotation = Prefix + "superseded-time"
+
+	// OwnerUIDLabel is the label on a Secret generated by a ConfigMapSecret that
+	// doesn't manage its ownership, whose value is the UID of the ConfigMapSecret.
+	OwnerUIDLabel = Prefix + "owner-uid"
+
+	// OwnerNameAnnotation is the annotation on a Secret generated by a
+	// ConfigMapSecret that doesn't manage its ownership, whose value is the name
+	// of the ConfigMapSecret.
+	OwnerNameAnnotation = Prefix + "owner-name"
+
+	// ReconcileIDAnnotation is the annotation on events emitted by the
+	// controller, whose value is the ID of the reconciliation that emitted them.
+	ReconcileIDAnnotation = Prefix + "reconcile-id"
+)
+
+// VeleroExcludeFromBackupLabel is the label by which Velero excludes a resource
+// from backups if its value is "true". It's the default backup exclusion label.
+const VeleroExcludeFromBackupLabel = "velero.io/exclude-from-backup"
@@ -5,6 +5,8 @@
 package v1alpha1
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -15,6 +17,12 @@ func init() {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cms;categories=all
+// +kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.template.metadata.name`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Render Failure",type=string,JSONPath=`.status.conditions[?(@.type=="RenderFailure")].status`
+// +kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ConfigMapSecret holds configuration data with embedded secrets.
 type ConfigMapSecret struct {
@@ -56,8 +64,71 @@ type ConfigMapSecretSpec struct {
 	// in the input data will be unchanged. The $(VAR_NAME) syntax can be escaped
 	// with a double $$, ie: $$(VAR_NAME). Escaped references will never be expanded,
 	// regardless of whether the variable exists or not.
+	//
+	// The syntax of template data depends on the TemplateVersion.
 	Template ConfigMapTemplate `json:"template,omitempty"`
 
+	// GitSource, if set, is a file or directory of a Git repository whose files
+	// are templates of keys of the Secret, e.g. to manage them with GitOps
+	// while their variables come from the cluster. Keys of the Template take
+	// precedence over files with the same name.
+	GitSource *GitSource `json:"gitSource,omitempty"`
+
+	// TemplateVersion is the version of the template language of the data in
+	// the Template. Variable values always use $(VAR_NAME) expansion.
+	//
+	// - v1 (the default) expands $(VAR_NAME) references, as described above.
+	//
+	// - v2 renders Go templates (https://pkg.go.dev/text/template), in which
+	// variables are fields of dot, e.g. {{ .VAR_NAME }}. A reference to a variable
+	// that cannot be resolved is a render failure. Templates may call a subset
+	// of the Sprig function library (https://masterminds.github.io/sprig/),
+	// such as default, quote, splitList, and toJson.
+	//
+	// +kubebuilder:validation:Enum=v1;v2
+	TemplateVersion TemplateVersion `json:"templateVersion,omitempty"`
+
+	// DisableExpansion copies the template data to the Secret literally,
+	// without expanding variables or reading any sources, and is the fast
+	// path for Secrets that only need to be owned by a ConfigMapSecret.
+	// The TemplateVersion is ignored, and Vars and VarsFrom must be empty.
+	DisableExpansion bool `json:"disableExpansion,omitempty"`
+
+	// RefreshInterval, if set, is the interval at which the Secret is rendered
+	// again even if neither its sources nor the ConfigMapSecret changed, e.g. to
+	// pick up values that change over time. It must be at least one minute.
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// ManageOwnership, if false, writes the Secret without an owner reference,
+	// so it isn't garbage collected with the ConfigMapSecret, e.g. for consumers
+	// that copy Secrets across namespaces. The Secret is tracked by the
+	// secrets.mz.com/owner-uid label and secrets.mz.com/owner-name annotation
+	// instead. Defaults to true.
+	ManageOwnership *bool `json:"manageOwnership,omitempty"`
+
+	// Provenance, if true, writes a ConfigMap named after the Secret with the
+	// suffix "-provenance", which maps each key of the Secret to a JSON list of
+	// the sources of the variables it uses, e.g. "Secret/db[PASSWORD]", without
+	// their values. It's deleted when Provenance is disabled.
+	Provenance bool `json:"provenance,omitempty"`
+
+	// BackupPolicy is whether backup tools should back up the Secret, which can
+	// usually be rendered again from its sources.
+	//
+	// - Exclude labels the Secret with the controller's backup exclusion label,
+	// velero.io/exclude-from-backup=true by default.
+	//
+	// - Include removes the backup exclusion label, e.g. if it's inherited or a
+	// default.
+	//
+	// If unset, the labels of the Secret aren't changed.
+	//
+	// +kubebuilder:validation:Enum=Include;Exclude
+	BackupPolicy BackupPolicy `json:"backupPolicy,omitempty"`
+
+	// Migration configures how the Secret is migrated when its name changes.
+	Migration *Migration `json:"migration,omitempty"`
+
 	// List of sources to populate template variables.
 	// Keys defined in a source must consist of alphanumeric characters, '-', '_' or '.'.
 	// When a key exists in multiple sources, the value associated with the last
@@ -65,10 +136,131 @@ type ConfigMapSecretSpec struct {
 	// will take precedence.
 	VarsFrom []VarsFromSource `json:"varsFrom,omitempty"`
 
+	// InvalidKeyPolicy is what to do with keys of VarsFrom sources that aren't
+	// valid variable names.
+	//
+	// - Skip (the default) skips them and records an event.
+	//
+	// - Error fails rendering.
+	//
+	// - Sanitize replaces invalid characters with '_', and prepends '_' to a
+	// leading digit. Valid keys take precedence over sanitized keys with the same
+	// name, and sanitized keys are otherwise applied in sorted order.
+	//
+	// +kubebuilder:validation:Enum=Skip;Error;Sanitize
+	InvalidKeyPolicy InvalidKeyPolicy `json:"invalidKeyPolicy,omitempty"`
+
 	// List of template variables.
+	//
+	// +kubebuilder:validation:XValidation:rule="size(self) <= 256",message="must have at most 256 vars"
 	Vars []Var `json:"vars,omitempty"`
 }
 
+// TemplateVersion is the version of a template language.
+type TemplateVersion string
+
+const (
+	// TemplateVersionV1 expands $(VAR_NAME) references.
+	TemplateVersionV1 TemplateVersion = "v1"
+	// TemplateVersionV2 renders Go templates.
+	TemplateVersionV2 TemplateVersion = "v2"
+)
+
+// InvalidKeyPolicy is what to do with source keys that aren't valid variable names.
+type InvalidKeyPolicy string
+
+const (
+	// InvalidKeyPolicySkip skips invalid keys.
+	InvalidKeyPolicySkip InvalidKeyPolicy = "Skip"
+	// InvalidKeyPolicyError fails rendering if a key is invalid.
+	InvalidKeyPolicyError InvalidKeyPolicy = "Error"
+	// InvalidKeyPolicySanitize replaces invalid characters of keys with '_'.
+	InvalidKeyPolicySanitize InvalidKeyPolicy = "Sanitize"
+)
+
+// BackupPolicy is whether backup tools should back up a rendered Secret.
+type BackupPolicy string
+
+const (
+	// BackupPolicyInclude removes the backup exclusion label.
+	BackupPolicyInclude BackupPolicy = "Include"
+	// BackupPolicyExclude sets the backup exclusion label.
+	BackupPolicyExclude BackupPolicy = "Exclude"
+)
+
+// Migration configures how a Secret is migrated when its name changes, e.g.
+// when the name of the template metadata is changed.
+type Migration struct {
+	// GracePeriod, if set, is how long the Secret with the previous name is kept
+	// updated with the data of the new one before it's deleted, so that pods that
+	// mount it can be restarted in the meantime. By default, it's deleted
+	// immediately.
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// Limits enforced by the validation rules of the CustomResourceDefinition.
+// An admission webhook may enforce lower limits.
+const (
+	// MaxVars is the maximum number of Vars of a ConfigMapSecret.
+	MaxVars = 256
+	// MaxVarValueLength is the maximum length of the Value of a Var, in characters.
+	MaxVarValueLength = 64 << 10
+)
+
+// MinRefreshInterval is the minimum RefreshInterval. Shorter intervals are
+// rejected by the admission webhook and lengthened by the controller.
+const MinRefreshInterval = time.Minute
+
+// DefaultGitSourceInterval is the default Interval of a GitSource.
+const DefaultGitSourceInterval = 5 * time.Minute
+
+// GitSource is a file or directory of a Git repository, which is read with
+// the git command of the controller. Variable references $(VAR_NAME) in its
+// Repository, Ref, and Path are expanded using the ConfigMapSecret's variables.
+type GitSource struct {
+	// Repository is the URL of the repository, e.g.
+	// https://github.com/example/config.git or ssh://git@github.com/example/config.git.
+	// The protocols that the controller may use are configured by its
+	// --git-protocols flag.
+	//
+	// +kubebuilder:validation:MinLength=1
+	Repository string `json:"repository"`
+
+	// Ref is the branch, tag, or commit to read. Defaults to HEAD, i.e. the
+	// default branch.
+	Ref string `json:"ref,omitempty"`
+
+	// Path is the path of a file or directory in the repository. The file, or
+	// each file of the directory, is the template of the key named after its
+	// base name. Subdirectories and symbolic links are ignored. Defaults to the
+	// root of the repository.
+	Path string `json:"path,omitempty"`
+
+	// Interval is the interval at which the repository is read again and the
+	// Secret rendered. It must be at least one minute. Defaults to 5m.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// SecretRef is the name of a Secret in the namespace with credentials for
+	// the repository: the username and password keys for HTTPS, as in Secrets
+	// of type kubernetes.io/basic-auth, or the ssh-privatekey key for SSH, as
+	// in Secrets of type kubernetes.io/ssh-auth. For SSH, the known_hosts key
+	// is required, with the known host keys of the server, which is never
+	// trusted on first use.
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// SyncInterval returns the interval at which the source is read, which is
+// the Interval, lengthened to MinRefreshInterval, or the default.
+func (s *GitSource) SyncInterval() time.Duration {
+	switch {
+	case s.Interval == nil:
+		return DefaultGitSourceInterval
+	case s.Interval.Duration < MinRefreshInterval:
+		return MinRefreshInterval
+	}
+	return s.Interval.Duration
+}
+
 // ConfigMapTemplate is a ConfigMap template.
 type ConfigMapTemplate struct {
 	// Metadata is a stripped down version of the standard object metadata.
@@ -78,19 +270,196 @@ type ConfigMapTemplate struct {
 
 	// Data contains the configuration data.
 	// Each key must consist of alphanumeric characters, '-', '_' or '.'.
+	// Unlike the data field of a Secret, values are strings rather than
+	// base64-encoded bytes, as in a ConfigMap.
 	// Values with non-UTF-8 byte sequences must use the BinaryData field.
 	// The keys stored in Data must not overlap with the keys in
 	// the BinaryData field.
 	Data map[string]string `json:"data,omitempty"`
 
+	// StringData contains string data with the semantics of the stringData
+	// field of a Secret. Each key must consist of alphanumeric characters,
+	// '-', '_' or '.'. Its keys and values are merged into the data of the
+	// generated Secret, overwriting any values of the same keys from the
+	// Data and BinaryData fields.
+	StringData map[string]string `json:"stringData,omitempty"`
+
 	// BinaryData contains the binary data.
 	// Each key must consist of alphanumeric characters, '-', '_' or '.'.
 	// BinaryData can contain byte sequences that are not in the UTF-8 range.
 	// The keys stored in BinaryData must not overlap with the keys in
 	// the Data field.
 	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+
+	// KeyOptions contains hints about how each key should be consumed,
+	// the content type as which its rendered value is validated, and the
+	// algorithm with which it's compressed.
+	// The hints are recorded as JSON in the KeyOptionsAnnotation of the
+	// generated Secret, so that pod spec generators can set file modes
+	// and ownership. Options for keys that aren't rendered are ignored.
+	KeyOptions map[string]KeyOptions `json:"keyOptions,omitempty"`
+
+	// Delimiters replace the $( and ) delimiters of variable references in
+	// v1 templates, e.g. for data that contains $(...) literally.
+	// Variable values always use $(VAR_NAME) expansion.
+	// +optional
+	Delimiters *Delimiters `json:"delimiters,omitempty"`
+}
+
+// Delimiters are the delimiters of variable references in v1 templates,
+// e.g. "%{" and "}%" for references of the form %{VAR_NAME}%.
+// A reference is escaped by preceding the left delimiter with its first
+// character, e.g. %%{VAR_NAME}% renders as %{VAR_NAME}%.
+type Delimiters struct {
+	// Left opens a variable reference.
+	// +kubebuilder:validation:MinLength=1
+	Left string `json:"left"`
+
+	// Right closes a variable reference.
+	// +kubebuilder:validation:MinLength=1
+	Right string `json:"right"`
+}
+
+// HasKey reports whether the key is in the template's Data, BinaryData, or
+// StringData.
+func (t *ConfigMapTemplate) HasKey(key string) bool {
+	_, inData := t.Data[key]
+	_, inBinaryData := t.BinaryData[key]
+	_, inStringData := t.StringData[key]
+	return inData || inBinaryData || inStringData
+}
+
+// KeyOptions contains hints about how a key should be consumed and how its
+// rendered value is validated and compressed.
+type KeyOptions struct {
+	// Mode is the intended mode bits of the key's file when the Secret is
+	// mounted as a volume, e.g. as the mode of a KeyToPath item.
+	// It must be an octal value between 0000 and 0777 or a decimal value
+	// between 0 and 511.
+	//
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=511
+	Mode *int32 `json:"mode,omitempty"`
+	// Owner is the intended user ID that owns the key's file when the Secret
+	// is mounted as a volume.
+	//
+	// +kubebuilder:validation:Minimum=0
+	Owner *int64 `json:"owner,omitempty"`
+	// Validate is the content type as which the rendered value of the key is
+	// parsed. If it's syntactically invalid, rendering fails with an error
+	// giving the position of the problem. Like Verbatim, it isn't recorded in
+	// the KeyOptionsAnnotation.
+	//
+	// +kubebuilder:validation:Enum=json;yaml;toml;ini;pem
+	Validate ContentType `json:"validate,omitempty"`
+	// Compress is the algorithm with which the rendered value of the key is
+	// compressed, which keeps large generated configs within the size limit
+	// of a Secret. The compressed value is written to the key with the
+	// algorithm's suffix, e.g. "config.yaml.gz" for gzip, and is validated
+	// before it's compressed.
+	//
+	// +kubebuilder:validation:Enum=gzip
+	Compress Compression `json:"compress,omitempty"`
+	// LineEndings are the line endings to which those of the rendered value
+	// of the key are converted, e.g. CRLF for Windows workloads. The value is
+	// converted before it's validated and compressed. By default, it's
+	// unchanged.
+	//
+	// +kubebuilder:validation:Enum=LF;CRLF
+	LineEndings LineEnding `json:"lineEndings,omitempty"`
+	// Verbatim copies the key's template as is, without expanding variable
+	// references, e.g. for third-party files that must not be modified.
+	// Unlike DisableExpansion, it applies only to the key. Like Validate,
+	// it isn't recorded in the KeyOptionsAnnotation.
+	Verbatim bool `json:"verbatim,omitempty"`
+	// Transforms are applied in order to the rendered value of the key,
+	// before its line endings are converted. They aren't recorded in the
+	// KeyOptionsAnnotation.
+	//
+	// +kubebuilder:validation:MaxItems=16
+	Transforms []Transform `json:"transforms,omitempty"`
 }
 
+// A Transform is a function applied to a value, e.g. to reshape a value read
+// from a source without an external job. Functions have the names and
+// semantics of the functions of v2 templates.
+type Transform struct {
+	// Func is the name of the function.
+	//
+	// +kubebuilder:validation:Enum=b64enc;b64dec;toJson;indent;trim;sha256sum
+	Func TransformFunc `json:"func"`
+	// Spaces is the number of spaces with which indent prefixes each line.
+	// It may only be set for indent.
+	//
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=64
+	Spaces int32 `json:"spaces,omitempty"`
+}
+
+// TransformFunc is the name of the function of a Transform.
+type TransformFunc string
+
+const (
+	// TransformB64Enc encodes a value in standard base64.
+	TransformB64Enc TransformFunc = "b64enc"
+	// TransformB64Dec decodes a value from standard base64.
+	TransformB64Dec TransformFunc = "b64dec"
+	// TransformToJSON encodes a value as a JSON string.
+	TransformToJSON TransformFunc = "toJson"
+	// TransformIndent prefixes each line of a value with Spaces spaces.
+	TransformIndent TransformFunc = "indent"
+	// TransformTrim removes the leading and trailing white space of a value.
+	TransformTrim TransformFunc = "trim"
+	// TransformSHA256Sum replaces a value with its hex-encoded SHA-256 digest.
+	TransformSHA256Sum TransformFunc = "sha256sum"
+)
+
+// LineEnding is the sequence that ends each line of a rendered value.
+type LineEnding string
+
+const (
+	// LineEndingLF ends lines with "\n", as on Linux.
+	LineEndingLF LineEnding = "LF"
+	// LineEndingCRLF ends lines with "\r\n", as on Windows.
+	LineEndingCRLF LineEnding = "CRLF"
+)
+
+// Compression is an algorithm with which a rendered value is compressed.
+type Compression string
+
+const (
+	// CompressionGzip compresses a value in the gzip format, with the ".gz"
+	// suffix.
+	CompressionGzip Compression = "gzip"
+)
+
+// Key returns the key to which a value of the key compressed with c is
+// written. If c is empty, it's the key.
+func (c Compression) Key(key string) string {
+	switch c {
+	case CompressionGzip:
+		return key + ".gz"
+	default:
+		return key
+	}
+}
+
+// ContentType is the syntax of a rendered value.
+type ContentType string
+
+const (
+	// ContentTypeJSON is a JSON value.
+	ContentTypeJSON ContentType = "json"
+	// ContentTypeYAML is a stream of YAML documents.
+	ContentTypeYAML ContentType = "yaml"
+	// ContentTypeTOML is a TOML document.
+	ContentTypeTOML ContentType = "toml"
+	// ContentTypeINI is an INI file of sections and key-value pairs.
+	ContentTypeINI ContentType = "ini"
+	// ContentTypePEM is a sequence of PEM blocks.
+	ContentTypePEM ContentType = "pem"
+)
+
 // EmbeddedObjectMeta contains a subset of the fields from k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta.
 // Only fields which are relevant to embedded resources are included.
 type EmbeddedObjectMeta struct {
@@ -112,6 +481,11 @@ type EmbeddedObjectMeta struct {
 	// queryable and should be preserved when modifying objects.
 	// More info: https://kubernetes.io/docs/user-guide/annotations
 	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// InheritLabels are the keys of the labels of the ConfigMapSecret that
+	// are copied to the generated Secret, or "*" to copy all of them, so that
+	// label selectors match the Secret too. Labels take precedence.
+	InheritLabels []string `json:"inheritLabels,omitempty"`
 }
 
 // Var is a template variable.
@@ -124,6 +498,8 @@ type Var struct {
 	// the reference in the input string will be unchanged. The $(VAR_NAME) syntax
 	// can be escaped with a double $$, ie: $$(VAR_NAME). Escaped references will
 	// never be expanded, regardless of whether the variable exists or not.
+	//
+	// +kubebuilder:validation:XValidation:rule="size(self) <= 65536",message="must have at most 65536 characters"
 	Value string `json:"value,omitempty"`
 
 	// SecretValue selects a value by its key in a Secret.
@@ -131,6 +507,73 @@ type Var struct {
 
 	// ConfigMapValue selects a value by its key in a ConfigMap.
 	ConfigMapValue *corev1.ConfigMapKeySelector `json:"configMapValue,omitempty"`
+
+	// ClusterTrustBundleValue selects the PEM certificates of ClusterTrustBundles,
+	// e.g. so that rotated CAs are rendered into application configs. It requires
+	// the certificates.k8s.io/v1alpha1 API, which the controller detects at
+	// startup.
+	ClusterTrustBundleValue *ClusterTrustBundleSelector `json:"clusterTrustBundleValue,omitempty"`
+
+	// Transforms are applied in order to the value of the variable, after
+	// its references are expanded, e.g. to decode a base64-encoded value
+	// read from a Secret.
+	//
+	// +kubebuilder:validation:MaxItems=16
+	Transforms []Transform `json:"transforms,omitempty"`
+}
+
+// ClusterTrustBundleSelector selects ClusterTrustBundles by name, or by signer
+// and label, with the semantics of the clusterTrustBundle projected volume
+// source of a pod.
+//
+// +kubebuilder:validation:XValidation:rule="has(self.name) != has(self.signerName)",message="exactly one of name and signerName must be set"
+type ClusterTrustBundleSelector struct {
+	// Name of the ClusterTrustBundle to select.
+	Name string `json:"name,omitempty"`
+
+	// SignerName selects the ClusterTrustBundles of the signer that match the
+	// LabelSelector. Their certificates are deduplicated and concatenated in
+	// order of the names of the ClusterTrustBundles.
+	SignerName string `json:"signerName,omitempty"`
+
+	// LabelSelector selects the ClusterTrustBundles of the SignerName. If
+	// unset, none are selected; if empty, all of them are.
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+
+	// Specify whether the ClusterTrustBundles must be defined. Defaults to
+	// false. If true, the variable is unset if they don't exist, no bundles
+	// are selected, or the API isn't available.
+	// +kubebuilder:default=false
+	Optional *bool `json:"optional,omitempty"`
+}
+
+// IsOptional returns whether the ClusterTrustBundles may be missing.
+func (s ClusterTrustBundleSelector) IsOptional() bool {
+	return s.Optional != nil && *s.Optional
+}
+
+// Built-in template variables, which are set before varsFrom and vars, so
+// they can be overridden by them.
+const (
+	// NamespaceVar is the namespace of the ConfigMapSecret.
+	NamespaceVar = "__NAMESPACE"
+	// NameVar is the name of the ConfigMapSecret.
+	NameVar = "__NAME"
+	// ClusterVar is the name of the cluster, if the controller is configured with it.
+	ClusterVar = "__CLUSTER"
+)
+
+// BuiltinVars returns the built-in template variables of the ConfigMapSecret.
+// The cluster is empty if it's unknown, in which case ClusterVar isn't set.
+func BuiltinVars(cms *ConfigMapSecret, cluster string) map[string]string {
+	vars := map[string]string{
+		NamespaceVar: cms.Namespace,
+		NameVar:      cms.Name,
+	}
+	if cluster != "" {
+		vars[ClusterVar] = cluster
+	}
+	return vars
 }
 
 // VarsFromSource represents the source of a set of template variables.
@@ -143,6 +586,10 @@ type VarsFromSource struct {
 
 	// The ConfigMap to select.
 	ConfigMapRef *ConfigMapVarsSource `json:"configMapRef,omitempty"`
+
+	// The ConfigMaps to select by label, e.g. fragments published by each
+	// service.
+	ConfigMapSelector *ConfigMapSelector `json:"configMapSelector,omitempty"`
 }
 
 // SecretVarsSource selects a Secret to populate template variables with.
@@ -150,30 +597,142 @@ type SecretVarsSource struct {
 	// The Secret to select.
 	corev1.LocalObjectReference `json:",inline"`
 
-	// Specify whether the Secret must be defined.
+	// Specify whether the Secret must be defined. Defaults to false.
+	// +kubebuilder:default=false
 	Optional *bool `json:"optional,omitempty"`
 }
 
+// IsOptional returns whether the Secret may be missing. Optional is
+// defaulted by the API server, but may be unset in objects that weren't
+// read from it.
+func (s SecretVarsSource) IsOptional() bool {
+	return s.Optional != nil && *s.Optional
+}
+
 // ConfigMapVarsSource selects a ConfigMap to populate template variables with.
 type ConfigMapVarsSource struct {
 	// The ConfigMap to select.
 	corev1.LocalObjectReference `json:",inline"`
 
-	// Specify whether the ConfigMap must be defined.
+	// Specify whether the ConfigMap must be defined. Defaults to false.
+	// +kubebuilder:default=false
 	Optional *bool `json:"optional,omitempty"`
 }
 
+// IsOptional returns whether the ConfigMap may be missing.
+func (s ConfigMapVarsSource) IsOptional() bool {
+	return s.Optional != nil && *s.Optional
+}
+
+// ConfigMapSelector selects every ConfigMap in the namespace whose labels
+// match a selector to populate template variables with. The keys of the
+// ConfigMaps are aggregated in order of their names.
+type ConfigMapSelector struct {
+	// LabelSelector selects the ConfigMaps. It must not be empty.
+	LabelSelector metav1.LabelSelector `json:"labelSelector"`
+
+	// ConflictPolicy is what to do when more than one selected ConfigMap
+	// defines the same key.
+	//
+	// - LastWins (the default) takes the value of the last ConfigMap in order
+	// of name, and records the collision in the status.
+	//
+	// - Error fails rendering.
+	//
+	// +kubebuilder:validation:Enum=LastWins;Error
+	ConflictPolicy ConflictPolicy `json:"conflictPolicy,omitempty"`
+}
+
+// ConflictPolicy is what to do when more than one selected source defines the same key.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyLastWins takes the value of the last source.
+	ConflictPolicyLastWins ConflictPolicy = "LastWins"
+	// ConflictPolicyError fails rendering.
+	ConflictPolicyError ConflictPolicy = "Error"
+)
+
 // ConfigMapSecretStatus describes the observed state of a ConfigMapSecret.
 type ConfigMapSecretStatus struct {
 	// The generation observed by the ConfigMapSecret controller.
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 
+	// The ID of the reconciliation that last updated the status.
+	// It matches the reconcileID logged by the controller.
+	ReconcileID string `json:"reconcileID,omitempty"`
+
 	// Represents the latest available observations of a ConfigMapSecret's current state.
 	//
 	// +listType=map
 	// +listMapKey=type
 	// +listMapKeys=type
 	Conditions []ConfigMapSecretCondition `json:"conditions,omitempty"`
+
+	// The sources of template variables that were read to render the Secret.
+	// If a source can't be read, its previous entry is retained, such that
+	// a stale render can be identified by a source's lastReadTime.
+	//
+	// +listType=map
+	// +listMapKey=kind
+	// +listMapKey=name
+	Sources []ConfigMapSecretSource `json:"sources,omitempty"`
+
+	// The time at which the Secret will next be rendered because of the
+	// RefreshInterval.
+	NextRenderTime *metav1.Time `json:"nextRenderTime,omitempty"`
+
+	// Variables defined by more than one VarsFrom source, sorted by name.
+	// The last source in spec order takes precedence. At most MaxVarCollisions
+	// are recorded.
+	VarCollisions []VarCollision `json:"varCollisions,omitempty"`
+
+	// The number of $(VAR_NAME) references in the template that were left
+	// unchanged when the Secret was last rendered, because their variables
+	// aren't defined, so that a Secret that was rendered but is incomplete can
+	// be identified. Only v1 templates leave references unresolved.
+	UnresolvedReferences int32 `json:"unresolvedReferences,omitempty"`
+
+	// The names of the variables of the UnresolvedReferences, sorted. At most
+	// MaxUnresolvedVars are recorded.
+	UnresolvedVars []string `json:"unresolvedVars,omitempty"`
+}
+
+// MaxVarCollisions is the maximum number of VarCollisions in the status of
+// a ConfigMapSecret.
+const MaxVarCollisions = 20
+
+// MaxUnresolvedVars is the maximum number of UnresolvedVars in the status of
+// a ConfigMapSecret.
+const MaxUnresolvedVars = 20
+
+// VarCollision describes a template variable defined by more than one VarsFrom
+// source.
+type VarCollision struct {
+	// Name of the variable.
+	Name string `json:"name"`
+
+	// The sources that define the variable in spec order, e.g.
+	// "Secret/db-credentials". The last one takes precedence.
+	Sources []string `json:"sources"`
+}
+
+// ConfigMapSecretSource describes the last successful read of a source of
+// template variables.
+type ConfigMapSecretSource struct {
+	// Kind of the source: ConfigMap, Secret, or Git. The name of a Git source
+	// is the URL of its repository, and its resourceVersion is the commit.
+	Kind string `json:"kind"`
+
+	// Name of the source.
+	Name string `json:"name"`
+
+	// The resourceVersion of the source that was last read.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// The last time the source was successfully read at a new resourceVersion.
+	// Reads that observe an unchanged resourceVersion don't update it.
+	LastReadTime metav1.Time `json:"lastReadTime,omitempty"`
 }
 
 // ConfigMapSecretCondition describes the state of a ConfigMapSecret.
@@ -191,7 +750,7 @@ type ConfigMapSecretCondition struct {
 	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
 
 	// The reason for the last update.
-	Reason string `json:"reason,omitempty"`
+	Reason ConfigMapSecretConditionReason `json:"reason,omitempty"`
 
 	// A human readable message indicating details about the last update.
 	Message string `json:"message,omitempty"`
@@ -201,7 +760,118 @@ type ConfigMapSecretCondition struct {
 type ConfigMapSecretConditionType string
 
 const (
+	// ConfigMapSecretReady means that the Secret was rendered and written from
+	// the current generation of the ConfigMapSecret. It's false whenever
+	// RenderFailure or PendingChanges is true, e.g. for
+	// `kubectl wait --for=condition=Ready`.
+	ConfigMapSecretReady ConfigMapSecretConditionType = "Ready"
+
 	// ConfigMapSecretRenderFailure means that the target secret could not be
 	// rendered.
 	ConfigMapSecretRenderFailure ConfigMapSecretConditionType = "RenderFailure"
+
+	// ConfigMapSecretDegraded means that rendering has repeatedly failed for
+	// the same reason and that retries have been backed off until a source
+	// or the ConfigMapSecret changes.
+	ConfigMapSecretDegraded ConfigMapSecretConditionType = "Degraded"
+
+	// ConfigMapSecretCleanupFailed means that Secrets previously rendered by
+	// the ConfigMapSecret, e.g. under another name, could not be deleted.
+	ConfigMapSecretCleanupFailed ConfigMapSecretConditionType = "CleanupFailed"
+
+	// ConfigMapSecretDegradedSources means that the Secret was rendered, but
+	// some optional sources, or keys of them, didn't exist, so the variables
+	// they define were left unresolved.
+	ConfigMapSecretDegradedSources ConfigMapSecretConditionType = "DegradedSources"
+
+	// ConfigMapSecretPendingChanges means that the Secret was rendered, but
+	// writing it was deferred, e.g. until a blackout window of the controller
+	// ends.
+	ConfigMapSecretPendingChanges ConfigMapSecretConditionType = "PendingChanges"
+)
+
+// ConfigMapSecretConditionReason is a valid value for ConfigMapSecretCondition.Reason
+type ConfigMapSecretConditionReason string
+
+const (
+	// CreateVariablesErrorReason means that required variables couldn't be
+	// resolved from the sources.
+	CreateVariablesErrorReason ConfigMapSecretConditionReason = "CreateVariablesError"
+
+	// VariableCycleReason means that the values of vars reference each other
+	// in a cycle, so they can't be fully expanded.
+	VariableCycleReason ConfigMapSecretConditionReason = "VariableCycle"
+
+	// TemplateErrorReason means that the template couldn't be rendered.
+	TemplateErrorReason ConfigMapSecretConditionReason = "TemplateError"
+
+	// InvalidContentReason means that the rendered value of a key isn't valid
+	// in the content type of its KeyOptions.
+	InvalidContentReason ConfigMapSecretConditionReason = "InvalidContent"
+
+	// TransformErrorReason means that a transform of a var or key failed,
+	// e.g. because its value isn't valid base64.
+	TransformErrorReason ConfigMapSecretConditionReason = "TransformError"
+
+	// RenderLimitExceededReason means that rendering the template exceeded the
+	// controller's render limits.
+	RenderLimitExceededReason ConfigMapSecretConditionReason = "RenderLimitExceeded"
+
+	// PostRenderHookErrorReason means that a post-render hook failed or blocked
+	// the rendered Secret from being written.
+	PostRenderHookErrorReason ConfigMapSecretConditionReason = "PostRenderHookError"
+
+	// PolicyViolationReason means that the rendered Secret violates the
+	// controller's policy, e.g. it's missing a required label, so it wasn't
+	// written.
+	PolicyViolationReason ConfigMapSecretConditionReason = "PolicyViolation"
+
+	// DefaultsErrorReason means that the defaults ConfigMap of the namespace
+	// is invalid.
+	DefaultsErrorReason ConfigMapSecretConditionReason = "DefaultsError"
+
+	// InvalidSecretNameReason means that the name of the Secret, with the
+	// controller's prefix and suffix, isn't a valid name.
+	InvalidSecretNameReason ConfigMapSecretConditionReason = "InvalidSecretName"
+
+	// GitSourceErrorReason means that the files of the GitSource couldn't be
+	// read, e.g. because the repository is unreachable or the path doesn't exist.
+	GitSourceErrorReason ConfigMapSecretConditionReason = "GitSourceError"
+
+	// DependencyCycleReason means that the ConfigMapSecret depends on its own
+	// Secret, directly or through the Secrets of other ConfigMapSecrets.
+	DependencyCycleReason ConfigMapSecretConditionReason = "DependencyCycle"
+
+	// TenantViolationReason means that the ConfigMapSecret references a
+	// Secret or ConfigMap of another tenant, according to the controller's
+	// tenant label.
+	TenantViolationReason ConfigMapSecretConditionReason = "TenantViolation"
+
+	// BlockedReason means that the Secret is owned by another object, so it
+	// can't be written.
+	BlockedReason ConfigMapSecretConditionReason = "Blocked"
+
+	// ReconcileTimeoutReason means that reconciling the ConfigMapSecret
+	// exceeded the controller's reconcile timeout.
+	ReconcileTimeoutReason ConfigMapSecretConditionReason = "ReconcileTimeout"
+
+	// InternalErrorReason means that rendering failed because of an error of
+	// the controller or the API server, rather than of the ConfigMapSecret.
+	InternalErrorReason ConfigMapSecretConditionReason = "InternalError"
+
+	// RetryBudgetExhaustedReason is the reason of the Degraded condition after
+	// too many consecutive render failures.
+	RetryBudgetExhaustedReason ConfigMapSecretConditionReason = "RetryBudgetExhausted"
+
+	// CleanupErrorReason is the reason of the CleanupFailed condition when
+	// Secrets previously rendered by the ConfigMapSecret couldn't be deleted.
+	CleanupErrorReason ConfigMapSecretConditionReason = "CleanupError"
+
+	// OptionalSourcesMissingReason is the reason of the DegradedSources
+	// condition when the Secret was rendered without some optional sources.
+	OptionalSourcesMissingReason ConfigMapSecretConditionReason = "OptionalSourcesMissing"
+
+	// BlackoutWindowReason is the reason of the PendingChanges condition when
+	// an update of the Secret was deferred until a blackout window ends.
+	BlackoutWindowReason ConfigMapSecretConditionReason = "BlackoutWindow"
 )
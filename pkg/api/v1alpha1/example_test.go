@@ -0,0 +1,78 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v1alpha1_test
+
+import (
+	"fmt"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// This example constructs a minimal ConfigMapSecret, whose rendered Secret
+// contains a config file with a password read from another Secret.
+func Example() {
+	cms := &v1alpha1.ConfigMapSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "ConfigMapSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "app-config",
+		},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Data: map[string]string{
+					"config.yaml": "user: app\npassword: $(PASSWORD)\n",
+				},
+			},
+			Vars: []v1alpha1.Var{{
+				Name: "PASSWORD",
+				SecretValue: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "app-credentials"},
+					Key:                  "password",
+				},
+			}},
+		},
+	}
+	buf, err := yaml.Marshal(cms.Spec)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(string(buf))
+	// Output:
+	// template:
+	//   data:
+	//     config.yaml: |
+	//       user: app
+	//       password: $(PASSWORD)
+	//   metadata: {}
+	// vars:
+	// - name: PASSWORD
+	//   secretValue:
+	//     key: password
+	//     name: app-credentials
+}
+
+// This example adds the API types to a scheme, e.g. for a client.
+func ExampleAddToScheme() {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		fmt.Println(err)
+		return
+	}
+	gvks, _, err := scheme.ObjectKinds(&v1alpha1.ConfigMapSecret{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(gvks[0])
+	// Output: secrets.mz.com/v1alpha1, Kind=ConfigMapSecret
+}
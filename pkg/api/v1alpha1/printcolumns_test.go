@@ -0,0 +1,98 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v1alpha1
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+func readCRD(t *testing.T) *apiextensionsv1.CustomResourceDefinition {
+	buf, err := os.ReadFile("../../../manifest/customresourcedefinition.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(buf, crd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return crd
+}
+
+func TestResourceNames(t *testing.T) {
+	names := readCRD(t).Spec.Names
+	if diff := cmp.Diff([]string{"cms"}, names.ShortNames); diff != "" {
+		t.Errorf("unexpected short names (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"all"}, names.Categories); diff != "" {
+		t.Errorf("unexpected categories (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrinterColumns(t *testing.T) {
+	created := metav1.NewTime(time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC))
+	cms := &ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "app",
+			Namespace:         "default",
+			CreationTimestamp: created,
+		},
+		Spec: ConfigMapSecretSpec{
+			Template: ConfigMapTemplate{
+				Metadata: EmbeddedObjectMeta{Name: "app-config"},
+			},
+		},
+		Status: ConfigMapSecretStatus{
+			Conditions: []ConfigMapSecretCondition{
+				{Type: ConfigMapSecretReady, Status: corev1.ConditionFalse},
+				{Type: ConfigMapSecretRenderFailure, Status: corev1.ConditionTrue},
+				{Type: ConfigMapSecretDegraded, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"Secret":         "app-config",
+		"Ready":          "False",
+		"Render Failure": "True",
+		"Degraded":       "False",
+		"Age":            "2022-08-01T00:00:00Z",
+	}
+	got := make(map[string]string)
+	crd := readCRD(t)
+	for _, version := range crd.Spec.Versions {
+		if version.Name != GroupVersion.Version {
+			continue
+		}
+		for _, col := range version.AdditionalPrinterColumns {
+			jp := jsonpath.New(col.Name)
+			if err := jp.Parse("{" + col.JSONPath + "}"); err != nil {
+				t.Fatalf("column %q: invalid JSONPath %q: %v", col.Name, col.JSONPath, err)
+			}
+			var buf bytes.Buffer
+			if err := jp.Execute(&buf, obj); err != nil {
+				t.Fatalf("column %q: unexpected error: %v", col.Name, err)
+			}
+			got[col.Name] = buf.String()
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected printer columns (-want +got):\n%s", diff)
+	}
+}
@@ -11,9 +11,35 @@ package v1alpha1
 
 import (
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterTrustBundleSelector) DeepCopyInto(out *ClusterTrustBundleSelector) {
+	*out = *in
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Optional != nil {
+		in, out := &in.Optional, &out.Optional
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterTrustBundleSelector.
+func (in *ClusterTrustBundleSelector) DeepCopy() *ClusterTrustBundleSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterTrustBundleSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapSecret) DeepCopyInto(out *ConfigMapSecret) {
 	*out = *in
@@ -90,10 +116,31 @@ func (in *ConfigMapSecretList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapSecretSource) DeepCopyInto(out *ConfigMapSecretSource) {
+	*out = *in
+	in.LastReadTime.DeepCopyInto(&out.LastReadTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapSecretSource.
+func (in *ConfigMapSecretSource) DeepCopy() *ConfigMapSecretSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapSecretSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapSecretSpec) DeepCopyInto(out *ConfigMapSecretSpec) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.GitSource != nil {
+		in, out := &in.GitSource, &out.GitSource
+		*out = new(GitSource)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.VarsFrom != nil {
 		in, out := &in.VarsFrom, &out.VarsFrom
 		*out = make([]VarsFromSource, len(*in))
@@ -108,6 +155,21 @@ func (in *ConfigMapSecretSpec) DeepCopyInto(out *ConfigMapSecretSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ManageOwnership != nil {
+		in, out := &in.ManageOwnership, &out.ManageOwnership
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Migration != nil {
+		in, out := &in.Migration, &out.Migration
+		*out = new(Migration)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapSecretSpec.
@@ -130,6 +192,29 @@ func (in *ConfigMapSecretStatus) DeepCopyInto(out *ConfigMapSecretStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]ConfigMapSecretSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NextRenderTime != nil {
+		in, out := &in.NextRenderTime, &out.NextRenderTime
+		*out = (*in).DeepCopy()
+	}
+	if in.VarCollisions != nil {
+		in, out := &in.VarCollisions, &out.VarCollisions
+		*out = make([]VarCollision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UnresolvedVars != nil {
+		in, out := &in.UnresolvedVars, &out.UnresolvedVars
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapSecretStatus.
@@ -142,6 +227,22 @@ func (in *ConfigMapSecretStatus) DeepCopy() *ConfigMapSecretStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapSelector) DeepCopyInto(out *ConfigMapSelector) {
+	*out = *in
+	in.LabelSelector.DeepCopyInto(&out.LabelSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapSelector.
+func (in *ConfigMapSelector) DeepCopy() *ConfigMapSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapTemplate) DeepCopyInto(out *ConfigMapTemplate) {
 	*out = *in
@@ -168,6 +269,25 @@ func (in *ConfigMapTemplate) DeepCopyInto(out *ConfigMapTemplate) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.StringData != nil {
+		in, out := &in.StringData, &out.StringData
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.KeyOptions != nil {
+		in, out := &in.KeyOptions, &out.KeyOptions
+		*out = make(map[string]KeyOptions, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Delimiters != nil {
+		in, out := &in.Delimiters, &out.Delimiters
+		*out = new(Delimiters)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapTemplate.
@@ -180,6 +300,21 @@ func (in *ConfigMapTemplate) DeepCopy() *ConfigMapTemplate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Delimiters) DeepCopyInto(out *Delimiters) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Delimiters.
+func (in *Delimiters) DeepCopy() *Delimiters {
+	if in == nil {
+		return nil
+	}
+	out := new(Delimiters)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapVarsSource) DeepCopyInto(out *ConfigMapVarsSource) {
 	*out = *in
@@ -218,6 +353,11 @@ func (in *EmbeddedObjectMeta) DeepCopyInto(out *EmbeddedObjectMeta) {
 			(*out)[key] = val
 		}
 	}
+	if in.InheritLabels != nil {
+		in, out := &in.InheritLabels, &out.InheritLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EmbeddedObjectMeta.
@@ -230,6 +370,81 @@ func (in *EmbeddedObjectMeta) DeepCopy() *EmbeddedObjectMeta {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitSource) DeepCopyInto(out *GitSource) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitSource.
+func (in *GitSource) DeepCopy() *GitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyOptions) DeepCopyInto(out *KeyOptions) {
+	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Owner != nil {
+		in, out := &in.Owner, &out.Owner
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Transforms != nil {
+		in, out := &in.Transforms, &out.Transforms
+		*out = make([]Transform, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyOptions.
+func (in *KeyOptions) DeepCopy() *KeyOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Migration) DeepCopyInto(out *Migration) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Migration.
+func (in *Migration) DeepCopy() *Migration {
+	if in == nil {
+		return nil
+	}
+	out := new(Migration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretVarsSource) DeepCopyInto(out *SecretVarsSource) {
 	*out = *in
@@ -251,6 +466,21 @@ func (in *SecretVarsSource) DeepCopy() *SecretVarsSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Transform) DeepCopyInto(out *Transform) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Transform.
+func (in *Transform) DeepCopy() *Transform {
+	if in == nil {
+		return nil
+	}
+	out := new(Transform)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Var) DeepCopyInto(out *Var) {
 	*out = *in
@@ -264,6 +494,16 @@ func (in *Var) DeepCopyInto(out *Var) {
 		*out = new(v1.ConfigMapKeySelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ClusterTrustBundleValue != nil {
+		in, out := &in.ClusterTrustBundleValue, &out.ClusterTrustBundleValue
+		*out = new(ClusterTrustBundleSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Transforms != nil {
+		in, out := &in.Transforms, &out.Transforms
+		*out = make([]Transform, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Var.
@@ -276,6 +516,26 @@ func (in *Var) DeepCopy() *Var {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VarCollision) DeepCopyInto(out *VarCollision) {
+	*out = *in
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VarCollision.
+func (in *VarCollision) DeepCopy() *VarCollision {
+	if in == nil {
+		return nil
+	}
+	out := new(VarCollision)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VarsFromSource) DeepCopyInto(out *VarsFromSource) {
 	*out = *in
@@ -289,6 +549,11 @@ func (in *VarsFromSource) DeepCopyInto(out *VarsFromSource) {
 		*out = new(ConfigMapVarsSource)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ConfigMapSelector != nil {
+		in, out := &in.ConfigMapSelector, &out.ConfigMapSelector
+		*out = new(ConfigMapSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VarsFromSource.
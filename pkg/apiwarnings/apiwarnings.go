@@ -0,0 +1,93 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package apiwarnings surfaces the warnings returned by the API server, e.g.
+// that an API used by the controller is deprecated, so that upgrades that
+// would break it are visible ahead of time.
+package apiwarnings
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	warningsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "configmapsecret_controller_api_warnings_total",
+		Help: "Total number of warnings returned by the API server, by kind (deprecation or other).",
+	}, []string{"kind"})
+	deprecations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "configmapsecret_controller_api_deprecations",
+		Help: "Deprecation warnings returned by the API server since the controller started, by warning. Always 1.",
+	}, []string{"warning"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(warningsTotal, deprecations)
+}
+
+// Kinds of warnings.
+const (
+	KindDeprecation = "deprecation"
+	KindOther       = "other"
+)
+
+// MaxUnique is the maximum number of unique warnings that are logged and, if
+// they're deprecations, exported as metrics. Later ones are only counted.
+const MaxUnique = 100
+
+// A Handler logs each unique warning of the API server once and exports
+// metrics of them. It implements rest.WarningHandler.
+type Handler struct {
+	log logr.Logger
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+var _ rest.WarningHandler = (*Handler)(nil)
+
+// NewHandler returns a Handler that logs warnings to log.
+func NewHandler(log logr.Logger) *Handler {
+	return &Handler{log: log, seen: make(map[string]bool)}
+}
+
+// HandleWarningHeader handles a warning header returned by the API server.
+// As in client-go, only warnings with code 299 and text are handled.
+func (h *Handler) HandleWarningHeader(code int, agent, text string) {
+	if code != 299 || text == "" {
+		return
+	}
+	kind := Kind(text)
+	warningsTotal.WithLabelValues(kind).Inc()
+
+	h.mu.Lock()
+	first := !h.seen[text] && len(h.seen) < MaxUnique
+	if first {
+		h.seen[text] = true
+	}
+	h.mu.Unlock()
+	if !first {
+		return
+	}
+	if kind == KindDeprecation {
+		deprecations.WithLabelValues(text).Set(1)
+	}
+	h.log.Info("API server warning", "warning", text, "kind", kind)
+}
+
+// Kind returns the kind of the warning text. Deprecation warnings of the API
+// server are of the form "policy/v1beta1 PodDisruptionBudget is deprecated in
+// v1.21+, unavailable in v1.25+; use policy/v1 PodDisruptionBudget".
+func Kind(text string) string {
+	if strings.Contains(text, " deprecated") {
+		return KindDeprecation
+	}
+	return KindOther
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apiwarnings
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func metricValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	m := &dto.Metric{}
+	if err := (<-ch).Write(m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
+
+func TestHandler(t *testing.T) {
+	var logs []string
+	h := NewHandler(funcr.New(func(prefix, args string) { logs = append(logs, args) }, funcr.Options{}))
+	const deprecation = "policy/v1beta1 PodDisruptionBudget is deprecated in v1.21+, unavailable in v1.25+; use policy/v1 PodDisruptionBudget"
+	const other = "unknown field spec.foo"
+	before := map[string]float64{
+		KindDeprecation: metricValue(t, warningsTotal.WithLabelValues(KindDeprecation)),
+		KindOther:       metricValue(t, warningsTotal.WithLabelValues(KindOther)),
+	}
+
+	// Each unique warning is logged once, and every warning is counted.
+	for i := 0; i < 3; i++ {
+		h.HandleWarningHeader(299, "-", deprecation)
+	}
+	h.HandleWarningHeader(299, "-", other)
+	h.HandleWarningHeader(299, "-", "")
+	h.HandleWarningHeader(199, "-", "miscellaneous warning")
+	if len(logs) != 2 || !strings.Contains(logs[0], deprecation) || !strings.Contains(logs[1], other) {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+	for kind, want := range map[string]float64{KindDeprecation: 3, KindOther: 1} {
+		if got := metricValue(t, warningsTotal.WithLabelValues(kind)) - before[kind]; got != want {
+			t.Errorf("unexpected %s warnings; want: %v; got: %v", kind, want, got)
+		}
+	}
+	if got := metricValue(t, deprecations.WithLabelValues(deprecation)); got != 1 {
+		t.Errorf("unexpected deprecation metric: %v", got)
+	}
+
+	// Unique warnings beyond the limit are only counted.
+	logs = nil
+	for i := 0; i < MaxUnique; i++ {
+		h.HandleWarningHeader(299, "-", fmt.Sprintf("warning %d", i))
+	}
+	if want := MaxUnique - 2; len(logs) != want {
+		t.Errorf("unexpected number of logs; want: %d; got: %d", want, len(logs))
+	}
+}
+
+func TestKind(t *testing.T) {
+	for text, want := range map[string]string{
+		"extensions/v1beta1 Ingress is deprecated in v1.14+, unavailable in v1.22+; use networking.k8s.io/v1 Ingress": KindDeprecation,
+		"autoscaling/v2beta2 HorizontalPodAutoscaler is deprecated in v1.23+":                                         KindDeprecation,
+		"metadata.finalizers: \"example.com\": prefer a domain-qualified finalizer name":                              KindOther,
+	} {
+		if got := Kind(text); got != want {
+			t.Errorf("Kind(%q): want: %s; got: %s", text, want, got)
+		}
+	}
+}
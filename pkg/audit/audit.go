@@ -0,0 +1,145 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit records the Secret writes performed by the controller.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// An Operation is a type of write to a Secret.
+type Operation string
+
+const (
+	// Create means that the Secret was created.
+	Create Operation = "Create"
+	// Update means that the Secret was updated.
+	Update Operation = "Update"
+	// Delete means that the Secret was deleted.
+	Delete Operation = "Delete"
+)
+
+// A Record describes a write to a Secret performed by the controller.
+// It never includes Secret values.
+type Record struct {
+	// Time of the write.
+	Time time.Time `json:"time"`
+	// Operation performed on the Secret.
+	Operation Operation `json:"operation"`
+	// Secret that was written.
+	Secret types.NamespacedName `json:"secret"`
+	// ConfigMapSecret that triggered the write.
+	ConfigMapSecret types.NamespacedName `json:"configMapSecret"`
+	// ID of the reconciliation that triggered the write.
+	ReconcileID string `json:"reconcileID,omitempty"`
+	// Keys added to the Secret.
+	AddedKeys []string `json:"addedKeys,omitempty"`
+	// Keys whose values changed.
+	ChangedKeys []string `json:"changedKeys,omitempty"`
+	// Keys removed from the Secret.
+	RemovedKeys []string `json:"removedKeys,omitempty"`
+}
+
+// A Sink receives audit records.
+type Sink interface {
+	Emit(ctx context.Context, rec Record) error
+}
+
+// DiffKeys returns the keys added, changed, and removed between old and new data.
+func DiffKeys(old, new map[string][]byte) (added, changed, removed []string) {
+	for k, v := range new {
+		if prev, ok := old[k]; !ok {
+			added = append(added, k)
+		} else if !bytes.Equal(prev, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// NewSink returns a Sink for the target, which is either an http(s) URL
+// to which records are posted or the path of a file to which they're appended.
+func NewSink(target string) (Sink, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewWebhookSink(target, nil), nil
+	}
+	return NewFileSink(target)
+}
+
+type fileSink struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileSink returns a Sink that appends records to the file at path,
+// encoded as JSON lines.
+func NewFileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *fileSink) Emit(_ context.Context, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(rec)
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a Sink that posts records to the url as JSON.
+// If client is nil, a client with a 10 second timeout is used.
+func NewWebhookSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &webhookSink{url: url, client: client}
+}
+
+func (s *webhookSink) Emit(ctx context.Context, rec Record) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned status %s", resp.Status)
+	}
+	return nil
+}
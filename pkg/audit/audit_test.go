@@ -0,0 +1,99 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDiffKeys(t *testing.T) {
+	old := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}
+	new := map[string][]byte{"a": []byte("1"), "b": []byte("x"), "d": []byte("4")}
+	added, changed, removed := DiffKeys(old, new)
+	if diff := cmp.Diff([]string{"d"}, added); diff != "" {
+		t.Errorf("unexpected added keys (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"b"}, changed); diff != "" {
+		t.Errorf("unexpected changed keys (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"c"}, removed); diff != "" {
+		t.Errorf("unexpected removed keys (-want +got):\n%s", diff)
+	}
+}
+
+var testRecord = Record{
+	Operation:       Update,
+	Secret:          types.NamespacedName{Namespace: "default", Name: "secret"},
+	ConfigMapSecret: types.NamespacedName{Namespace: "default", Name: "cms"},
+	ReconcileID:     "abc",
+	ChangedKeys:     []string{"key"},
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewSink(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.Emit(context.Background(), testRecord); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected line count: got %d; want 2", len(lines))
+	}
+	var got Record
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(testRecord, got); diff != "" {
+		t.Errorf("unexpected record (-want +got):\n%s", diff)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got Record
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := NewSink(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sink.Emit(context.Background(), testRecord); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(testRecord, got); diff != "" {
+		t.Errorf("unexpected record (-want +got):\n%s", diff)
+	}
+
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer fail.Close()
+	if err := NewWebhookSink(fail.URL, nil).Emit(context.Background(), testRecord); err == nil {
+		t.Errorf("expected error for failed webhook")
+	}
+}
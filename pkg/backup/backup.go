@@ -0,0 +1,391 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backup exports ConfigMapSecrets and their rendered Secrets to an
+// archive and restores them from it, e.g. for disaster recovery.
+//
+// An archive is a gzipped tar file containing a manifest, the ConfigMapSecrets
+// with their status, and optionally the rendered Secrets, which are always
+// encrypted with AES-256-GCM.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the version of the archive format.
+const Version = 1
+
+// KeySize is the size of an encryption key, in bytes.
+const KeySize = 32
+
+const (
+	manifestFile         = "manifest.json"
+	configMapSecretsFile = "configmapsecrets.yaml"
+	secretsFile          = "secrets.yaml.enc"
+)
+
+// ErrKeyRequired is returned when Secrets are written without a key.
+var ErrKeyRequired = errors.New("backup: an encryption key is required for Secrets")
+
+// An Archive is a backup of ConfigMapSecrets.
+type Archive struct {
+	// Created is the time the backup was created.
+	Created time.Time
+	// ConfigMapSecrets are the backed up ConfigMapSecrets, including their status.
+	ConfigMapSecrets []v1alpha1.ConfigMapSecret
+	// Secrets are the Secrets rendered from the ConfigMapSecrets, if included.
+	Secrets []corev1.Secret
+}
+
+type manifest struct {
+	Version          int       `json:"version"`
+	Created          time.Time `json:"created"`
+	ConfigMapSecrets int       `json:"configMapSecrets"`
+	Secrets          int       `json:"secrets"`
+}
+
+// Backup returns an archive of the ConfigMapSecrets in the namespace, or in all
+// namespaces if it's empty. If includeSecrets is true, the Secrets rendered
+// from them are included.
+func Backup(ctx context.Context, c client.Reader, namespace string, includeSecrets bool) (*Archive, error) {
+	a := &Archive{Created: time.Now().UTC()}
+	list := &v1alpha1.ConfigMapSecretList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	a.ConfigMapSecrets = list.Items
+	if !includeSecrets {
+		return a, nil
+	}
+	secrets := &corev1.SecretList{}
+	if err := c.List(ctx, secrets, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets.Items {
+		if ownerName(&secret) != "" {
+			a.Secrets = append(a.Secrets, secret)
+		}
+	}
+	return a, nil
+}
+
+// ownerName returns the name of the ConfigMapSecret that controls the Secret,
+// or an empty string if there's none.
+func ownerName(secret *corev1.Secret) string {
+	owner := metav1.GetControllerOf(secret)
+	if owner == nil || owner.Kind != "ConfigMapSecret" {
+		return ""
+	}
+	if gv, _ := schema.ParseGroupVersion(owner.APIVersion); gv.Group != v1alpha1.GroupVersion.Group {
+		return ""
+	}
+	return owner.Name
+}
+
+// Write writes the archive to w. The Secrets, if any, are encrypted with key,
+// which must be KeySize bytes.
+func (a *Archive) Write(w io.Writer, key []byte) error {
+	if len(a.Secrets) > 0 && key == nil {
+		return ErrKeyRequired
+	}
+	m, err := json.MarshalIndent(&manifest{
+		Version:          Version,
+		Created:          a.Created,
+		ConfigMapSecrets: len(a.ConfigMapSecrets),
+		Secrets:          len(a.Secrets),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	var cmsDocs []interface{}
+	for i := range a.ConfigMapSecrets {
+		cms := a.ConfigMapSecrets[i].DeepCopy()
+		cms.APIVersion, cms.Kind = v1alpha1.GroupVersion.String(), "ConfigMapSecret"
+		cms.ManagedFields = nil
+		cmsDocs = append(cmsDocs, cms)
+	}
+	cmsBuf, err := marshalDocs(cmsDocs)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{manifestFile, m},
+		{configMapSecretsFile, cmsBuf},
+	}
+	if len(a.Secrets) > 0 {
+		var secretDocs []interface{}
+		for i := range a.Secrets {
+			secret := a.Secrets[i].DeepCopy()
+			secret.APIVersion, secret.Kind = "v1", "Secret"
+			secret.ManagedFields = nil
+			secretDocs = append(secretDocs, secret)
+		}
+		buf, err := marshalDocs(secretDocs)
+		if err != nil {
+			return err
+		}
+		if buf, err = encrypt(key, buf); err != nil {
+			return err
+		}
+		files = append(files, struct {
+			name string
+			data []byte
+		}{secretsFile, buf})
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0o600,
+			Size:    int64(len(f.data)),
+			ModTime: a.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Read reads an archive from r. If it contains Secrets, they're decrypted
+// with key. If key is nil, the Secrets are skipped.
+func Read(r io.Reader, key []byte) (*Archive, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("backup: invalid archive: %w", err)
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backup: invalid archive: %w", err)
+		}
+		buf, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("backup: invalid archive: %w", err)
+		}
+		files[hdr.Name] = buf
+	}
+
+	var m manifest
+	if err := json.Unmarshal(files[manifestFile], &m); err != nil {
+		return nil, fmt.Errorf("backup: invalid manifest: %w", err)
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("backup: unsupported archive version: %d", m.Version)
+	}
+	a := &Archive{Created: m.Created}
+	for _, doc := range splitDocs(files[configMapSecretsFile]) {
+		var cms v1alpha1.ConfigMapSecret
+		if err := yaml.Unmarshal(doc, &cms); err != nil {
+			return nil, fmt.Errorf("backup: invalid ConfigMapSecret: %w", err)
+		}
+		a.ConfigMapSecrets = append(a.ConfigMapSecrets, cms)
+	}
+	if len(a.ConfigMapSecrets) != m.ConfigMapSecrets {
+		return nil, fmt.Errorf("backup: archive has %d ConfigMapSecrets; want %d", len(a.ConfigMapSecrets), m.ConfigMapSecrets)
+	}
+	if m.Secrets == 0 || key == nil {
+		return a, nil
+	}
+	buf, err := decrypt(key, files[secretsFile])
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range splitDocs(buf) {
+		var secret corev1.Secret
+		if err := yaml.Unmarshal(doc, &secret); err != nil {
+			return nil, fmt.Errorf("backup: invalid Secret: %w", err)
+		}
+		a.Secrets = append(a.Secrets, secret)
+	}
+	if len(a.Secrets) != m.Secrets {
+		return nil, fmt.Errorf("backup: archive has %d Secrets; want %d", len(a.Secrets), m.Secrets)
+	}
+	return a, nil
+}
+
+const docSeparator = "---\n"
+
+func marshalDocs(docs []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(docSeparator)
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+func splitDocs(buf []byte) [][]byte {
+	var docs [][]byte
+	for _, doc := range strings.Split("\n"+string(buf), "\n"+docSeparator) {
+		if strings.TrimSpace(doc) != "" {
+			docs = append(docs, []byte(doc))
+		}
+	}
+	return docs
+}
+
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("backup: invalid encrypted Secrets")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("backup: unable to decrypt Secrets: wrong key or corrupt archive")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("backup: invalid key size: %d bytes; want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// A Result summarizes a restore.
+type Result struct {
+	// Created is the number of ConfigMapSecrets that were created.
+	Created int
+	// Updated is the number of existing ConfigMapSecrets whose spec was updated.
+	Updated int
+	// Secrets is the number of Secrets that were created.
+	Secrets int
+}
+
+// Restore applies the ConfigMapSecrets of the archive, creating them or
+// updating the spec, labels, and annotations of existing ones, and restores
+// their status. Then it creates the Secrets of the archive that don't exist,
+// owned by the restored ConfigMapSecrets, so that they're available before
+// their sources are restored. Existing Secrets are left to the controller.
+func Restore(ctx context.Context, c client.Client, a *Archive) (Result, error) {
+	var res Result
+	restored := make(map[types.NamespacedName]*v1alpha1.ConfigMapSecret)
+	for i := range a.ConfigMapSecrets {
+		src := &a.ConfigMapSecrets[i]
+		key := types.NamespacedName{Namespace: src.Namespace, Name: src.Name}
+		cms := &v1alpha1.ConfigMapSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   src.Namespace,
+				Name:        src.Name,
+				Labels:      src.Labels,
+				Annotations: src.Annotations,
+			},
+			Spec: src.Spec,
+		}
+		err := c.Create(ctx, cms)
+		switch {
+		case err == nil:
+			res.Created++
+		case apierrors.IsAlreadyExists(err):
+			if err := c.Get(ctx, key, cms); err != nil {
+				return res, err
+			}
+			cms.Labels, cms.Annotations, cms.Spec = src.Labels, src.Annotations, src.Spec
+			if err := c.Update(ctx, cms); err != nil {
+				return res, fmt.Errorf("unable to update ConfigMapSecret %s: %w", key, err)
+			}
+			res.Updated++
+		default:
+			return res, fmt.Errorf("unable to create ConfigMapSecret %s: %w", key, err)
+		}
+		cms.Status = src.Status
+		if err := c.Status().Update(ctx, cms); err != nil {
+			return res, fmt.Errorf("unable to restore status of ConfigMapSecret %s: %w", key, err)
+		}
+		restored[key] = cms
+	}
+
+	gvk := v1alpha1.GroupVersion.WithKind("ConfigMapSecret")
+	for i := range a.Secrets {
+		src := &a.Secrets[i]
+		key := types.NamespacedName{Namespace: src.Namespace, Name: src.Name}
+		owner, ok := restored[types.NamespacedName{Namespace: src.Namespace, Name: ownerName(src)}]
+		if !ok {
+			continue // Its ConfigMapSecret wasn't restored.
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       src.Namespace,
+				Name:            src.Name,
+				Labels:          src.Labels,
+				Annotations:     src.Annotations,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, gvk)},
+			},
+			Data: src.Data,
+			Type: src.Type,
+		}
+		if err := c.Create(ctx, secret); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			return res, fmt.Errorf("unable to create Secret %s: %w", key, err)
+		}
+		res.Secrets++
+	}
+	return res, nil
+}
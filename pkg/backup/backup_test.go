@@ -0,0 +1,157 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func testArchive() *Archive {
+	cms := v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", UID: "old-uid", Labels: map[string]string{"app": "web"}},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Data: map[string]string{"config.yaml": "a: b\n---\nc: $(C)\n"},
+			},
+		},
+		Status: v1alpha1.ConfigMapSecretStatus{ObservedGeneration: 3},
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"},
+		Data:       map[string][]byte{"config.yaml": []byte("a: b\n---\nc: d\n")},
+		Type:       corev1.SecretTypeOpaque,
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&cms, v1alpha1.GroupVersion.WithKind("ConfigMapSecret"))}
+	return &Archive{
+		Created:          time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		ConfigMapSecrets: []v1alpha1.ConfigMapSecret{cms},
+		Secrets:          []corev1.Secret{secret},
+	}
+}
+
+func TestArchive(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	want := testArchive()
+	var buf bytes.Buffer
+	if err := want.Write(&buf, nil); err != ErrKeyRequired {
+		t.Fatalf("expected key required error; got: %v", err)
+	}
+	buf.Reset()
+	if err := want.Write(&buf, key); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("c: d")) {
+		t.Fatalf("archive contains a plaintext Secret value")
+	}
+	archive := buf.Bytes()
+
+	// Write sets the type metadata.
+	want.ConfigMapSecrets[0].TypeMeta = metav1.TypeMeta{APIVersion: "secrets.mz.com/v1alpha1", Kind: "ConfigMapSecret"}
+	want.Secrets[0].TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+
+	got, err := Read(bytes.NewReader(archive), key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected archive (-want +got):\n%s", diff)
+	}
+
+	got, err = Read(bytes.NewReader(archive), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.ConfigMapSecrets) != 1 || len(got.Secrets) != 0 {
+		t.Errorf("unexpected archive without key: %+v", got)
+	}
+
+	if _, err := Read(bytes.NewReader(archive), bytes.Repeat([]byte{2}, KeySize)); err == nil {
+		t.Errorf("expected error with wrong key")
+	}
+}
+
+// A memClient stores ConfigMapSecrets and Secrets in memory.
+type memClient struct {
+	client.Client
+	objs map[string]client.Object
+	uids int
+}
+
+func objKey(obj client.Object, key types.NamespacedName) string {
+	return fmt.Sprintf("%T %s", obj, key)
+}
+
+func (c *memClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	key := objKey(obj, client.ObjectKeyFromObject(obj))
+	if _, ok := c.objs[key]; ok {
+		return apierrors.NewAlreadyExists(corev1.Resource("object"), obj.GetName())
+	}
+	c.uids++
+	obj.SetUID(types.UID(fmt.Sprintf("uid-%d", c.uids)))
+	c.objs[key] = obj.DeepCopyObject().(client.Object)
+	return nil
+}
+
+func (c *memClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	src, ok := c.objs[objKey(obj, key)].(*v1alpha1.ConfigMapSecret)
+	if !ok {
+		return apierrors.NewNotFound(corev1.Resource("object"), key.Name)
+	}
+	src.DeepCopyInto(obj.(*v1alpha1.ConfigMapSecret))
+	return nil
+}
+
+func (c *memClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.objs[objKey(obj, client.ObjectKeyFromObject(obj))] = obj.DeepCopyObject().(client.Object)
+	return nil
+}
+
+func (c *memClient) Status() client.StatusWriter { return c }
+
+func (c *memClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	panic("not implemented")
+}
+
+func TestRestore(t *testing.T) {
+	c := &memClient{objs: make(map[string]client.Object)}
+	a := testArchive()
+	res, err := Restore(context.Background(), c, a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(Result{Created: 1, Secrets: 1}, res); diff != "" {
+		t.Errorf("unexpected result (-want +got):\n%s", diff)
+	}
+	key := types.NamespacedName{Namespace: "ns", Name: "app"}
+	cms := c.objs[objKey(&v1alpha1.ConfigMapSecret{}, key)].(*v1alpha1.ConfigMapSecret)
+	if cms.Status.ObservedGeneration != 3 {
+		t.Errorf("status wasn't restored: %+v", cms.Status)
+	}
+	secret := c.objs[objKey(&corev1.Secret{}, key)].(*corev1.Secret)
+	if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != cms.UID {
+		t.Errorf("unexpected owner: %v", owner)
+	}
+
+	// Restoring again updates the ConfigMapSecret and skips the existing Secret.
+	res, err = Restore(context.Background(), c, a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(Result{Updated: 1}, res); diff != "" {
+		t.Errorf("unexpected result (-want +got):\n%s", diff)
+	}
+}
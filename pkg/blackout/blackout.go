@@ -0,0 +1,234 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blackout parses blackout windows, which are recurring periods during
+// which changes are frozen, e.g. by an organization's change-freeze policy.
+//
+// A window is specified by a cron schedule of its start, with the five fields
+// minute, hour, day of month, month, and day of week, followed by its duration.
+// The schedule may be prefixed with CRON_TZ=<zone> to start the window in the
+// IANA time zone; the default is UTC. For example, this window freezes changes
+// from Friday at 18:00 until Monday at 09:00 in New York:
+//
+//	CRON_TZ=America/New_York 0 18 * * FRI 63h
+//
+// Fields may be *, a value, a range such as 1-5, a step such as */15 or 9-17/2,
+// or a comma-separated list of them. Months and days of the week may be named
+// by their first three letters, and both 0 and 7 are Sunday. As in cron, if
+// both the day of month and the day of week are restricted, a day matches if
+// either does.
+package blackout
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Window is a recurring blackout window.
+type Window struct {
+	spec     string
+	loc      *time.Location
+	duration time.Duration
+
+	minute, hour, dom, month, dow bits
+	// domStar and dowStar are whether the day of month and day of week are
+	// unrestricted, for the cron rule that restricted days match if either does.
+	domStar, dowStar bool
+}
+
+// bits is a set of values of a field.
+type bits uint64
+
+func (b bits) has(v int) bool { return b&(1<<uint(v)) != 0 }
+
+// field describes a field of a cron schedule.
+type field struct {
+	name     string
+	min, max int
+	names    []string // names of the values from min, if any
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	dowField    = field{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// Parse parses a blackout window.
+func Parse(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	w := &Window{spec: strings.Join(fields, " "), loc: time.UTC}
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "CRON_TZ=") {
+		loc, err := time.LoadLocation(strings.TrimPrefix(fields[0], "CRON_TZ="))
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: invalid time zone: %w", spec, err)
+		}
+		w.loc = loc
+		fields = fields[1:]
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("blackout window %q: want 5 schedule fields and a duration, got %d fields", spec, len(fields))
+	}
+	var err error
+	for _, f := range []struct {
+		bits *bits
+		field
+	}{
+		{&w.minute, minuteField},
+		{&w.hour, hourField},
+		{&w.dom, domField},
+		{&w.month, monthField},
+		{&w.dow, dowField},
+	} {
+		if *f.bits, err = f.parse(fields[0]); err != nil {
+			return nil, fmt.Errorf("blackout window %q: %w", spec, err)
+		}
+		fields = fields[1:]
+	}
+	if w.dow.has(7) {
+		w.dow |= 1 // Sunday
+	}
+	w.domStar = w.dom == domField.all()
+	w.dowStar = w.dow&^(1<<7) == dowField.all()&^(1<<7)
+
+	if w.duration, err = time.ParseDuration(fields[0]); err != nil {
+		return nil, fmt.Errorf("blackout window %q: invalid duration: %w", spec, err)
+	}
+	if w.duration <= 0 {
+		return nil, fmt.Errorf("blackout window %q: duration must be positive", spec)
+	}
+	return w, nil
+}
+
+// all returns the set of all values of the field.
+func (f field) all() bits {
+	var b bits
+	for v := f.min; v <= f.max; v++ {
+		b |= 1 << uint(v)
+	}
+	return b
+}
+
+// parse parses a comma-separated list of ranges of the field.
+func (f field) parse(s string) (bits, error) {
+	var b bits
+	for _, r := range strings.Split(s, ",") {
+		rb, err := f.parseRange(r)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", f.name, s, err)
+		}
+		b |= rb
+	}
+	return b, nil
+}
+
+// parseRange parses *, a value, or a range, optionally with a step.
+func (f field) parseRange(s string) (bits, error) {
+	rng, stepStr, hasStep := strings.Cut(s, "/")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid step %q", stepStr)
+		}
+	}
+	lo, hi := f.min, f.max
+	if rng != "*" {
+		loStr, hiStr, isRange := strings.Cut(rng, "-")
+		var err error
+		if lo, err = f.value(loStr); err != nil {
+			return 0, err
+		}
+		hi = lo
+		if isRange {
+			if hi, err = f.value(hiStr); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("range %q is empty", rng)
+			}
+		} else if hasStep {
+			hi = f.max // As in cron, a value with a step is the start of a range.
+		}
+	}
+	var b bits
+	for v := lo; v <= hi; v += step {
+		b |= 1 << uint(v)
+	}
+	return b, nil
+}
+
+// value parses a value of the field, which may be a name.
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("invalid value " + strconv.Quote(s))
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the specification of the window, with its fields separated
+// by single spaces.
+func (w *Window) String() string { return w.spec }
+
+// Duration returns the duration of each occurrence of the window.
+func (w *Window) Duration() time.Duration { return w.duration }
+
+// End returns the end of the occurrence of the window that t is in, if any.
+// If occurrences overlap, it's the end of the last that started.
+func (w *Window) End(t time.Time) (end time.Time, ok bool) {
+	start, ok := w.lastStart(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return start.Add(w.duration), true
+}
+
+// lastStart returns the last start of the window at or before t that's less
+// than its duration before t, if any. It steps back over the days and hours
+// that don't match the schedule, rather than minute by minute.
+func (w *Window) lastStart(t time.Time) (time.Time, bool) {
+	earliest := t.Add(-w.duration)
+	t = t.In(w.loc)
+	s := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, w.loc)
+	for s.After(earliest) {
+		y, m, d := s.Date()
+		switch {
+		case !w.matchDay(s):
+			s = time.Date(y, m, d, 0, 0, 0, 0, w.loc).Add(-time.Minute)
+		case !w.hour.has(s.Hour()):
+			s = time.Date(y, m, d, s.Hour(), 0, 0, 0, w.loc).Add(-time.Minute)
+		case !w.minute.has(s.Minute()):
+			s = s.Add(-time.Minute)
+		default:
+			return s, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchDay returns whether the day of t matches the schedule.
+func (w *Window) matchDay(t time.Time) bool {
+	if !w.month.has(int(t.Month())) {
+		return false
+	}
+	dom, dow := w.dom.has(t.Day()), w.dow.has(int(t.Weekday()))
+	if w.domStar || w.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package blackout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0 18 * * FRI",
+		"0 18 * * FRI 1h extra",
+		"60 18 * * FRI 1h",
+		"0 24 * * FRI 1h",
+		"0 18 0 * * 1h",
+		"0 18 * 13 * 1h",
+		"0 18 * * 8 1h",
+		"0 18 * * FOO 1h",
+		"0 18 * * FRI-MON 1h",
+		"*/0 18 * * * 1h",
+		"0 18 * * FRI 1",
+		"0 18 * * FRI -1h",
+		"CRON_TZ=Nowhere/Special 0 18 * * FRI 1h",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected error", spec)
+		}
+	}
+}
+
+func TestEnd(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		spec string
+		t    string
+		end  string // empty if t isn't in the window
+	}{
+		// 2022-07-01 is a Friday.
+		{spec: "0 18 * * FRI 63h", t: "2022-07-01T17:59:59Z"},
+		{spec: "0 18 * * FRI 63h", t: "2022-07-01T18:00:00Z", end: "2022-07-04T09:00:00Z"},
+		{spec: "0 18 * * FRI 63h", t: "2022-07-03T12:00:00Z", end: "2022-07-04T09:00:00Z"},
+		{spec: "0 18 * * FRI 63h", t: "2022-07-04T09:00:00Z"},
+		{spec: "0 18 * * 5 63h", t: "2022-07-02T00:00:00Z", end: "2022-07-04T09:00:00Z"},
+		// Sunday is 0 or 7.
+		{spec: "0 0 * * 7 24h", t: "2022-07-03T12:00:00Z", end: "2022-07-04T00:00:00Z"},
+		{spec: "0 0 * * SUN 24h", t: "2022-07-03T12:00:00Z", end: "2022-07-04T00:00:00Z"},
+		// Steps, ranges, and lists.
+		{spec: "*/15 * * * * 5m", t: "2022-07-01T10:31:00Z", end: "2022-07-01T10:35:00Z"},
+		{spec: "*/15 * * * * 5m", t: "2022-07-01T10:36:00Z"},
+		{spec: "0 9-17/4 * * * 1h", t: "2022-07-01T13:30:00Z", end: "2022-07-01T14:00:00Z"},
+		{spec: "0 9-17/4 * * * 1h", t: "2022-07-01T11:30:00Z"},
+		{spec: "0 9,12 * * MON-FRI 1h", t: "2022-07-01T12:59:00Z", end: "2022-07-01T13:00:00Z"},
+		{spec: "0 9,12 * * MON-FRI 1h", t: "2022-07-02T12:30:00Z"},
+		// A holiday freeze from December 20 through January 2.
+		{spec: "0 0 20 DEC * 336h", t: "2023-01-01T12:00:00Z", end: "2023-01-03T00:00:00Z"},
+		{spec: "0 0 20 DEC * 336h", t: "2022-12-19T23:59:00Z"},
+		// If both days are restricted, either matches.
+		{spec: "0 0 1 * MON 1h", t: "2022-07-01T00:30:00Z", end: "2022-07-01T01:00:00Z"},
+		{spec: "0 0 1 * MON 1h", t: "2022-07-04T00:30:00Z", end: "2022-07-04T01:00:00Z"},
+		{spec: "0 0 1 * MON 1h", t: "2022-07-05T00:30:00Z"},
+		// Overlapping occurrences end with the last.
+		{spec: "* * * * * 2m", t: "2022-07-01T10:00:30Z", end: "2022-07-01T10:02:00Z"},
+		// Time zones.
+		{spec: "CRON_TZ=America/New_York 0 18 * * FRI 63h", t: "2022-07-01T21:59:00Z"},
+		{spec: "CRON_TZ=America/New_York 0 18 * * FRI 63h", t: "2022-07-01T22:00:00Z", end: "2022-07-04T13:00:00Z"},
+		{spec: "CRON_TZ=Asia/Kolkata 0 9 * * * 1h", t: "2022-07-01T03:45:00Z", end: "2022-07-01T04:30:00Z"},
+	}
+	for _, tt := range tests {
+		w, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		end, ok := w.End(utc(tt.t))
+		if tt.end == "" {
+			if ok {
+				t.Errorf("%q: End(%s): got %v, want not in window", tt.spec, tt.t, end)
+			}
+			continue
+		}
+		if !ok || !end.Equal(utc(tt.end)) {
+			t.Errorf("%q: End(%s): got %v, %t, want %s", tt.spec, tt.t, end, ok, tt.end)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	w, err := Parse("  CRON_TZ=UTC 0  18 * * FRI\t63h ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "CRON_TZ=UTC 0 18 * * FRI 63h"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := w.Duration(), 63*time.Hour; got != want {
+		t.Errorf("got duration %v, want %v", got, want)
+	}
+}
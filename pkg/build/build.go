@@ -0,0 +1,251 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package build builds ConfigMapSecrets programmatically, e.g. in tests or
+// by platforms that generate them from service descriptors, without the
+// nested struct literals of their sources and variables.
+//
+//	cms := build.NewConfigMapSecret("app-config").
+//		WithNamespace("default").
+//		WithDataTemplate("config.yaml", "password: $(PASSWORD)\n").
+//		WithSecretVar("PASSWORD", "app-credentials", "password").
+//		ConfigMapSecret()
+package build
+
+import (
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A Builder builds a ConfigMapSecret. Its methods set a field and return the
+// builder, so that calls can be chained. The zero value isn't usable.
+type Builder struct {
+	cms *v1alpha1.ConfigMapSecret
+
+	// lastVar is whether a variable was appended after the last variables
+	// source, for Optional.
+	lastVar bool
+}
+
+// NewConfigMapSecret returns a builder of a ConfigMapSecret with the name,
+// whose rendered Secret has the same name unless it's set.
+func NewConfigMapSecret(name string) *Builder {
+	return &Builder{cms: &v1alpha1.ConfigMapSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "ConfigMapSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}}
+}
+
+// ConfigMapSecret returns a copy of the built ConfigMapSecret, so that the
+// builder may be reused, e.g. as the base of variants.
+func (b *Builder) ConfigMapSecret() *v1alpha1.ConfigMapSecret {
+	return b.cms.DeepCopy()
+}
+
+// WithNamespace sets the namespace of the ConfigMapSecret.
+func (b *Builder) WithNamespace(namespace string) *Builder {
+	b.cms.Namespace = namespace
+	return b
+}
+
+// WithLabel sets a label of the ConfigMapSecret.
+func (b *Builder) WithLabel(key, value string) *Builder {
+	setString(&b.cms.Labels, key, value)
+	return b
+}
+
+// WithAnnotation sets an annotation of the ConfigMapSecret, e.g. one of the
+// v1alpha1 annotations that configure how it's reconciled.
+func (b *Builder) WithAnnotation(key, value string) *Builder {
+	setString(&b.cms.Annotations, key, value)
+	return b
+}
+
+// WithSecretName sets the name of the rendered Secret.
+func (b *Builder) WithSecretName(name string) *Builder {
+	b.cms.Spec.Template.Metadata.Name = name
+	return b
+}
+
+// WithSecretLabel sets a label of the rendered Secret.
+func (b *Builder) WithSecretLabel(key, value string) *Builder {
+	setString(&b.cms.Spec.Template.Metadata.Labels, key, value)
+	return b
+}
+
+// WithSecretAnnotation sets an annotation of the rendered Secret.
+func (b *Builder) WithSecretAnnotation(key, value string) *Builder {
+	setString(&b.cms.Spec.Template.Metadata.Annotations, key, value)
+	return b
+}
+
+// WithTemplateVersion sets the version of the template language.
+func (b *Builder) WithTemplateVersion(version v1alpha1.TemplateVersion) *Builder {
+	b.cms.Spec.TemplateVersion = version
+	return b
+}
+
+// WithDelimiters sets the delimiters of variable references of v1 templates.
+func (b *Builder) WithDelimiters(left, right string) *Builder {
+	b.cms.Spec.Template.Delimiters = &v1alpha1.Delimiters{Left: left, Right: right}
+	return b
+}
+
+// WithDataTemplate sets the template of a key of the data of the rendered Secret.
+func (b *Builder) WithDataTemplate(key, text string) *Builder {
+	setString(&b.cms.Spec.Template.Data, key, text)
+	return b
+}
+
+// WithStringDataTemplate sets the template of a key of the string data of the
+// rendered Secret.
+func (b *Builder) WithStringDataTemplate(key, text string) *Builder {
+	setString(&b.cms.Spec.Template.StringData, key, text)
+	return b
+}
+
+// WithBinaryDataTemplate sets the template of a key of the binary data of the
+// rendered Secret.
+func (b *Builder) WithBinaryDataTemplate(key string, data []byte) *Builder {
+	if b.cms.Spec.Template.BinaryData == nil {
+		b.cms.Spec.Template.BinaryData = make(map[string][]byte)
+	}
+	b.cms.Spec.Template.BinaryData[key] = append([]byte(nil), data...)
+	return b
+}
+
+// WithKeyOptions sets the options of a key of the rendered Secret.
+func (b *Builder) WithKeyOptions(key string, opts v1alpha1.KeyOptions) *Builder {
+	if b.cms.Spec.Template.KeyOptions == nil {
+		b.cms.Spec.Template.KeyOptions = make(map[string]v1alpha1.KeyOptions)
+	}
+	b.cms.Spec.Template.KeyOptions[key] = opts
+	return b
+}
+
+// WithVar appends a variable with the value, which may reference previously
+// defined variables.
+func (b *Builder) WithVar(name, value string) *Builder {
+	b.cms.Spec.Vars = append(b.cms.Spec.Vars, v1alpha1.Var{Name: name, Value: value})
+	b.lastVar = true
+	return b
+}
+
+// WithSecretVar appends a variable whose value is the key of the Secret.
+func (b *Builder) WithSecretVar(name, secretName, key string) *Builder {
+	b.cms.Spec.Vars = append(b.cms.Spec.Vars, v1alpha1.Var{
+		Name: name,
+		SecretValue: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+			Key:                  key,
+		},
+	})
+	b.lastVar = true
+	return b
+}
+
+// WithConfigMapVar appends a variable whose value is the key of the ConfigMap.
+func (b *Builder) WithConfigMapVar(name, configMapName, key string) *Builder {
+	b.cms.Spec.Vars = append(b.cms.Spec.Vars, v1alpha1.Var{
+		Name: name,
+		ConfigMapValue: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			Key:                  key,
+		},
+	})
+	b.lastVar = true
+	return b
+}
+
+// WithClusterTrustBundleVar appends a variable whose value is the PEM
+// certificates of the ClusterTrustBundles selected by sel.
+func (b *Builder) WithClusterTrustBundleVar(name string, sel v1alpha1.ClusterTrustBundleSelector) *Builder {
+	b.cms.Spec.Vars = append(b.cms.Spec.Vars, v1alpha1.Var{
+		Name:                    name,
+		ClusterTrustBundleValue: sel.DeepCopy(),
+	})
+	b.lastVar = true
+	return b
+}
+
+// WithSecretVarsFrom appends a source of variables named by the prefix and
+// the keys of the Secret.
+func (b *Builder) WithSecretVarsFrom(secretName, prefix string) *Builder {
+	b.cms.Spec.VarsFrom = append(b.cms.Spec.VarsFrom, v1alpha1.VarsFromSource{
+		Prefix: prefix,
+		SecretRef: &v1alpha1.SecretVarsSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+		},
+	})
+	b.lastVar = false
+	return b
+}
+
+// WithConfigMapVarsFrom appends a source of variables named by the prefix and
+// the keys of the ConfigMap.
+func (b *Builder) WithConfigMapVarsFrom(configMapName, prefix string) *Builder {
+	b.cms.Spec.VarsFrom = append(b.cms.Spec.VarsFrom, v1alpha1.VarsFromSource{
+		Prefix: prefix,
+		ConfigMapRef: &v1alpha1.ConfigMapVarsSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+		},
+	})
+	b.lastVar = false
+	return b
+}
+
+// Optional makes the source of the last appended variable or variables
+// source optional, such that it isn't an error if it doesn't exist. It
+// panics if there's none or its value is literal.
+func (b *Builder) Optional() *Builder {
+	optional := true
+	spec := &b.cms.Spec
+	if n := len(spec.Vars); n > 0 && b.lastVar {
+		switch v := &spec.Vars[n-1]; {
+		case v.SecretValue != nil:
+			v.SecretValue.Optional = &optional
+		case v.ConfigMapValue != nil:
+			v.ConfigMapValue.Optional = &optional
+		case v.ClusterTrustBundleValue != nil:
+			v.ClusterTrustBundleValue.Optional = &optional
+		default:
+			panic("build: literal variable " + v.Name + " can't be optional")
+		}
+		return b
+	}
+	n := len(spec.VarsFrom)
+	if n == 0 {
+		panic("build: no variable source to make optional")
+	}
+	switch v := &spec.VarsFrom[n-1]; {
+	case v.SecretRef != nil:
+		v.SecretRef.Optional = &optional
+	case v.ConfigMapRef != nil:
+		v.ConfigMapRef.Optional = &optional
+	}
+	return b
+}
+
+// WithTransforms appends transforms to the last appended variable. It panics
+// if there's none, e.g. if a variables source was appended last.
+func (b *Builder) WithTransforms(transforms ...v1alpha1.Transform) *Builder {
+	n := len(b.cms.Spec.Vars)
+	if n == 0 || !b.lastVar {
+		panic("build: no variable to transform")
+	}
+	v := &b.cms.Spec.Vars[n-1]
+	v.Transforms = append(v.Transforms, transforms...)
+	return b
+}
+
+func setString(m *map[string]string, key, value string) {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+}
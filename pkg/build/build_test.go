@@ -0,0 +1,125 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuilder(t *testing.T) {
+	optional := true
+	b := NewConfigMapSecret("cms").
+		WithNamespace("ns").
+		WithLabel("app", "cms").
+		WithAnnotation(v1alpha1.DebugAnnotation, "true").
+		WithSecretName("secret").
+		WithSecretLabel("app", "secret").
+		WithDataTemplate("a", "$(A)").
+		WithStringDataTemplate("b", "$(B)").
+		WithBinaryDataTemplate("c", []byte{0, 1}).
+		WithKeyOptions("a", v1alpha1.KeyOptions{Validate: "json"}).
+		WithVar("A", "a").
+		WithSecretVar("B", "s", "b").
+		WithTransforms(v1alpha1.Transform{Func: v1alpha1.TransformB64Dec}).
+		WithConfigMapVarsFrom("cm", "CM_").
+		Optional().
+		WithConfigMapVar("C", "cm", "c").
+		Optional().
+		WithSecretVarsFrom("s", "S_")
+
+	want := &v1alpha1.ConfigMapSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "ConfigMapSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "ns",
+			Name:        "cms",
+			Labels:      map[string]string{"app": "cms"},
+			Annotations: map[string]string{v1alpha1.DebugAnnotation: "true"},
+		},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Metadata: v1alpha1.EmbeddedObjectMeta{
+					Name:   "secret",
+					Labels: map[string]string{"app": "secret"},
+				},
+				Data:       map[string]string{"a": "$(A)"},
+				StringData: map[string]string{"b": "$(B)"},
+				BinaryData: map[string][]byte{"c": {0, 1}},
+				KeyOptions: map[string]v1alpha1.KeyOptions{"a": {Validate: "json"}},
+			},
+			Vars: []v1alpha1.Var{
+				{Name: "A", Value: "a"},
+				{Name: "B", SecretValue: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "s"},
+					Key:                  "b",
+				}, Transforms: []v1alpha1.Transform{{Func: v1alpha1.TransformB64Dec}}},
+				{Name: "C", ConfigMapValue: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "cm"},
+					Key:                  "c",
+					Optional:             &optional,
+				}},
+			},
+			VarsFrom: []v1alpha1.VarsFromSource{
+				{Prefix: "CM_", ConfigMapRef: &v1alpha1.ConfigMapVarsSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "cm"},
+					Optional:             &optional,
+				}},
+				{Prefix: "S_", SecretRef: &v1alpha1.SecretVarsSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "s"},
+				}},
+			},
+		},
+	}
+	got := b.ConfigMapSecret()
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// The built ConfigMapSecret is a copy.
+	got.Spec.Template.Data["a"] = "changed"
+	b.WithDataTemplate("d", "$(D)")
+	if got := b.ConfigMapSecret().Spec.Template.Data; got["a"] != "$(A)" || got["d"] != "$(D)" {
+		t.Errorf("got data %v after changing a copy", got)
+	}
+}
+
+func TestOptionalPanics(t *testing.T) {
+	for name, b := range map[string]*Builder{
+		"none":    NewConfigMapSecret("cms"),
+		"literal": NewConfigMapSecret("cms").WithVar("A", "a"),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Optional didn't panic", name)
+				}
+			}()
+			b.Optional()
+		}()
+	}
+}
+
+func TestWithTransformsPanics(t *testing.T) {
+	for name, b := range map[string]*Builder{
+		"none":     NewConfigMapSecret("cms"),
+		"varsFrom": NewConfigMapSecret("cms").WithVar("A", "a").WithSecretVarsFrom("s", ""),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: WithTransforms didn't panic", name)
+				}
+			}()
+			b.WithTransforms(v1alpha1.Transform{Func: v1alpha1.TransformTrim})
+		}()
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package build_test
+
+import (
+	"fmt"
+
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	"sigs.k8s.io/yaml"
+)
+
+// This example builds the minimal ConfigMapSecret of the v1alpha1 example,
+// whose rendered Secret contains a config file with a password read from
+// another Secret.
+func Example() {
+	cms := build.NewConfigMapSecret("app-config").
+		WithNamespace("default").
+		WithDataTemplate("config.yaml", "user: app\npassword: $(PASSWORD)\n").
+		WithSecretVar("PASSWORD", "app-credentials", "password").
+		ConfigMapSecret()
+
+	buf, err := yaml.Marshal(cms.Spec)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(string(buf))
+	// Output:
+	// template:
+	//   data:
+	//     config.yaml: |
+	//       user: app
+	//       password: $(PASSWORD)
+	//   metadata: {}
+	// vars:
+	// - name: PASSWORD
+	//   secretValue:
+	//     key: password
+	//     name: app-credentials
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// setSyncAnnotations sets the annotations, which must be LastAppliedHashAnnotation
+// or LastSyncTimeAnnotation, on the Secret to be written over prev, which is nil if it doesn't exist. The
+// sync time is only changed with the data, so that unchanged Secrets aren't
+// rewritten.
+func setSyncAnnotations(secret, prev *corev1.Secret, annotations []string, now time.Time) {
+	if len(annotations) == 0 {
+		return
+	}
+	m := make(map[string]string, len(secret.Annotations)+len(annotations))
+	for k, v := range secret.Annotations {
+		m[k] = v
+	}
+	for _, key := range annotations {
+		switch key {
+		case v1alpha1.LastAppliedHashAnnotation:
+			m[key] = dataHash(secret.Data)
+		case v1alpha1.LastSyncTimeAnnotation:
+			m[key] = now.UTC().Format(time.RFC3339)
+			if prev != nil && reflect.DeepEqual(prev.Data, secret.Data) {
+				if t, ok := prev.Annotations[key]; ok {
+					m[key] = t
+				}
+			}
+		}
+	}
+	secret.Annotations = m
+}
+
+// dataHash returns the hex-encoded SHA-256 hash of the data.
+func dataHash(data map[string][]byte) string {
+	// Maps are encoded with sorted keys, so the encoding is deterministic.
+	buf, _ := json.Marshal(data) // Can't fail.
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetSyncAnnotations(t *testing.T) {
+	const (
+		then = "2022-08-01T00:00:00Z"
+		now  = "2022-08-02T00:00:00Z"
+	)
+	nowTime, _ := time.Parse(time.RFC3339, now)
+	data := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	hash := dataHash(data)
+	all := []string{v1alpha1.LastAppliedHashAnnotation, v1alpha1.LastSyncTimeAnnotation}
+
+	tests := []struct {
+		name        string
+		prev        *corev1.Secret
+		annotations []string
+		want        map[string]string
+	}{
+		{
+			name: "disabled",
+			prev: nil,
+			want: map[string]string{"app": "web"},
+		},
+		{
+			name:        "created",
+			prev:        nil,
+			annotations: all,
+			want: map[string]string{
+				"app":                              "web",
+				v1alpha1.LastAppliedHashAnnotation: hash,
+				v1alpha1.LastSyncTimeAnnotation:    now,
+			},
+		},
+		{
+			name: "unchanged",
+			prev: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.LastSyncTimeAnnotation: then}},
+				Data:       map[string][]byte{"a": []byte("1"), "b": []byte("2")},
+			},
+			annotations: all,
+			want: map[string]string{
+				"app":                              "web",
+				v1alpha1.LastAppliedHashAnnotation: hash,
+				v1alpha1.LastSyncTimeAnnotation:    then,
+			},
+		},
+		{
+			name: "changed",
+			prev: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.LastSyncTimeAnnotation: then}},
+				Data:       map[string][]byte{"a": []byte("1")},
+			},
+			annotations: all,
+			want: map[string]string{
+				"app":                              "web",
+				v1alpha1.LastAppliedHashAnnotation: hash,
+				v1alpha1.LastSyncTimeAnnotation:    now,
+			},
+		},
+		{
+			name: "newly enabled",
+			prev: &corev1.Secret{
+				Data: map[string][]byte{"a": []byte("1"), "b": []byte("2")},
+			},
+			annotations: []string{v1alpha1.LastSyncTimeAnnotation},
+			want: map[string]string{
+				"app":                           "web",
+				v1alpha1.LastSyncTimeAnnotation: now,
+			},
+		},
+	}
+	for _, tt := range tests {
+		rendered := map[string]string{"app": "web"}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: rendered},
+			Data:       data,
+		}
+		setSyncAnnotations(secret, tt.prev, tt.annotations, nowTime)
+		if diff := cmp.Diff(tt.want, secret.Annotations); diff != "" {
+			t.Errorf("%s: unexpected annotations (-want +got):\n%s", tt.name, diff)
+		}
+		if len(rendered) != 1 {
+			t.Errorf("%s: unexpected rendered annotations modified: %v", tt.name, rendered)
+		}
+	}
+
+	if dataHash(map[string][]byte{"a": []byte("1")}) == hash {
+		t.Errorf("unexpected hash of different data")
+	}
+}
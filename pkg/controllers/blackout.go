@@ -0,0 +1,48 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/blackout"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var deferredWrites = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "configmapsecret_controller_deferred_writes_total",
+		Help: "Number of Secret updates and deletions deferred by blackout windows.",
+	},
+	[]string{"namespace", "op"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(deferredWrites)
+}
+
+// blackout returns the window that defers writes of the Secrets of the
+// ConfigMapSecret at now, and when it ends, if any. If windows overlap, it's
+// the one that ends last. Critical ConfigMapSecrets are never deferred.
+func (r *ConfigMapSecret) blackout(cms *v1alpha1.ConfigMapSecret, now time.Time) (window *blackout.Window, end time.Time, ok bool) {
+	if cms.Annotations[v1alpha1.CriticalAnnotation] == "true" {
+		return nil, time.Time{}, false
+	}
+	for _, w := range r.BlackoutWindows {
+		if e, in := w.End(now); in && e.After(end) {
+			window, end, ok = w, e, true
+		}
+	}
+	return window, end, ok
+}
+
+// pendingMessage returns the message of the PendingChanges condition of a
+// Secret update deferred by the window until end.
+func pendingMessage(window *blackout.Window, end time.Time) string {
+	return fmt.Sprintf("Secret update deferred until %s by blackout window %q.", end.UTC().Format(time.RFC3339), window)
+}
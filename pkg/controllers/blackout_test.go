@@ -0,0 +1,174 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/blackout"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func mustParseWindow(t *testing.T, spec string) *blackout.Window {
+	t.Helper()
+	w, err := blackout.Parse(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return w
+}
+
+func TestBlackout(t *testing.T) {
+	// 2022-07-01 is a Friday.
+	now := time.Date(2022, 7, 1, 20, 0, 0, 0, time.UTC)
+	weekend := mustParseWindow(t, "0 18 * * FRI 63h")
+	evening := mustParseWindow(t, "0 17 * * * 4h")
+	r := &ConfigMapSecret{BlackoutWindows: []*blackout.Window{evening, weekend}}
+	cms := &v1alpha1.ConfigMapSecret{}
+
+	// Overlapping windows defer until the last ends.
+	window, end, ok := r.blackout(cms, now)
+	if !ok || window != weekend || !end.Equal(time.Date(2022, 7, 4, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected blackout: %v, %v, %t", window, end, ok)
+	}
+	if _, _, ok := r.blackout(cms, now.Add(-4*time.Hour)); ok {
+		t.Error("unexpected blackout outside of the windows")
+	}
+
+	// Critical ConfigMapSecrets aren't deferred.
+	cms.Annotations = map[string]string{v1alpha1.CriticalAnnotation: "true"}
+	if _, _, ok := r.blackout(cms, now); ok {
+		t.Error("unexpected blackout of a critical ConfigMapSecret")
+	}
+}
+
+// A blackoutClient gets its ConfigMapSecret, and gets and writes Secrets in
+// memory.
+type blackoutClient struct {
+	fixtureClient
+	cms     *v1alpha1.ConfigMapSecret
+	updates int
+	deleted []string
+}
+
+func (c *blackoutClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if obj, ok := obj.(*v1alpha1.ConfigMapSecret); ok {
+		c.cms.DeepCopyInto(obj)
+		return nil
+	}
+	return c.fixtureClient.Get(ctx, key, obj)
+}
+
+func (c *blackoutClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.updates++
+	secret := obj.(*corev1.Secret)
+	c.secrets[types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}] = secret.DeepCopy()
+	return nil
+}
+
+func (c *blackoutClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.deleted = append(c.deleted, obj.GetName())
+	delete(c.secrets, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+	return nil
+}
+
+func (c *blackoutClient) Status() client.StatusWriter {
+	return &blackoutStatusWriter{c}
+}
+
+type blackoutStatusWriter struct {
+	*blackoutClient
+}
+
+func (w *blackoutStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	obj.(*v1alpha1.ConfigMapSecret).Status.DeepCopyInto(&w.cms.Status)
+	return nil
+}
+
+func TestReconcileBlackout(t *testing.T) {
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "uid"},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{Data: map[string]string{"key": "new"}},
+		},
+	}
+	owner := []metav1.OwnerReference{{
+		APIVersion:         v1alpha1.GroupVersion.String(),
+		Kind:               "ConfigMapSecret",
+		Name:               "app",
+		UID:                "uid",
+		Controller:         pointer.Bool(true),
+		BlockOwnerDeletion: pointer.Bool(true),
+	}}
+	c := &blackoutClient{
+		fixtureClient: fixtureClient{
+			secrets: map[types.NamespacedName]*corev1.Secret{
+				{Namespace: "default", Name: "app"}: {
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", OwnerReferences: owner},
+					Data:       map[string][]byte{"key": []byte("old")},
+				},
+				{Namespace: "default", Name: "old"}: {
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "old", OwnerReferences: owner},
+				},
+			},
+		},
+		cms: cms,
+	}
+	r := &ConfigMapSecret{
+		BlackoutWindows: []*blackout.Window{mustParseWindow(t, "* * * * * 1h")},
+		client:          c,
+		scheme:          scheme,
+		logger:          logr.Discard(),
+	}
+	r.owned.set("default", "old", map[string]bool{"uid": true})
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+
+	// During the window, neither the update nor the cleanup is made.
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > time.Hour {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if c.updates != 0 || len(c.deleted) != 0 {
+		t.Fatalf("unexpected writes: %d updates, deleted %v", c.updates, c.deleted)
+	}
+	cond := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretPendingChanges)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != v1alpha1.BlackoutWindowReason {
+		t.Fatalf("unexpected condition: %+v", cond)
+	}
+	ready := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretReady)
+	if ready == nil || ready.Status != corev1.ConditionFalse || ready.Message != cond.Message {
+		t.Errorf("unexpected Ready condition: %+v", ready)
+	}
+
+	// A critical ConfigMapSecret is written, and the condition is removed.
+	cms.Annotations = map[string]string{v1alpha1.CriticalAnnotation: "true"}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.updates != 1 || len(c.deleted) != 1 {
+		t.Fatalf("unexpected writes: %d updates, deleted %v", c.updates, c.deleted)
+	}
+	if got := string(c.secrets[req.NamespacedName].Data["key"]); got != "new" {
+		t.Errorf("unexpected data: %q", got)
+	}
+	if cond := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretPendingChanges); cond != nil {
+		t.Errorf("unexpected condition: %+v", cond)
+	}
+	if ready := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretReady); ready == nil || ready.Status != corev1.ConditionTrue {
+		t.Errorf("unexpected Ready condition: %+v", ready)
+	}
+}
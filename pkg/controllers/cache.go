@@ -0,0 +1,80 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"sync"
+
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var renderCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_render_cache_lookups_total",
+	Help: "Total number of lookups of rendered data in the render cache, by result (hit or miss).",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(renderCacheLookups)
+}
+
+// A renderCache holds the data last rendered for each ConfigMapSecret and the
+// hash of its inputs, so that a reconciliation whose inputs haven't changed
+// skips rendering. The zero value is ready to use.
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]renderCacheEntry
+}
+
+type renderCacheEntry struct {
+	hash renderer.Hash
+	data map[string][]byte
+}
+
+// Get returns a copy of the data rendered for the ConfigMapSecret, if it was
+// rendered from inputs with the hash.
+func (c *renderCache) Get(key types.NamespacedName, hash renderer.Hash) (map[string][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.hash != hash {
+		renderCacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	renderCacheLookups.WithLabelValues("hit").Inc()
+	return copyData(e.data), true
+}
+
+// Put records the data rendered for the ConfigMapSecret from inputs with the hash.
+func (c *renderCache) Put(key types.NamespacedName, hash renderer.Hash, data map[string][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[types.NamespacedName]renderCacheEntry)
+	}
+	c.entries[key] = renderCacheEntry{hash: hash, data: copyData(data)}
+}
+
+// forget removes the data rendered for the ConfigMapSecret.
+func (c *renderCache) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// copyData returns a copy of the map. Values are shared, because they're
+// replaced rather than modified, e.g. by hooks.
+func copyData(data map[string][]byte) map[string][]byte {
+	m := make(map[string][]byte, len(data))
+	for k, v := range data {
+		m[k] = v
+	}
+	return m
+}
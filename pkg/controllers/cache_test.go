@@ -0,0 +1,52 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRenderCache(t *testing.T) {
+	spec := &v1alpha1.ConfigMapSecretSpec{
+		Template: v1alpha1.ConfigMapTemplate{
+			Data: map[string]string{"a": "$(A)", "b": "$(B)"},
+		},
+	}
+	hash, err := renderer.NewHash(spec, map[string]string{"A": "1", "B": "2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var c renderCache
+	key := types.NamespacedName{Namespace: "ns", Name: "cms"}
+	if _, ok := c.Get(key, hash); ok {
+		t.Fatalf("unexpected hit in empty cache")
+	}
+	want := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	c.Put(key, hash, want)
+	got, ok := c.Get(key, hash)
+	if !ok {
+		t.Fatalf("unexpected miss")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected data (-want +got):\n%s", diff)
+	}
+	got["c"] = []byte("3") // Copies must be independent.
+	if got, _ := c.Get(key, hash); len(got) != 2 {
+		t.Errorf("unexpected data modified by caller: %v", got)
+	}
+	if _, ok := c.Get(key, renderer.Hash{}); ok {
+		t.Errorf("unexpected hit for different hash")
+	}
+	c.forget(key)
+	if _, ok := c.Get(key, hash); ok {
+		t.Errorf("unexpected hit after forget")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// A cleanupClient gets its ConfigMapSecret and a Secret it used to own,
+// fails to get its rendered Secret, has no ConfigMaps, and fails to delete
+// Secrets if deleteErr is set.
+type cleanupClient struct {
+	client.Client
+	cms       *v1alpha1.ConfigMapSecret
+	deleteErr error
+	deleted   []string
+}
+
+func (c *cleanupClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	switch obj := obj.(type) {
+	case *v1alpha1.ConfigMapSecret:
+		c.cms.DeepCopyInto(obj)
+		return nil
+	case *corev1.ConfigMap:
+		return apierrors.NewNotFound(corev1.Resource("configmaps"), key.Name)
+	case *corev1.Secret:
+		if key.Name == "old" {
+			obj.ObjectMeta = metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}
+			return nil
+		}
+	}
+	return errors.New("get failed")
+}
+
+func (c *cleanupClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+	c.deleted = append(c.deleted, obj.GetName())
+	return nil
+}
+
+func (c *cleanupClient) Status() client.StatusWriter {
+	return &cleanupStatusWriter{c}
+}
+
+type cleanupStatusWriter struct {
+	*cleanupClient
+}
+
+func (w *cleanupStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	obj.(*v1alpha1.ConfigMapSecret).Status.DeepCopyInto(&w.cms.Status)
+	return nil
+}
+
+func TestReconcileCleanupError(t *testing.T) {
+	c := &cleanupClient{
+		cms: &v1alpha1.ConfigMapSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "renamed", UID: "uid"},
+		},
+		deleteErr: errors.New("delete failed"),
+	}
+	r := &ConfigMapSecret{
+		client: c,
+		scheme: scheme,
+		logger: logr.Discard(),
+	}
+	r.owned.set("default", "old", map[string]bool{"uid": true})
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "renamed"}}
+
+	// Both the sync and cleanup errors are reported.
+	_, err := r.Reconcile(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "get failed") || !strings.Contains(err.Error(), "delete failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cond := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretCleanupFailed)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != v1alpha1.CleanupErrorReason || cond.Message != "delete failed" {
+		t.Errorf("unexpected condition: %+v", cond)
+	}
+
+	// The condition is removed once cleanup succeeds.
+	c.deleteErr = nil
+	if _, err := r.Reconcile(context.Background(), req); err == nil || err.Error() != "get failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := []string{"old"}, c.deleted; !reflect.DeepEqual(want, got) {
+		t.Errorf("unexpected deleted Secrets: want: %v; got: %v", want, got)
+	}
+	if cond := GetConfigMapSecretCondition(c.cms.Status, v1alpha1.ConfigMapSecretCleanupFailed); cond != nil {
+		t.Errorf("unexpected condition: %+v", cond)
+	}
+}
+
+// A migrationClient gets, updates, and deletes Secrets in memory.
+type migrationClient struct {
+	client.Client
+	secrets map[string]*corev1.Secret
+}
+
+func (c *migrationClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	secret, ok := c.secrets[key.Name]
+	if !ok {
+		return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	secret.DeepCopyInto(obj.(*corev1.Secret))
+	return nil
+}
+
+func (c *migrationClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.secrets[obj.GetName()] = obj.(*corev1.Secret).DeepCopy()
+	return nil
+}
+
+func (c *migrationClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	delete(c.secrets, obj.GetName())
+	return nil
+}
+
+func TestCleanupMigration(t *testing.T) {
+	ctx := context.Background()
+	c := &migrationClient{secrets: map[string]*corev1.Secret{
+		"old": {
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "old"},
+			Data:       map[string][]byte{"key": []byte("old")},
+		},
+		"new": {
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "new"},
+			Data:       map[string][]byte{"key": []byte("new")},
+		},
+	}}
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cms", UID: "uid"},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template:  v1alpha1.ConfigMapTemplate{Metadata: v1alpha1.EmbeddedObjectMeta{Name: "new"}},
+			Migration: &v1alpha1.Migration{GracePeriod: &metav1.Duration{Duration: time.Hour}},
+		},
+	}
+	r := &ConfigMapSecret{client: c, scheme: scheme, logger: logr.Discard()}
+	r.owned.set("default", "old", map[string]bool{"uid": true})
+	r.owned.set("default", "new", map[string]bool{"uid": true})
+
+	// The old Secret is updated with the data of the new one.
+	after, err := r.cleanup(ctx, r.logger, cms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after <= 0 || after > time.Hour {
+		t.Errorf("unexpected requeue: %v", after)
+	}
+	old, ok := c.secrets["old"]
+	if !ok {
+		t.Fatal("old Secret was deleted during the grace period")
+	}
+	if want, got := "new", string(old.Data["key"]); want != got {
+		t.Errorf("unexpected data; want: %q; got: %q", want, got)
+	}
+	since, err := time.Parse(time.RFC3339, old.Annotations[v1alpha1.SupersededTimeAnnotation])
+	if err != nil {
+		t.Fatalf("unexpected superseded time: %v", err)
+	}
+
+	// The old Secret is deleted once the grace period ends.
+	old.Annotations[v1alpha1.SupersededTimeAnnotation] = since.Add(-time.Hour).Format(time.RFC3339)
+	if after, err := r.cleanup(ctx, r.logger, cms); err != nil || after != 0 {
+		t.Fatalf("unexpected result: %v, %v", after, err)
+	}
+	if _, ok := c.secrets["old"]; ok {
+		t.Error("old Secret wasn't deleted after the grace period")
+	}
+	if _, ok := c.secrets["new"]; !ok {
+		t.Error("new Secret was deleted")
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	if err := joinErrors(nil, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := joinErrors(nil, a, nil); err != a {
+		t.Errorf("unexpected error: want: %v; got: %v", a, err)
+	}
+	err := joinErrors(a, nil, b)
+	if !errors.Is(err, a) || !errors.Is(err, b) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -5,29 +5,59 @@
 package controllers
 
 import (
+	"sort"
+	"unicode/utf8"
+
 	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	// CreateVariablesErrorReason is the reason given when required ConfigMapSecret
-	// variables cannot be resolved.
-	CreateVariablesErrorReason = "CreateVariablesError"
+	// MaxConditionMessageLength is the maximum length in bytes of a condition
+	// message. Longer messages are truncated.
+	MaxConditionMessageLength = 1024
+	// MaxConditions is the maximum number of conditions in a status.
+	MaxConditions = 8
 
-	internalError = "InternalError"
+	truncatedSuffix = "..."
 )
 
+// conditionTypes are the condition types managed by the controller.
+// Conditions of any other type are pruned from the status.
+var conditionTypes = map[v1alpha1.ConfigMapSecretConditionType]bool{
+	v1alpha1.ConfigMapSecretReady:           true,
+	v1alpha1.ConfigMapSecretRenderFailure:   true,
+	v1alpha1.ConfigMapSecretDegraded:        true,
+	v1alpha1.ConfigMapSecretCleanupFailed:   true,
+	v1alpha1.ConfigMapSecretDegradedSources: true,
+	v1alpha1.ConfigMapSecretPendingChanges:  true,
+}
+
 // NewConfigMapSecretCondition creates a new deployment condition.
-func NewConfigMapSecretCondition(typ v1alpha1.ConfigMapSecretConditionType, status corev1.ConditionStatus, reason, message string) *v1alpha1.ConfigMapSecretCondition {
+// The message is truncated to MaxConditionMessageLength.
+func NewConfigMapSecretCondition(typ v1alpha1.ConfigMapSecretConditionType, status corev1.ConditionStatus, reason v1alpha1.ConfigMapSecretConditionReason, message string) *v1alpha1.ConfigMapSecretCondition {
 	return &v1alpha1.ConfigMapSecretCondition{
 		Type:               typ,
 		Status:             status,
 		LastUpdateTime:     metav1.Now(),
 		LastTransitionTime: metav1.Now(),
 		Reason:             reason,
-		Message:            message,
+		Message:            truncateMessage(message, MaxConditionMessageLength),
+	}
+}
+
+// truncateMessage truncates msg to at most n bytes without splitting a UTF-8
+// sequence, marking truncation with a suffix.
+func truncateMessage(msg string, n int) string {
+	if len(msg) <= n {
+		return msg
 	}
+	i := n - len(truncatedSuffix)
+	for i > 0 && !utf8.RuneStart(msg[i]) {
+		i--
+	}
+	return msg[:i] + truncatedSuffix
 }
 
 // GetConfigMapSecretCondition returns the condition with the provided type.
@@ -69,3 +99,22 @@ func RemoveConfigMapSecretCondition(status *v1alpha1.ConfigMapSecretStatus, typ
 	}
 	status.Conditions = conds
 }
+
+// PruneConfigMapSecretConditions removes conditions of types that aren't managed
+// by the controller and, if there are more than MaxConditions, the least recently
+// updated conditions.
+func PruneConfigMapSecretConditions(status *v1alpha1.ConfigMapSecretStatus) {
+	var conds []v1alpha1.ConfigMapSecretCondition
+	for _, c := range status.Conditions {
+		if conditionTypes[c.Type] {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) > MaxConditions {
+		sort.SliceStable(conds, func(i, k int) bool {
+			return conds[k].LastUpdateTime.Before(&conds[i].LastUpdateTime)
+		})
+		conds = conds[:MaxConditions]
+	}
+	status.Conditions = conds
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		n    int
+		want string
+	}{
+		{msg: "short", n: 10, want: "short"},
+		{msg: "exactly10!", n: 10, want: "exactly10!"},
+		{msg: "this is too long", n: 10, want: "this is..."},
+		{msg: "ünïcödé strings", n: 10, want: "ünïc..."},
+	}
+	for _, tt := range tests {
+		got := truncateMessage(tt.msg, tt.n)
+		if got != tt.want {
+			t.Errorf("truncateMessage(%q, %d): want: %q; got: %q", tt.msg, tt.n, tt.want, got)
+		}
+		if len(got) > tt.n || !utf8.ValidString(got) {
+			t.Errorf("truncateMessage(%q, %d): invalid result: %q", tt.msg, tt.n, got)
+		}
+	}
+
+	cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretRenderFailure, "True", "", strings.Repeat("x", 2*MaxConditionMessageLength))
+	if n := len(cond.Message); n != MaxConditionMessageLength {
+		t.Errorf("unexpected message length: want: %d; got: %d", MaxConditionMessageLength, n)
+	}
+}
+
+func TestPruneConditions(t *testing.T) {
+	status := &v1alpha1.ConfigMapSecretStatus{
+		Conditions: []v1alpha1.ConfigMapSecretCondition{
+			{Type: "Stale"},
+			{Type: v1alpha1.ConfigMapSecretRenderFailure},
+			{Type: v1alpha1.ConfigMapSecretDegraded},
+		},
+	}
+	PruneConfigMapSecretConditions(status)
+	want := []v1alpha1.ConfigMapSecretCondition{
+		{Type: v1alpha1.ConfigMapSecretRenderFailure},
+		{Type: v1alpha1.ConfigMapSecretDegraded},
+	}
+	if diff := cmp.Diff(want, status.Conditions); diff != "" {
+		t.Errorf("unexpected conditions (-want +got):\n%s", diff)
+	}
+
+	// Exceed the cap with duplicates of a managed type.
+	status.Conditions = nil
+	for i := 0; i < MaxConditions+2; i++ {
+		status.Conditions = append(status.Conditions, v1alpha1.ConfigMapSecretCondition{
+			Type:           v1alpha1.ConfigMapSecretRenderFailure,
+			LastUpdateTime: metav1.NewTime(time.Unix(int64(i), 0)),
+		})
+	}
+	PruneConfigMapSecretConditions(status)
+	if n := len(status.Conditions); n != MaxConditions {
+		t.Fatalf("unexpected number of conditions: want: %d; got: %d", MaxConditions, n)
+	}
+	if got := status.Conditions[0].LastUpdateTime.Unix(); got != MaxConditions+1 {
+		t.Errorf("expected most recently updated condition first; got time: %d", got)
+	}
+}
@@ -9,21 +9,29 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"sort"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
-	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	"github.com/machinezone/configmapsecrets/pkg/audit"
+	"github.com/machinezone/configmapsecrets/pkg/blackout"
+	"github.com/machinezone/configmapsecrets/pkg/gitsource"
+	"github.com/machinezone/configmapsecrets/pkg/hooks"
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"github.com/machinezone/configmapsecrets/pkg/trustbundle"
 	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/validation"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -43,21 +51,143 @@ var missingValues = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Help: "Total number of ConfigMapSecret controller render errors due to missing required values.",
 }, []string{"namespace"})
 
+var reconcileTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_reconcile_timeouts_total",
+	Help: "Total number of ConfigMapSecret reconciliations that exceeded the reconcile timeout.",
+}, []string{"namespace"})
+
 func init() {
-	metrics.Registry.MustRegister(missingValues)
+	metrics.Registry.MustRegister(missingValues, reconcileTimeouts)
 }
 
 // ConfigMapSecret reconciles a ConfigMapSecret object
 type ConfigMapSecret struct {
+	// AuditSink, if set, receives a record of every Secret write.
+	AuditSink audit.Sink
+	// Hooks are applied in order to each rendered Secret before it's written.
+	Hooks []hooks.Hook
+	// RenderFailureThreshold is the number of consecutive render failures with
+	// the same reason after which a ConfigMapSecret is degraded and retried at
+	// DegradedRetryInterval, until one of its sources or its spec changes.
+	// If zero, failures are retried with the workqueue's rate limiter.
+	RenderFailureThreshold int
+	// DegradedRetryInterval is the interval at which degraded ConfigMapSecrets
+	// are retried.
+	DegradedRetryInterval time.Duration
+	// SnapshotPath, if set, is the path of a file to which the reconciler's
+	// state is periodically persisted and from which it's restored on startup,
+	// such that objects that haven't changed aren't reconciled again. It's
+	// discarded if it was written by another build or configuration.
+	SnapshotPath string
+	// SnapshotInterval is the interval at which the snapshot is written.
+	// If zero, it defaults to one minute.
+	SnapshotInterval time.Duration
+	// ReconcileTimeout is the maximum duration of a reconciliation, such that
+	// a hung request can't block a worker indefinitely. If zero, there's no limit.
+	ReconcileTimeout time.Duration
+	// DefaultsConfigMap, if set, is the name of the ConfigMap in each namespace
+	// whose labels, annotations, and type are the defaults of the Secrets
+	// rendered in that namespace. See the renderer.Defaults*Key constants.
+	DefaultsConfigMap string
+	// RenderLimits are the limits within which templates are rendered. A
+	// template that exceeds them is a render failure with the
+	// v1alpha1.RenderLimitExceededReason. Zero limits are disabled.
+	RenderLimits render.Limits
+	// MaxEventsPerMinute is the maximum number of events recorded per minute
+	// for each object. Identical events are aggregated regardless.
+	// If zero, there's no limit.
+	MaxEventsPerMinute int
+	// SyncAnnotations are set on each written Secret, e.g. so that progressive
+	// delivery controllers can gate on config freshness. They may be
+	// v1alpha1.LastAppliedHashAnnotation and v1alpha1.LastSyncTimeAnnotation.
+	SyncAnnotations []string
+	// ClusterName, if set, is the value of the v1alpha1.ClusterVar built-in
+	// template variable.
+	ClusterName string
+	// SecretNamePrefix and SecretNameSuffix are added to the name of every
+	// rendered Secret, e.g. to enforce a naming convention. A ConfigMapSecret
+	// whose Secret name isn't valid with them fails to render with the
+	// v1alpha1.InvalidSecretNameReason.
+	SecretNamePrefix string
+	SecretNameSuffix string
+	// BackupExclusionLabel and BackupExclusionValue are the label set on the
+	// Secrets of ConfigMapSecrets whose BackupPolicy is Exclude, so that backup
+	// tools skip them. If BackupExclusionLabel is empty, they default to
+	// v1alpha1.VeleroExcludeFromBackupLabel and "true".
+	BackupExclusionLabel string
+	BackupExclusionValue string
+	// ReportConfigMap, if set, is the name of the ConfigMap in each namespace
+	// to which a NamespaceReport is written every ReportInterval, summarizing
+	// its ConfigMapSecrets and their failures and drift corrections over the
+	// last ReportWindow. They default to one minute and one hour.
+	ReportConfigMap string
+	ReportInterval  time.Duration
+	ReportWindow    time.Duration
+	// GitProtocols are the transport protocols with which GitSources may be
+	// read, e.g. "https" and "ssh". If empty, ConfigMapSecrets with a GitSource
+	// fail to render.
+	GitProtocols []string
+	// DisableSecretWatch and DisableConfigMapWatch disable the watches of
+	// Secrets and ConfigMaps, e.g. to reduce the load of the watch and its
+	// cache in clusters with very many of them. Their changes aren't observed,
+	// so ConfigMapSecrets are only reconciled when they change and every
+	// PollInterval, which corrects drift of their Secrets and changes of their
+	// sources with that latency. The client must not read them from a cache.
+	DisableSecretWatch    bool
+	DisableConfigMapWatch bool
+	// PollInterval is the interval at which ConfigMapSecrets are reconciled
+	// if a watch is disabled. If zero, they aren't polled.
+	PollInterval time.Duration
+	// BlackoutWindows are recurring windows during which updates and
+	// deletions of existing Secrets are deferred until the window ends, e.g.
+	// to comply with a change freeze. Secrets are still created, and the
+	// Secrets of ConfigMapSecrets with the v1alpha1.CriticalAnnotation are
+	// still written. A deferred update is reported by the PendingChanges
+	// condition.
+	BlackoutWindows []*blackout.Window
+	// Policy, if set, is checked against each rendered Secret after the Hooks
+	// are applied. A Secret that violates it isn't written, and its
+	// ConfigMapSecret fails to render with the v1alpha1.PolicyViolationReason.
+	Policy *policy.Policy
+	// StatusFlushInterval, if positive, is the interval at which statuses are
+	// written in batches, off the reconcile critical path. Statuses of the
+	// same ConfigMapSecret that are set within an interval are coalesced, which
+	// reduces writes when many ConfigMapSecrets are rendered at once. If zero,
+	// each status is written when it's set.
+	StatusFlushInterval time.Duration
+	// DisableClusterTrustBundles disables vars from ClusterTrustBundles, which
+	// are otherwise enabled if their API is available, e.g. if the controller
+	// isn't permitted to read cluster-scoped objects.
+	DisableClusterTrustBundles bool
+	// TenantLabel, if set, is the key of the label that identifies the tenant
+	// of ConfigMapSecrets and their sources. A ConfigMapSecret may only read
+	// Secrets and ConfigMaps whose value of the label equals its own.
+	TenantLabel string
+
 	client   client.Client
 	scheme   *runtime.Scheme
 	logger   logr.Logger
 	recorder record.EventRecorder
+	queue    queueTracker
+	events   eventAggregator
+	renders  renderCache
+	git      gitCache
+	stats    objectStats
+	ctx      handlerContext
 
 	mu         sync.RWMutex
 	secrets    refMap
 	configMaps refMap
+	outputs    refMap                                  // ConfigMapSecret -> Secret
+	selectors  map[string]map[string][]labels.Selector // namespace -> ConfigMapSecret -> ConfigMap selectors
 	owned      refMap
+	failures   map[types.NamespacedName]renderFailure
+	snapshot   *snapshot
+	report     *reporter
+	status     *statusUpdater
+
+	trustBundles    client.Reader // nil if ClusterTrustBundles aren't available
+	trustBundleSels map[types.NamespacedName][]v1alpha1.ClusterTrustBundleSelector
 
 	testNotifyFn func(types.NamespacedName)
 }
@@ -68,42 +198,125 @@ func (r *ConfigMapSecret) SetupWithManager(manager manager.Manager) error {
 	r.scheme = manager.GetScheme()
 	r.logger = manager.GetLogger().WithName("controller").WithName("ConfigMapSecret")
 	r.recorder = manager.GetEventRecorderFor("configmapsecret-controller")
+	if err := r.detectClusterTrustBundles(manager.GetRESTMapper(), manager.GetCache()); err != nil {
+		return err
+	}
+
+	if r.SnapshotPath != "" {
+		if err := r.setupSnapshot(manager); err != nil {
+			return err
+		}
+	}
+	if r.ReportConfigMap != "" {
+		if err := r.setupReport(manager); err != nil {
+			return err
+		}
+	}
+	if r.StatusFlushInterval > 0 {
+		if err := r.setupStatusUpdater(manager); err != nil {
+			return err
+		}
+	}
+	if err := manager.Add(&r.ctx); err != nil {
+		return err
+	}
 
-	return builder.ControllerManagedBy(manager).
-		For(&v1alpha1.ConfigMapSecret{}).
-		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.Funcs{
-			CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
-				r.secretEventHandler(q, e.Object.(*corev1.Secret), false)
+	b := builder.ControllerManagedBy(manager).
+		For(&v1alpha1.ConfigMapSecret{}, builder.WithPredicates(configMapSecretChanged(), r.snapshot.predicate(), r.queue.predicate()))
+	if !r.DisableSecretWatch {
+		b = b.Watches(&source.Kind{Type: &corev1.Secret{}}, r.queue.handler(secretTrigger, r.snapshot.handler("Secret", &contextFuncs{
+			ctx: &r.ctx,
+			CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+				r.secretEventHandler(ctx, q, e.Object.(*corev1.Secret), false)
 			},
-			UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
-				r.secretEventHandler(q, e.ObjectNew.(*corev1.Secret), false)
+			UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+				r.secretEventHandler(ctx, q, e.ObjectNew.(*corev1.Secret), false)
 			},
-			DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
-				r.secretEventHandler(q, e.Object.(*corev1.Secret), true)
+			DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+				r.secretEventHandler(ctx, q, e.Object.(*corev1.Secret), true)
 			},
-			GenericFunc: func(e event.GenericEvent, q workqueue.RateLimitingInterface) {
-				r.secretEventHandler(q, e.Object.(*corev1.Secret), false)
+			GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+				r.secretEventHandler(ctx, q, e.Object.(*corev1.Secret), false)
 			},
-		}).
-		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, r.configMapEventHandler()).
-		Complete(r)
+		})), builder.WithPredicates(r.secretChanged()))
+	}
+	if !r.DisableConfigMapWatch {
+		b = b.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, r.queue.handler(configMapTrigger, r.snapshot.handler("ConfigMap", r.configMapEventHandler())),
+			builder.WithPredicates(configMapChanged()))
+	}
+	if r.trustBundles != nil {
+		b = b.Watches(&source.Kind{Type: trustbundle.New()}, r.queue.handler(clusterTrustBundleTrigger, r.trustBundleEventHandler()))
+	}
+	return b.Complete(r)
+}
+
+func (r *ConfigMapSecret) setupSnapshot(mgr manager.Manager) error {
+	config, err := r.snapshotConfig()
+	if err != nil {
+		return err
+	}
+	snapshot, err := loadSnapshot(r.SnapshotPath, config)
+	switch {
+	case errors.Is(err, errSnapshotConfig):
+		r.logger.Info("Discarding snapshot of another build or configuration", "path", r.SnapshotPath)
+	case err != nil:
+		// Start from scratch rather than failing, since it's only an optimization.
+		r.logger.Error(err, "Unable to restore snapshot", "path", r.SnapshotPath)
+	}
+	snapshot.restoreRefs(r)
+	r.snapshot = snapshot
+
+	interval := r.SnapshotInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		// Unclaimed entries are dropped, so wait for every object to be seen.
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return nil
+		}
+		return snapshot.run(ctx, r.logger, interval)
+	}))
+}
+
+func (r *ConfigMapSecret) setupStatusUpdater(mgr manager.Manager) error {
+	r.status = newStatusUpdater(r.client)
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		r.status.run(ctx, r.logger, r.StatusFlushInterval)
+		return nil
+	}))
 }
 
 func (r *ConfigMapSecret) configMapEventHandler() handler.EventHandler {
-	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+	return enqueueRequestsFromMapFunc(&r.ctx, func(ctx context.Context, obj client.Object) []reconcile.Request {
+		if ctx.Err() != nil {
+			return nil
+		}
 		namespace := obj.GetNamespace()
 		name := obj.GetName()
 
-		r.mu.RLock()
-		defer r.mu.RUnlock()
-		return toReqs(namespace, r.configMaps.srcs(namespace, name))
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		cmsNames := r.configMaps.srcs(namespace, name)
+		if selecting := r.selecting(namespace, obj.GetLabels()); len(selecting) > 0 {
+			union := make(map[string]bool, len(cmsNames)+len(selecting))
+			for cmsName := range cmsNames {
+				union[cmsName] = true
+			}
+			for cmsName := range selecting {
+				union[cmsName] = true
+			}
+			cmsNames = union
+		}
+		r.resetRenderFailures(namespace, cmsNames)
+		return toReqs(namespace, cmsNames)
 	})
 }
 
-func (r *ConfigMapSecret) secretEventHandler(q workqueue.RateLimitingInterface, secret *corev1.Secret, deleted bool) {
+func (r *ConfigMapSecret) secretEventHandler(ctx context.Context, q workqueue.RateLimitingInterface, secret *corev1.Secret, deleted bool) {
 	name := secret.Name
 	namespace := secret.Namespace
-	owner := getOwner(secret)
+	owner := getManager(secret)
 
 	r.mu.Lock()
 	if deleted || owner == nil {
@@ -111,9 +324,20 @@ func (r *ConfigMapSecret) secretEventHandler(q workqueue.RateLimitingInterface,
 	} else {
 		r.owned.set(namespace, name, map[string]bool{string(owner.UID): true})
 	}
-	cmsNames := keys(r.secrets.srcs(namespace, name))
+	cmsSet := r.secrets.srcs(namespace, name)
+	r.resetRenderFailures(namespace, cmsSet)
+	cmsNames := keys(cmsSet)
+	// ConfigMapSecrets that render the Secret, e.g. blocked by its owner.
+	for cmsName := range r.outputs.srcs(namespace, name) {
+		if !cmsSet[cmsName] {
+			cmsNames = append(cmsNames, cmsName)
+		}
+	}
 	r.mu.Unlock()
 
+	if ctx.Err() != nil {
+		return
+	}
 	if owner != nil {
 		q.Add(reconcile.Request{
 			NamespacedName: types.NamespacedName{
@@ -135,16 +359,67 @@ func (r *ConfigMapSecret) secretEventHandler(q workqueue.RateLimitingInterface,
 	}
 }
 
-func (r *ConfigMapSecret) setRefs(namespace, name string, secrets, configMaps map[string]bool) {
+// renderFailure describes consecutive render failures of a ConfigMapSecret.
+type renderFailure struct {
+	generation int64
+	reason     v1alpha1.ConfigMapSecretConditionReason
+	count      int
+}
+
+// renderFailed records a render failure of the ConfigMapSecret with the given
+// reason and reports whether its retry budget is exhausted.
+func (r *ConfigMapSecret) renderFailed(cms *v1alpha1.ConfigMapSecret, reason v1alpha1.ConfigMapSecretConditionReason) (degraded bool) {
+	if r.RenderFailureThreshold <= 0 {
+		return false
+	}
+	key := types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.failures[key]
+	if f.generation != cms.Generation || f.reason != reason {
+		f = renderFailure{generation: cms.Generation, reason: reason}
+	}
+	f.count++
+	if r.failures == nil {
+		r.failures = make(map[types.NamespacedName]renderFailure)
+	}
+	r.failures[key] = f
+	return f.count >= r.RenderFailureThreshold
+}
+
+// clearRenderFailures resets the render failures of the ConfigMapSecret.
+func (r *ConfigMapSecret) clearRenderFailures(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.failures, key)
+}
+
+// resetRenderFailures resets the render failures of the named ConfigMapSecrets,
+// e.g. when one of their sources changes. The caller must hold r.mu.
+func (r *ConfigMapSecret) resetRenderFailures(namespace string, names map[string]bool) {
+	for name := range names {
+		delete(r.failures, types.NamespacedName{Namespace: namespace, Name: name})
+	}
+}
+
+func (r *ConfigMapSecret) setRefs(namespace, name string, secrets, configMaps map[string]bool, output string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.secrets.set(namespace, name, secrets)
 	r.configMaps.set(namespace, name, configMaps)
+	if output == "" {
+		r.outputs.set(namespace, name, nil)
+	} else {
+		r.outputs.set(namespace, name, map[string]bool{output: true})
+	}
 }
 
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;update
-// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;delete
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=secrets.mz.com,resources=configmapsecrets,verbs=get;list;watch;update;patch;delete
 // +kubebuilder:rbac:groups=secrets.mz.com,resources=configmapsecrets/status;configmapsecrets/finalizers,verbs=get;update;patch
@@ -155,36 +430,87 @@ func (r *ConfigMapSecret) Reconcile(ctx context.Context, req reconcile.Request)
 	if r.testNotifyFn != nil {
 		defer r.testNotifyFn(req.NamespacedName)
 	}
-	log := r.logger.WithValues("configmapsecret", req.NamespacedName)
+	r.queue.start(req.NamespacedName)
+	reconcileID := uuid.NewUUID()
+	ctx = withReconcileID(ctx, reconcileID)
+	log := r.logger.WithValues("configmapsecret", req.NamespacedName, "reconcileID", reconcileID)
 
 	// Fetch the ConfigMapSecret instance
 	cms := &v1alpha1.ConfigMapSecret{}
 	if err := r.client.Get(ctx, req.NamespacedName, cms); err != nil {
 		if apierrors.IsNotFound(err) {
 			// Object not found. Owned objects are automatically garbage collected.
-			r.setRefs(req.Namespace, req.Name, nil, nil)
+			r.setRefs(req.Namespace, req.Name, nil, nil, "")
+			r.setSelectors(req.Namespace, req.Name, nil)
+			r.setTrustBundleSelectors(req.Namespace, req.Name, nil)
+			r.clearRenderFailures(req.NamespacedName)
+			r.renders.forget(req.NamespacedName)
+			r.git.forget(req.NamespacedName)
+			r.stats.forget(req.NamespacedName)
+			r.snapshot.forget(req.NamespacedName)
+			r.report.forget(req.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 	// Set the Secret and ConfigMap references for the instance
-	secretNames, configMapNames := varRefs(cms.Spec.VarsFrom, cms.Spec.Vars)
-	r.setRefs(cms.Namespace, cms.Name, secretNames, configMapNames)
+	secretNames, configMapNames := varRefs(&cms.Spec)
+	if r.DefaultsConfigMap != "" {
+		if configMapNames == nil {
+			configMapNames = make(map[string]bool)
+		}
+		configMapNames[r.DefaultsConfigMap] = true
+	}
+	r.setRefs(cms.Namespace, cms.Name, secretNames, configMapNames, r.secretName(cms))
+	r.setSelectors(cms.Namespace, cms.Name, configMapSelectors(cms.Spec.VarsFrom))
+	r.setTrustBundleSelectors(cms.Namespace, cms.Name, trustBundleSelectors(cms.Spec.Vars))
 
 	// Sync and cleanup
-	requeue, err := r.sync(ctx, log, cms)
-	if cleanupErr := r.cleanup(ctx, log, cms); cleanupErr != nil && err == nil {
-		err = cleanupErr
+	syncCtx := ctx
+	if r.ReconcileTimeout > 0 {
+		var cancel context.CancelFunc
+		syncCtx, cancel = context.WithTimeout(ctx, r.ReconcileTimeout)
+		defer cancel()
+	}
+	// Report both sync and cleanup errors, and reflect the latter in the status.
+	result, syncErr := r.sync(syncCtx, log, cms)
+	migrateAfter, cleanupErr := r.cleanup(syncCtx, log, cms)
+	if migrateAfter > 0 && (result.RequeueAfter == 0 || migrateAfter < result.RequeueAfter) {
+		result.RequeueAfter = migrateAfter
+	}
+	statusErr := r.syncCleanupStatus(ctx, log, cms, cleanupErr)
+	err := joinErrors(syncErr, cleanupErr, statusErr)
+	if err == nil {
+		result = r.pollResult(result)
 	}
-	return reconcile.Result{Requeue: requeue}, err
+	if err != nil && syncCtx.Err() == context.DeadlineExceeded {
+		return r.timedOut(ctx, log, cms, err)
+	}
+	return result, err
 }
 
-func (r *ConfigMapSecret) cleanup(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret) error {
-	secretName := cms.Spec.Template.Metadata.Name
-	if secretName == "" {
-		secretName = cms.Name
+// timedOut records that the reconciliation of the ConfigMapSecret exceeded the
+// reconcile timeout and returns an error so that it's retried with backoff.
+func (r *ConfigMapSecret) timedOut(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, err error) (reconcile.Result, error) {
+	reconcileTimeouts.WithLabelValues(cms.Namespace).Inc()
+	log.Error(err, "Reconcile timed out", "timeout", r.ReconcileTimeout)
+	msg := fmt.Sprintf("Reconcile timed out after %v: %v", r.ReconcileTimeout, err)
+	// The sources read before the deadline aren't known, so their statuses
+	// are retained.
+	if statusErr := r.syncRenderFailureStatus(ctx, log, cms, nil, v1alpha1.ReconcileTimeoutReason, msg, false); statusErr != nil {
+		log.Error(statusErr, "Unable to update status after timeout")
 	}
+	return reconcile.Result{}, err
+}
+
+// cleanup deletes the Secrets that the ConfigMapSecret rendered with previous
+// names. If it has a migration grace period, they're kept updated until it ends,
+// and the remaining duration of the first to end is returned.
+func (r *ConfigMapSecret) cleanup(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret) (migrateAfter time.Duration, err error) {
+	secretName := r.secretName(cms)
+	grace := migrationGracePeriod(cms)
+	now := time.Now()
 
 	r.mu.Lock()
 	owned := keys(r.owned.srcs(cms.Namespace, string(cms.UID)))
@@ -197,45 +523,88 @@ func (r *ConfigMapSecret) cleanup(ctx context.Context, log logr.Logger, cms *v1a
 
 		key := types.NamespacedName{Namespace: cms.Namespace, Name: name}
 		secretLog := log.WithValues("secret", key)
+		if grace > 0 {
+			remaining, err := r.migrate(ctx, secretLog, cms, key, grace, now)
+			if err != nil {
+				return 0, err
+			}
+			if remaining > 0 {
+				if migrateAfter == 0 || remaining < migrateAfter {
+					migrateAfter = remaining
+				}
+				continue
+			}
+		}
+		if window, end, ok := r.blackout(cms, now); ok {
+			secretLog.Info("Deferring secret cleanup during blackout window", "window", window, "end", end)
+			deferredWrites.WithLabelValues(cms.Namespace, "delete").Inc()
+			if remaining := end.Sub(now); migrateAfter == 0 || remaining < migrateAfter {
+				migrateAfter = remaining
+			}
+			continue
+		}
+		if err := r.deleteProvenance(ctx, secretLog, cms, name); err != nil {
+			return 0, err
+		}
 		secretLog.Info("Cleaning up secret")
 
 		secret := &corev1.Secret{}
 		if err := r.client.Get(ctx, key, secret); err != nil {
 			if apierrors.IsNotFound(err) {
 				secretLog.Info("Cleaning up secret unnecessary, already removed")
+				r.observeUnwatched(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}, true)
 				continue
 			}
 			secretLog.Error(err, "Cleaning up secret, get failed")
-			return err
+			return 0, err
 		}
 		if err := r.client.Delete(ctx, secret); err != nil {
 			secretLog.Error(err, "Cleaning up secret, delete failed")
-			return err
+			return 0, err
 		}
+		r.observeUnwatched(secret, true)
+		r.audit(ctx, secretLog, audit.Delete, cms, key, secret.Data, nil)
 	}
-	return nil
+	return migrateAfter, nil
 }
 
-func (r *ConfigMapSecret) sync(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret) (requeue bool, err error) {
-	secret, reason, err := r.renderSecret(ctx, cms)
+func (r *ConfigMapSecret) sync(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret) (result reconcile.Result, err error) {
+	if cycle := r.dependencyCycle(cms.Namespace, cms.Name); cycle != nil {
+		return r.cycleDetected(ctx, log, cms, cycle)
+	}
+
+	srcs := newSources()
+	trace := newRenderTrace(cms)
+	start := time.Now()
+	secret, reason, err := r.renderSecret(ctx, cms, srcs, trace)
+	r.stats.observeRender(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}, time.Since(start), secret, srcs)
+	r.emitTrace(ctx, log, cms, trace)
 	if err != nil {
 		msg := err.Error()
+		degraded := r.renderFailed(cms, reason)
 		defer func() {
-			if statusErr := r.syncRenderFailureStatus(ctx, log, cms, reason, msg); statusErr != nil {
+			if statusErr := r.syncRenderFailureStatus(ctx, log, cms, srcs, reason, msg, degraded); statusErr != nil {
 				if err == nil {
 					err = statusErr
 				}
-				requeue = true
+				result.Requeue = true
 			}
 		}()
 		if isConfigError(err) {
 			missingValues.WithLabelValues(cms.Namespace).Inc()
+		}
+		if degraded {
+			log.Info("Unable to render ConfigMapSecret, backing off", "warning", err, "retryInterval", r.DegradedRetryInterval)
+			return reconcile.Result{RequeueAfter: r.DegradedRetryInterval}, nil
+		}
+		if isConfigError(err) {
 			log.Info("Unable to render ConfigMapSecret", "warning", err)
-			return true, nil
+			return reconcile.Result{Requeue: true}, nil
 		}
 		log.Error(err, "Unable to render ConfigMapSecret")
-		return false, err
+		return reconcile.Result{}, err
 	}
+	r.clearRenderFailures(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name})
 
 	key := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
 	secretLog := log.WithValues("secret", key)
@@ -244,25 +613,46 @@ func (r *ConfigMapSecret) sync(ctx context.Context, log logr.Logger, cms *v1alph
 	found := &corev1.Secret{}
 	if err := r.client.Get(ctx, key, found); err != nil {
 		if apierrors.IsNotFound(err) {
+			setSyncAnnotations(secret, nil, r.SyncAnnotations, time.Now())
 			secretLog.Info("Creating Secret")
 			if err := r.client.Create(ctx, secret); err != nil {
 				secretLog.Error(err, "Unable to create Secret")
-				return false, err
+				return reconcile.Result{}, err
 			}
-			return false, r.syncSuccessStatus(ctx, log, cms)
+			r.audit(ctx, secretLog, audit.Create, cms, key, nil, secret.Data)
+			r.report.wrote(key, secret.Data)
+			return refreshResult(cms), r.synced(ctx, log, cms, srcs, secret)
 		}
 		secretLog.Error(err, "Unable to get Secret")
-		return false, err
+		return reconcile.Result{}, err
 	}
 
 	// Confirm or take ownership.
-	ownerChanged, err := r.setOwner(secretLog, cms, found)
+	ownerChanged, prevOwner, err := r.setOwner(ctx, secretLog, cms, found)
 	if err != nil {
-		return false, err
+		if blocked := (*blockedError)(nil); errors.As(err, &blocked) {
+			secretLog.Info("Unable to write Secret", "warning", err)
+			return reconcile.Result{}, r.syncRenderFailureStatus(ctx, log, cms, srcs, v1alpha1.BlockedReason, blocked.Error(), false)
+		}
+		return reconcile.Result{}, err
 	}
 
+	// The type of a Secret is immutable, so a changed default only applies to new Secrets.
+	if found.Type != secret.Type {
+		secretLog.Info("Keeping type of existing Secret", "type", found.Type, "renderedType", secret.Type)
+		secret.Type = found.Type
+	}
+	setSyncAnnotations(secret, found, r.SyncAnnotations, time.Now())
+
 	// Update the object and write the result back if there are any changes
 	if ownerChanged || shouldUpdate(found, secret) {
+		if window, end, ok := r.blackout(cms, time.Now()); ok {
+			secretLog.Info("Deferring Secret update during blackout window", "window", window, "end", end)
+			deferredWrites.WithLabelValues(cms.Namespace, "update").Inc()
+			return reconcile.Result{RequeueAfter: time.Until(end)}, r.syncPendingStatus(ctx, log, cms, srcs, pendingMessage(window, end))
+		}
+		r.report.updating(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}, key, found.Data, secret.Data, time.Now())
+		oldData := found.Data
 		found.Labels = secret.Labels
 		found.Annotations = secret.Annotations
 		found.Data = secret.Data
@@ -270,16 +660,42 @@ func (r *ConfigMapSecret) sync(ctx context.Context, log logr.Logger, cms *v1alph
 		secretLog.Info("Updating Secret")
 		if err := r.client.Update(ctx, found); err != nil {
 			secretLog.Error(err, "Unable to update Secret")
-			return false, err
+			return reconcile.Result{}, err
 		}
+		r.audit(ctx, secretLog, audit.Update, cms, key, oldData, found.Data)
+		r.report.wrote(key, found.Data)
+		if prevOwner != nil {
+			r.tookOwnership(ctx, cms, prevOwner, key)
+		}
+	}
+	return refreshResult(cms), r.synced(ctx, log, cms, srcs, found)
+}
+
+// synced writes the provenance of a ConfigMapSecret that was synced to the
+// Secret, updates its status, and records it in the snapshot.
+func (r *ConfigMapSecret) synced(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources, secret *corev1.Secret) error {
+	r.observeUnwatched(secret, false)
+	if err := r.syncProvenance(ctx, log, cms, srcs, secret); err != nil {
+		return err
+	}
+	if err := r.syncSuccessStatus(ctx, log, cms, srcs); err != nil {
+		return err
 	}
-	return false, r.syncSuccessStatus(ctx, log, cms)
+	r.snapshot.record(cms, srcs, secret)
+	return nil
 }
 
-func (r *ConfigMapSecret) setOwner(log logr.Logger, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) (bool, error) {
+// setOwner confirms or takes ownership of the Secret and reports whether its
+// owner references changed. If ownership was taken over from another
+// ConfigMapSecret, it's returned. If the Secret has a different owner that
+// can't be taken over, a *blockedError is returned.
+func (r *ConfigMapSecret) setOwner(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) (changed bool, prevOwner *v1alpha1.ConfigMapSecret, err error) {
+	if !managesOwnership(cms) {
+		return r.releaseOwner(ctx, log, cms, secret)
+	}
 	gvk, err := apiutil.GVKForObject(cms, r.scheme)
 	if err != nil {
-		return false, err
+		return false, nil, err
 	}
 	owner := metav1.NewControllerRef(cms, gvk)
 	for i, ref := range secret.OwnerReferences {
@@ -287,19 +703,53 @@ func (r *ConfigMapSecret) setOwner(log logr.Logger, cms *v1alpha1.ConfigMapSecre
 			continue
 		}
 		if ref.UID != cms.UID {
-			log.Error(err, "Secret has a different owner", "owner", ref)
-			return false, &controllerutil.AlreadyOwnedError{Object: cms, Owner: ref}
+			prevOwner, err := r.takeover(ctx, cms, secret)
+			if err != nil {
+				return false, nil, err
+			}
+			log.Info("Taking over ownership of Secret", "owner", *owner, "previousOwner", ref)
+			secret.OwnerReferences[i] = *owner
+			return true, prevOwner, nil
 		}
 		if !reflect.DeepEqual(&ref, owner) { // e.g. apiVersion changed
 			log.Info("Updating ownership of Secret")
 			secret.OwnerReferences[i] = *owner
-			return true, nil
+			return true, nil, nil
+		}
+		return false, nil, nil
+	}
+	if ref := getManager(secret); ref != nil && ref.UID != cms.UID {
+		prevOwner, err := r.takeover(ctx, cms, secret)
+		if err != nil {
+			return false, nil, err
 		}
-		return false, nil
+		log.Info("Taking over ownership of Secret", "owner", *owner, "previousOwner", *ref)
+		secret.OwnerReferences = append(secret.OwnerReferences, *owner)
+		return true, prevOwner, nil
 	}
 	log.Info("Taking ownership of Secret", "owner", *owner)
-	cms.OwnerReferences = append(secret.OwnerReferences, *owner)
-	return true, nil
+	secret.OwnerReferences = append(secret.OwnerReferences, *owner)
+	return true, nil, nil
+}
+
+func (r *ConfigMapSecret) audit(ctx context.Context, log logr.Logger, op audit.Operation, cms *v1alpha1.ConfigMapSecret, key types.NamespacedName, oldData, newData map[string][]byte) {
+	if r.AuditSink == nil {
+		return
+	}
+	added, changed, removed := audit.DiffKeys(oldData, newData)
+	rec := audit.Record{
+		Time:            time.Now(),
+		Operation:       op,
+		Secret:          key,
+		ConfigMapSecret: types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name},
+		ReconcileID:     string(reconcileIDFrom(ctx)),
+		AddedKeys:       added,
+		ChangedKeys:     changed,
+		RemovedKeys:     removed,
+	}
+	if err := r.AuditSink.Emit(ctx, rec); err != nil {
+		log.Error(err, "Unable to emit audit record", "operation", op)
+	}
 }
 
 func shouldUpdate(a, b *corev1.Secret) bool {
@@ -309,271 +759,204 @@ func shouldUpdate(a, b *corev1.Secret) bool {
 		!reflect.DeepEqual(a.Data, b.Data)
 }
 
-func (r *ConfigMapSecret) renderSecret(ctx context.Context, cms *v1alpha1.ConfigMapSecret) (*corev1.Secret, string, error) {
-	vars, err := r.makeVariables(ctx, cms)
-	if err != nil {
-		return nil, CreateVariablesErrorReason, err
-	}
-	varMapFn := expansion.MappingFuncFor(vars)
-
-	data := make(map[string][]byte)
-	for k, v := range cms.Spec.Template.Data {
-		data[k] = []byte(expansion.Expand(v, varMapFn))
-	}
-	for k, v := range cms.Spec.Template.BinaryData {
-		data[k] = []byte(expansion.Expand(string(v), varMapFn))
-	}
-
-	meta := cms.Spec.Template.Metadata
-	name := meta.Name
-	if name == "" {
-		name = cms.Name
+// renderSecret renders the Secret of the ConfigMapSecret and sets its owner.
+func (r *ConfigMapSecret) renderSecret(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *sources, trace *renderer.Trace) (*corev1.Secret, v1alpha1.ConfigMapSecretConditionReason, error) {
+	secret, reason, err := r.renderer().Render(ctx, cms, &srcs.Sources, trace)
+	if !cms.Spec.DisableExpansion {
+		srcs.observeCacheSize()
 	}
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        name,
-			Namespace:   cms.Namespace,
-			Labels:      meta.Labels,
-			Annotations: meta.Annotations,
-		},
-		Data: data,
-		Type: corev1.SecretTypeOpaque,
+	if err != nil {
+		if reason == v1alpha1.PolicyViolationReason {
+			countPolicyViolations(cms.Namespace, err)
+		}
+		return nil, reason, err
 	}
-	if err := controllerutil.SetControllerReference(cms, secret, r.scheme); err != nil {
-		return nil, internalError, err
+	if !managesOwnership(cms) {
+		setManager(cms, secret)
+	} else if err := controllerutil.SetControllerReference(cms, secret, r.scheme); err != nil {
+		return nil, v1alpha1.InternalErrorReason, err
 	}
 	return secret, "", nil
 }
 
-// Same logic as container env vars: Kubelet.makeEnvironmentVariables
-// https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go
-func (r *ConfigMapSecret) makeVariables(ctx context.Context, cms *v1alpha1.ConfigMapSecret) (vars map[string]string, err error) {
-	vars = make(map[string]string)
-	mappingFn := expansion.MappingFuncFor(vars)
-	configMaps := make(map[string]*corev1.ConfigMap)
-	secrets := make(map[string]*corev1.Secret)
-
-	for _, v := range cms.Spec.VarsFrom {
-		var (
-			kind, name  string
-			invalidKeys []string
-			srcVars     map[string]string
-		)
-		switch {
-		case v.SecretRef != nil:
-			kind = "Secret"
-			name = v.SecretRef.Name
-			srcVars, invalidKeys, err = r.secretValues(ctx, secrets, cms.Namespace, v.Prefix, *v.SecretRef)
-		case v.ConfigMapRef != nil:
-			kind = "ConfigMap"
-			name = v.ConfigMapRef.Name
-			srcVars, invalidKeys, err = r.configMapValues(ctx, configMaps, cms.Namespace, v.Prefix, *v.ConfigMapRef)
-		}
-		if err != nil {
-			return nil, err
-		}
-		for k, v := range srcVars {
-			vars[k] = v
-		}
-		if len(invalidKeys) > 0 {
-			sort.Strings(invalidKeys)
-			r.recorder.Eventf(
-				cms,
-				corev1.EventTypeWarning,
-				"InvalidTemplateVariableNames",
-				"Keys [%s] from the VarsFrom %s %s/%s were skipped since they are considered invalid template variable names.",
-				strings.Join(invalidKeys, ", "),
-				kind,
-				cms.Namespace,
-				name,
-			)
-		}
+// renderer returns the renderer of the Secrets of ConfigMapSecrets, which
+// caches rendered data and Git files, measures and reports their sources,
+// and runs post-render hooks.
+func (r *ConfigMapSecret) renderer() *renderer.Renderer {
+	rr := r.previewRenderer(r.client)
+	rr.Hooks = r.Hooks
+	rr.Cache = &r.renders
+	rr.Lookup = func(kind, result string) {
+		sourceLookups.WithLabelValues(kind, result).Inc()
 	}
-
-	for _, v := range cms.Spec.Vars {
-		val := v.Value
-		found := true
-
-		switch {
-		case val != "":
-			val = expansion.Expand(val, mappingFn)
-		case v.SecretValue != nil:
-			val, found, err = r.secretValue(ctx, secrets, cms.Namespace, *v.SecretValue)
-		case v.ConfigMapValue != nil:
-			val, found, err = r.configMapValue(ctx, configMaps, cms.Namespace, *v.ConfigMapValue)
-		}
-
-		if err != nil {
-			return nil, err
-		}
-		if !found {
-			continue
-		}
-
-		vars[v.Name] = val
+	rr.Eventf = r.eventf
+	if rr.Git != nil {
+		rr.Git = r.fetchGit
 	}
+	return rr
+}
 
-	return vars, nil
+// PreviewRenderer returns a renderer of the Secrets of ConfigMapSecrets with
+// the same configuration as the reconciler, which reads sources from c, but
+// doesn't share its caches, metrics, or events, e.g. to render previews.
+// It doesn't run post-render hooks, which may have side effects, since
+// previews may render ConfigMapSecrets written by anyone.
+func (r *ConfigMapSecret) PreviewRenderer(c client.Reader) *renderer.Renderer {
+	return r.previewRenderer(c)
 }
 
-func (r *ConfigMapSecret) secret(ctx context.Context, cache map[string]*corev1.Secret, namespace string, ref v1alpha1.SecretVarsSource) (secret *corev1.Secret, err error) {
-	name := ref.Name
-	secret, found := cache[name]
-	if found {
-		return secret, nil
+func (r *ConfigMapSecret) previewRenderer(c client.Reader) *renderer.Renderer {
+	rr := &renderer.Renderer{
+		Client:               c,
+		TrustBundles:         r.trustBundles,
+		Limits:               r.RenderLimits,
+		ClusterName:          r.ClusterName,
+		TenantLabel:          r.TenantLabel,
+		DefaultsConfigMap:    r.DefaultsConfigMap,
+		SecretNamePrefix:     r.SecretNamePrefix,
+		SecretNameSuffix:     r.SecretNameSuffix,
+		BackupExclusionLabel: r.BackupExclusionLabel,
+		BackupExclusionValue: r.BackupExclusionValue,
+		Policy:               r.Policy,
 	}
-	secret = &corev1.Secret{}
-	err = r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			if ref.Optional != nil && *ref.Optional {
-				return nil, nil
-			}
-			return nil, &configError{err}
+	if len(r.GitProtocols) > 0 {
+		f := &gitsource.Fetcher{Protocols: r.GitProtocols}
+		rr.Git = func(ctx context.Context, _ *v1alpha1.ConfigMapSecret, src gitsource.Source) (map[string][]byte, string, error) {
+			return f.Fetch(ctx, src)
 		}
-		return nil, err
 	}
-	cache[name] = secret
-	return secret, nil
+	return rr
 }
 
-func (r *ConfigMapSecret) secretValues(ctx context.Context, cache map[string]*corev1.Secret, namespace, prefix string, ref v1alpha1.SecretVarsSource) (values map[string]string, invalidKeys []string, err error) {
-	secret, err := r.secret(ctx, cache, namespace, ref)
-	if secret == nil || err != nil {
-		return nil, nil, err
-	}
-	values = make(map[string]string, len(secret.Data))
-	for k, v := range secret.Data {
-		switch k, valid := validPrefixedKey(prefix, k); valid {
-		case true:
-			values[k] = string(v)
-		case false:
-			invalidKeys = append(invalidKeys, k)
-		}
-	}
-	return values, invalidKeys, nil
+func (r *ConfigMapSecret) syncSuccessStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources) error {
+	return r.syncStatus(ctx, log, cms, srcs, corev1.ConditionFalse, "", "", false, "")
 }
 
-func (r *ConfigMapSecret) secretValue(ctx context.Context, cache map[string]*corev1.Secret, namespace string, ref corev1.SecretKeySelector) (value string, found bool, err error) {
-	key := ref.Key
-	secret, err := r.secret(ctx, cache, namespace, v1alpha1.SecretVarsSource{
-		LocalObjectReference: ref.LocalObjectReference,
-		Optional:             ref.Optional,
-	})
-	if secret == nil || err != nil {
-		return "", false, err
-	}
-	if buf, found := secret.Data[key]; found {
-		return string(buf), true, nil
-	}
-	if ref.Optional != nil && *ref.Optional {
-		return "", false, nil
-	}
-	return "", false, newConfigError("Couldn't find key %s in Secret %s/%s", key, namespace, ref.Name)
+// syncPendingStatus updates the status of a ConfigMapSecret whose Secret was
+// rendered, but whose update was deferred for the reason in the message.
+func (r *ConfigMapSecret) syncPendingStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources, message string) error {
+	return r.syncStatus(ctx, log, cms, srcs, corev1.ConditionFalse, "", "", false, message)
 }
 
-func (r *ConfigMapSecret) configMap(ctx context.Context, cache map[string]*corev1.ConfigMap, namespace string, ref v1alpha1.ConfigMapVarsSource) (configMap *corev1.ConfigMap, err error) {
-	name := ref.Name
-	configMap, found := cache[name]
-	if found {
-		return configMap, nil
-	}
-	configMap = &corev1.ConfigMap{}
-	err = r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configMap)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			if ref.Optional != nil && *ref.Optional {
-				return nil, nil
-			}
-			return nil, &configError{err}
-		}
-		return nil, err
-	}
-	cache[name] = configMap
-	return configMap, nil
+func (r *ConfigMapSecret) syncRenderFailureStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources, reason v1alpha1.ConfigMapSecretConditionReason, message string, degraded bool) error {
+	r.report.failed(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}, reason, message, time.Now())
+	return r.syncStatus(ctx, log, cms, srcs, corev1.ConditionTrue, reason, message, degraded, "")
 }
 
-func (r *ConfigMapSecret) configMapValues(ctx context.Context, cache map[string]*corev1.ConfigMap, namespace, prefix string, ref v1alpha1.ConfigMapVarsSource) (values map[string]string, invalidKeys []string, err error) {
-	configMap, err := r.configMap(ctx, cache, namespace, ref)
-	if configMap == nil || err != nil {
-		return nil, nil, err
+func (r *ConfigMapSecret) syncStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources, condStatus corev1.ConditionStatus, reason v1alpha1.ConfigMapSecretConditionReason, message string, degraded bool, pending string) error {
+	status := v1alpha1.ConfigMapSecretStatus{
+		ObservedGeneration: cms.Generation,
+		ReconcileID:        cms.Status.ReconcileID,
+		Conditions:         cms.Status.Conditions,
+		Sources:            srcs.statuses(cms, metav1.Now()),
+		VarCollisions:      srcs.varCollisions(cms),
 	}
-	values = make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
-	for k, v := range configMap.Data {
-		switch k, valid := validPrefixedKey(prefix, k); valid {
-		case true:
-			values[k] = v
-		case false:
-			invalidKeys = append(invalidKeys, k)
-		}
+	status.UnresolvedReferences, status.UnresolvedVars = srcs.unresolvedRefs(cms)
+	if condStatus == corev1.ConditionFalse {
+		status.NextRenderTime = nextRenderTime(cms, time.Now())
+		srcs.syncDegradedSources(&status)
 	}
-	for k, v := range configMap.BinaryData {
-		switch k, valid := validPrefixedKey(prefix, k); valid {
-		case true:
-			values[k] = string(v)
-		case false:
-			invalidKeys = append(invalidKeys, k)
-		}
+	cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretRenderFailure, condStatus, reason, message)
+	SetConfigMapSecretCondition(&status, *cond) // original backing array not modified
+	ready, readyReason, readyMessage := corev1.ConditionTrue, reason, message
+	if condStatus != corev1.ConditionFalse {
+		ready = corev1.ConditionFalse
+	} else if pending != "" {
+		ready, readyReason, readyMessage = corev1.ConditionFalse, v1alpha1.BlackoutWindowReason, pending
 	}
-	return values, invalidKeys, nil
-}
-
-func (r *ConfigMapSecret) configMapValue(ctx context.Context, cache map[string]*corev1.ConfigMap, namespace string, ref corev1.ConfigMapKeySelector) (value string, found bool, err error) {
-	key := ref.Key
-	configMap, err := r.configMap(ctx, cache, namespace, v1alpha1.ConfigMapVarsSource{
-		LocalObjectReference: ref.LocalObjectReference,
-		Optional:             ref.Optional,
-	})
-	if configMap == nil || err != nil {
-		return "", false, err
+	cond = NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretReady, ready, readyReason, readyMessage)
+	SetConfigMapSecretCondition(&status, *cond)
+	if pending != "" {
+		cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretPendingChanges, corev1.ConditionTrue, v1alpha1.BlackoutWindowReason, pending)
+		SetConfigMapSecretCondition(&status, *cond)
+	} else {
+		RemoveConfigMapSecretCondition(&status, v1alpha1.ConfigMapSecretPendingChanges)
 	}
-	if str, found := configMap.Data[key]; found {
-		return str, true, nil
+	if degraded {
+		msg := fmt.Sprintf("Rendering failed %d consecutive times with reason %s, retrying every %v until a source or the ConfigMapSecret changes.",
+			r.RenderFailureThreshold, reason, r.DegradedRetryInterval)
+		cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretDegraded, corev1.ConditionTrue, v1alpha1.RetryBudgetExhaustedReason, msg)
+		SetConfigMapSecretCondition(&status, *cond)
+	} else {
+		RemoveConfigMapSecretCondition(&status, v1alpha1.ConfigMapSecretDegraded)
 	}
-	if buf, found := configMap.BinaryData[key]; found {
-		return string(buf), true, nil
+	PruneConfigMapSecretConditions(&status)
+	phase := phaseReady
+	if degraded {
+		phase = phaseDegraded
+	} else if condStatus != corev1.ConditionFalse {
+		phase = phaseFailed
 	}
-	if ref.Optional != nil && *ref.Optional {
-		return "", false, nil
+	r.stats.setPhase(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}, phase)
+	if reflect.DeepEqual(cms.Status, status) && !r.status.isPending(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}) {
+		return nil
 	}
-	return "", false, newConfigError("Couldn't find key %s in ConfigMap %s/%s", key, namespace, ref.Name)
-}
-
-func (r *ConfigMapSecret) syncSuccessStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret) error {
-	return r.syncStatus(ctx, log, cms, corev1.ConditionFalse, "", "")
-}
-
-func (r *ConfigMapSecret) syncRenderFailureStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, reason, message string) error {
-	return r.syncStatus(ctx, log, cms, corev1.ConditionTrue, reason, message)
+	status.ReconcileID = string(reconcileIDFrom(ctx))
+	return r.updateStatus(ctx, log, cms, status)
 }
 
-func (r *ConfigMapSecret) syncStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, condStatus corev1.ConditionStatus, reason, message string) error {
-	status := v1alpha1.ConfigMapSecretStatus{
-		ObservedGeneration: cms.Generation,
-		Conditions:         cms.Status.Conditions,
+// syncCleanupStatus sets the CleanupFailed condition of the ConfigMapSecret
+// if cleanupErr is non-nil, or removes it otherwise.
+func (r *ConfigMapSecret) syncCleanupStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, cleanupErr error) error {
+	status := *cms.Status.DeepCopy()
+	if cleanupErr != nil {
+		cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretCleanupFailed, corev1.ConditionTrue, v1alpha1.CleanupErrorReason, cleanupErr.Error())
+		SetConfigMapSecretCondition(&status, *cond)
+	} else {
+		RemoveConfigMapSecretCondition(&status, v1alpha1.ConfigMapSecretCleanupFailed)
 	}
-	cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretRenderFailure, condStatus, reason, message)
-	SetConfigMapSecretCondition(&status, *cond) // original backing array not modified
-	if reflect.DeepEqual(cms.Status, status) {
+	if reflect.DeepEqual(cms.Status.Conditions, status.Conditions) && !r.status.isPending(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}) {
 		return nil
 	}
+	status.ReconcileID = string(reconcileIDFrom(ctx))
+	return r.updateStatus(ctx, log, cms, status)
+}
+
+// updateStatus sets the status of the ConfigMapSecret and writes it, or
+// enqueues it to be written by the status updater if statuses are batched.
+// If the write fails, the previous status is restored.
+func (r *ConfigMapSecret) updateStatus(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, status v1alpha1.ConfigMapSecretStatus) error {
+	prev := cms.Status
 	cms.Status = status
+	if r.status != nil {
+		r.status.enqueue(cms, prev)
+		return nil
+	}
 	log.Info("Updating status")
 	if err := r.client.Status().Update(ctx, cms); err != nil {
 		log.Error(err, "Unable to update status")
+		cms.Status = prev
 		return err
 	}
 	return nil
 }
 
-func validPrefixedKey(prefix, key string) (string, bool) {
-	if prefix != "" {
-		key = prefix + key
+// eventf records an event annotated with the reconcile ID from ctx,
+// unless it's suppressed by the event aggregator.
+func (r *ConfigMapSecret) eventf(ctx context.Context, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	msg := fmt.Sprintf(messageFmt, args...)
+	if o, ok := obj.(metav1.Object); ok {
+		var record bool
+		if msg, record = r.events.record(o.GetUID(), eventType, reason, msg, r.MaxEventsPerMinute); !record {
+			return
+		}
 	}
-	if errMsgs := validation.IsEnvVarName(key); len(errMsgs) > 0 {
-		return key, false
+	var annotations map[string]string
+	if id := reconcileIDFrom(ctx); id != "" {
+		annotations = map[string]string{v1alpha1.ReconcileIDAnnotation: string(id)}
 	}
-	return key, true
+	r.recorder.AnnotatedEventf(obj, annotations, eventType, reason, "%s", msg)
+}
+
+type reconcileIDKey struct{}
+
+func withReconcileID(ctx context.Context, id types.UID) context.Context {
+	return context.WithValue(ctx, reconcileIDKey{}, id)
+}
+
+func reconcileIDFrom(ctx context.Context) types.UID {
+	id, _ := ctx.Value(reconcileIDKey{}).(types.UID)
+	return id
 }
 
 func getOwner(secret *corev1.Secret) *metav1.OwnerReference {
@@ -587,6 +970,12 @@ func getOwner(secret *corev1.Secret) *metav1.OwnerReference {
 	return owner
 }
 
+// secretName returns the name of the Secret rendered from the ConfigMapSecret,
+// with the SecretNamePrefix and SecretNameSuffix.
+func (r *ConfigMapSecret) secretName(cms *v1alpha1.ConfigMapSecret) string {
+	return renderer.SecretName(r.SecretNamePrefix, r.SecretNameSuffix, cms)
+}
+
 func keys(set map[string]bool) []string {
 	n := len(set)
 	if n == 0 {
@@ -612,7 +1001,7 @@ func toReqs(namespace string, names map[string]bool) []reconcile.Request {
 	return reqs
 }
 
-func varRefs(varsFrom []v1alpha1.VarsFromSource, vars []v1alpha1.Var) (secrets, configMaps map[string]bool) {
+func varRefs(spec *v1alpha1.ConfigMapSecretSpec) (secrets, configMaps map[string]bool) {
 	addSecret := func(name string) {
 		if secrets == nil {
 			secrets = make(map[string]bool)
@@ -625,7 +1014,7 @@ func varRefs(varsFrom []v1alpha1.VarsFromSource, vars []v1alpha1.Var) (secrets,
 		}
 		configMaps[name] = true
 	}
-	for _, v := range varsFrom {
+	for _, v := range spec.VarsFrom {
 		if v.SecretRef != nil {
 			addSecret(v.SecretRef.Name)
 		}
@@ -633,7 +1022,7 @@ func varRefs(varsFrom []v1alpha1.VarsFromSource, vars []v1alpha1.Var) (secrets,
 			addConfigMap(v.ConfigMapRef.Name)
 		}
 	}
-	for _, v := range vars {
+	for _, v := range spec.Vars {
 		if v.SecretValue != nil {
 			addSecret(v.SecretValue.Name)
 		}
@@ -641,9 +1030,25 @@ func varRefs(varsFrom []v1alpha1.VarsFromSource, vars []v1alpha1.Var) (secrets,
 			addConfigMap(v.ConfigMapValue.Name)
 		}
 	}
+	if spec.GitSource != nil && spec.GitSource.SecretRef != nil {
+		addSecret(spec.GitSource.SecretRef.Name)
+	}
 	return secrets, configMaps
 }
 
+// joinErrors returns nil if every error is nil, the error if only one isn't,
+// or else an aggregate of the errors that aren't.
+func joinErrors(errs ...error) error {
+	agg := utilerrors.NewAggregate(errs)
+	if agg == nil {
+		return nil
+	}
+	if errs := agg.Errors(); len(errs) == 1 {
+		return errs[0]
+	}
+	return agg
+}
+
 type configError struct {
 	err error
 }
@@ -6,6 +6,7 @@ package controllers
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -15,8 +16,11 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/hooks"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
 )
 
 const timeout = time.Second * 10
@@ -204,6 +208,95 @@ func TestReconciler(t *testing.T) {
 			parallel: true,
 		},
 
+		{
+			name: "key-options",
+			steps: []step{
+				createConfigMapSecretStep(&v1alpha1.ConfigMapSecret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "key-options",
+						Namespace: "default",
+					},
+					Spec: v1alpha1.ConfigMapSecretSpec{
+						Template: v1alpha1.ConfigMapTemplate{
+							Metadata: v1alpha1.EmbeddedObjectMeta{
+								Annotations: map[string]string{
+									"foo": "bar",
+								},
+							},
+							Data: map[string]string{
+								"config.yaml": "foo: bar",
+							},
+							KeyOptions: map[string]v1alpha1.KeyOptions{
+								"config.yaml": {Mode: int32Ptr(0400), Owner: int64Ptr(1000), Validate: v1alpha1.ContentTypeYAML},
+								"missing":     {Mode: int32Ptr(0444)},
+							},
+						},
+					},
+				}),
+				checkSecretStep(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "key-options",
+						Namespace: "default",
+						Annotations: map[string]string{
+							"foo":                         "bar",
+							v1alpha1.KeyOptionsAnnotation: `{"config.yaml":{"mode":256,"owner":1000}}`,
+						},
+					},
+					Data: map[string][]byte{
+						"config.yaml": []byte("foo: bar"),
+					},
+				}),
+				checkStatusStep(true, types.NamespacedName{
+					Name:      "key-options",
+					Namespace: "default",
+				}),
+			},
+			parallel: true,
+		},
+
+		{
+			name: "string-data",
+			steps: []step{
+				createConfigMapSecretStep(&v1alpha1.ConfigMapSecret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "string-data",
+						Namespace: "default",
+					},
+					Spec: v1alpha1.ConfigMapSecretSpec{
+						Template: v1alpha1.ConfigMapTemplate{
+							Data: map[string]string{
+								"config.yaml": "foo: bar",
+								"user":        "default",
+							},
+							StringData: map[string]string{
+								"user":     "$(USER)",
+								"password": "hunter2",
+							},
+						},
+						Vars: []v1alpha1.Var{
+							{Name: "USER", Value: "admin"},
+						},
+					},
+				}),
+				checkSecretStep(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "string-data",
+						Namespace: "default",
+					},
+					Data: map[string][]byte{
+						"config.yaml": []byte("foo: bar"),
+						"user":        []byte("admin"),
+						"password":    []byte("hunter2"),
+					},
+				}),
+				checkStatusStep(true, types.NamespacedName{
+					Name:      "string-data",
+					Namespace: "default",
+				}),
+			},
+			parallel: true,
+		},
+
 		{
 			name: "no-values",
 			steps: []step{
@@ -1205,24 +1298,37 @@ func checkStatusStep(ok bool, key types.NamespacedName) step {
 				}
 			})
 			stat := cms.Status
-			if want, got := 1, len(stat.Conditions); want != got {
+			if want, got := 2, len(stat.Conditions); want != got {
 				t.Fatalf("unexpected number of conditions; want: %d; got: %d", want, got)
 			}
-			cond := stat.Conditions[0]
-			if want, got := v1alpha1.ConfigMapSecretRenderFailure, cond.Type; want != got {
-				t.Fatalf("unexpected condition type; want: %q; got: %q", want, got)
+			cond := GetConfigMapSecretCondition(stat, v1alpha1.ConfigMapSecretRenderFailure)
+			if cond == nil {
+				t.Fatalf("missing condition: %q", v1alpha1.ConfigMapSecretRenderFailure)
+			}
+			ready := GetConfigMapSecretCondition(stat, v1alpha1.ConfigMapSecretReady)
+			if ready == nil {
+				t.Fatalf("missing condition: %q", v1alpha1.ConfigMapSecretReady)
 			}
 			if ok {
 				if want, got := corev1.ConditionFalse, cond.Status; want != got {
 					t.Fatalf("unexpected condition status; want: %q; got: %q", want, got)
 				}
+				if want, got := corev1.ConditionTrue, ready.Status; want != got {
+					t.Fatalf("unexpected ready status; want: %q; got: %q", want, got)
+				}
 			} else {
 				if want, got := corev1.ConditionTrue, cond.Status; want != got {
 					t.Fatalf("unexpected condition status; want: %q; got: %q", want, got)
 				}
-				if want, got := CreateVariablesErrorReason, cond.Reason; want != got {
+				if want, got := v1alpha1.CreateVariablesErrorReason, cond.Reason; want != got {
 					t.Fatalf("unexpected condition reason; want: %q; got: %q", want, got)
 				}
+				if want, got := corev1.ConditionFalse, ready.Status; want != got {
+					t.Fatalf("unexpected ready status; want: %q; got: %q", want, got)
+				}
+				if want, got := v1alpha1.CreateVariablesErrorReason, ready.Reason; want != got {
+					t.Fatalf("unexpected ready reason; want: %q; got: %q", want, got)
+				}
 			}
 		})
 	}
@@ -1365,3 +1471,63 @@ func waitStep(key types.NamespacedName) step {
 }
 
 func boolPtr(v bool) *bool { return &v }
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestSecretName(t *testing.T) {
+	r := &ConfigMapSecret{SecretNamePrefix: "team-", SecretNameSuffix: "-cms"}
+	cms := &v1alpha1.ConfigMapSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"}}
+	if want, got := "team-app-cms", r.secretName(cms); want != got {
+		t.Errorf("unexpected name; want: %q; got: %q", want, got)
+	}
+	cms.Spec.Template.Metadata.Name = "app-secret"
+	if want, got := "team-app-secret-cms", r.secretName(cms); want != got {
+		t.Errorf("unexpected name; want: %q; got: %q", want, got)
+	}
+
+	// Names that exceed the limit with the prefix and suffix fail to render.
+	cms.Spec.Template.Metadata.Name = strings.Repeat("a", 250)
+	_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+	if !isConfigError(err) {
+		t.Fatalf("expected config error; got: %v", err)
+	}
+	if reason != v1alpha1.InvalidSecretNameReason {
+		t.Errorf("unexpected reason; want: %q; got: %q", v1alpha1.InvalidSecretNameReason, reason)
+	}
+}
+
+func TestPreviewRendererHooks(t *testing.T) {
+	r := &ConfigMapSecret{Hooks: []hooks.Hook{hooks.Exec("/bin/true")}}
+	if got := r.renderer().Hooks; len(got) != 1 {
+		t.Errorf("unexpected reconciler hooks; want: 1; got: %d", len(got))
+	}
+	if got := r.PreviewRenderer(nil).Hooks; len(got) != 0 {
+		t.Errorf("unexpected preview hooks; want: none; got: %d", len(got))
+	}
+}
+
+func TestInvalidKeyPolicyError(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/invalid-keys.input.yaml")
+	cms.Spec.InvalidKeyPolicy = v1alpha1.InvalidKeyPolicyError
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+	if !isConfigError(err) {
+		t.Fatalf("expected config error; got: %v", err)
+	}
+	if reason != v1alpha1.CreateVariablesErrorReason {
+		t.Errorf("unexpected reason; want: %q; got: %q", v1alpha1.CreateVariablesErrorReason, reason)
+	}
+	if want := "Keys [1st, db:host, log level] from the VarsFrom ConfigMap default/app are invalid template variable names"; err.Error() != want {
+		t.Errorf("unexpected error; want: %q; got: %q", want, err)
+	}
+}
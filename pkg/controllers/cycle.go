@@ -0,0 +1,63 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// dependencyCycle returns the names of the ConfigMapSecrets of a dependency
+// cycle that starts and ends with the named ConfigMapSecret, or nil if there's
+// none. A ConfigMapSecret depends on another if it references its Secret.
+//
+// Dependencies are known from the references of reconciled ConfigMapSecrets,
+// so a cycle is found by the first of its members reconciled after the others.
+func (r *ConfigMapSecret) dependencyCycle(namespace, name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	visited := make(map[string]bool)
+	var path []string
+	var visit func(cms string) bool
+	visit = func(cms string) bool {
+		path = append(path, cms)
+		for _, secret := range sortedKeys(r.secrets.dsts(namespace, cms)) {
+			for _, dep := range sortedKeys(r.outputs.srcs(namespace, secret)) {
+				if dep == name {
+					path = append(path, dep)
+					return true
+				}
+				if !visited[dep] {
+					visited[dep] = true
+					if visit(dep) {
+						return true
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(name) {
+		return path
+	}
+	return nil
+}
+
+// cycleDetected reports the dependency cycle in the status of the ConfigMapSecret,
+// whose Secret is left unchanged until the cycle is broken. The cycle is broken
+// by a change to the spec of one of its members, which changes its Secret and
+// so enqueues the ConfigMapSecrets that reference it.
+func (r *ConfigMapSecret) cycleDetected(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, cycle []string) (reconcile.Result, error) {
+	msg := fmt.Sprintf("Dependency cycle through the Secrets of ConfigMapSecrets: %s", strings.Join(cycle, " -> "))
+	log.Info("Unable to render ConfigMapSecret", "warning", msg)
+	return reconcile.Result{}, r.syncRenderFailureStatus(ctx, log, cms, newSources(), v1alpha1.DependencyCycleReason, msg, false)
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDependencyCycle(t *testing.T) {
+	set := func(names ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, name := range names {
+			m[name] = true
+		}
+		return m
+	}
+
+	r := &ConfigMapSecret{}
+	r.setRefs("ns", "self", set("self-secret"), nil, "self-secret")
+	r.setRefs("ns", "a", set("creds", "b-secret"), nil, "a-secret")
+	r.setRefs("ns", "b", set("c-secret"), nil, "b-secret")
+	r.setRefs("ns", "c", set("a-secret"), nil, "c-secret")
+	r.setRefs("ns", "d", set("a-secret"), nil, "d-secret")
+	r.setRefs("other", "e", set("a-secret"), nil, "b-secret")
+
+	tests := []struct {
+		namespace, name string
+		want            []string
+	}{
+		{namespace: "ns", name: "self", want: []string{"self", "self"}},
+		{namespace: "ns", name: "a", want: []string{"a", "b", "c", "a"}},
+		{namespace: "ns", name: "c", want: []string{"c", "a", "b", "c"}},
+		{namespace: "ns", name: "d"}, // Depends on a cycle, but isn't part of it.
+		{namespace: "other", name: "e"},
+		{namespace: "ns", name: "unknown"},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, r.dependencyCycle(tt.namespace, tt.name)); diff != "" {
+			t.Errorf("%s/%s: unexpected cycle (-want +got):\n%s", tt.namespace, tt.name, diff)
+		}
+	}
+
+	// Breaking the cycle.
+	r.setRefs("ns", "b", nil, nil, "b-secret")
+	if cycle := r.dependencyCycle("ns", "a"); cycle != nil {
+		t.Errorf("unexpected cycle: %v", cycle)
+	}
+	r.setRefs("ns", "self", nil, nil, "")
+	if cycle := r.dependencyCycle("ns", "self"); cycle != nil {
+		t.Errorf("unexpected cycle: %v", cycle)
+	}
+}
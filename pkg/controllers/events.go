@@ -0,0 +1,132 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// eventDedupInterval is the minimum interval at which an identical event
+	// is recorded for an object. Repeats in between are only counted.
+	eventDedupInterval = time.Minute
+	// eventAggregationWindow is the duration after its last occurrence for
+	// which the count of an event is kept.
+	eventAggregationWindow = 10 * time.Minute
+)
+
+var suppressedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_suppressed_events_total",
+	Help: "Total number of events that weren't recorded because they were duplicates or exceeded the per-object rate limit, by reason.",
+}, []string{"reason"})
+
+func init() {
+	metrics.Registry.MustRegister(suppressedEvents)
+}
+
+// An eventAggregator deduplicates and rate limits the events of each object,
+// so a bad rollout can't flood etcd with events. Identical events are recorded
+// at most once per eventDedupInterval, and once repeated, their messages
+// include their count and the times they were first and last seen.
+// The zero value is ready to use.
+type eventAggregator struct {
+	mu       sync.Mutex
+	now      func() time.Time // For tests.
+	events   map[eventKey]*eventCount
+	limiters map[types.UID]*rate.Limiter
+	pruned   time.Time
+}
+
+type eventKey struct {
+	uid       types.UID
+	eventType string
+	reason    string
+	message   string
+}
+
+type eventCount struct {
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	recorded  time.Time
+}
+
+// record counts an occurrence of the event of the object with the given UID
+// and reports whether it should be recorded, with the aggregated message.
+// If perMinute is positive, at most that many events are recorded per minute
+// for each object.
+func (a *eventAggregator) record(uid types.UID, eventType, reason, message string, perMinute int) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	if a.events == nil {
+		a.events = make(map[eventKey]*eventCount)
+		a.limiters = make(map[types.UID]*rate.Limiter)
+	}
+	if now.Sub(a.pruned) > eventAggregationWindow {
+		a.prune(now)
+	}
+
+	key := eventKey{uid: uid, eventType: eventType, reason: reason, message: message}
+	ev := a.events[key]
+	if ev == nil || now.Sub(ev.lastSeen) > eventAggregationWindow {
+		ev = &eventCount{firstSeen: now}
+		a.events[key] = ev
+	}
+	ev.count++
+	ev.lastSeen = now
+
+	if !ev.recorded.IsZero() && now.Sub(ev.recorded) < eventDedupInterval {
+		suppressedEvents.WithLabelValues(reason).Inc()
+		return "", false
+	}
+	if perMinute > 0 {
+		lim := a.limiters[uid]
+		if lim == nil {
+			lim = rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute)
+			a.limiters[uid] = lim
+		}
+		if !lim.AllowN(now, 1) {
+			suppressedEvents.WithLabelValues(reason).Inc()
+			return "", false
+		}
+	}
+	ev.recorded = now
+	if ev.count > 1 {
+		message = fmt.Sprintf("%s (%d times, first seen %s, last seen %s)", message, ev.count,
+			ev.firstSeen.UTC().Format(time.RFC3339), ev.lastSeen.UTC().Format(time.RFC3339))
+	}
+	return message, true
+}
+
+// prune forgets the events that are outside of the aggregation window,
+// and the rate limiters of objects without events.
+func (a *eventAggregator) prune(now time.Time) {
+	uids := make(map[types.UID]bool)
+	for key, ev := range a.events {
+		if now.Sub(ev.lastSeen) > eventAggregationWindow {
+			delete(a.events, key)
+		} else {
+			uids[key.uid] = true
+		}
+	}
+	for uid := range a.limiters {
+		if !uids[uid] {
+			delete(a.limiters, uid)
+		}
+	}
+	a.pruned = now
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEventAggregator(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &eventAggregator{now: func() time.Time { return now }}
+	suppressed := suppressedEvents.WithLabelValues("Test")
+	before := metricValue(t, suppressed)
+
+	type result struct {
+		msg    string
+		record bool
+	}
+	record := func(uid, msg string, perMinute int) result {
+		msg, ok := a.record(types.UID("uid-"+uid), corev1.EventTypeWarning, "Test", msg, perMinute)
+		return result{msg, ok}
+	}
+	steps := []struct {
+		advance   time.Duration
+		uid       string
+		msg       string
+		perMinute int
+		want      result
+	}{
+		{uid: "1", msg: "bad", want: result{"bad", true}},
+		{advance: time.Second, uid: "1", msg: "bad", want: result{}},
+		{advance: time.Second, uid: "2", msg: "bad", want: result{"bad", true}},
+		{advance: time.Minute, uid: "1", msg: "bad", want: result{"bad (3 times, first seen 2022-01-01T00:00:00Z, last seen 2022-01-01T00:01:02Z)", true}},
+		{advance: 11 * time.Minute, uid: "1", msg: "bad", want: result{"bad", true}},
+
+		// Rate limited.
+		{uid: "3", msg: "a", perMinute: 2, want: result{"a", true}},
+		{uid: "3", msg: "b", perMinute: 2, want: result{"b", true}},
+		{uid: "3", msg: "c", perMinute: 2, want: result{}},
+		{advance: 30 * time.Second, uid: "3", msg: "c", perMinute: 2, want: result{"c (2 times, first seen 2022-01-01T00:12:02Z, last seen 2022-01-01T00:12:32Z)", true}},
+	}
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		if got := record(step.uid, step.msg, step.perMinute); got != step.want {
+			t.Errorf("step %d: unexpected result: want: %+v; got: %+v", i, step.want, got)
+		}
+	}
+	if want, got := before+2, metricValue(t, suppressed); want != got {
+		t.Errorf("unexpected suppressed events: want: %v; got: %v", want, got)
+	}
+
+	now = now.Add(eventAggregationWindow + time.Second)
+	record("4", "new", 0)
+	if want, got := 1, len(a.events); want != got {
+		t.Errorf("unexpected events after pruning: want: %d; got: %d", want, got)
+	}
+	if want, got := 0, len(a.limiters); want != got {
+		t.Errorf("unexpected limiters after pruning: want: %d; got: %d", want, got)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// A failureClient has no sources and accepts status updates.
+type failureClient struct {
+	client.Client
+}
+
+func (failureClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+}
+
+func (c failureClient) Status() client.StatusWriter { return failureStatusWriter{} }
+
+type failureStatusWriter struct {
+	client.StatusWriter
+}
+
+func (failureStatusWriter) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return nil
+}
+
+func failureCMS(generation int64) *v1alpha1.ConfigMapSecret {
+	return &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Generation: generation},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Vars: []v1alpha1.Var{{
+				Name: "PASSWORD",
+				SecretValue: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+					Key:                  "password",
+				},
+			}},
+			Template: v1alpha1.ConfigMapTemplate{
+				Data: map[string]string{"password": "$(PASSWORD)"},
+			},
+		},
+	}
+}
+
+func TestRenderFailureThreshold(t *testing.T) {
+	r := &ConfigMapSecret{RenderFailureThreshold: 3}
+	cms := failureCMS(1)
+	for i, want := range []bool{false, false, true, true} {
+		if got := r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason); got != want {
+			t.Errorf("failure %d: unexpected degraded: want: %t; got: %t", i+1, want, got)
+		}
+	}
+
+	// Success resets the count.
+	r.clearRenderFailures(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name})
+	if r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason) {
+		t.Error("degraded after success")
+	}
+
+	// A zero threshold disables the circuit breaker.
+	r = &ConfigMapSecret{}
+	for i := 0; i < 10; i++ {
+		if r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason) {
+			t.Fatalf("failure %d: degraded without a threshold", i+1)
+		}
+	}
+}
+
+func TestRenderFailureResetOnChange(t *testing.T) {
+	r := &ConfigMapSecret{RenderFailureThreshold: 2}
+	cms := failureCMS(1)
+	r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason)
+
+	// A failure with another reason starts a new count.
+	if r.renderFailed(cms, v1alpha1.PostRenderHookErrorReason) {
+		t.Error("degraded after the reason changed")
+	}
+	if !r.renderFailed(cms, v1alpha1.PostRenderHookErrorReason) {
+		t.Error("not degraded after consecutive failures")
+	}
+
+	// So does a failure of another generation of the spec.
+	if r.renderFailed(failureCMS(2), v1alpha1.PostRenderHookErrorReason) {
+		t.Error("degraded after the generation changed")
+	}
+}
+
+func TestRenderFailureResetOnSourceChange(t *testing.T) {
+	r := &ConfigMapSecret{RenderFailureThreshold: 2}
+	cms := failureCMS(1)
+	r.setRefs(cms.Namespace, cms.Name, map[string]bool{"creds": true}, map[string]bool{"config": true}, "")
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	for name, change := range map[string]func(){
+		"Secret": func() {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "creds"}}
+			r.secretEventHandler(context.Background(), q, secret, false)
+		},
+		"ConfigMap": func() {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"}}
+			r.configMapEventHandler().Create(event.CreateEvent{Object: cm}, q)
+		},
+	} {
+		r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason)
+		if !r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason) {
+			t.Fatalf("%s: not degraded after consecutive failures", name)
+		}
+		change()
+		if r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason) {
+			t.Errorf("%s: degraded after a source changed", name)
+		}
+		r.clearRenderFailures(types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name})
+	}
+
+	// Changes of other sources don't reset the count.
+	r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason)
+	other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"}}
+	r.secretEventHandler(context.Background(), q, other, false)
+	if !r.renderFailed(cms, v1alpha1.CreateVariablesErrorReason) {
+		t.Error("not degraded after another source changed")
+	}
+}
+
+func TestRenderFailureDegraded(t *testing.T) {
+	r := &ConfigMapSecret{
+		RenderFailureThreshold: 2,
+		DegradedRetryInterval:  time.Minute,
+		client:                 failureClient{},
+		logger:                 logr.Discard(),
+	}
+	cms := failureCMS(1)
+	sync := func() (reconcile.Result, *v1alpha1.ConfigMapSecretCondition) {
+		t.Helper()
+		result, err := r.sync(context.Background(), logr.Discard(), cms)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result, GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretDegraded)
+	}
+
+	// Failures below the threshold are retried with the rate limiter.
+	result, cond := sync()
+	if result != (reconcile.Result{Requeue: true}) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if cond != nil {
+		t.Errorf("unexpected Degraded condition: %+v", cond)
+	}
+
+	// Once the threshold is reached, it's retried at the degraded interval.
+	result, cond = sync()
+	if result != (reconcile.Result{RequeueAfter: time.Minute}) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != v1alpha1.RetryBudgetExhaustedReason {
+		t.Fatalf("unexpected Degraded condition: %+v", cond)
+	}
+	if got := r.stats.entries[types.NamespacedName{Namespace: "default", Name: "app"}].phase; got != phaseDegraded {
+		t.Errorf("unexpected phase: want: %s; got: %s", phaseDegraded, got)
+	}
+
+	// The status of a rendered Secret removes the condition.
+	if err := r.syncStatus(context.Background(), logr.Discard(), cms, newSources(), corev1.ConditionFalse, "", "", false, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cond := GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretDegraded); cond != nil {
+		t.Errorf("unexpected Degraded condition after rendering: %+v", cond)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/gitsource"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var gitFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_git_fetches_total",
+	Help: "Total number of fetches of Git sources, by result (success or error).",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(gitFetches)
+}
+
+// A gitCache holds the files last fetched from the GitSource of each
+// ConfigMapSecret, so that they're fetched once per interval rather than on
+// every reconciliation. The zero value is ready to use.
+type gitCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]gitCacheEntry
+}
+
+type gitCacheEntry struct {
+	hash   [sha256.Size]byte // Of the source, including its credentials.
+	files  map[string][]byte
+	commit string
+	time   time.Time
+}
+
+// get returns the entry of the ConfigMapSecret, if it was fetched from the
+// source with the hash less than nine tenths of the interval before now. The
+// slack makes the refresh at the end of the interval fetch again, even if it
+// runs a little early.
+func (c *gitCache) get(key types.NamespacedName, hash [sha256.Size]byte, interval time.Duration, now time.Time) (gitCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.hash != hash || now.Sub(e.time) >= interval*9/10 {
+		return gitCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *gitCache) put(key types.NamespacedName, e gitCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[types.NamespacedName]gitCacheEntry)
+	}
+	c.entries[key] = e
+}
+
+func (c *gitCache) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// fetchGit returns the files of the ConfigMapSecret's expanded GitSource and
+// the commit from which they were read, fetching them at most once per sync
+// interval.
+func (r *ConfigMapSecret) fetchGit(ctx context.Context, cms *v1alpha1.ConfigMapSecret, src gitsource.Source) (map[string][]byte, string, error) {
+	buf, err := json.Marshal(src)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.Sum256(buf)
+
+	key := types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}
+	now := time.Now()
+	if e, ok := r.git.get(key, hash, cms.Spec.GitSource.SyncInterval(), now); ok {
+		return e.files, e.commit, nil
+	}
+	files, commit, err := (&gitsource.Fetcher{Protocols: r.GitProtocols}).Fetch(ctx, src)
+	if err != nil {
+		gitFetches.WithLabelValues("error").Inc()
+		return nil, "", err
+	}
+	gitFetches.WithLabelValues("success").Inc()
+	r.git.put(key, gitCacheEntry{hash: hash, files: files, commit: commit, time: now})
+	return files, commit, nil
+}
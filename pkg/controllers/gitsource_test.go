@@ -0,0 +1,112 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGitFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+repo)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	commit := func(name, data string) {
+		t.Helper()
+		file := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", name)
+	}
+	git("init", "--quiet")
+	commit("prod/app.conf", "password=$(PASSWORD)")
+
+	ctx := context.Background()
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Vars:      []v1alpha1.Var{{Name: "ENV", Value: "prod"}},
+			GitSource: &v1alpha1.GitSource{Repository: repo, Path: "$(ENV)"},
+		},
+	}
+
+	r := &ConfigMapSecret{}
+	gitFiles := func(srcs *sources) (map[string][]byte, error) {
+		secret, _, err := r.renderer().Render(ctx, cms, &srcs.Sources, nil)
+		if err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	}
+	if _, err := gitFiles(newSources()); !isConfigError(err) {
+		t.Errorf("expected config error with GitSources disabled, got: %v", err)
+	}
+
+	r.GitProtocols = []string{"file"}
+	srcs := newSources()
+	files, err := gitFiles(srcs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string][]byte{"app.conf": []byte("password=$(PASSWORD)")}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("unexpected files (-want +got):\n%s", diff)
+	}
+	now := metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	statuses := srcs.statuses(cms, now)
+	if len(statuses) != 1 || statuses[0].Kind != "Git" || statuses[0].Name != repo || len(statuses[0].ResourceVersion) != 40 {
+		t.Errorf("unexpected source statuses: %+v", statuses)
+	}
+
+	// Files are cached for the interval.
+	commit("prod/app.conf", "password=$(NEW_PASSWORD)")
+	if files, err = gitFiles(newSources()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("unexpected cached files (-want +got):\n%s", diff)
+	}
+	r.git.forget(types.NamespacedName{Namespace: "default", Name: "app"})
+	if files, err = gitFiles(newSources()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(files["app.conf"]); got != "password=$(NEW_PASSWORD)" {
+		t.Errorf("unexpected file after forgetting the cache: %q", got)
+	}
+
+	// A source that can't be read keeps its previous status.
+	cms.Status.Sources = statuses
+	cms.Spec.GitSource.Path = "missing"
+	srcs = newSources()
+	if _, err := gitFiles(srcs); err == nil || isConfigError(err) {
+		t.Errorf("expected transient error for a missing path, got: %v", err)
+	}
+	if diff := cmp.Diff(statuses, srcs.statuses(cms, now)); diff != "" {
+		t.Errorf("unexpected source statuses (-want +got):\n%s", diff)
+	}
+}
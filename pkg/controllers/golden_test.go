@@ -0,0 +1,213 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/preview"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "Update the golden files of render contract tests.")
+
+// TestRenderGolden renders each ConfigMapSecret in testdata/render and compares
+// the result to its golden file. The golden files are a contract with users:
+// a change in rendered bytes changes their Secrets, so existing golden files
+// must never be updated to accommodate a refactoring.
+func TestRenderGolden(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	paths, err := filepath.Glob("testdata/render/*.input.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no render fixtures found")
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".input.yaml")
+		t.Run(name, func(t *testing.T) {
+			cms, c := readRenderFixture(t, s, path)
+			r := &ConfigMapSecret{client: c, scheme: s, recorder: record.NewFakeRecorder(10), DefaultsConfigMap: "configmapsecret-defaults"}
+			secret, _, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := yaml.Marshal(newRenderGolden(secret))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			goldenPath := strings.TrimSuffix(path, ".input.yaml") + ".golden.yaml"
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Errorf("rendered output changed (-want +got):\n%s", diff)
+			}
+
+			// The preview must render the same bytes as the controller.
+			values, err := preview.Render(context.Background(), r.PreviewRenderer(c), cms)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := make(map[string][]byte, len(values))
+			for k, v := range values {
+				data[k] = v.Data
+			}
+			if diff := cmp.Diff(secret.Data, data); diff != "" {
+				t.Errorf("preview differs from controller (-controller +preview):\n%s", diff)
+			}
+		})
+	}
+}
+
+// A renderGolden is the rendered output of a ConfigMapSecret in a readable
+// form. Values that are valid UTF-8 are strings and the rest are bytes.
+type renderGolden struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Type        corev1.SecretType `json:"type"`
+	Data        map[string]string `json:"data,omitempty"`
+	BinaryData  map[string][]byte `json:"binaryData,omitempty"`
+}
+
+func newRenderGolden(secret *corev1.Secret) *renderGolden {
+	g := &renderGolden{
+		Name:        secret.Name,
+		Labels:      secret.Labels,
+		Annotations: secret.Annotations,
+		Type:        secret.Type,
+	}
+	for k, v := range secret.Data {
+		if utf8.Valid(v) {
+			if g.Data == nil {
+				g.Data = make(map[string]string)
+			}
+			g.Data[k] = string(v)
+		} else {
+			if g.BinaryData == nil {
+				g.BinaryData = make(map[string][]byte)
+			}
+			g.BinaryData[k] = v
+		}
+	}
+	return g
+}
+
+// readRenderFixture reads a ConfigMapSecret and its sources from a
+// multi-document YAML file.
+func readRenderFixture(t *testing.T, s *runtime.Scheme, path string) (*v1alpha1.ConfigMapSecret, *fixtureClient) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoder := serializer.NewCodecFactory(s).UniversalDeserializer()
+	var cms *v1alpha1.ConfigMapSecret
+	c := &fixtureClient{
+		configMaps: make(map[types.NamespacedName]*corev1.ConfigMap),
+		secrets:    make(map[types.NamespacedName]*corev1.Secret),
+	}
+	for _, doc := range bytes.Split(buf, []byte("\n---\n")) {
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			t.Fatalf("unable to decode %s: %v", path, err)
+		}
+		switch obj := obj.(type) {
+		case *v1alpha1.ConfigMapSecret:
+			cms = obj
+		case *corev1.ConfigMap:
+			c.configMaps[types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}] = obj
+		case *corev1.Secret:
+			c.secrets[types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}] = obj
+		default:
+			t.Fatalf("unexpected object type in %s: %T", path, obj)
+		}
+	}
+	if cms == nil {
+		t.Fatalf("no ConfigMapSecret in %s", path)
+	}
+	return cms, c
+}
+
+// A fixtureClient is a client that only gets and lists objects from a fixture.
+type fixtureClient struct {
+	client.Client
+	configMaps map[types.NamespacedName]*corev1.ConfigMap
+	secrets    map[types.NamespacedName]*corev1.Secret
+}
+
+func (c *fixtureClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		src, ok := c.configMaps[key]
+		if !ok {
+			return apierrors.NewNotFound(corev1.Resource("configmaps"), key.Name)
+		}
+		src.DeepCopyInto(obj)
+	case *corev1.Secret:
+		src, ok := c.secrets[key]
+		if !ok {
+			return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+		}
+		src.DeepCopyInto(obj)
+	default:
+		panic("unexpected object type")
+	}
+	return nil
+}
+
+func (c *fixtureClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	switch list := list.(type) {
+	case *corev1.ConfigMapList:
+		list.Items = nil
+		for key, src := range c.configMaps {
+			if listOpts.Namespace != "" && key.Namespace != listOpts.Namespace {
+				continue
+			}
+			if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(src.Labels)) {
+				continue
+			}
+			list.Items = append(list.Items, *src.DeepCopy())
+		}
+	default:
+		panic("unexpected list type")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// The event handlers of the reconciler take a context, like those of newer
+// versions of controller-runtime, so that they stop enqueueing requests once
+// the controller stops. The vendored version predates contexts in handlers,
+// so they're adapted to it with the context of the running manager, and the
+// adapters can be removed when upgrading.
+
+// A handlerContext is the context of the running manager, which is passed
+// to event handlers. It's a manager.Runnable.
+type handlerContext struct {
+	v atomic.Value
+}
+
+// Start stores the context until it's done.
+func (c *handlerContext) Start(ctx context.Context) error {
+	c.v.Store(ctx)
+	<-ctx.Done()
+	return nil
+}
+
+// get returns the context of the running manager, or the background context
+// if it hasn't started yet.
+func (c *handlerContext) get() context.Context {
+	if ctx, ok := c.v.Load().(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// contextFuncs is an event handler whose funcs take a context.
+type contextFuncs struct {
+	ctx         *handlerContext
+	CreateFunc  func(context.Context, event.CreateEvent, workqueue.RateLimitingInterface)
+	UpdateFunc  func(context.Context, event.UpdateEvent, workqueue.RateLimitingInterface)
+	DeleteFunc  func(context.Context, event.DeleteEvent, workqueue.RateLimitingInterface)
+	GenericFunc func(context.Context, event.GenericEvent, workqueue.RateLimitingInterface)
+}
+
+func (h *contextFuncs) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if h.CreateFunc != nil {
+		h.CreateFunc(h.ctx.get(), e, q)
+	}
+}
+
+func (h *contextFuncs) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if h.UpdateFunc != nil {
+		h.UpdateFunc(h.ctx.get(), e, q)
+	}
+}
+
+func (h *contextFuncs) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if h.DeleteFunc != nil {
+		h.DeleteFunc(h.ctx.get(), e, q)
+	}
+}
+
+func (h *contextFuncs) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	if h.GenericFunc != nil {
+		h.GenericFunc(h.ctx.get(), e, q)
+	}
+}
+
+// A contextMapFunc maps an object to the requests to enqueue for it.
+type contextMapFunc func(context.Context, client.Object) []reconcile.Request
+
+// enqueueRequestsFromMapFunc returns an event handler that enqueues the
+// requests returned by fn.
+func enqueueRequestsFromMapFunc(ctx *handlerContext, fn contextMapFunc) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return fn(ctx.get(), obj)
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestContextHandlers(t *testing.T) {
+	r := &ConfigMapSecret{}
+	r.setRefs("ns", "cms", map[string]bool{"src": true}, map[string]bool{"cm": true}, "cms")
+	src := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "src"}}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cm"}}
+
+	h := &contextFuncs{
+		ctx: &r.ctx,
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			r.secretEventHandler(ctx, q, e.Object.(*corev1.Secret), false)
+		},
+	}
+	enqueued := func() int {
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer q.ShutDown()
+		h.Create(event.CreateEvent{Object: src}, q)
+		r.configMapEventHandler().Create(event.CreateEvent{Object: cm}, q)
+		return q.Len()
+	}
+
+	// Before the manager starts, the background context is used.
+	if n := enqueued(); n != 1 {
+		t.Fatalf("unexpected number of requests before start: %d", n)
+	}
+
+	// Once the manager stops, nothing is enqueued.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.ctx.Start(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := enqueued(); n != 0 {
+		t.Fatalf("unexpected number of requests after stop: %d", n)
+	}
+}
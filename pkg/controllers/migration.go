@@ -0,0 +1,78 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/audit"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// migrationGracePeriod returns the migration grace period of the
+// ConfigMapSecret, or zero if it doesn't have one.
+func migrationGracePeriod(cms *v1alpha1.ConfigMapSecret) time.Duration {
+	if m := cms.Spec.Migration; m != nil && m.GracePeriod != nil && m.GracePeriod.Duration > 0 {
+		return m.GracePeriod.Duration
+	}
+	return 0
+}
+
+// migrate keeps the Secret that the ConfigMapSecret rendered with a previous
+// name updated with the data of its current Secret, until the grace period
+// since it was superseded ends. It returns the remaining duration of the grace
+// period, or zero if the Secret should be deleted.
+func (r *ConfigMapSecret) migrate(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, key types.NamespacedName, grace time.Duration, now time.Time) (time.Duration, error) {
+	old := &corev1.Secret{}
+	if err := r.client.Get(ctx, key, old); err != nil {
+		if apierrors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	since := now
+	prevSince, superseded := old.Annotations[v1alpha1.SupersededTimeAnnotation]
+	if t, err := time.Parse(time.RFC3339, prevSince); superseded && err == nil {
+		since = t
+	}
+	remaining := since.Add(grace).Sub(now)
+	if remaining <= 0 {
+		return 0, nil
+	}
+
+	// Until the current Secret is rendered, keep the data of the old one.
+	data := old.Data
+	cur := &corev1.Secret{}
+	if err := r.client.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: r.secretName(cms)}, cur); err == nil {
+		data = cur.Data
+	} else if !apierrors.IsNotFound(err) {
+		return 0, err
+	}
+	if superseded && reflect.DeepEqual(old.Data, data) {
+		return remaining, nil
+	}
+
+	annotations := make(map[string]string, len(old.Annotations)+1)
+	for k, v := range old.Annotations {
+		annotations[k] = v
+	}
+	annotations[v1alpha1.SupersededTimeAnnotation] = since.UTC().Format(time.RFC3339)
+	oldData := old.Data
+	old.Annotations = annotations
+	old.Data = data
+	log.Info("Updating superseded Secret", "remaining", remaining)
+	if err := r.client.Update(ctx, old); err != nil {
+		log.Error(err, "Unable to update superseded Secret")
+		return 0, err
+	}
+	r.audit(ctx, log, audit.Update, cms, key, oldData, data)
+	return remaining, nil
+}
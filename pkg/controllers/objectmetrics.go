@@ -0,0 +1,166 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Phases of a ConfigMapSecret reported by per-object metrics.
+const (
+	phaseReady    = "Ready"
+	phaseFailed   = "Failed"
+	phaseDegraded = "Degraded"
+)
+
+var (
+	objectPhaseDesc = prometheus.NewDesc(
+		"configmapsecret_object_phase",
+		"Phase of the ConfigMapSecret (Ready, Failed, or Degraded), whose value is 1.",
+		[]string{"namespace", "name", "phase"}, nil,
+	)
+	objectRenderDurationDesc = prometheus.NewDesc(
+		"configmapsecret_object_render_duration_seconds",
+		"Duration of the last rendering of the ConfigMapSecret.",
+		[]string{"namespace", "name"}, nil,
+	)
+	objectOutputSizeDesc = prometheus.NewDesc(
+		"configmapsecret_object_output_bytes",
+		"Total size of the data of the Secret last rendered by the ConfigMapSecret.",
+		[]string{"namespace", "name"}, nil,
+	)
+	objectUnresolvedRefsDesc = prometheus.NewDesc(
+		"configmapsecret_object_unresolved_references",
+		"Number of variable references left unresolved in the Secret last rendered by the ConfigMapSecret.",
+		[]string{"namespace", "name"}, nil,
+	)
+	objectsOmittedDesc = prometheus.NewDesc(
+		"configmapsecret_objects_omitted",
+		"Number of ConfigMapSecrets omitted from per-object metrics because of the limit.",
+		nil, nil,
+	)
+)
+
+// objectStats holds the per-object telemetry of ConfigMapSecrets.
+// The zero value is ready to use.
+type objectStats struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]objectStat
+}
+
+type objectStat struct {
+	phase          string
+	renderDuration time.Duration
+	outputSize     int
+	unresolvedRefs int32
+}
+
+func (s *objectStats) update(key types.NamespacedName, fn func(*objectStat)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[types.NamespacedName]objectStat)
+	}
+	e := s.entries[key]
+	fn(&e)
+	s.entries[key] = e
+}
+
+// observeRender records the duration of rendering the ConfigMapSecret and,
+// if it succeeded, the size of the data of the rendered Secret and the number
+// of unresolved references of the sources with which it was rendered.
+func (s *objectStats) observeRender(key types.NamespacedName, d time.Duration, secret *corev1.Secret, srcs *sources) {
+	s.update(key, func(e *objectStat) {
+		e.renderDuration = d
+		if secret != nil {
+			e.outputSize = 0
+			for _, v := range secret.Data {
+				e.outputSize += len(v)
+			}
+			e.unresolvedRefs = 0
+			for _, n := range srcs.Unresolved {
+				e.unresolvedRefs += int32(n)
+			}
+		}
+	})
+}
+
+// setPhase records the phase of the ConfigMapSecret.
+func (s *objectStats) setPhase(key types.NamespacedName, phase string) {
+	s.update(key, func(e *objectStat) { e.phase = phase })
+}
+
+// forget removes the telemetry of the ConfigMapSecret.
+func (s *objectStats) forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// ObjectCollector returns a collector of per-object metrics of the ConfigMapSecrets
+// reconciled by the reconciler: their phase, last render duration, output size,
+// and unresolved references.
+// To bound cardinality, at most limit ConfigMapSecrets are collected, in order
+// of namespace and name, and the number of omitted ones is reported instead.
+// Only the leader reconciles, so other replicas collect nothing.
+func (r *ConfigMapSecret) ObjectCollector(limit int) prometheus.Collector {
+	return &objectCollector{stats: &r.stats, limit: limit}
+}
+
+type objectCollector struct {
+	stats *objectStats
+	limit int
+}
+
+func (c *objectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- objectPhaseDesc
+	ch <- objectRenderDurationDesc
+	ch <- objectOutputSizeDesc
+	ch <- objectUnresolvedRefsDesc
+	ch <- objectsOmittedDesc
+}
+
+func (c *objectCollector) Collect(ch chan<- prometheus.Metric) {
+	c.stats.mu.Lock()
+	keys := make([]types.NamespacedName, 0, len(c.stats.entries))
+	for k := range c.stats.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, k int) bool {
+		if keys[i].Namespace != keys[k].Namespace {
+			return keys[i].Namespace < keys[k].Namespace
+		}
+		return keys[i].Name < keys[k].Name
+	})
+	omitted := 0
+	if len(keys) > c.limit {
+		omitted = len(keys) - c.limit
+		keys = keys[:c.limit]
+	}
+	entries := make([]objectStat, len(keys))
+	for i, k := range keys {
+		entries[i] = c.stats.entries[k]
+	}
+	c.stats.mu.Unlock()
+
+	for i, k := range keys {
+		e := entries[i]
+		if e.phase != "" {
+			ch <- prometheus.MustNewConstMetric(objectPhaseDesc, prometheus.GaugeValue, 1, k.Namespace, k.Name, e.phase)
+		}
+		ch <- prometheus.MustNewConstMetric(objectRenderDurationDesc, prometheus.GaugeValue, e.renderDuration.Seconds(), k.Namespace, k.Name)
+		ch <- prometheus.MustNewConstMetric(objectOutputSizeDesc, prometheus.GaugeValue, float64(e.outputSize), k.Namespace, k.Name)
+		ch <- prometheus.MustNewConstMetric(objectUnresolvedRefsDesc, prometheus.GaugeValue, float64(e.unresolvedRefs), k.Namespace, k.Name)
+	}
+	ch <- prometheus.MustNewConstMetric(objectsOmittedDesc, prometheus.GaugeValue, float64(omitted))
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestObjectCollector(t *testing.T) {
+	r := &ConfigMapSecret{}
+	a := types.NamespacedName{Namespace: "a", Name: "cms"}
+	b := types.NamespacedName{Namespace: "b", Name: "cms"}
+	c := types.NamespacedName{Namespace: "c", Name: "cms"}
+
+	secret := &corev1.Secret{Data: map[string][]byte{"x": []byte("123"), "y": []byte("45")}}
+	srcs := newSources()
+	srcs.Unresolved = map[string]int{"HOST": 1, "PASSWORD": 2}
+	r.stats.observeRender(a, time.Second, secret, srcs)
+	r.stats.setPhase(a, phaseReady)
+	r.stats.observeRender(b, time.Second, secret, newSources())
+	r.stats.observeRender(b, 2*time.Second, nil, newSources()) // Failed renders keep the size.
+	r.stats.setPhase(b, phaseDegraded)
+	r.stats.observeRender(c, time.Second, secret, newSources())
+	r.stats.setPhase(c, phaseReady)
+	r.stats.observeRender(types.NamespacedName{Namespace: "d", Name: "deleted"}, time.Second, secret, newSources())
+	r.stats.forget(types.NamespacedName{Namespace: "d", Name: "deleted"})
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(r.ObjectCollector(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+			got = append(got, fmt.Sprintf("%s{%s} %g", mf.GetName(), strings.Join(labels, ","), m.GetGauge().GetValue()))
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"configmapsecret_object_output_bytes{name=cms,namespace=a} 5",
+		"configmapsecret_object_output_bytes{name=cms,namespace=b} 5",
+		"configmapsecret_object_phase{name=cms,namespace=a,phase=Ready} 1",
+		"configmapsecret_object_phase{name=cms,namespace=b,phase=Degraded} 1",
+		"configmapsecret_object_render_duration_seconds{name=cms,namespace=a} 1",
+		"configmapsecret_object_render_duration_seconds{name=cms,namespace=b} 2",
+		"configmapsecret_object_unresolved_references{name=cms,namespace=a} 3",
+		"configmapsecret_object_unresolved_references{name=cms,namespace=b} 0",
+		"configmapsecret_objects_omitted{} 1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// TookOwnershipReason is the reason of events of a ConfigMapSecret that
+	// took over ownership of its Secret from another ConfigMapSecret.
+	TookOwnershipReason = "TookOwnership"
+	// LostOwnershipReason is the reason of events of a ConfigMapSecret whose
+	// Secret was taken over by another ConfigMapSecret.
+	LostOwnershipReason = "LostOwnership"
+)
+
+// A blockedError indicates that a Secret is owned by another object.
+type blockedError struct {
+	msg string
+}
+
+func (e *blockedError) Error() string { return e.msg }
+
+// takeover returns the ConfigMapSecret that owns the Secret if cms may take it
+// over, or a *blockedError if it may not. If the owner no longer exists, nil
+// is returned, since the Secret is about to be garbage collected.
+func (r *ConfigMapSecret) takeover(ctx context.Context, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) (*v1alpha1.ConfigMapSecret, error) {
+	key := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	ref := getManager(secret)
+	if ref == nil {
+		ref := metav1.GetControllerOf(secret)
+		return nil, &blockedError{fmt.Sprintf("Secret %s is owned by %s %s", key, ref.Kind, ref.Name)}
+	}
+	// Secrets that aren't owned outlive their ConfigMapSecret, so they may be
+	// adopted once it's gone.
+	if metav1.GetControllerOf(secret) == nil {
+		if owner, err := r.currentOwner(ctx, secret.Namespace, ref); owner == nil || err != nil {
+			return nil, err
+		}
+	}
+	if cms.Annotations[v1alpha1.TakeoverAnnotation] != "true" {
+		return nil, &blockedError{fmt.Sprintf("Secret %s is owned by ConfigMapSecret %s; set the %s annotation to take it over",
+			key, ref.Name, v1alpha1.TakeoverAnnotation)}
+	}
+	owner, err := r.currentOwner(ctx, secret.Namespace, ref)
+	if owner == nil || err != nil {
+		return nil, err
+	}
+	if owner.Annotations[v1alpha1.TakeoverAnnotation] == "true" {
+		return nil, &blockedError{fmt.Sprintf("Secret %s is owned by ConfigMapSecret %s, which also has the %s annotation",
+			key, ref.Name, v1alpha1.TakeoverAnnotation)}
+	}
+	return owner, nil
+}
+
+// currentOwner returns the ConfigMapSecret referenced by ref, or nil if it no
+// longer exists.
+func (r *ConfigMapSecret) currentOwner(ctx context.Context, namespace string, ref *metav1.OwnerReference) (*v1alpha1.ConfigMapSecret, error) {
+	owner := &v1alpha1.ConfigMapSecret{}
+	if err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, owner); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if owner.UID != ref.UID {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+// releaseOwner confirms or takes over the Secret for a ConfigMapSecret that
+// doesn't manage its ownership, and removes its controller reference, if any.
+// Its tracking metadata is set when it's rendered. The results are those of
+// setOwner.
+func (r *ConfigMapSecret) releaseOwner(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) (changed bool, prevOwner *v1alpha1.ConfigMapSecret, err error) {
+	ctrl := metav1.GetControllerOf(secret)
+	if ref := getManager(secret); (ctrl != nil && ctrl.UID != cms.UID) || (ref != nil && ref.UID != cms.UID) {
+		if prevOwner, err = r.takeover(ctx, cms, secret); err != nil {
+			return false, nil, err
+		}
+		log.Info("Taking over Secret without ownership", "previousOwner", *ref)
+		changed = true
+	}
+	if ctrl != nil {
+		if ctrl.UID == cms.UID {
+			log.Info("Releasing ownership of Secret")
+		}
+		var refs []metav1.OwnerReference
+		for _, ref := range secret.OwnerReferences {
+			if ref.Controller == nil || !*ref.Controller {
+				refs = append(refs, ref)
+			}
+		}
+		secret.OwnerReferences = refs
+		changed = true
+	}
+	return changed, prevOwner, nil
+}
+
+// managesOwnership reports whether the ConfigMapSecret owns its Secret.
+func managesOwnership(cms *v1alpha1.ConfigMapSecret) bool {
+	return cms.Spec.ManageOwnership == nil || *cms.Spec.ManageOwnership
+}
+
+// setManager sets the metadata by which the Secret of a ConfigMapSecret that
+// doesn't manage its ownership is tracked.
+func setManager(cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) {
+	secret.Labels = renderer.MergeStrings(secret.Labels, map[string]string{v1alpha1.OwnerUIDLabel: string(cms.UID)})
+	secret.Annotations = renderer.MergeStrings(secret.Annotations, map[string]string{v1alpha1.OwnerNameAnnotation: cms.Name})
+}
+
+// getManager returns the reference to the ConfigMapSecret that controls the
+// Secret, by its controller reference or, if it has none, by its tracking
+// metadata, or nil if there's none.
+func getManager(secret *corev1.Secret) *metav1.OwnerReference {
+	if metav1.GetControllerOf(secret) != nil {
+		return getOwner(secret)
+	}
+	uid, name := secret.Labels[v1alpha1.OwnerUIDLabel], secret.Annotations[v1alpha1.OwnerNameAnnotation]
+	if uid == "" || name == "" {
+		return nil
+	}
+	return &metav1.OwnerReference{
+		APIVersion: v1alpha1.GroupVersion.String(),
+		Kind:       "ConfigMapSecret",
+		Name:       name,
+		UID:        types.UID(uid),
+	}
+}
+
+// tookOwnership records events of the transfer of the Secret's ownership
+// from the previous owner to cms.
+func (r *ConfigMapSecret) tookOwnership(ctx context.Context, cms, prevOwner *v1alpha1.ConfigMapSecret, secret types.NamespacedName) {
+	r.eventf(ctx, cms, corev1.EventTypeNormal, TookOwnershipReason,
+		"Took ownership of Secret %s from ConfigMapSecret %s", secret, prevOwner.Name)
+	r.eventf(ctx, prevOwner, corev1.EventTypeWarning, LostOwnershipReason,
+		"Ownership of Secret %s was taken over by ConfigMapSecret %s", secret, cms.Name)
+}
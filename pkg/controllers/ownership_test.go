@@ -0,0 +1,139 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A cmsClient gets ConfigMapSecrets from a map.
+type cmsClient struct {
+	client.Client
+	objs map[string]*v1alpha1.ConfigMapSecret
+}
+
+func (c *cmsClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if cms, ok := c.objs[key.Name]; ok {
+		cms.DeepCopyInto(obj.(*v1alpha1.ConfigMapSecret))
+		return nil
+	}
+	return apierrors.NewNotFound(v1alpha1.GroupVersion.WithResource("configmapsecrets").GroupResource(), key.Name)
+}
+
+func TestSetOwner(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unmanaged := func(cms *v1alpha1.ConfigMapSecret) *v1alpha1.ConfigMapSecret {
+		cms.Spec.ManageOwnership = new(bool)
+		return cms
+	}
+	newCMS := func(name string, takeover bool) *v1alpha1.ConfigMapSecret {
+		cms := &v1alpha1.ConfigMapSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, UID: types.UID(name + "-uid")},
+		}
+		if takeover {
+			cms.Annotations = map[string]string{v1alpha1.TakeoverAnnotation: "true"}
+		}
+		return cms
+	}
+	ownedBy := func(owner *v1alpha1.ConfigMapSecret) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "secret"}}
+		if owner != nil {
+			gvk := v1alpha1.GroupVersion.WithKind("ConfigMapSecret")
+			secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, gvk)}
+		}
+		return secret
+	}
+	trackedBy := func(owner *v1alpha1.ConfigMapSecret) *corev1.Secret {
+		secret := ownedBy(nil)
+		setManager(owner, secret)
+		return secret
+	}
+
+	old := newCMS("old", false)
+	oldTakeover := newCMS("old-takeover", true)
+	deleted := newCMS("deleted", false)
+	r := &ConfigMapSecret{
+		client: &cmsClient{objs: map[string]*v1alpha1.ConfigMapSecret{"old": old, "old-takeover": oldTakeover}},
+		scheme: scheme,
+		logger: logr.Discard(),
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		cms         *v1alpha1.ConfigMapSecret
+		secret      *corev1.Secret
+		wantChanged bool
+		wantPrev    string
+		wantBlocked bool
+	}{
+		{name: "unmanaged unowned", cms: unmanaged(newCMS("new", false)), secret: ownedBy(nil)},
+		{name: "unmanaged tracked", cms: unmanaged(old.DeepCopy()), secret: trackedBy(old)},
+		{name: "unmanaged released", cms: unmanaged(old.DeepCopy()), secret: ownedBy(old), wantChanged: true},
+		{name: "unmanaged blocked", cms: unmanaged(newCMS("new", false)), secret: trackedBy(old), wantBlocked: true},
+		{name: "unmanaged takeover", cms: unmanaged(newCMS("new", true)), secret: ownedBy(old), wantChanged: true, wantPrev: "old"},
+		{name: "unmanaged orphaned", cms: unmanaged(newCMS("new", false)), secret: trackedBy(deleted), wantChanged: true},
+		{name: "tracked blocked", cms: newCMS("new", false), secret: trackedBy(old), wantBlocked: true},
+		{name: "tracked orphaned", cms: newCMS("new", false), secret: trackedBy(deleted), wantChanged: true},
+		{name: "unowned", cms: newCMS("new", false), secret: ownedBy(nil), wantChanged: true},
+		{name: "owned", cms: old, secret: ownedBy(old)},
+		{name: "blocked", cms: newCMS("new", false), secret: ownedBy(old), wantBlocked: true},
+		{name: "takeover", cms: newCMS("new", true), secret: ownedBy(old), wantChanged: true, wantPrev: "old"},
+		{name: "contested", cms: newCMS("new", true), secret: ownedBy(oldTakeover), wantBlocked: true},
+		{name: "deleted owner", cms: newCMS("new", true), secret: ownedBy(deleted), wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, prev, err := r.setOwner(ctx, r.logger, tt.cms, tt.secret)
+			if blocked := (*blockedError)(nil); errors.As(err, &blocked) != tt.wantBlocked {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantBlocked {
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("unexpected changed: want: %v; got: %v", tt.wantChanged, changed)
+			}
+			var gotPrev string
+			if prev != nil {
+				gotPrev = prev.Name
+			}
+			if gotPrev != tt.wantPrev {
+				t.Errorf("unexpected previous owner: want: %q; got: %q", tt.wantPrev, gotPrev)
+			}
+			owner := metav1.GetControllerOf(tt.secret)
+			if !managesOwnership(tt.cms) {
+				if owner != nil {
+					t.Errorf("unexpected owner: %v", owner)
+				}
+				return
+			}
+			if owner == nil || owner.UID != tt.cms.UID {
+				t.Errorf("unexpected owner: %v", owner)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"errors"
+
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var policyViolations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "configmapsecret_controller_policy_violations_total",
+		Help: "Number of violations of the policy by rendered Secrets, by check.",
+	},
+	[]string{"namespace", "check"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(policyViolations)
+}
+
+// countPolicyViolations counts the violations of the policy by a Secret
+// rendered in the namespace, if err is a violation of it.
+func countPolicyViolations(namespace string, err error) {
+	var policyErr *policy.Error
+	if errors.As(err, &policyErr) {
+		for _, v := range policyErr.Violations {
+			policyViolations.WithLabelValues(namespace, v.Check).Inc()
+		}
+	}
+}
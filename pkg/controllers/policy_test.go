@@ -0,0 +1,50 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+)
+
+func TestRenderPolicyViolation(t *testing.T) {
+	r := &ConfigMapSecret{
+		Policy: &policy.Policy{RequiredLabels: []string{"team"}, MaxKeySize: 8},
+		client: &fixtureClient{},
+		scheme: scheme,
+	}
+	b := build.NewConfigMapSecret("app").
+		WithNamespace("policy").
+		WithVar("PASSWORD", "hunter2").
+		WithDataTemplate("password", "$(PASSWORD)").
+		WithDataTemplate("config", "password: $(PASSWORD)")
+	violations := policyViolations.WithLabelValues("policy", policy.CheckRequiredLabel)
+	before := metricValue(t, violations)
+
+	_, reason, err := r.renderSecret(context.Background(), b.ConfigMapSecret(), newSources(), nil)
+	if reason != v1alpha1.PolicyViolationReason || !isConfigError(err) {
+		t.Fatalf("unexpected result: %s: %v", reason, err)
+	}
+	want := "policy violation: missing required label team; key config of 17 bytes exceeds the maximum of 8"
+	if err.Error() != want {
+		t.Errorf("unexpected error: want: %q; got: %q", want, err)
+	}
+	if got := metricValue(t, violations) - before; got != 1 {
+		t.Errorf("unexpected violations metric: want: 1; got: %v", got)
+	}
+
+	// Labels set by the template satisfy the policy.
+	secret, _, err := r.renderSecret(context.Background(), b.WithSecretLabel("team", "sre").WithDataTemplate("config", "").ConfigMapSecret(), newSources(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret.Labels["team"] != "sre" {
+		t.Errorf("unexpected labels: %v", secret.Labels)
+	}
+}
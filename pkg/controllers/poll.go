@@ -0,0 +1,43 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// pollResult returns the result of successfully reconciling a ConfigMapSecret,
+// which requeues it after the poll interval if a watch is disabled, unless it's
+// requeued sooner.
+func (r *ConfigMapSecret) pollResult(result reconcile.Result) reconcile.Result {
+	if r.PollInterval <= 0 || !(r.DisableSecretWatch || r.DisableConfigMapWatch) || result.Requeue {
+		return result
+	}
+	if result.RequeueAfter == 0 || r.PollInterval < result.RequeueAfter {
+		result.RequeueAfter = r.PollInterval
+	}
+	return result
+}
+
+// observeUnwatched records the ownership of a Secret that was written or
+// deleted, as its watch would, if the watch is disabled, so that it's cleaned
+// up when it's renamed. Secrets renamed while the controller isn't running
+// aren't cleaned up, but they're still garbage collected with their owner.
+func (r *ConfigMapSecret) observeUnwatched(secret *corev1.Secret, deleted bool) {
+	if !r.DisableSecretWatch {
+		return
+	}
+	owner := getManager(secret)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if deleted || owner == nil {
+		r.owned.set(secret.Namespace, secret.Name, nil)
+		return
+	}
+	r.owned.set(secret.Namespace, secret.Name, map[string]bool{string(owner.UID): true})
+}
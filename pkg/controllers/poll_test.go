@@ -0,0 +1,106 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestPollResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		r      *ConfigMapSecret
+		result reconcile.Result
+		want   reconcile.Result
+	}{
+		{
+			name: "watched",
+			r:    &ConfigMapSecret{PollInterval: time.Minute},
+			want: reconcile.Result{},
+		},
+		{
+			name: "secrets unwatched",
+			r:    &ConfigMapSecret{DisableSecretWatch: true, PollInterval: time.Minute},
+			want: reconcile.Result{RequeueAfter: time.Minute},
+		},
+		{
+			name: "configmaps unwatched",
+			r:    &ConfigMapSecret{DisableConfigMapWatch: true, PollInterval: time.Minute},
+			want: reconcile.Result{RequeueAfter: time.Minute},
+		},
+		{
+			name: "polling disabled",
+			r:    &ConfigMapSecret{DisableSecretWatch: true},
+			want: reconcile.Result{},
+		},
+		{
+			name:   "refreshed sooner",
+			r:      &ConfigMapSecret{DisableSecretWatch: true, PollInterval: time.Minute},
+			result: reconcile.Result{RequeueAfter: time.Second},
+			want:   reconcile.Result{RequeueAfter: time.Second},
+		},
+		{
+			name:   "refreshed later",
+			r:      &ConfigMapSecret{DisableSecretWatch: true, PollInterval: time.Minute},
+			result: reconcile.Result{RequeueAfter: time.Hour},
+			want:   reconcile.Result{RequeueAfter: time.Minute},
+		},
+		{
+			name:   "requeued",
+			r:      &ConfigMapSecret{DisableSecretWatch: true, PollInterval: time.Minute},
+			result: reconcile.Result{Requeue: true},
+			want:   reconcile.Result{Requeue: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.pollResult(tt.result); got != tt.want {
+				t.Errorf("unexpected result: want: %+v; got: %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestObserveUnwatched(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "secret",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "secrets.mz.com/v1alpha1",
+				Kind:       "ConfigMapSecret",
+				Name:       "cms",
+				UID:        "uid",
+				Controller: pointer.Bool(true),
+			}},
+		},
+	}
+	owned := func(r *ConfigMapSecret) bool {
+		return r.owned.srcs("default", "uid")["secret"]
+	}
+
+	// The watch records ownership if it's enabled.
+	r := &ConfigMapSecret{}
+	r.observeUnwatched(secret, false)
+	if owned(r) {
+		t.Fatal("unexpected ownership recorded with the Secret watch enabled")
+	}
+
+	r = &ConfigMapSecret{DisableSecretWatch: true}
+	r.observeUnwatched(secret, false)
+	if !owned(r) {
+		t.Fatal("expected ownership to be recorded")
+	}
+	r.observeUnwatched(secret, true)
+	if owned(r) {
+		t.Fatal("expected ownership to be removed")
+	}
+}
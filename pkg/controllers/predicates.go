@@ -0,0 +1,110 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var ignoredUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_ignored_updates_total",
+	Help: "Total number of update events ignored by the ConfigMapSecret controller because they don't affect rendering, by kind.",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(ignoredUpdates)
+}
+
+// configMapSecretChanged returns a predicate that ignores updates of
+// ConfigMapSecrets that only change their status. Labels and annotations
+// don't change the generation, but may be inherited or change the behavior
+// of the controller.
+func configMapSecretChanged() predicate.Predicate {
+	return ignoreUpdates("ConfigMapSecret", predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+	).Update)
+}
+
+// secretChanged returns a predicate that ignores updates of Secrets that
+// don't change their content, e.g. periodic resyncs and updates of managed
+// fields. The labels and annotations of Secrets rendered by ConfigMapSecrets
+// are part of their content, since they're written by the controller, but
+// other Secrets are only read for their data, so changes to their labels or
+// annotations by other controllers are ignored, except for the tenant label,
+// which decides whether they may be read at all.
+func (r *ConfigMapSecret) secretChanged() predicate.Predicate {
+	return ignoreUpdates("Secret", func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*corev1.Secret)
+		if !ok {
+			return true
+		}
+		cur, ok := e.ObjectNew.(*corev1.Secret)
+		if !ok {
+			return true
+		}
+		if old.Type != cur.Type ||
+			!reflect.DeepEqual(old.Data, cur.Data) ||
+			!reflect.DeepEqual(old.OwnerReferences, cur.OwnerReferences) {
+			return true
+		}
+		if r.TenantLabel != "" && old.Labels[r.TenantLabel] != cur.Labels[r.TenantLabel] {
+			return true
+		}
+		if reflect.DeepEqual(old.Labels, cur.Labels) && reflect.DeepEqual(old.Annotations, cur.Annotations) {
+			return false
+		}
+		return getManager(old) != nil || getManager(cur) != nil || r.rendered(cur.Namespace, cur.Name)
+	})
+}
+
+// rendered reports whether the named Secret is rendered by a ConfigMapSecret.
+func (r *ConfigMapSecret) rendered(namespace, name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.outputs.srcs(namespace, name)) > 0
+}
+
+// configMapChanged returns a predicate that ignores updates of ConfigMaps
+// that don't change their data, labels, or annotations, since the labels and
+// annotations of a defaults ConfigMap are defaults of rendered Secrets.
+func configMapChanged() predicate.Predicate {
+	return ignoreUpdates("ConfigMap", func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*corev1.ConfigMap)
+		if !ok {
+			return true
+		}
+		cur, ok := e.ObjectNew.(*corev1.ConfigMap)
+		if !ok {
+			return true
+		}
+		return !reflect.DeepEqual(old.Data, cur.Data) ||
+			!reflect.DeepEqual(old.BinaryData, cur.BinaryData) ||
+			!reflect.DeepEqual(old.Labels, cur.Labels) ||
+			!reflect.DeepEqual(old.Annotations, cur.Annotations)
+	})
+}
+
+// ignoreUpdates returns a predicate that ignores updates of objects of the
+// given kind for which changed returns false.
+func ignoreUpdates(kind string, changed func(event.UpdateEvent) bool) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil || changed(e) {
+				return true
+			}
+			ignoredUpdates.WithLabelValues(kind).Inc()
+			return false
+		},
+	}
+}
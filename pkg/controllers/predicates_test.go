@@ -0,0 +1,97 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+func TestPredicates(t *testing.T) {
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cms", Generation: 1, ResourceVersion: "1"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "secret", ResourceVersion: "1"},
+		Data:       map[string][]byte{"a": []byte("1")},
+	}
+	rendered := secret.DeepCopy()
+	rendered.Name = "rendered"
+	owned := secret.DeepCopy()
+	owned.Name = "owned"
+	setManager(&v1alpha1.ConfigMapSecret{ObjectMeta: metav1.ObjectMeta{Name: "cms", UID: "cms-uid"}}, owned)
+	tenanted := secret.DeepCopy()
+	tenanted.Labels = map[string]string{"tenant": "a", "app": "web"}
+
+	r := &ConfigMapSecret{TenantLabel: "tenant"}
+	r.setRefs("ns", "cms", nil, nil, "rendered")
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", ResourceVersion: "1"},
+		Data:       map[string]string{"a": "1"},
+	}
+
+	tests := []struct {
+		name string
+		obj  client.Object
+		fn   func(client.Object)
+		want bool
+	}{
+		{"cms resync", cms, func(client.Object) {}, false},
+		{"cms status", cms, func(o client.Object) {
+			o.(*v1alpha1.ConfigMapSecret).Status.ObservedGeneration = 1
+		}, false},
+		{"cms spec", cms, func(o client.Object) { o.SetGeneration(2) }, true},
+		{"cms labels", cms, func(o client.Object) { o.SetLabels(map[string]string{"app": "web"}) }, true},
+		{"cms annotations", cms, func(o client.Object) {
+			o.SetAnnotations(map[string]string{v1alpha1.DebugAnnotation: "true"})
+		}, true},
+		{"secret resync", secret, func(client.Object) {}, false},
+		{"secret managed fields", secret, func(o client.Object) {
+			o.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "kubectl"}})
+		}, false},
+		{"secret data", secret, func(o client.Object) { o.(*corev1.Secret).Data["a"] = []byte("2") }, true},
+		{"secret type", secret, func(o client.Object) { o.(*corev1.Secret).Type = corev1.SecretTypeOpaque }, true},
+		{"secret owner", secret, func(o client.Object) {
+			o.SetOwnerReferences([]metav1.OwnerReference{{Name: "cms"}})
+		}, true},
+		{"secret annotations", secret, func(o client.Object) { o.SetAnnotations(map[string]string{"a": "b"}) }, false},
+		{"secret labels", secret, func(o client.Object) { o.SetLabels(map[string]string{"app": "web"}) }, false},
+		{"secret tenant label", secret, func(o client.Object) { o.SetLabels(map[string]string{"tenant": "a"}) }, true},
+		{"secret tenant label removed", tenanted, func(o client.Object) { o.SetLabels(map[string]string{"app": "web"}) }, true},
+		{"secret tenant label changed", tenanted, func(o client.Object) { o.GetLabels()["tenant"] = "b" }, true},
+		{"tenant secret labels", tenanted, func(o client.Object) { o.GetLabels()["app"] = "api" }, false},
+		{"rendered secret annotations", rendered, func(o client.Object) { o.SetAnnotations(map[string]string{"a": "b"}) }, true},
+		{"owned secret labels", owned, func(o client.Object) { o.SetLabels(nil) }, true},
+		{"configmap data", configMap, func(o client.Object) { o.(*corev1.ConfigMap).Data["a"] = "2" }, true},
+		{"configmap binary data", configMap, func(o client.Object) {
+			o.(*corev1.ConfigMap).BinaryData = map[string][]byte{"b": {0}}
+		}, true},
+		{"configmap labels", configMap, func(o client.Object) { o.SetLabels(map[string]string{"app": "web"}) }, true},
+		{"configmap resync", configMap, func(client.Object) {}, false},
+	}
+	for _, tt := range tests {
+		var p predicate.Predicate
+		switch tt.obj.(type) {
+		case *v1alpha1.ConfigMapSecret:
+			p = configMapSecretChanged()
+		case *corev1.Secret:
+			p = r.secretChanged()
+		default:
+			p = configMapChanged()
+		}
+		obj := tt.obj.DeepCopyObject().(client.Object)
+		tt.fn(obj)
+		obj.SetResourceVersion("2")
+		if got := p.Update(event.UpdateEvent{ObjectOld: tt.obj, ObjectNew: obj}); got != tt.want {
+			t.Errorf("%s: unexpected update result; want: %v; got: %v", tt.name, tt.want, got)
+		}
+	}
+}
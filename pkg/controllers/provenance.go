@@ -0,0 +1,114 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// provenanceSuffix is added to the name of a Secret to name its provenance ConfigMap.
+const provenanceSuffix = "-provenance"
+
+// provenanceData returns the data of the provenance ConfigMap of the Secret,
+// which maps each of its keys to a JSON list of its sources.
+func (s *sources) provenanceData(secret *corev1.Secret) (map[string]string, error) {
+	data := make(map[string]string, len(secret.Data))
+	for k := range secret.Data {
+		list := s.KeySources[k]
+		if list == nil {
+			list = []string{}
+		}
+		b, err := json.Marshal(list)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = string(b)
+	}
+	return data, nil
+}
+
+// syncProvenance writes the provenance ConfigMap of the Secret rendered from
+// the ConfigMapSecret, or deletes it if provenance is disabled.
+func (r *ConfigMapSecret) syncProvenance(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, srcs *sources, secret *corev1.Secret) error {
+	if !cms.Spec.Provenance {
+		return r.deleteProvenance(ctx, log, cms, secret.Name)
+	}
+	if srcs.KeySources == nil { // Not rendered, e.g. due to an error.
+		return nil
+	}
+	data, err := srcs.provenanceData(secret)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name + provenanceSuffix}
+	cmLog := log.WithValues("configMap", key)
+
+	found := &corev1.ConfigMap{}
+	if err := r.client.Get(ctx, key, found); err != nil {
+		if !apierrors.IsNotFound(err) {
+			cmLog.Error(err, "Unable to get provenance ConfigMap")
+			return err
+		}
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       data,
+		}
+		if err := controllerutil.SetControllerReference(cms, cm, r.scheme); err != nil {
+			return err
+		}
+		cmLog.Info("Creating provenance ConfigMap")
+		if err := r.client.Create(ctx, cm); err != nil {
+			cmLog.Error(err, "Unable to create provenance ConfigMap")
+			return err
+		}
+		return nil
+	}
+	if !metav1.IsControlledBy(found, cms) {
+		return fmt.Errorf("provenance ConfigMap %s isn't controlled by the ConfigMapSecret", key)
+	}
+	if reflect.DeepEqual(found.Data, data) {
+		return nil
+	}
+	found.Data = data
+	cmLog.Info("Updating provenance ConfigMap")
+	if err := r.client.Update(ctx, found); err != nil {
+		cmLog.Error(err, "Unable to update provenance ConfigMap")
+		return err
+	}
+	return nil
+}
+
+// deleteProvenance deletes the provenance ConfigMap of the Secret, if it
+// exists and is controlled by the ConfigMapSecret.
+func (r *ConfigMapSecret) deleteProvenance(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, secretName string) error {
+	key := types.NamespacedName{Namespace: cms.Namespace, Name: secretName + provenanceSuffix}
+	found := &corev1.ConfigMap{}
+	if err := r.client.Get(ctx, key, found); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !metav1.IsControlledBy(found, cms) {
+		return nil
+	}
+	log.Info("Deleting provenance ConfigMap", "configMap", key)
+	if err := r.client.Delete(ctx, found); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Unable to delete provenance ConfigMap", "configMap", key)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestProvenance(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/provenance.input.yaml")
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	srcs := newSources()
+	secret, _, err := r.renderSecret(context.Background(), cms, srcs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := srcs.provenanceData(secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"dsn":       `["ConfigMap/db[HOST]","ConfigMap/db[USER]","Secret/db-credentials[password]"]`,
+		"host":      `["ConfigMap/db[HOST]"]`,
+		"namespace": `[]`,
+		"static":    `[]`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected provenance (-want +got):\n%s", diff)
+	}
+
+	// Go templates may use any variable.
+	cms.Spec.TemplateVersion = v1alpha1.TemplateVersionV2
+	cms.Spec.Template.Data = map[string]string{"host": `{{ index . "DB_HOST" }}`}
+	cms.Spec.Template.StringData = nil
+	srcs = newSources()
+	if secret, _, err = r.renderSecret(context.Background(), cms, srcs, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err = srcs.provenanceData(secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = map[string]string{
+		"host": `["ConfigMap/db[HOST]","ConfigMap/db[USER]","Secret/db-credentials[password]"]`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected provenance (-want +got):\n%s", diff)
+	}
+
+	// Provenance isn't recorded unless it's enabled.
+	cms.Spec.Provenance = false
+	srcs = newSources()
+	if _, _, err = r.renderSecret(context.Background(), cms, srcs, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if srcs.KeySources != nil {
+		t.Errorf("unexpected key sources: %v", srcs.KeySources)
+	}
+}
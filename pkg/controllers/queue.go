@@ -0,0 +1,152 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Triggers are the kinds of objects whose events enqueue ConfigMapSecrets.
+const (
+	configMapSecretTrigger    = "ConfigMapSecret"
+	configMapTrigger          = "ConfigMap"
+	secretTrigger             = "Secret"
+	clusterTrustBundleTrigger = "ClusterTrustBundle"
+)
+
+var (
+	enqueuedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "configmapsecret_controller_enqueued_requests_total",
+		Help: "Total number of ConfigMapSecret reconcile requests enqueued by events, by the kind of object that triggered them.",
+	}, []string{"trigger"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "configmapsecret_controller_queue_depth",
+		Help: "Current number of pending ConfigMapSecret reconcile requests, by the kind of object that triggered them.",
+	}, []string{"trigger"})
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "configmapsecret_controller_queue_latency_seconds",
+		Help:    "How long ConfigMapSecret reconcile requests wait in the queue, by the kind of object that triggered them.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10), // Same as the controller-runtime workqueue metrics.
+	}, []string{"trigger"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(enqueuedRequests, queueDepth, queueLatency)
+}
+
+// A queueTracker attributes reconcile requests to the kind of object whose
+// event enqueued them. The workqueue deduplicates pending requests, so only
+// the first trigger of a pending request is attributed its depth and latency.
+type queueTracker struct {
+	mu      sync.Mutex
+	pending map[types.NamespacedName]pendingRequest
+}
+
+type pendingRequest struct {
+	trigger string
+	time    time.Time
+}
+
+// add records that the request was enqueued by the trigger.
+func (t *queueTracker) add(key types.NamespacedName, trigger string) {
+	enqueuedRequests.WithLabelValues(trigger).Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[key]; ok {
+		return
+	}
+	if t.pending == nil {
+		t.pending = make(map[types.NamespacedName]pendingRequest)
+	}
+	t.pending[key] = pendingRequest{trigger: trigger, time: time.Now()}
+	queueDepth.WithLabelValues(trigger).Inc()
+}
+
+// start records that the request was dequeued for reconciliation.
+func (t *queueTracker) start(key types.NamespacedName) {
+	t.mu.Lock()
+	p, ok := t.pending[key]
+	delete(t.pending, key)
+	t.mu.Unlock()
+
+	if !ok {
+		return // Requeued by the controller.
+	}
+	queueDepth.WithLabelValues(p.trigger).Dec()
+	queueLatency.WithLabelValues(p.trigger).Observe(time.Since(p.time).Seconds())
+}
+
+// predicate returns a predicate that attributes the events of ConfigMapSecrets,
+// which must be the last of the predicates of their watch.
+func (t *queueTracker) predicate() predicate.Predicate {
+	add := func(obj client.Object) bool {
+		t.add(types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, configMapSecretTrigger)
+		return true
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return add(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return add(e.ObjectNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return add(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return add(e.Object) },
+	}
+}
+
+// handler returns an event handler that attributes the requests added by h
+// to the trigger.
+func (t *queueTracker) handler(trigger string, h handler.EventHandler) handler.EventHandler {
+	return &trackingHandler{EventHandler: h, tracker: t, trigger: trigger}
+}
+
+type trackingHandler struct {
+	handler.EventHandler
+	tracker *queueTracker
+	trigger string
+}
+
+func (h *trackingHandler) queue(q workqueue.RateLimitingInterface) workqueue.RateLimitingInterface {
+	return &trackingQueue{RateLimitingInterface: q, tracker: h.tracker, trigger: h.trigger}
+}
+
+func (h *trackingHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Create(e, h.queue(q))
+}
+
+func (h *trackingHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Update(e, h.queue(q))
+}
+
+func (h *trackingHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Delete(e, h.queue(q))
+}
+
+func (h *trackingHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Generic(e, h.queue(q))
+}
+
+type trackingQueue struct {
+	workqueue.RateLimitingInterface
+	tracker *queueTracker
+	trigger string
+}
+
+func (q *trackingQueue) Add(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		q.tracker.add(req.NamespacedName, q.trigger)
+	}
+	q.RateLimitingInterface.Add(item)
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func metricValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	m := &dto.Metric{}
+	if err := (<-ch).Write(m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Histogram != nil:
+		return float64(m.Histogram.GetSampleCount())
+	}
+	t.Fatalf("unexpected metric: %v", m)
+	return 0
+}
+
+func TestQueueTracker(t *testing.T) {
+	var tracker queueTracker
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	h := tracker.handler(configMapTrigger, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: "app"}}}
+	}))
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "queue-test", Name: "config"}}
+
+	enqueued := metricValue(t, enqueuedRequests.WithLabelValues(configMapTrigger))
+	latencies := metricValue(t, queueLatency.WithLabelValues(configMapTrigger).(prometheus.Histogram))
+	depth := queueDepth.WithLabelValues(configMapTrigger)
+
+	h.Create(event.CreateEvent{Object: cm}, q)
+	h.Update(event.UpdateEvent{ObjectOld: cm, ObjectNew: cm}, q)
+	if got, want := metricValue(t, enqueuedRequests.WithLabelValues(configMapTrigger)), enqueued+2; got != want {
+		t.Errorf("unexpected enqueued requests: want: %v; got: %v", want, got)
+	}
+	if got := metricValue(t, depth); got != 1 {
+		t.Errorf("unexpected queue depth: want: 1; got: %v", got)
+	}
+	if n := q.Len(); n != 1 {
+		t.Errorf("unexpected queue length: want: 1; got: %d", n)
+	}
+
+	tracker.start(types.NamespacedName{Namespace: "queue-test", Name: "app"})
+	if got := metricValue(t, depth); got != 0 {
+		t.Errorf("unexpected queue depth: want: 0; got: %v", got)
+	}
+	if got, want := metricValue(t, queueLatency.WithLabelValues(configMapTrigger).(prometheus.Histogram)), latencies+1; got != want {
+		t.Errorf("unexpected latency samples: want: %v; got: %v", want, got)
+	}
+
+	// Requeues by the controller aren't attributed.
+	tracker.start(types.NamespacedName{Namespace: "queue-test", Name: "app"})
+	if got := metricValue(t, depth); got != 0 {
+		t.Errorf("unexpected queue depth: want: 0; got: %v", got)
+	}
+}
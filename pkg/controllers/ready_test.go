@@ -0,0 +1,97 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// waitForReady waits for the Ready condition of the ConfigMapSecret to have
+// the status, as `kubectl wait --for=condition=Ready` does: it's only
+// satisfied by a status observing the current generation.
+func waitForReady(ctx context.Context, t *testing.T, r *testReconciler, key types.NamespacedName, status corev1.ConditionStatus) *v1alpha1.ConfigMapSecretCondition {
+	var cond *v1alpha1.ConfigMapSecretCondition
+	eventually(t, timeout, r.wait(key), func(t T) {
+		cms := &v1alpha1.ConfigMapSecret{}
+		if err := r.api.Get(ctx, key, cms); err != nil {
+			t.Fatalf("failed to get ConfigMapSecret: %v", err)
+		}
+		if gen, obs := cms.Generation, cms.Status.ObservedGeneration; gen != obs {
+			t.Fatalf("ObservedGeneration doesn't match Generation; %d != %d", obs, gen)
+		}
+		cond = GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretReady)
+		if cond == nil {
+			t.Fatalf("missing condition: %q", v1alpha1.ConfigMapSecretReady)
+		}
+		if cond.Status != status {
+			t.Fatalf("unexpected ready status; want: %q; got: %q", status, cond.Status)
+		}
+	})
+	return cond
+}
+
+func TestReadyCondition(t *testing.T) {
+	r := newTestReconciler(t)
+	defer r.close(t)
+
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "ready"}
+	if err := r.api.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ready-vars"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}); err != nil {
+		t.Fatalf("failed to create Secret: %v", err)
+	}
+	if err := r.api.Create(ctx, &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Data: map[string]string{"password": "$(PASSWORD)"},
+			},
+			Vars: []v1alpha1.Var{{
+				Name: "PASSWORD",
+				SecretValue: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "ready-vars"},
+					Key:                  "password",
+				},
+			}},
+		},
+	}); err != nil {
+		t.Fatalf("failed to create ConfigMapSecret: %v", err)
+	}
+	waitForReady(ctx, t, r, key, corev1.ConditionTrue)
+	secret := &corev1.Secret{}
+	if err := r.api.Get(ctx, key, secret); err != nil {
+		t.Fatalf("failed to get Secret: %v", err)
+	}
+	if want, got := "hunter2", string(secret.Data["password"]); want != got {
+		t.Fatalf("unexpected data; want: %q; got: %q", want, got)
+	}
+
+	// Referencing a missing key makes it unready with the reason of the failure.
+	updateConfigMapSecretStep(key, func(obj *v1alpha1.ConfigMapSecret) {
+		obj.Spec.Vars[0].SecretValue.Key = "missing"
+	})(ctx, t, r)
+	cond := waitForReady(ctx, t, r, key, corev1.ConditionFalse)
+	if want, got := v1alpha1.CreateVariablesErrorReason, cond.Reason; want != got {
+		t.Errorf("unexpected ready reason; want: %q; got: %q", want, got)
+	}
+	unready := cond.LastTransitionTime
+
+	// Fixing it makes it ready again.
+	updateConfigMapSecretStep(key, func(obj *v1alpha1.ConfigMapSecret) {
+		obj.Spec.Vars[0].SecretValue.Key = "password"
+	})(ctx, t, r)
+	cond = waitForReady(ctx, t, r, key, corev1.ConditionTrue)
+	if cond.LastTransitionTime.Before(&unready) {
+		t.Errorf("unexpected ready transition time; %v before %v", cond.LastTransitionTime, unready)
+	}
+}
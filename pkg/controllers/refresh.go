@@ -0,0 +1,59 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// refreshInterval returns the interval at which the ConfigMapSecret is
+// re-rendered, lengthened to the minimum, or zero if it isn't. The interval
+// of a GitSource applies if it's shorter than the RefreshInterval.
+func refreshInterval(cms *v1alpha1.ConfigMapSecret) time.Duration {
+	var interval time.Duration
+	if cms.Spec.RefreshInterval != nil {
+		interval = v1alpha1.MinRefreshInterval
+		if d := cms.Spec.RefreshInterval.Duration; d > interval {
+			interval = d
+		}
+	}
+	if gs := cms.Spec.GitSource; gs != nil && (interval == 0 || gs.SyncInterval() < interval) {
+		interval = gs.SyncInterval()
+	}
+	return interval
+}
+
+// nextRenderTime returns the time at which the ConfigMapSecret, rendered at
+// now, is next re-rendered, or nil if it has no refresh interval. A pending
+// refresh is kept, so that reconciling for other reasons, such as updating
+// the status, doesn't postpone it indefinitely.
+func nextRenderTime(cms *v1alpha1.ConfigMapSecret, now time.Time) *metav1.Time {
+	interval := refreshInterval(cms)
+	if interval == 0 {
+		return nil
+	}
+	if next := cms.Status.NextRenderTime; next != nil && next.After(now) && !next.After(now.Add(interval)) {
+		return next.DeepCopy()
+	}
+	next := metav1.NewTime(now.Add(interval)).Rfc3339Copy()
+	return &next
+}
+
+// refreshResult returns the result of successfully syncing the ConfigMapSecret,
+// which requeues it at its next render time, if any.
+func refreshResult(cms *v1alpha1.ConfigMapSecret) reconcile.Result {
+	next := cms.Status.NextRenderTime
+	if next == nil {
+		return reconcile.Result{}
+	}
+	if d := time.Until(next.Time); d > 0 {
+		return reconcile.Result{RequeueAfter: d}
+	}
+	return reconcile.Result{Requeue: true}
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextRenderTime(t *testing.T) {
+	now := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(d))
+		return &t
+	}
+	tests := []struct {
+		name     string
+		interval *metav1.Duration
+		git      *v1alpha1.GitSource
+		next     *metav1.Time
+		want     *metav1.Time
+	}{
+		{
+			name: "disabled",
+			next: at(time.Minute),
+			want: nil,
+		},
+		{
+			name:     "first",
+			interval: &metav1.Duration{Duration: time.Hour},
+			want:     at(time.Hour),
+		},
+		{
+			name:     "pending",
+			interval: &metav1.Duration{Duration: time.Hour},
+			next:     at(10 * time.Minute),
+			want:     at(10 * time.Minute),
+		},
+		{
+			name:     "due",
+			interval: &metav1.Duration{Duration: time.Hour},
+			next:     at(0),
+			want:     at(time.Hour),
+		},
+		{
+			name:     "shortened",
+			interval: &metav1.Duration{Duration: time.Hour},
+			next:     at(2 * time.Hour),
+			want:     at(time.Hour),
+		},
+		{
+			name:     "too short",
+			interval: &metav1.Duration{Duration: time.Second},
+			want:     at(v1alpha1.MinRefreshInterval),
+		},
+		{
+			name: "git source",
+			git:  &v1alpha1.GitSource{Repository: "https://example.com/config.git"},
+			want: at(v1alpha1.DefaultGitSourceInterval),
+		},
+		{
+			name:     "shorter git source",
+			interval: &metav1.Duration{Duration: time.Hour},
+			git:      &v1alpha1.GitSource{Interval: &metav1.Duration{Duration: 2 * time.Minute}},
+			want:     at(2 * time.Minute),
+		},
+		{
+			name:     "longer git source",
+			interval: &metav1.Duration{Duration: time.Hour},
+			git:      &v1alpha1.GitSource{Interval: &metav1.Duration{Duration: 2 * time.Hour}},
+			want:     at(time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		cms := &v1alpha1.ConfigMapSecret{
+			Spec:   v1alpha1.ConfigMapSecretSpec{RefreshInterval: tt.interval, GitSource: tt.git},
+			Status: v1alpha1.ConfigMapSecretStatus{NextRenderTime: tt.next},
+		}
+		got := nextRenderTime(cms, now)
+		if (got == nil) != (tt.want == nil) || got != nil && !got.Equal(tt.want) {
+			t.Errorf("%s: unexpected next render time; want: %v; got: %v", tt.name, tt.want, got)
+		}
+	}
+
+	cms := &v1alpha1.ConfigMapSecret{}
+	if res := refreshResult(cms); res.Requeue || res.RequeueAfter != 0 {
+		t.Errorf("unexpected requeue without refresh interval: %+v", res)
+	}
+	next := metav1.NewTime(time.Now().Add(time.Hour))
+	cms.Status.NextRenderTime = &next
+	if res := refreshResult(cms); res.RequeueAfter <= 0 || res.RequeueAfter > time.Hour {
+		t.Errorf("unexpected requeue: %+v", res)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// ReportDataKey is the key of the JSON-encoded NamespaceReport in a report ConfigMap.
+const ReportDataKey = "report.json"
+
+// A NamespaceReport summarizes the ConfigMapSecrets of a namespace and what
+// the controller did with them over a rolling window, e.g. for tenants'
+// dashboards without access to metrics.
+type NamespaceReport struct {
+	// Window is the duration over which failures and drift corrections are reported.
+	Window metav1.Duration `json:"window"`
+	// ConfigMapSecrets is the number of ConfigMapSecrets in the namespace.
+	ConfigMapSecrets int `json:"configMapSecrets"`
+	// Ready is the number of them that are ready.
+	Ready int `json:"ready"`
+	// Failures are the render failures of each ConfigMapSecret in the window.
+	Failures []ReportFailure `json:"failures"`
+	// DriftCorrections are the Secrets that were modified by something other
+	// than the controller and restored in the window.
+	DriftCorrections []ReportDriftCorrection `json:"driftCorrections"`
+}
+
+// A ReportFailure describes the render failures of a ConfigMapSecret.
+type ReportFailure struct {
+	// Name is the name of the ConfigMapSecret.
+	Name string `json:"name"`
+	// Count is the number of failures.
+	Count int `json:"count"`
+	// Reason is the reason of the last failure.
+	Reason v1alpha1.ConfigMapSecretConditionReason `json:"reason"`
+	// Message is the message of the last failure.
+	Message string `json:"message"`
+	// LastTime is the time of the last failure.
+	LastTime metav1.Time `json:"lastTime"`
+}
+
+// A ReportDriftCorrection describes the corrections of a drifted Secret.
+type ReportDriftCorrection struct {
+	// Name is the name of the ConfigMapSecret.
+	Name string `json:"name"`
+	// Secret is the name of the Secret.
+	Secret string `json:"secret"`
+	// Count is the number of corrections.
+	Count int `json:"count"`
+	// LastTime is the time of the last correction.
+	LastTime metav1.Time `json:"lastTime"`
+}
+
+// A reportEvent is a render failure or a drift correction.
+type reportEvent struct {
+	time    time.Time
+	reason  v1alpha1.ConfigMapSecretConditionReason
+	message string
+	secret  string
+}
+
+// A reporter records the render failures and drift corrections of
+// ConfigMapSecrets for a rolling window, from which it makes reports.
+//
+// A nil *reporter records nothing.
+type reporter struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	failures map[types.NamespacedName][]reportEvent // ConfigMapSecret -> events
+	drifts   map[types.NamespacedName][]reportEvent // ConfigMapSecret -> events
+	written  map[types.NamespacedName]string        // Secret -> hash of the data last written
+	reported map[string]bool                        // namespaces with a report
+}
+
+func newReporter(window time.Duration) *reporter {
+	return &reporter{
+		window:   window,
+		failures: make(map[types.NamespacedName][]reportEvent),
+		drifts:   make(map[types.NamespacedName][]reportEvent),
+		written:  make(map[types.NamespacedName]string),
+		reported: make(map[string]bool),
+	}
+}
+
+// failed records a render failure of the ConfigMapSecret.
+func (r *reporter) failed(key types.NamespacedName, reason v1alpha1.ConfigMapSecretConditionReason, message string, now time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[key] = append(r.failures[key], reportEvent{time: now, reason: reason, message: message})
+}
+
+// wrote records the data written to the Secret.
+func (r *reporter) wrote(secret types.NamespacedName, data map[string][]byte) {
+	if r == nil {
+		return
+	}
+	hash := dataHash(data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written[secret] = hash
+}
+
+// updating records a drift correction of the Secret of the ConfigMapSecret if
+// the rendered data is the data last written to it, but its current data isn't,
+// i.e. it was modified by something other than the controller.
+func (r *reporter) updating(key, secret types.NamespacedName, cur, rendered map[string][]byte, now time.Time) {
+	if r == nil {
+		return
+	}
+	hash := dataHash(rendered)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, ok := r.written[secret]; !ok || last != hash || dataHash(cur) == hash {
+		return
+	}
+	r.drifts[key] = append(r.drifts[key], reportEvent{time: now, secret: secret.Name})
+}
+
+// forget removes the records of a deleted ConfigMapSecret.
+func (r *reporter) forget(key types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, key)
+	delete(r.drifts, key)
+}
+
+// reports returns the reports of the namespaces with ConfigMapSecrets, with
+// events in the window, or with a previous report, by namespace. Records of
+// Secrets that aren't rendered by the ConfigMapSecrets, whose names are
+// returned by secretName, are dropped.
+func (r *reporter) reports(items []v1alpha1.ConfigMapSecret, secretName func(*v1alpha1.ConfigMapSecret) string, now time.Time) map[string]*NamespaceReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rendered := make(map[types.NamespacedName]bool, len(items))
+	for i := range items {
+		rendered[types.NamespacedName{Namespace: items[i].Namespace, Name: secretName(&items[i])}] = true
+	}
+	for secret := range r.written {
+		if !rendered[secret] {
+			delete(r.written, secret)
+		}
+	}
+
+	reports := make(map[string]*NamespaceReport)
+	get := func(namespace string) *NamespaceReport {
+		rep, ok := reports[namespace]
+		if !ok {
+			rep = &NamespaceReport{
+				Window:           metav1.Duration{Duration: r.window},
+				Failures:         []ReportFailure{},
+				DriftCorrections: []ReportDriftCorrection{},
+			}
+			reports[namespace] = rep
+		}
+		return rep
+	}
+	for namespace := range r.reported {
+		get(namespace)
+	}
+	for i := range items {
+		rep := get(items[i].Namespace)
+		rep.ConfigMapSecrets++
+		if cond := GetConfigMapSecretCondition(items[i].Status, v1alpha1.ConfigMapSecretReady); cond != nil && cond.Status == corev1.ConditionTrue {
+			rep.Ready++
+		}
+	}
+	start := now.Add(-r.window)
+	for key, events := range r.failures {
+		if events = pruneEvents(events, start); len(events) == 0 {
+			delete(r.failures, key)
+			continue
+		}
+		r.failures[key] = events
+		last := events[len(events)-1]
+		rep := get(key.Namespace)
+		rep.Failures = append(rep.Failures, ReportFailure{
+			Name:     key.Name,
+			Count:    len(events),
+			Reason:   last.reason,
+			Message:  last.message,
+			LastTime: metav1.NewTime(last.time),
+		})
+	}
+	for key, events := range r.drifts {
+		if events = pruneEvents(events, start); len(events) == 0 {
+			delete(r.drifts, key)
+			continue
+		}
+		r.drifts[key] = events
+		last := events[len(events)-1]
+		rep := get(key.Namespace)
+		rep.DriftCorrections = append(rep.DriftCorrections, ReportDriftCorrection{
+			Name:     key.Name,
+			Secret:   last.secret,
+			Count:    len(events),
+			LastTime: metav1.NewTime(last.time),
+		})
+	}
+	for _, rep := range reports {
+		sort.Slice(rep.Failures, func(i, j int) bool { return rep.Failures[i].Name < rep.Failures[j].Name })
+		sort.Slice(rep.DriftCorrections, func(i, j int) bool { return rep.DriftCorrections[i].Name < rep.DriftCorrections[j].Name })
+	}
+	return reports
+}
+
+// reportedNamespace records whether the namespace has a report.
+func (r *reporter) reportedNamespace(namespace string, reported bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reported {
+		r.reported[namespace] = true
+	} else {
+		delete(r.reported, namespace)
+	}
+}
+
+// pruneEvents returns the events at or after start, which are sorted by time.
+func pruneEvents(events []reportEvent, start time.Time) []reportEvent {
+	i := sort.Search(len(events), func(i int) bool { return !events[i].time.Before(start) })
+	return events[i:]
+}
+
+// isEmpty reports whether the report has nothing to report.
+func (rep *NamespaceReport) isEmpty() bool {
+	return rep.ConfigMapSecrets == 0 && len(rep.Failures) == 0 && len(rep.DriftCorrections) == 0
+}
+
+func (r *ConfigMapSecret) setupReport(mgr manager.Manager) error {
+	window := r.ReportWindow
+	if window <= 0 {
+		window = time.Hour
+	}
+	interval := r.ReportInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	r.report = newReporter(window)
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return nil
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := r.writeReports(ctx, time.Now()); err != nil {
+				r.logger.Error(err, "Unable to write reports")
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}))
+}
+
+// writeReports writes the report ConfigMap of each namespace whose report
+// changed.
+func (r *ConfigMapSecret) writeReports(ctx context.Context, now time.Time) error {
+	list := &v1alpha1.ConfigMapSecretList{}
+	if err := r.client.List(ctx, list); err != nil {
+		return err
+	}
+	reports := r.report.reports(list.Items, r.secretName, now)
+	namespaces := make([]string, 0, len(reports))
+	for namespace := range reports {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	var firstErr error
+	for _, namespace := range namespaces {
+		rep := reports[namespace]
+		if err := r.writeReport(ctx, namespace, rep); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// An empty report is written once, replacing the previous one.
+		r.report.reportedNamespace(namespace, !rep.isEmpty())
+	}
+	return firstErr
+}
+
+// writeReport writes the report to the report ConfigMap of the namespace, if
+// it changed.
+func (r *ConfigMapSecret) writeReport(ctx context.Context, namespace string, rep *NamespaceReport) error {
+	buf, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	data := map[string]string{ReportDataKey: string(buf)}
+	key := types.NamespacedName{Namespace: namespace, Name: r.ReportConfigMap}
+	log := r.logger.WithValues("configMap", key)
+
+	found := &corev1.ConfigMap{}
+	if err := r.client.Get(ctx, key, found); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if rep.isEmpty() {
+			return nil
+		}
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       data,
+		}
+		log.V(1).Info("Creating report ConfigMap")
+		return r.client.Create(ctx, cm)
+	}
+	if found.Data[ReportDataKey] == data[ReportDataKey] {
+		return nil
+	}
+	found.Data = data
+	log.V(1).Info("Updating report ConfigMap")
+	return r.client.Update(ctx, found)
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReporter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	rep := newReporter(time.Hour)
+	db := types.NamespacedName{Namespace: "default", Name: "db"}
+	api := types.NamespacedName{Namespace: "default", Name: "api"}
+	secret := types.NamespacedName{Namespace: "default", Name: "db-secret"}
+
+	items := []v1alpha1.ConfigMapSecret{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}, Status: v1alpha1.ConfigMapSecretStatus{
+			Conditions: []v1alpha1.ConfigMapSecretCondition{{Type: v1alpha1.ConfigMapSecretReady, Status: corev1.ConditionTrue}},
+		}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"}},
+	}
+	secretName := func(cms *v1alpha1.ConfigMapSecret) string { return cms.Name + "-secret" }
+
+	rendered := map[string][]byte{"password": []byte("hunter2")}
+	rep.updating(db, secret, nil, rendered, now) // Not written by the controller.
+	rep.wrote(secret, rendered)
+	rep.updating(db, secret, rendered, rendered, now) // Not modified.
+	rep.updating(db, secret, map[string][]byte{"password": []byte("edited")}, rendered, now.Add(-30*time.Minute))
+	rep.updating(db, secret, nil, map[string][]byte{"password": []byte("rotated")}, now) // Rendered data changed.
+
+	rep.failed(api, v1alpha1.TemplateErrorReason, "old", now.Add(-2*time.Hour))
+	rep.failed(api, v1alpha1.CreateVariablesErrorReason, "missing", now.Add(-10*time.Minute))
+	rep.failed(api, v1alpha1.TemplateErrorReason, "bad template", now.Add(-time.Minute))
+
+	want := map[string]*NamespaceReport{
+		"default": {
+			Window:           metav1.Duration{Duration: time.Hour},
+			ConfigMapSecrets: 2,
+			Ready:            1,
+			Failures: []ReportFailure{{
+				Name:     "api",
+				Count:    2,
+				Reason:   v1alpha1.TemplateErrorReason,
+				Message:  "bad template",
+				LastTime: metav1.NewTime(now.Add(-time.Minute)),
+			}},
+			DriftCorrections: []ReportDriftCorrection{{
+				Name:     "db",
+				Secret:   "db-secret",
+				Count:    1,
+				LastTime: metav1.NewTime(now.Add(-30 * time.Minute)),
+			}},
+		},
+	}
+	if diff := cmp.Diff(want, rep.reports(items, secretName, now)); diff != "" {
+		t.Errorf("unexpected reports (-want +got):\n%s", diff)
+	}
+
+	// Events age out of the window, and the reports of namespaces that had
+	// one are kept until they're written empty.
+	rep.forget(db)
+	rep.reportedNamespace("default", true)
+	later := now.Add(2 * time.Hour)
+	got := rep.reports(nil, secretName, later)
+	if r := got["default"]; r == nil || !r.isEmpty() {
+		t.Errorf("expected an empty report of a reported namespace, got: %+v", r)
+	}
+	if len(rep.failures) != 0 || len(rep.drifts) != 0 || len(rep.written) != 0 {
+		t.Errorf("unexpected records after the window: %d failures, %d drifts, %d written", len(rep.failures), len(rep.drifts), len(rep.written))
+	}
+	rep.reportedNamespace("default", false)
+	if got := rep.reports(nil, secretName, later); len(got) != 0 {
+		t.Errorf("unexpected reports: %v", got)
+	}
+
+	var disabled *reporter
+	disabled.failed(api, v1alpha1.TemplateErrorReason, "", now)
+	disabled.wrote(secret, rendered)
+	disabled.updating(db, secret, nil, rendered, now)
+	disabled.forget(db)
+}
@@ -0,0 +1,366 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build faultinject
+// +build faultinject
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	"github.com/machinezone/configmapsecrets/pkg/faultinject"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// memKey is the key of an object in a memClient.
+type memKey struct {
+	typ reflect.Type
+	key types.NamespacedName
+}
+
+// A memClient stores objects in memory like the API server: writes conflict
+// if their resourceVersion isn't current, the generation of ConfigMapSecrets
+// changes with their spec, and status is only written through the status
+// subresource. Changes are passed to onChange, like a watch.
+type memClient struct {
+	client.Client
+
+	mu       sync.Mutex
+	objs     map[memKey]client.Object
+	version  int
+	onChange func(obj client.Object, deleted bool)
+}
+
+func newMemClient() *memClient {
+	return &memClient{objs: make(map[memKey]client.Object)}
+}
+
+func memKeyOf(obj client.Object) memKey {
+	return memKey{
+		typ: reflect.TypeOf(obj),
+		key: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()},
+	}
+}
+
+func memResource(obj client.Object) schema.GroupResource {
+	return schema.GroupResource{Resource: strings.ToLower(reflect.TypeOf(obj).Elem().Name()) + "s"}
+}
+
+// copyObject copies src into dst, which must have the same type.
+func copyObject(dst, src client.Object) {
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src.DeepCopyObject()).Elem())
+}
+
+func (c *memClient) Scheme() *runtime.Scheme { return scheme }
+
+func (c *memClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.objs[memKey{typ: reflect.TypeOf(obj), key: key}]
+	if !ok {
+		return apierrors.NewNotFound(memResource(obj), key.Name)
+	}
+	copyObject(obj, stored)
+	return nil
+}
+
+func (c *memClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.mu.Lock()
+	k := memKeyOf(obj)
+	if _, ok := c.objs[k]; ok {
+		c.mu.Unlock()
+		return apierrors.NewAlreadyExists(memResource(obj), obj.GetName())
+	}
+	c.version++
+	obj.SetResourceVersion(fmt.Sprint(c.version))
+	obj.SetUID(types.UID(fmt.Sprintf("uid-%d", c.version)))
+	if _, ok := obj.(*v1alpha1.ConfigMapSecret); ok {
+		obj.SetGeneration(1)
+	}
+	c.objs[k] = obj.DeepCopyObject().(client.Object)
+	c.mu.Unlock()
+
+	c.changed(obj, false)
+	return nil
+}
+
+func (c *memClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.update(obj, false)
+}
+
+func (c *memClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.mu.Lock()
+	k := memKeyOf(obj)
+	stored, ok := c.objs[k]
+	if !ok {
+		c.mu.Unlock()
+		return apierrors.NewNotFound(memResource(obj), obj.GetName())
+	}
+	delete(c.objs, k)
+	c.mu.Unlock()
+
+	c.changed(stored, true)
+	return nil
+}
+
+func (c *memClient) Status() client.StatusWriter {
+	return &memStatusWriter{c}
+}
+
+type memStatusWriter struct {
+	*memClient
+}
+
+func (w *memStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return w.update(obj, true)
+}
+
+// update writes obj, or only its status, if its resourceVersion is current.
+func (c *memClient) update(obj client.Object, status bool) error {
+	c.mu.Lock()
+	k := memKeyOf(obj)
+	stored, ok := c.objs[k]
+	if !ok {
+		c.mu.Unlock()
+		return apierrors.NewNotFound(memResource(obj), obj.GetName())
+	}
+	if obj.GetResourceVersion() != stored.GetResourceVersion() {
+		c.mu.Unlock()
+		return apierrors.NewConflict(memResource(obj), obj.GetName(), fmt.Errorf("resourceVersion %s is stale", obj.GetResourceVersion()))
+	}
+	next := obj.DeepCopyObject().(client.Object)
+	if cms, ok := next.(*v1alpha1.ConfigMapSecret); ok {
+		prev := stored.(*v1alpha1.ConfigMapSecret)
+		if status {
+			status := cms.Status
+			cms = prev.DeepCopy()
+			cms.Status = status
+			next = cms
+		} else {
+			cms.Status = prev.Status
+			if !reflect.DeepEqual(cms.Spec, prev.Spec) {
+				cms.Generation = prev.Generation + 1
+			}
+		}
+	}
+	c.version++
+	next.SetResourceVersion(fmt.Sprint(c.version))
+	c.objs[k] = next
+	copyObject(obj, next)
+	c.mu.Unlock()
+
+	c.changed(next, false)
+	return nil
+}
+
+func (c *memClient) changed(obj client.Object, deleted bool) {
+	if c.onChange != nil {
+		c.onChange(obj.DeepCopyObject().(client.Object), deleted)
+	}
+}
+
+// A resilienceHarness reconciles the ConfigMapSecrets in a memClient through
+// a faultinject.Client, with a work queue fed by their changes.
+type resilienceHarness struct {
+	t      *testing.T
+	ctx    context.Context
+	api    *memClient
+	faults *faultinject.Client
+	r      *ConfigMapSecret
+	q      workqueue.RateLimitingInterface
+}
+
+func newResilienceHarness(t *testing.T, rates faultinject.Rates, seed int64) *resilienceHarness {
+	h := &resilienceHarness{
+		t:   t,
+		ctx: context.Background(),
+		api: newMemClient(),
+		q:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+	h.faults = faultinject.New(h.api, rates, seed)
+	h.r = &ConfigMapSecret{
+		client:   h.faults,
+		scheme:   scheme,
+		logger:   logr.Discard(),
+		recorder: &record.FakeRecorder{},
+	}
+	configMapHandler := h.r.configMapEventHandler()
+	h.api.onChange = func(obj client.Object, deleted bool) {
+		switch obj := obj.(type) {
+		case *v1alpha1.ConfigMapSecret:
+			h.q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}})
+		case *corev1.Secret:
+			h.r.secretEventHandler(h.ctx, h.q, obj, deleted)
+		case *corev1.ConfigMap:
+			if deleted {
+				configMapHandler.Delete(event.DeleteEvent{Object: obj}, h.q)
+			} else {
+				configMapHandler.Update(event.UpdateEvent{ObjectOld: obj, ObjectNew: obj}, h.q)
+			}
+		}
+	}
+	t.Cleanup(h.q.ShutDown)
+	return h
+}
+
+// drain reconciles the queued requests until it's empty, requeueing those
+// that fail or ask to be requeued.
+func (h *resilienceHarness) drain() {
+	h.t.Helper()
+	const max = 10000
+	for i := 0; h.q.Len() > 0; i++ {
+		if i == max {
+			h.t.Fatalf("queue not drained after %d reconciles", max)
+		}
+		item, _ := h.q.Get()
+		req := item.(reconcile.Request)
+		result, err := h.r.Reconcile(h.ctx, req)
+		h.q.Done(item)
+		if err != nil || result.Requeue || result.RequeueAfter > 0 {
+			h.q.Add(req)
+		}
+	}
+}
+
+// resync disables faults, enqueues every ConfigMapSecret, and drains the queue,
+// as a resync does after faults stop.
+func (h *resilienceHarness) resync() {
+	h.t.Helper()
+	h.faults.SetRates(faultinject.Rates{})
+	h.api.mu.Lock()
+	for k := range h.api.objs {
+		if k.typ == reflect.TypeOf(&v1alpha1.ConfigMapSecret{}) {
+			h.q.Add(reconcile.Request{NamespacedName: k.key})
+		}
+	}
+	h.api.mu.Unlock()
+	h.drain()
+}
+
+func (h *resilienceHarness) create(obj client.Object) {
+	h.t.Helper()
+	if err := h.api.Create(h.ctx, obj); err != nil {
+		h.t.Fatalf("failed to create %s: %v", obj.GetName(), err)
+	}
+}
+
+// update applies fn to the current version of the object.
+func (h *resilienceHarness) update(obj client.Object, fn func()) {
+	h.t.Helper()
+	if err := h.api.Get(h.ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		h.t.Fatalf("failed to get %s: %v", obj.GetName(), err)
+	}
+	fn()
+	if err := h.api.Update(h.ctx, obj); err != nil {
+		h.t.Fatalf("failed to update %s: %v", obj.GetName(), err)
+	}
+}
+
+func resilienceConfigMapSecret(name string) *v1alpha1.ConfigMapSecret {
+	return build.NewConfigMapSecret(name).
+		WithNamespace("default").
+		WithDataTemplate("config", name+": $(VALUE)").
+		WithConfigMapVar("VALUE", "vars", "value").
+		ConfigMapSecret()
+
+}
+
+func TestResilience(t *testing.T) {
+	rates := faultinject.Rates{Conflict: 0.1, Timeout: 0.1, NotFound: 0.1}
+	injected := make(map[faultinject.Fault]int)
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			h := newResilienceHarness(t, rates, seed)
+
+			// Sync several ConfigMapSecrets from a shared source.
+			vars := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "vars"},
+				Data:       map[string]string{"value": "v1"},
+			}
+			h.create(vars)
+			names := []string{"a", "b", "c"}
+			for _, name := range names {
+				h.create(resilienceConfigMapSecret(name))
+			}
+			h.drain()
+
+			// Rename the Secret of one, so that the old one is cleaned up.
+			renamed := &v1alpha1.ConfigMapSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+			h.update(renamed, func() {
+				renamed.Spec.Template.Metadata.Name = "a-renamed"
+			})
+			h.drain()
+
+			// Change the source of all of them.
+			h.update(vars, func() {
+				vars.Data["value"] = "v2"
+			})
+			h.drain()
+
+			h.resync()
+			for f, n := range h.faults.Injected() {
+				injected[f] += n
+			}
+
+			secretNames := map[string]string{"a": "a-renamed", "b": "b", "c": "c"}
+			for _, name := range names {
+				cms := &v1alpha1.ConfigMapSecret{}
+				if err := h.api.Get(h.ctx, types.NamespacedName{Namespace: "default", Name: name}, cms); err != nil {
+					t.Fatalf("failed to get ConfigMapSecret: %v", err)
+				}
+				if gen, obs := cms.Generation, cms.Status.ObservedGeneration; gen != obs {
+					t.Errorf("%s: ObservedGeneration doesn't match Generation; %d != %d", name, obs, gen)
+				}
+				if cond := GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretReady); cond == nil || cond.Status != corev1.ConditionTrue {
+					t.Errorf("%s: unexpected ready condition: %+v", name, cond)
+				}
+				if cond := GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretCleanupFailed); cond != nil {
+					t.Errorf("%s: unexpected cleanup condition: %+v", name, cond)
+				}
+
+				secret := &corev1.Secret{}
+				if err := h.api.Get(h.ctx, types.NamespacedName{Namespace: "default", Name: secretNames[name]}, secret); err != nil {
+					t.Fatalf("failed to get Secret: %v", err)
+				}
+				if want, got := name+": v2", string(secret.Data["config"]); want != got {
+					t.Errorf("%s: unexpected data; want: %q; got: %q", name, want, got)
+				}
+				if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != cms.UID {
+					t.Errorf("%s: unexpected owner: %+v", name, owner)
+				}
+			}
+			err := h.api.Get(h.ctx, types.NamespacedName{Namespace: "default", Name: "a"}, &corev1.Secret{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("renamed Secret not cleaned up: %v", err)
+			}
+		})
+	}
+
+	// Every kind of fault was survived.
+	for _, f := range []faultinject.Fault{faultinject.Conflict, faultinject.Timeout, faultinject.NotFound} {
+		if injected[f] == 0 {
+			t.Errorf("no %s faults injected", f)
+		}
+	}
+}
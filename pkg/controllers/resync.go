@@ -0,0 +1,103 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// A ResyncSummary is the outcome of resyncing ConfigMapSecrets. They're
+// identified by their namespace and name, e.g. "default/db".
+type ResyncSummary struct {
+	// Synced are the ConfigMapSecrets that are ready.
+	Synced []string `json:"synced"`
+	// Failed are the messages of the ConfigMapSecrets that aren't ready.
+	Failed map[string]string `json:"failed"`
+}
+
+// Resync reconciles every ConfigMapSecret in the namespace, or in every
+// namespace if it's empty, once, in order of namespace and name, and returns
+// a summary of their outcomes. It's a run-to-completion
+// alternative to SetupWithManager, e.g. for a Job that forces Secrets to be
+// re-rendered during a migration, and doesn't interact with a controller
+// running concurrently. Reconciliations are throttled by limiter, if it isn't
+// nil, so as not to overwhelm the API server.
+func (r *ConfigMapSecret) Resync(
+	ctx context.Context,
+	c client.Client,
+	scheme *runtime.Scheme,
+	logger logr.Logger,
+	recorder record.EventRecorder,
+	namespace string,
+	limiter *rate.Limiter,
+) (*ResyncSummary, error) {
+	r.client = c
+	r.scheme = scheme
+	r.logger = logger.WithName("resync").WithName("ConfigMapSecret")
+	r.recorder = recorder
+	if err := r.detectClusterTrustBundles(c.RESTMapper(), c); err != nil {
+		return nil, err
+	}
+
+	list := &v1alpha1.ConfigMapSecretList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	summary := &ResyncSummary{Synced: []string{}, Failed: make(map[string]string)}
+	for _, key := range resyncOrder(list.Items) {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return summary, err
+			}
+		}
+		name := key.String()
+		if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: key}); err != nil {
+			summary.Failed[name] = err.Error()
+			continue
+		}
+		cms := &v1alpha1.ConfigMapSecret{}
+		if err := c.Get(ctx, key, cms); err != nil {
+			summary.Failed[name] = err.Error()
+			continue
+		}
+		if cond := GetConfigMapSecretCondition(cms.Status, v1alpha1.ConfigMapSecretReady); cond == nil || cond.Status != corev1.ConditionTrue {
+			msg := "not ready"
+			if cond != nil && cond.Message != "" {
+				msg = cond.Message
+			}
+			summary.Failed[name] = msg
+			continue
+		}
+		summary.Synced = append(summary.Synced, name)
+	}
+	return summary, ctx.Err()
+}
+
+// resyncOrder returns the keys of the ConfigMapSecrets, sorted by namespace
+// and name.
+func resyncOrder(items []v1alpha1.ConfigMapSecret) []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(items))
+	for _, cms := range items {
+		keys = append(keys, types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
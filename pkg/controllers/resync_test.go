@@ -0,0 +1,77 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"bursavich.dev/testr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestResync(t *testing.T) {
+	ctx := context.Background()
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const ns = "resync"
+	if err := c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+		t.Fatalf("failed to create Namespace: %v", err)
+	}
+	for _, cms := range []*v1alpha1.ConfigMapSecret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "ok"},
+			Spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{Data: map[string]string{"key": "value"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "missing"},
+			Spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{Data: map[string]string{"key": "$(VALUE)"}},
+				Vars: []v1alpha1.Var{{
+					Name: "VALUE",
+					SecretValue: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "missing"},
+						Key:                  "value",
+					},
+				}},
+			},
+		},
+	} {
+		if err := c.Create(ctx, cms); err != nil {
+			t.Fatalf("failed to create ConfigMapSecret: %v", err)
+		}
+	}
+
+	r := &ConfigMapSecret{}
+	summary, err := r.Resync(ctx, c, scheme, testr.NewLogger(t), record.NewFakeRecorder(10), ns, rate.NewLimiter(rate.Inf, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"resync/ok"}, summary.Synced); diff != "" {
+		t.Errorf("unexpected synced (-want +got):\n%s", diff)
+	}
+	if _, ok := summary.Failed["resync/missing"]; !ok || len(summary.Failed) != 1 {
+		t.Errorf("unexpected failed: %v", summary.Failed)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: ns, Name: "ok"}, secret); err != nil {
+		t.Fatalf("failed to get Secret: %v", err)
+	}
+	if want, got := "value", string(secret.Data["key"]); want != got {
+		t.Errorf("unexpected data; want: %q; got: %q", want, got)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"bursavich.dev/testr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/preview"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// A replica is one of several controllers that share a leader election.
+type replica struct {
+	mgr    manager.Manager
+	cancel func()
+	closed chan struct{}
+	err    error
+
+	mu         sync.Mutex
+	reconciles int
+}
+
+func newReplica(t *testing.T, electionID string) *replica {
+	ctx, cancel := context.WithCancel(context.TODO())
+	mgr, err := manager.New(cfg, manager.Options{
+		Scheme:                        scheme,
+		Logger:                        testr.NewLogger(t),
+		MetricsBindAddress:            "0",
+		LeaderElection:                true,
+		LeaderElectionID:              electionID,
+		LeaderElectionNamespace:       "default",
+		LeaderElectionReleaseOnCancel: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &replica{mgr: mgr, cancel: cancel, closed: make(chan struct{})}
+	rec := ConfigMapSecret{testNotifyFn: func(types.NamespacedName) {
+		r.mu.Lock()
+		r.reconciles++
+		r.mu.Unlock()
+	}}
+	if err := rec.SetupWithManager(mgr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go func() {
+		defer close(r.closed)
+		r.err = mgr.Start(ctx)
+	}()
+	return r
+}
+
+func (r *replica) elected() bool {
+	select {
+	case <-r.mgr.Elected():
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *replica) reconcileCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reconciles
+}
+
+func (r *replica) close(t *testing.T) {
+	r.cancel()
+	<-r.closed
+	if r.err != nil {
+		t.Errorf("unexpected error: %v", r.err)
+	}
+}
+
+// TestActiveActive runs several replicas of the controller and checks that
+// only the leader reconciles, while every replica serves reads.
+func TestActiveActive(t *testing.T) {
+	const n = 3
+	replicas := make([]*replica, n)
+	for i := range replicas {
+		replicas[i] = newReplica(t, "active-active-test")
+		defer replicas[i].close(t)
+	}
+	api, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	retry := make(chan struct{})
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	go func() {
+		for range ticker.C {
+			select {
+			case retry <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	var leader *replica
+	eventually(t, 30*time.Second, retry, func(t T) {
+		var elected []*replica
+		for _, r := range replicas {
+			if r.elected() {
+				elected = append(elected, r)
+			}
+		}
+		if len(elected) != 1 {
+			t.Fatalf("unexpected number of leaders: want: 1; got: %d", len(elected))
+		}
+		leader = elected[0]
+	})
+
+	ctx := context.Background()
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "active-active", Namespace: "default"},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{Data: map[string]string{"user": "$(USER)"}},
+			Vars:     []v1alpha1.Var{{Name: "USER", Value: "admin"}},
+		},
+	}
+	if err := api.Create(ctx, cms); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := types.NamespacedName{Name: "active-active", Namespace: "default"}
+	eventually(t, 10*time.Second, retry, func(t T) {
+		secret := &corev1.Secret{}
+		if err := api.Get(ctx, key, secret); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want, got := "admin", string(secret.Data["user"]); want != got {
+			t.Fatalf("unexpected data: want: %q; got: %q", want, got)
+		}
+	})
+
+	for i, r := range replicas {
+		if r == leader {
+			if r.reconcileCount() == 0 {
+				t.Errorf("replica %d: leader didn't reconcile", i)
+			}
+			continue
+		}
+		if got := r.reconcileCount(); got != 0 {
+			t.Errorf("replica %d: unexpected reconciles by a replica that isn't the leader: %d", i, got)
+		}
+		values, err := preview.Render(ctx, preview.NewRenderer(r.mgr.GetAPIReader()), cms)
+		if err != nil {
+			t.Errorf("replica %d: unexpected render error: %v", i, err)
+			continue
+		}
+		if want, got := "admin", string(values["user"].Data); want != got {
+			t.Errorf("replica %d: unexpected render: want: %q; got: %q", i, want, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// configMapSelectors returns the valid ConfigMap selectors of varsFrom.
+func configMapSelectors(varsFrom []v1alpha1.VarsFromSource) []labels.Selector {
+	var selectors []labels.Selector
+	for _, v := range varsFrom {
+		if v.ConfigMapSelector == nil {
+			continue
+		}
+		if selector, err := renderer.LabelSelector(*v.ConfigMapSelector); err == nil {
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// setSelectors sets the ConfigMap selectors of the named ConfigMapSecret.
+func (r *ConfigMapSecret) setSelectors(namespace, name string, selectors []labels.Selector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(selectors) == 0 {
+		if delete(r.selectors[namespace], name); len(r.selectors[namespace]) == 0 {
+			delete(r.selectors, namespace)
+		}
+		return
+	}
+	if r.selectors == nil {
+		r.selectors = make(map[string]map[string][]labels.Selector)
+	}
+	if r.selectors[namespace] == nil {
+		r.selectors[namespace] = make(map[string][]labels.Selector)
+	}
+	r.selectors[namespace][name] = selectors
+}
+
+// selecting returns the names of the ConfigMapSecrets in the namespace that
+// select ConfigMaps with the given labels. The caller must hold r.mu.
+func (r *ConfigMapSecret) selecting(namespace string, set labels.Set) map[string]bool {
+	var names map[string]bool
+	for name, selectors := range r.selectors[namespace] {
+		for _, selector := range selectors {
+			if selector.Matches(set) {
+				if names == nil {
+					names = make(map[string]bool)
+				}
+				names[name] = true
+				break
+			}
+		}
+	}
+	return names
+}
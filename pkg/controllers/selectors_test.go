@@ -0,0 +1,89 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestConfigMapSelector(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/config-map-selector.input.yaml")
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	srcs := newSources()
+	if _, _, err := r.renderSecret(context.Background(), cms, srcs, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []v1alpha1.VarCollision{{Name: "TIMEOUT", Sources: []string{"ConfigMap/billing", "ConfigMap/orders"}}}
+	if diff := cmp.Diff(want, srcs.varCollisions(cms)); diff != "" {
+		t.Errorf("unexpected collisions (-want +got):\n%s", diff)
+	}
+	var names []string
+	for _, src := range srcs.statuses(cms, metav1.Now()) {
+		names = append(names, src.Kind+"/"+src.Name)
+	}
+	if diff := cmp.Diff([]string{"ConfigMap/billing", "ConfigMap/orders"}, names); diff != "" {
+		t.Errorf("unexpected sources (-want +got):\n%s", diff)
+	}
+
+	cms.Spec.VarsFrom[0].ConfigMapSelector.ConflictPolicy = v1alpha1.ConflictPolicyError
+	_, _, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+	if !isConfigError(err) {
+		t.Fatalf("expected config error; got: %v", err)
+	}
+	if want := "Variable TIMEOUT is defined by both ConfigMaps default/billing and default/orders selected by the VarsFrom selector"; err.Error() != want {
+		t.Errorf("unexpected error; want: %q; got: %q", want, err.Error())
+	}
+
+	cms.Spec.VarsFrom[0].ConfigMapSelector.LabelSelector = metav1.LabelSelector{}
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); !isConfigError(err) {
+		t.Errorf("expected config error for empty selector; got: %v", err)
+	}
+}
+
+func TestSelecting(t *testing.T) {
+	selector := func(s string) labels.Selector {
+		sel, err := labels.Parse(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return sel
+	}
+	r := &ConfigMapSecret{}
+	r.setSelectors("ns", "a", []labels.Selector{selector("fragment=endpoints")})
+	r.setSelectors("ns", "b", []labels.Selector{selector("fragment"), selector("team=x")})
+	r.setSelectors("other", "c", []labels.Selector{selector("fragment")})
+
+	if diff := cmp.Diff(map[string]bool{"a": true, "b": true}, r.selecting("ns", labels.Set{"fragment": "endpoints"})); diff != "" {
+		t.Errorf("unexpected selecting (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]bool{"b": true}, r.selecting("ns", labels.Set{"team": "x"})); diff != "" {
+		t.Errorf("unexpected selecting (-want +got):\n%s", diff)
+	}
+	if got := r.selecting("ns", nil); got != nil {
+		t.Errorf("unexpected selecting: %v", got)
+	}
+
+	r.setSelectors("ns", "a", nil)
+	r.setSelectors("ns", "b", nil)
+	if _, ok := r.selectors["ns"]; ok {
+		t.Errorf("expected namespace to be removed: %v", r.selectors)
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/buildinfo"
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const snapshotVersion = 1
+
+// errSnapshotConfig is returned when a snapshot was written by another build
+// or configuration of the controller, which may have rendered other Secrets.
+var errSnapshotConfig = errors.New("snapshot was written with another configuration")
+
+// A snapshot records the ConfigMapSecrets that were successfully synced and
+// the resourceVersions of the objects they were synced from. It's persisted
+// so that on startup the reference indexes can be restored and the initial
+// events of objects that haven't changed since the previous run can be
+// ignored, instead of reconciling every ConfigMapSecret. It's only restored
+// by the same build and configuration of the controller that wrote it, since
+// they may change the Secrets rendered from unchanged objects.
+//
+// A nil *snapshot ignores nothing.
+type snapshot struct {
+	path   string
+	config string
+
+	mu   sync.Mutex
+	prev map[types.NamespacedName]snapshotEntry // restored and unclaimed
+	cur  map[types.NamespacedName]snapshotEntry
+}
+
+type snapshotFile struct {
+	Version          int             `json:"version"`
+	Config           string          `json:"config"`
+	ConfigMapSecrets []snapshotEntry `json:"configMapSecrets"`
+}
+
+type snapshotEntry struct {
+	Namespace             string            `json:"namespace"`
+	Name                  string            `json:"name"`
+	ResourceVersion       string            `json:"resourceVersion"`
+	Secret                string            `json:"secret"`
+	SecretResourceVersion string            `json:"secretResourceVersion"`
+	Secrets               map[string]string `json:"secrets,omitempty"`    // name -> resourceVersion
+	ConfigMaps            map[string]string `json:"configMaps,omitempty"` // name -> resourceVersion
+}
+
+// loadSnapshot returns a snapshot of the configuration hash config restored
+// from the file at path. A missing file results in an empty snapshot, and so
+// does a file of another configuration, with errSnapshotConfig.
+func loadSnapshot(path, config string) (*snapshot, error) {
+	s := &snapshot{
+		path:   path,
+		config: config,
+		prev:   make(map[types.NamespacedName]snapshotEntry),
+		cur:    make(map[types.NamespacedName]snapshotEntry),
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	var f snapshotFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return s, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if f.Version != snapshotVersion {
+		return s, fmt.Errorf("unsupported snapshot %s version: %d", path, f.Version)
+	}
+	if f.Config != config {
+		return s, errSnapshotConfig
+	}
+	for _, e := range f.ConfigMapSecrets {
+		s.prev[types.NamespacedName{Namespace: e.Namespace, Name: e.Name}] = e
+	}
+	return s, nil
+}
+
+// snapshotConfig returns the hash of the build of the controller and the
+// configuration of the reconciler that affects the Secrets it renders.
+func (r *ConfigMapSecret) snapshotConfig() (string, error) {
+	hookNames := make([]string, len(r.Hooks))
+	for i, hook := range r.Hooks {
+		hookNames[i] = hook.Name()
+	}
+	buf, err := json.Marshal(struct {
+		Version                    string
+		Revision                   string
+		RenderLimits               render.Limits
+		Hooks                      []string
+		Policy                     *policy.Policy
+		TenantLabel                string
+		DefaultsConfigMap          string
+		SyncAnnotations            []string
+		ClusterName                string
+		SecretNamePrefix           string
+		SecretNameSuffix           string
+		BackupExclusionLabel       string
+		BackupExclusionValue       string
+		GitProtocols               []string
+		DisableClusterTrustBundles bool
+	}{
+		Version:                    buildinfo.Version(),
+		Revision:                   buildinfo.Revision(),
+		RenderLimits:               r.RenderLimits,
+		Hooks:                      hookNames,
+		Policy:                     r.Policy,
+		TenantLabel:                r.TenantLabel,
+		DefaultsConfigMap:          r.DefaultsConfigMap,
+		SyncAnnotations:            r.SyncAnnotations,
+		ClusterName:                r.ClusterName,
+		SecretNamePrefix:           r.SecretNamePrefix,
+		SecretNameSuffix:           r.SecretNameSuffix,
+		BackupExclusionLabel:       r.BackupExclusionLabel,
+		BackupExclusionValue:       r.BackupExclusionValue,
+		GitProtocols:               r.GitProtocols,
+		DisableClusterTrustBundles: r.DisableClusterTrustBundles,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// restoreRefs adds the references of restored entries to the reconciler.
+func (s *snapshot) restoreRefs(r *ConfigMapSecret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.prev {
+		secrets := make(map[string]bool, len(e.Secrets))
+		for name := range e.Secrets {
+			secrets[name] = true
+		}
+		configMaps := make(map[string]bool, len(e.ConfigMaps))
+		for name := range e.ConfigMaps {
+			configMaps[name] = true
+		}
+		r.setRefs(e.Namespace, e.Name, secrets, configMaps, e.Secret)
+	}
+}
+
+// record records that the ConfigMapSecret was synced to the Secret from srcs.
+func (s *snapshot) record(cms *v1alpha1.ConfigMapSecret, srcs *sources, secret *corev1.Secret) {
+	if s == nil {
+		return
+	}
+	e := snapshotEntry{
+		Namespace:             cms.Namespace,
+		Name:                  cms.Name,
+		ResourceVersion:       cms.ResourceVersion,
+		Secret:                secret.Name,
+		SecretResourceVersion: secret.ResourceVersion,
+		Secrets:               make(map[string]string, len(srcs.Secrets)),
+		ConfigMaps:            make(map[string]string, len(srcs.ConfigMaps)),
+	}
+	for name, obj := range srcs.Secrets {
+		if obj != nil {
+			e.Secrets[name] = obj.ResourceVersion
+		} else {
+			e.Secrets[name] = "" // Optional and missing.
+		}
+	}
+	for name, obj := range srcs.ConfigMaps {
+		if obj != nil {
+			e.ConfigMaps[name] = obj.ResourceVersion
+		} else {
+			e.ConfigMaps[name] = "" // Optional and missing.
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}
+	delete(s.prev, key)
+	s.cur[key] = e
+}
+
+// forget removes the entry of the ConfigMapSecret.
+func (s *snapshot) forget(key types.NamespacedName) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.prev, key)
+	delete(s.cur, key)
+}
+
+func (s *snapshot) entry(key types.NamespacedName) (snapshotEntry, bool) {
+	if e, ok := s.cur[key]; ok {
+		return e, true
+	}
+	e, ok := s.prev[key]
+	return e, ok
+}
+
+// unchangedConfigMapSecret reports whether the ConfigMapSecret is unchanged
+// since it was recorded, in which case its restored entry is claimed.
+func (s *snapshot) unchangedConfigMapSecret(obj client.Object) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if e, ok := s.prev[key]; ok {
+		delete(s.prev, key)
+		if e.ResourceVersion != obj.GetResourceVersion() {
+			return false
+		}
+		s.cur[key] = e
+		return true
+	}
+	e, ok := s.cur[key]
+	return ok && e.ResourceVersion == obj.GetResourceVersion()
+}
+
+// unchangedRef reports whether the object of the given kind, referenced by
+// the ConfigMapSecret as a source or its Secret, is unchanged since the
+// ConfigMapSecret was recorded.
+func (s *snapshot) unchangedRef(key types.NamespacedName, kind string, obj client.Object) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entry(key)
+	if !ok {
+		return false
+	}
+	name, rv := obj.GetName(), obj.GetResourceVersion()
+	refs := e.ConfigMaps
+	if kind == "Secret" {
+		refs = e.Secrets
+		if name == e.Secret {
+			if rv != e.SecretResourceVersion {
+				return false
+			}
+			if _, ok := refs[name]; !ok {
+				return true
+			}
+		}
+	}
+	v, ok := refs[name]
+	return ok && v == rv
+}
+
+// predicate returns a predicate that ignores the creation of unchanged
+// ConfigMapSecrets, except those that are periodically refreshed or read a
+// GitSource, since their next refresh must be scheduled, and those that
+// select ConfigMaps by label, since their selectors must be indexed and the
+// snapshot doesn't record ConfigMaps that they didn't select.
+func (s *snapshot) predicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			if !s.unchangedConfigMapSecret(e.Object) {
+				return true
+			}
+			cms, ok := e.Object.(*v1alpha1.ConfigMapSecret)
+			return ok && (cms.Spec.RefreshInterval != nil || cms.Spec.GitSource != nil || len(configMapSelectors(cms.Spec.VarsFrom)) > 0)
+		},
+	}
+}
+
+// handler returns an event handler of objects of the given kind, which ignores
+// requests for ConfigMapSecrets that are unchanged with respect to created objects.
+func (s *snapshot) handler(kind string, h handler.EventHandler) handler.EventHandler {
+	if s == nil {
+		return h
+	}
+	return &snapshotHandler{EventHandler: h, snapshot: s, kind: kind}
+}
+
+type snapshotHandler struct {
+	handler.EventHandler
+	snapshot *snapshot
+	kind     string
+}
+
+func (h *snapshotHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Create(e, &snapshotQueue{
+		RateLimitingInterface: q,
+		snapshot:              h.snapshot,
+		kind:                  h.kind,
+		obj:                   e.Object,
+	})
+}
+
+type snapshotQueue struct {
+	workqueue.RateLimitingInterface
+	snapshot *snapshot
+	kind     string
+	obj      client.Object
+}
+
+func (q *snapshotQueue) Add(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok && q.snapshot.unchangedRef(req.NamespacedName, q.kind, q.obj) {
+		return
+	}
+	q.RateLimitingInterface.Add(item)
+}
+
+// run writes the snapshot at the interval until the context is done,
+// and then writes it a final time.
+func (s *snapshot) run(ctx context.Context, log logr.Logger, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := s.write(); err != nil {
+				log.Error(err, "Unable to write snapshot")
+			}
+			return nil
+		}
+		if err := s.write(); err != nil {
+			log.Error(err, "Unable to write snapshot")
+		}
+	}
+}
+
+// write atomically writes the current entries to the file.
+func (s *snapshot) write() error {
+	s.mu.Lock()
+	f := snapshotFile{
+		Version:          snapshotVersion,
+		Config:           s.config,
+		ConfigMapSecrets: make([]snapshotEntry, 0, len(s.cur)),
+	}
+	for _, e := range s.cur {
+		f.ConfigMapSecrets = append(f.ConfigMapSecrets, e)
+	}
+	buf, err := json.Marshal(f)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
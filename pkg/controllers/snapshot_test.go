@@ -0,0 +1,144 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	s, err := loadSnapshot(path, "config")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", ResourceVersion: "1"},
+	}
+	srcs := newSources()
+	srcs.ConfigMaps["config"] = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", ResourceVersion: "2"}}
+	srcs.Secrets["creds"] = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "creds", ResourceVersion: "3"}}
+	srcs.Secrets["optional"] = nil
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app-config", ResourceVersion: "4"}}
+	s.record(cms, srcs, secret)
+	if err := s.write(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err = loadSnapshot(path, "config")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &ConfigMapSecret{}
+	s.restoreRefs(r)
+	if diff := cmp.Diff(map[string]bool{"app": true}, r.secrets.srcs("ns", "creds")); diff != "" {
+		t.Errorf("unexpected secret refs (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]bool{"app": true}, r.configMaps.srcs("ns", "config")); diff != "" {
+		t.Errorf("unexpected config map refs (-want +got):\n%s", diff)
+	}
+
+	key := types.NamespacedName{Namespace: "ns", Name: "app"}
+	obj := func(name, rv string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, ResourceVersion: rv}}
+	}
+	tests := []struct {
+		kind string
+		obj  *corev1.Secret
+		want bool
+	}{
+		{kind: "Secret", obj: obj("creds", "3"), want: true},
+		{kind: "Secret", obj: obj("creds", "5"), want: false},
+		{kind: "Secret", obj: obj("optional", "6"), want: false},
+		{kind: "Secret", obj: obj("app-config", "4"), want: true},
+		{kind: "Secret", obj: obj("app-config", "7"), want: false},
+		{kind: "ConfigMap", obj: obj("config", "2"), want: true},
+		{kind: "ConfigMap", obj: obj("creds", "3"), want: false},
+	}
+	for _, tt := range tests {
+		if got := s.unchangedRef(key, tt.kind, tt.obj); got != tt.want {
+			t.Errorf("unchangedRef(%s/%s@%s): want: %v; got: %v", tt.kind, tt.obj.Name, tt.obj.ResourceVersion, tt.want, got)
+		}
+	}
+
+	changed := cms.DeepCopy()
+	changed.ResourceVersion = "8"
+	if s.unchangedConfigMapSecret(changed) {
+		t.Errorf("expected changed ConfigMapSecret")
+	}
+	if s.unchangedConfigMapSecret(cms) {
+		t.Errorf("expected entry to be dropped after a change")
+	}
+
+	var nilSnapshot *snapshot
+	if nilSnapshot.unchangedConfigMapSecret(cms) {
+		t.Errorf("expected nil snapshot to ignore nothing")
+	}
+	nilSnapshot.record(cms, srcs, secret)
+	nilSnapshot.forget(key)
+}
+
+func TestLoadSnapshotInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"version":0}`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := loadSnapshot(path, "config")
+	if err == nil {
+		t.Fatalf("expected error for unsupported version")
+	}
+	if s == nil || len(s.prev) != 0 {
+		t.Errorf("expected empty snapshot")
+	}
+}
+
+func TestSnapshotConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	r := &ConfigMapSecret{}
+	config, err := r.snapshotConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := loadSnapshot(path, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", ResourceVersion: "1"},
+	}
+	s.record(cms, newSources(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "2"}})
+	if err := s.write(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s, err := loadSnapshot(path, config); err != nil || len(s.prev) != 1 {
+		t.Fatalf("unexpected snapshot of the same configuration: %d entries, %v", len(s.prev), err)
+	}
+	r.TenantLabel = "example.com/tenant"
+	changed, err := r.snapshotConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed == config {
+		t.Fatal("configuration hash didn't change with the tenant label")
+	}
+	s, err = loadSnapshot(path, changed)
+	if !errors.Is(err, errSnapshotConfig) {
+		t.Fatalf("unexpected error: want: %v; got: %v", errSnapshotConfig, err)
+	}
+	if len(s.prev) != 0 {
+		t.Errorf("expected snapshot of another configuration to be discarded")
+	}
+}
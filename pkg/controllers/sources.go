@@ -0,0 +1,212 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var sourceLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "configmapsecret_controller_source_lookups_total",
+	Help: "Total number of lookups of Secrets and ConfigMaps while rendering a ConfigMapSecret, by kind and result (hit of the per-reconcile cache, or fetch from the client).",
+}, []string{"kind", "result"})
+
+var sourceCacheSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "configmapsecret_controller_source_cache_size",
+	Help:    "Number of Secrets or ConfigMaps in the per-reconcile cache after making the variables of a ConfigMapSecret, by kind.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(sourceLookups, sourceCacheSize)
+}
+
+// sources caches the sources read while rendering a ConfigMapSecret, and
+// records how its variables were resolved from them.
+//
+// A nil *sources wasn't read, e.g. since the reconciliation timed out, so the
+// statuses derived from it retain their previous values.
+type sources struct {
+	renderer.Sources
+}
+
+func newSources() *sources {
+	return &sources{Sources: *renderer.NewSources()}
+}
+
+// observeCacheSize records the number of sources cached of each kind,
+// including optional sources that don't exist and ConfigMaps selected by label.
+func (s *sources) observeCacheSize() {
+	sourceCacheSize.WithLabelValues("Secret").Observe(float64(len(s.Secrets)))
+	sourceCacheSize.WithLabelValues("ConfigMap").Observe(float64(len(s.ConfigMaps)))
+}
+
+type sourceKey struct {
+	kind string
+	name string
+}
+
+// statuses returns the source statuses of the ConfigMapSecret, sorted by kind
+// and name. Sources that were read are recorded with their resourceVersion and,
+// if it changed, the given time. Sources that weren't read, e.g. due to an error,
+// retain their previous status. Optional sources that don't exist are omitted.
+// ConfigMaps selected by label are included if they were read.
+func (s *sources) statuses(cms *v1alpha1.ConfigMapSecret, now metav1.Time) []v1alpha1.ConfigMapSecretSource {
+	if s == nil {
+		return cms.Status.Sources
+	}
+	prev := make(map[sourceKey]v1alpha1.ConfigMapSecretSource, len(cms.Status.Sources))
+	for _, src := range cms.Status.Sources {
+		prev[sourceKey{src.Kind, src.Name}] = src
+	}
+
+	var list []v1alpha1.ConfigMapSecretSource
+	add := func(kind, name string, read bool, obj metav1.Object) {
+		old, found := prev[sourceKey{kind, name}]
+		switch {
+		case !read:
+			if found {
+				list = append(list, old)
+			}
+		case obj == nil:
+			return
+		case found && old.ResourceVersion == obj.GetResourceVersion():
+			list = append(list, old)
+		default:
+			list = append(list, v1alpha1.ConfigMapSecretSource{
+				Kind:            kind,
+				Name:            name,
+				ResourceVersion: obj.GetResourceVersion(),
+				LastReadTime:    now,
+			})
+		}
+	}
+
+	if s.TrustBundles == nil {
+		for _, src := range cms.Status.Sources {
+			if src.Kind == "ClusterTrustBundle" {
+				list = append(list, src)
+			}
+		}
+	}
+	trustBundleNames := make([]string, 0, len(s.TrustBundles))
+	for name := range s.TrustBundles {
+		trustBundleNames = append(trustBundleNames, name)
+	}
+	sort.Strings(trustBundleNames)
+	for _, name := range trustBundleNames {
+		add("ClusterTrustBundle", name, true, s.TrustBundles[name])
+	}
+	secretNames, configMapNames := varRefs(&cms.Spec)
+	for name := range s.Selected {
+		if configMapNames == nil {
+			configMapNames = make(map[string]bool)
+		}
+		configMapNames[name] = true
+	}
+	for _, name := range sortedKeys(configMapNames) {
+		obj, read := s.ConfigMaps[name]
+		if obj == nil { // Avoid a non-nil interface holding a nil pointer.
+			add("ConfigMap", name, read, nil)
+			continue
+		}
+		add("ConfigMap", name, read, obj)
+	}
+	switch {
+	case s.Git != nil:
+		add("Git", s.Git.Repository, true, &metav1.ObjectMeta{ResourceVersion: s.Git.Commit})
+	case cms.Spec.GitSource != nil:
+		for _, src := range cms.Status.Sources {
+			if src.Kind == "Git" {
+				list = append(list, src)
+			}
+		}
+	}
+	for _, name := range sortedKeys(secretNames) {
+		obj, read := s.Secrets[name]
+		if obj == nil { // Avoid a non-nil interface holding a nil pointer.
+			add("Secret", name, read, nil)
+			continue
+		}
+		add("Secret", name, read, obj)
+	}
+	return list
+}
+
+// varCollisions returns the variable collisions of the ConfigMapSecret, sorted
+// by name and limited to v1alpha1.MaxVarCollisions. If variables weren't made,
+// e.g. due to an error, its previous collisions are retained.
+func (s *sources) varCollisions(cms *v1alpha1.ConfigMapSecret) []v1alpha1.VarCollision {
+	if s == nil || s.Collisions == nil {
+		return cms.Status.VarCollisions
+	}
+	names := make([]string, 0, len(s.Collisions))
+	for name := range s.Collisions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > v1alpha1.MaxVarCollisions {
+		names = names[:v1alpha1.MaxVarCollisions]
+	}
+	var list []v1alpha1.VarCollision
+	for _, name := range names {
+		list = append(list, v1alpha1.VarCollision{Name: name, Sources: s.Collisions[name]})
+	}
+	return list
+}
+
+// unresolvedRefs returns the number of unresolved references of the
+// ConfigMapSecret and the names of their variables, sorted and limited to
+// v1alpha1.MaxUnresolvedVars. If the template wasn't rendered, e.g. due to an
+// error, its previous ones are retained.
+func (s *sources) unresolvedRefs(cms *v1alpha1.ConfigMapSecret) (int32, []string) {
+	if s == nil || s.Unresolved == nil {
+		return cms.Status.UnresolvedReferences, cms.Status.UnresolvedVars
+	}
+	var count int32
+	names := make([]string, 0, len(s.Unresolved))
+	for name, n := range s.Unresolved {
+		count += int32(n)
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	sort.Strings(names)
+	if len(names) > v1alpha1.MaxUnresolvedVars {
+		names = names[:v1alpha1.MaxUnresolvedVars]
+	}
+	return count, names
+}
+
+// syncDegradedSources sets the DegradedSources condition of the status if
+// optional sources were missing, or removes it otherwise. If variables weren't
+// made, e.g. due to an error, the previous condition is retained.
+func (s *sources) syncDegradedSources(status *v1alpha1.ConfigMapSecretStatus) {
+	if s == nil || s.Missing == nil {
+		return
+	}
+	if len(s.Missing) == 0 {
+		RemoveConfigMapSecretCondition(status, v1alpha1.ConfigMapSecretDegradedSources)
+		return
+	}
+	msg := "Optional sources not found: " + strings.Join(sortedKeys(s.Missing), ", ")
+	cond := NewConfigMapSecretCondition(v1alpha1.ConfigMapSecretDegradedSources, corev1.ConditionTrue, v1alpha1.OptionalSourcesMissingReason, msg)
+	SetConfigMapSecretCondition(status, *cond)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	s := keys(set)
+	sort.Strings(s)
+	return s
+}
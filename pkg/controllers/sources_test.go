@@ -0,0 +1,433 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSourceStatuses(t *testing.T) {
+	then := metav1.NewTime(time.Unix(1000, 0))
+	now := metav1.NewTime(time.Unix(2000, 0))
+
+	cms := &v1alpha1.ConfigMapSecret{
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			VarsFrom: []v1alpha1.VarsFromSource{
+				{SecretRef: &v1alpha1.SecretVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "failed"}}},
+				{SecretRef: &v1alpha1.SecretVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "unchanged"}}},
+				{ConfigMapRef: &v1alpha1.ConfigMapVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "changed"}}},
+				{ConfigMapRef: &v1alpha1.ConfigMapVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+			},
+			Vars: []v1alpha1.Var{
+				{Name: "NEW", SecretValue: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "new"}}},
+			},
+		},
+		Status: v1alpha1.ConfigMapSecretStatus{
+			Sources: []v1alpha1.ConfigMapSecretSource{
+				{Kind: "ConfigMap", Name: "changed", ResourceVersion: "1", LastReadTime: then},
+				{Kind: "ConfigMap", Name: "missing", ResourceVersion: "2", LastReadTime: then},
+				{Kind: "ConfigMap", Name: "removed", ResourceVersion: "3", LastReadTime: then},
+				{Kind: "Secret", Name: "failed", ResourceVersion: "4", LastReadTime: then},
+				{Kind: "Secret", Name: "unchanged", ResourceVersion: "5", LastReadTime: then},
+			},
+		},
+	}
+	srcs := newSources()
+	srcs.ConfigMaps["changed"] = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "6"}}
+	srcs.ConfigMaps["missing"] = nil
+	srcs.Secrets["unchanged"] = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "5"}}
+	srcs.Secrets["new"] = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "7"}}
+
+	want := []v1alpha1.ConfigMapSecretSource{
+		{Kind: "ConfigMap", Name: "changed", ResourceVersion: "6", LastReadTime: now},
+		{Kind: "Secret", Name: "failed", ResourceVersion: "4", LastReadTime: then},
+		{Kind: "Secret", Name: "new", ResourceVersion: "7", LastReadTime: now},
+		{Kind: "Secret", Name: "unchanged", ResourceVersion: "5", LastReadTime: then},
+	}
+	if diff := cmp.Diff(want, srcs.statuses(cms, now)); diff != "" {
+		t.Errorf("unexpected statuses (-want +got):\n%s", diff)
+	}
+}
+
+func TestVarCollisions(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/prefix-collision.input.yaml")
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	want := []v1alpha1.VarCollision{
+		{Name: "DB_HOST", Sources: []string{"ConfigMap/db", "ConfigMap/d", "Secret/db-credentials"}},
+		{Name: "DB_USER", Sources: []string{"ConfigMap/db", "Secret/db-credentials"}},
+	}
+	// Resolution doesn't depend on map iteration order.
+	for i := 0; i < 10; i++ {
+		srcs := newSources()
+		secret, _, err := r.renderSecret(context.Background(), cms, srcs, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want, got := "secret.example.com 5432 admin", string(secret.Data["values"]); want != got {
+			t.Fatalf("unexpected data; want: %q; got: %q", want, got)
+		}
+		if diff := cmp.Diff(want, srcs.varCollisions(cms)); diff != "" {
+			t.Fatalf("unexpected collisions (-want +got):\n%s", diff)
+		}
+	}
+
+	// Collisions are retained if variables weren't made.
+	cms.Status.VarCollisions = want
+	if diff := cmp.Diff(want, newSources().varCollisions(cms)); diff != "" {
+		t.Errorf("unexpected retained collisions (-want +got):\n%s", diff)
+	}
+
+	srcs := newSources()
+	srcs.Collisions = make(map[string][]string)
+	for i := 0; i < v1alpha1.MaxVarCollisions+1; i++ {
+		srcs.Collisions[fmt.Sprintf("VAR_%02d", i)] = []string{"ConfigMap/a", "ConfigMap/b"}
+	}
+	if got := srcs.varCollisions(cms); len(got) != v1alpha1.MaxVarCollisions || got[0].Name != "VAR_00" {
+		t.Errorf("unexpected limited collisions: %v", got)
+	}
+}
+
+func TestVarCycle(t *testing.T) {
+	cms := build.NewConfigMapSecret("app").
+		WithNamespace("default").
+		WithDataTemplate("url", "$(URL)").
+		WithVar("URL", "https://$(HOST)").
+		WithVar("HOST", "$(URL)").
+		ConfigMapSecret()
+	r := &ConfigMapSecret{client: &fixtureClient{}, scheme: scheme}
+
+	_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+	if reason != v1alpha1.VariableCycleReason || !isConfigError(err) {
+		t.Fatalf("unexpected result: %s, %v", reason, err)
+	}
+	if want := "HOST -> URL -> HOST"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error doesn't name the cycle %q: %v", want, err)
+	}
+}
+
+func TestUnresolvedRefs(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/optional-missing.input.yaml")
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	// Unresolved references are counted whether or not the data is cached.
+	for i := 0; i < 2; i++ {
+		srcs := newSources()
+		if _, _, err := r.renderSecret(context.Background(), cms, srcs, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count, names := srcs.unresolvedRefs(cms)
+		if want := []string{"DB_NAME", "DB_PASSWORD"}; count != 2 || !cmp.Equal(want, names) {
+			t.Errorf("unexpected unresolved references: %d %v", count, names)
+		}
+	}
+
+	// Unresolved references are retained if the template wasn't rendered.
+	cms.Status.UnresolvedReferences = 2
+	cms.Status.UnresolvedVars = []string{"DB_NAME", "DB_PASSWORD"}
+	if count, names := newSources().unresolvedRefs(cms); count != 2 || len(names) != 2 {
+		t.Errorf("unexpected retained unresolved references: %d %v", count, names)
+	}
+
+	srcs := newSources()
+	srcs.Unresolved = make(map[string]int)
+	if count, names := srcs.unresolvedRefs(cms); count != 0 || names != nil {
+		t.Errorf("unexpected unresolved references: %d %v", count, names)
+	}
+	for i := 0; i < v1alpha1.MaxUnresolvedVars+1; i++ {
+		srcs.Unresolved[fmt.Sprintf("VAR_%02d", i)] = 2
+	}
+	if count, names := srcs.unresolvedRefs(cms); count != 2*(v1alpha1.MaxUnresolvedVars+1) || len(names) != v1alpha1.MaxUnresolvedVars || names[0] != "VAR_00" {
+		t.Errorf("unexpected limited unresolved references: %d %v", count, names)
+	}
+}
+
+func TestDegradedSources(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := clientscheme.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := v1alpha1.AddToScheme(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cms, c := readRenderFixture(t, s, "testdata/render/optional-missing.input.yaml")
+	r := &ConfigMapSecret{client: c, scheme: s}
+
+	srcs := newSources()
+	if _, _, err := r.renderSecret(context.Background(), cms, srcs, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var status v1alpha1.ConfigMapSecretStatus
+	srcs.syncDegradedSources(&status)
+	cond := GetConfigMapSecretCondition(status, v1alpha1.ConfigMapSecretDegradedSources)
+	if cond == nil {
+		t.Fatal("expected DegradedSources condition")
+	}
+	want := "Optional sources not found: ConfigMap/db-names[default], ConfigMap/overrides, Secret/db-credentials[PASSWORD]"
+	if cond.Status != corev1.ConditionTrue || cond.Reason != v1alpha1.OptionalSourcesMissingReason || cond.Message != want {
+		t.Errorf("unexpected condition: %+v", *cond)
+	}
+
+	// The condition is retained if variables weren't made.
+	newSources().syncDegradedSources(&status)
+	if GetConfigMapSecretCondition(status, v1alpha1.ConfigMapSecretDegradedSources) == nil {
+		t.Error("expected DegradedSources condition to be retained")
+	}
+
+	// The condition is removed once every source is found.
+	srcs = newSources()
+	srcs.Missing = make(map[string]bool)
+	srcs.syncDegradedSources(&status)
+	if cond := GetConfigMapSecretCondition(status, v1alpha1.ConfigMapSecretDegradedSources); cond != nil {
+		t.Errorf("unexpected condition: %+v", *cond)
+	}
+}
+
+func TestSourceLookups(t *testing.T) {
+	cms := build.NewConfigMapSecret("lookups").
+		WithNamespace("default").
+		WithDataTemplate("dsn", "$(USER):$(PASSWORD)@$(HOST)").
+		WithConfigMapVarsFrom("db", "").
+		WithConfigMapVar("HOST", "db", "host").
+		WithSecretVar("USER", "creds", "user").
+		WithSecretVar("PASSWORD", "creds", "password").
+		ConfigMapSecret()
+	c := &fixtureClient{
+		configMaps: map[types.NamespacedName]*corev1.ConfigMap{
+			{Namespace: "default", Name: "db"}: {Data: map[string]string{"host": "db.example.com"}},
+		},
+		secrets: map[types.NamespacedName]*corev1.Secret{
+			{Namespace: "default", Name: "creds"}: {Data: map[string][]byte{"user": []byte("app"), "password": []byte("hunter2")}},
+		},
+	}
+	r := &ConfigMapSecret{client: c, scheme: scheme}
+
+	counters := map[string]prometheus.Collector{
+		"ConfigMap hit":   sourceLookups.WithLabelValues("ConfigMap", "hit"),
+		"ConfigMap fetch": sourceLookups.WithLabelValues("ConfigMap", "fetch"),
+		"Secret hit":      sourceLookups.WithLabelValues("Secret", "hit"),
+		"Secret fetch":    sourceLookups.WithLabelValues("Secret", "fetch"),
+		"ConfigMap sizes": sourceCacheSize.WithLabelValues("ConfigMap").(prometheus.Histogram),
+		"Secret sizes":    sourceCacheSize.WithLabelValues("Secret").(prometheus.Histogram),
+	}
+	before := make(map[string]float64)
+	for name, c := range counters {
+		before[name] = metricValue(t, c)
+	}
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Each source is fetched once, and each later reference hits the cache.
+	want := map[string]float64{
+		"ConfigMap hit":   1,
+		"ConfigMap fetch": 1,
+		"Secret hit":      1,
+		"Secret fetch":    1,
+		"ConfigMap sizes": 1,
+		"Secret sizes":    1,
+	}
+	got := make(map[string]float64)
+	for name, c := range counters {
+		got[name] = metricValue(t, c) - before[name]
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+	}
+}
+
+func TestTenantLabel(t *testing.T) {
+	const label = "example.com/tenant"
+	secret := func(name, tenant string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{label: tenant}},
+			Data:       map[string][]byte{"password": []byte(tenant)},
+		}
+	}
+	c := &fixtureClient{secrets: map[types.NamespacedName]*corev1.Secret{
+		{Namespace: "default", Name: "a-secret"}: secret("a-secret", "a"),
+		{Namespace: "default", Name: "b-secret"}: secret("b-secret", "b"),
+	}}
+	r := &ConfigMapSecret{client: c, scheme: scheme, TenantLabel: label}
+	newCMS := func(secretName string) *build.Builder {
+		return build.NewConfigMapSecret("app").
+			WithNamespace("default").
+			WithLabel(label, "a").
+			WithDataTemplate("password", "$(PASSWORD)").
+			WithSecretVar("PASSWORD", secretName, "password")
+	}
+
+	if _, _, err := r.renderSecret(context.Background(), newCMS("a-secret").ConfigMapSecret(), newSources(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, reason, err := r.renderSecret(context.Background(), newCMS("b-secret").ConfigMapSecret(), newSources(), nil)
+	if reason != v1alpha1.TenantViolationReason || !isConfigError(err) {
+		t.Fatalf("unexpected result: %s, %v", reason, err)
+	}
+
+	// Sources of other tenants are checked before their values are read, so
+	// that neither their keys nor their values are in errors.
+	foreign := secret("c-secret", "c")
+	foreign.Data = map[string][]byte{"invalid key": []byte("hunter2")}
+	c.secrets[types.NamespacedName{Namespace: "default", Name: "c-secret"}] = foreign
+	cms := newCMS("a-secret").WithSecretVarsFrom("c-secret", "").ConfigMapSecret()
+	cms.Spec.InvalidKeyPolicy = v1alpha1.InvalidKeyPolicyError
+	cms.Spec.Vars[0].Transforms = []v1alpha1.Transform{{Func: v1alpha1.TransformB64Dec}}
+	_, reason, err = r.renderSecret(context.Background(), cms, newSources(), nil)
+	if reason != v1alpha1.TenantViolationReason || !isConfigError(err) {
+		t.Fatalf("unexpected result: %s, %v", reason, err)
+	}
+	if msg := err.Error(); strings.Contains(msg, "invalid key") || strings.Contains(msg, "hunter2") {
+		t.Errorf("error contains the data of another tenant's Secret: %s", msg)
+	}
+
+	// The credentials of a GitSource are checked before they're used.
+	cms = newCMS("a-secret").ConfigMapSecret()
+	cms.Spec.GitSource = &v1alpha1.GitSource{
+		Repository: "https://example.com/config.git",
+		SecretRef:  &corev1.LocalObjectReference{Name: "b-secret"},
+	}
+	if _, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil); reason != v1alpha1.TenantViolationReason {
+		t.Fatalf("unexpected result: %s, %v", reason, err)
+	}
+
+	// Without the label, sources of any tenant can be read.
+	r.TenantLabel = ""
+	if _, _, err := r.renderSecret(context.Background(), newCMS("b-secret").ConfigMapSecret(), newSources(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTenantLabelRemoved(t *testing.T) {
+	const label = "example.com/tenant"
+	key := types.NamespacedName{Namespace: "default", Name: "creds"}
+	old := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default", Labels: map[string]string{label: "a"}},
+		Data:       map[string][]byte{"password": []byte("a")},
+	}
+	c := &fixtureClient{secrets: map[types.NamespacedName]*corev1.Secret{key: old}}
+	r := &ConfigMapSecret{client: c, scheme: scheme, TenantLabel: label}
+	cms := build.NewConfigMapSecret("app").
+		WithNamespace("default").
+		WithLabel(label, "a").
+		WithDataTemplate("password", "$(PASSWORD)").
+		WithSecretVar("PASSWORD", "creds", "password").
+		ConfigMapSecret()
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.setRefs(cms.Namespace, cms.Name, map[string]bool{"creds": true}, nil, "app")
+
+	// Removing the label isn't ignored as a metadata-only update, and
+	// re-renders the ConfigMapSecret that reads the Secret.
+	cur := old.DeepCopy()
+	cur.Labels = nil
+	cur.ResourceVersion = "2"
+	c.secrets[key] = cur
+	if !r.secretChanged().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: cur}) {
+		t.Fatal("removal of the tenant label was ignored")
+	}
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	r.secretEventHandler(context.Background(), q, cur, false)
+	if q.Len() != 1 {
+		t.Fatalf("unexpected queue length: want: 1; got: %d", q.Len())
+	}
+	item, _ := q.Get()
+	if want := (reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}); item != want {
+		t.Fatalf("unexpected request: want: %v; got: %v", want, item)
+	}
+	_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+	if reason != v1alpha1.TenantViolationReason || !isConfigError(err) {
+		t.Fatalf("unexpected result: %s, %v", reason, err)
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	b64dec := v1alpha1.Transform{Func: v1alpha1.TransformB64Dec}
+	r := &ConfigMapSecret{client: &fixtureClient{}, scheme: scheme}
+	for _, b := range []*build.Builder{
+		build.NewConfigMapSecret("app").
+			WithNamespace("default").
+			WithDataTemplate("password", "$(PASSWORD)").
+			WithVar("PASSWORD", "not base64").
+			WithTransforms(b64dec),
+		build.NewConfigMapSecret("app").
+			WithNamespace("default").
+			WithDataTemplate("password", "not base64").
+			WithKeyOptions("password", v1alpha1.KeyOptions{Transforms: []v1alpha1.Transform{b64dec}}),
+	} {
+		cms := b.ConfigMapSecret()
+		_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+		if reason != v1alpha1.TransformErrorReason || !isConfigError(err) {
+			t.Errorf("unexpected result: %s, %v", reason, err)
+		}
+	}
+}
+
+func TestLineEndingLimit(t *testing.T) {
+	r := &ConfigMapSecret{
+		RenderLimits: render.Limits{MaxOutputSize: 8},
+		client:       &fixtureClient{},
+		scheme:       scheme,
+	}
+	crlf := v1alpha1.KeyOptions{LineEndings: v1alpha1.LineEndingCRLF}
+	verbatim := v1alpha1.KeyOptions{LineEndings: v1alpha1.LineEndingCRLF, Verbatim: true}
+	literal := build.NewConfigMapSecret("app").
+		WithNamespace("default").
+		WithDataTemplate("config", "a\nb\nc\nd\n").
+		WithKeyOptions("config", crlf).
+		ConfigMapSecret()
+	literal.Spec.DisableExpansion = true
+	for name, cms := range map[string]*v1alpha1.ConfigMapSecret{
+		"expanded": build.NewConfigMapSecret("app").
+			WithNamespace("default").
+			WithDataTemplate("config", "a\nb\nc\nd\n").
+			WithKeyOptions("config", crlf).
+			ConfigMapSecret(),
+		"verbatim": build.NewConfigMapSecret("app").
+			WithNamespace("default").
+			WithDataTemplate("config", "a\nb\nc\nd\n").
+			WithKeyOptions("config", verbatim).
+			ConfigMapSecret(),
+		"disableExpansion": literal,
+	} {
+		_, reason, err := r.renderSecret(context.Background(), cms, newSources(), nil)
+		if reason != v1alpha1.RenderLimitExceededReason || !isConfigError(err) {
+			t.Errorf("%s: unexpected result: %s, %v", name, reason, err)
+		}
+	}
+}
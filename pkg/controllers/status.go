@@ -0,0 +1,184 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	statusWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "configmapsecret_controller_status_writes_total",
+			Help: "Number of statuses handled by the batched status updater, by result: written, coalesced, unchanged, or failed.",
+		},
+		[]string{"result"},
+	)
+	statusPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "configmapsecret_controller_status_pending",
+			Help: "Number of ConfigMapSecrets whose status is waiting to be written by the batched status updater.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(statusWrites, statusPending)
+}
+
+// A statusUpdater writes the statuses of ConfigMapSecrets in batches, off the
+// reconcile critical path. Statuses of the same object that are enqueued before
+// they're flushed are coalesced, so that only the latest is written, and
+// objects are written in the order in which they were first enqueued.
+//
+// Writes are merge patches from the status that was last observed before the
+// first coalesced status, so they don't conflict with concurrent writes of the
+// spec. A failed write is retried at the next flush, unless a newer status was
+// enqueued, so the status is eventually consistent. Pending writes are dropped
+// when the updater stops, e.g. when leadership is lost, since the statuses of
+// every object are written again when the next leader starts.
+type statusUpdater struct {
+	client client.Client
+
+	mu      sync.Mutex
+	pending map[types.NamespacedName]*statusWrite
+	order   []types.NamespacedName
+}
+
+// A statusWrite is a pending write of the status of a ConfigMapSecret.
+type statusWrite struct {
+	// prev is the status of the object before the write.
+	prev v1alpha1.ConfigMapSecretStatus
+	// obj is the object with the status to write.
+	obj *v1alpha1.ConfigMapSecret
+}
+
+func newStatusUpdater(c client.Client) *statusUpdater {
+	return &statusUpdater{
+		client:  c,
+		pending: make(map[types.NamespacedName]*statusWrite),
+	}
+}
+
+// isPending returns whether a status of the object is waiting to be written.
+// A nil updater never has pending statuses.
+func (u *statusUpdater) isPending(key types.NamespacedName) bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.pending[key] != nil
+}
+
+// enqueue enqueues a write of the status of the object, whose previous status
+// is prev. It replaces any pending write of the object.
+func (u *statusUpdater) enqueue(cms *v1alpha1.ConfigMapSecret, prev v1alpha1.ConfigMapSecretStatus) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.put(&statusWrite{prev: *prev.DeepCopy(), obj: cms.DeepCopy()})
+}
+
+// put adds a pending write, which must be locked. If the object already has a
+// pending write, it's replaced, but the status before the first is kept, since
+// the replaced write wasn't made.
+func (u *statusUpdater) put(w *statusWrite) {
+	key := types.NamespacedName{Namespace: w.obj.Namespace, Name: w.obj.Name}
+	if prev := u.pending[key]; prev != nil {
+		statusWrites.WithLabelValues("coalesced").Inc()
+		prev.obj = w.obj
+		return
+	}
+	u.pending[key] = w
+	u.order = append(u.order, key)
+	statusPending.Set(float64(len(u.pending)))
+}
+
+// flush writes the pending statuses and returns the number that failed, which
+// are enqueued again unless a newer status of their object was enqueued.
+func (u *statusUpdater) flush(ctx context.Context, log logr.Logger) (failed int) {
+	u.mu.Lock()
+	order, pending := u.order, u.pending
+	u.order, u.pending = nil, make(map[types.NamespacedName]*statusWrite)
+	statusPending.Set(0)
+	u.mu.Unlock()
+
+	for i, key := range order {
+		if ctx.Err() != nil {
+			u.requeue(order[i:], pending)
+			return failed + len(order) - i
+		}
+		w := pending[key]
+		if reflect.DeepEqual(w.prev, w.obj.Status) {
+			statusWrites.WithLabelValues("unchanged").Inc()
+			continue
+		}
+		if err := u.write(ctx, w); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			log.Error(err, "Unable to update status", "configmapsecret", key)
+			statusWrites.WithLabelValues("failed").Inc()
+			u.requeue(order[i:i+1], pending)
+			failed++
+			continue
+		}
+		log.Info("Updated status", "configmapsecret", key)
+		statusWrites.WithLabelValues("written").Inc()
+	}
+	return failed
+}
+
+// write patches the status of the object from its previous status.
+func (u *statusUpdater) write(ctx context.Context, w *statusWrite) error {
+	base := w.obj.DeepCopy()
+	base.Status = w.prev
+	return u.client.Status().Patch(ctx, w.obj.DeepCopy(), client.MergeFrom(base))
+}
+
+// requeue enqueues the writes of the keys again, unless newer statuses of their
+// objects were enqueued, which are written from their previous statuses instead.
+func (u *statusUpdater) requeue(keys []types.NamespacedName, writes map[types.NamespacedName]*statusWrite) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, key := range keys {
+		w := writes[key]
+		if newer := u.pending[key]; newer != nil {
+			newer.prev = w.prev
+			continue
+		}
+		u.pending[key] = w
+		u.order = append(u.order, key)
+	}
+	statusPending.Set(float64(len(u.pending)))
+}
+
+// run flushes pending statuses every interval until ctx is done.
+func (u *statusUpdater) run(ctx context.Context, log logr.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			u.flush(ctx, log)
+		}
+	}
+}
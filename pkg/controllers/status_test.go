@@ -0,0 +1,212 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A statusClient records the status patches that it's sent.
+type statusClient struct {
+	client.Client
+	patches []statusPatch
+	errs    map[string]error // name -> error of the next patch
+	onPatch func(name string)
+}
+
+type statusPatch struct {
+	name  string
+	id    string // ReconcileID of the patched status
+	patch string
+}
+
+func (c *statusClient) Status() client.StatusWriter {
+	return &statusClientWriter{c}
+}
+
+type statusClientWriter struct {
+	*statusClient
+}
+
+func (w *statusClientWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	name := obj.GetName()
+	if w.onPatch != nil {
+		w.onPatch(name)
+	}
+	if err := w.errs[name]; err != nil {
+		delete(w.errs, name)
+		return err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	id := obj.(*v1alpha1.ConfigMapSecret).Status.ReconcileID
+	w.patches = append(w.patches, statusPatch{name, id, string(data)})
+	return nil
+}
+
+func statusObj(name, id string) *v1alpha1.ConfigMapSecret {
+	return &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Status:     v1alpha1.ConfigMapSecretStatus{ReconcileID: id},
+	}
+}
+
+func statusStatus(id string) v1alpha1.ConfigMapSecretStatus {
+	return v1alpha1.ConfigMapSecretStatus{ReconcileID: id}
+}
+
+func patchIDs(patches []statusPatch) []string {
+	var ids []string
+	for _, p := range patches {
+		ids = append(ids, p.name+":"+p.id)
+	}
+	return ids
+}
+
+func TestStatusUpdaterOrder(t *testing.T) {
+	c := &statusClient{}
+	u := newStatusUpdater(c)
+	coalesced := metricValue(t, statusWrites.WithLabelValues("coalesced"))
+
+	// Statuses are coalesced per object, and written in first-enqueue order.
+	u.enqueue(statusObj("a", "a1"), statusStatus("a0"))
+	u.enqueue(statusObj("b", "b1"), statusStatus("b0"))
+	u.enqueue(statusObj("a", "a2"), statusStatus("a1"))
+	u.enqueue(statusObj("c", "c1"), statusStatus("c0"))
+	if !u.isPending(types.NamespacedName{Namespace: "default", Name: "a"}) {
+		t.Error("status of a isn't pending")
+	}
+	if failed := u.flush(context.Background(), logr.Discard()); failed != 0 {
+		t.Errorf("unexpected failures: %d", failed)
+	}
+	if got, want := patchIDs(c.patches), []string{"a:a2", "b:b1", "c:c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected writes: want: %v; got: %v", want, got)
+	}
+	// The coalesced write is patched from the status before the first.
+	if want := `{"status":{"reconcileID":"a2"}}`; c.patches[0].patch != want {
+		t.Errorf("unexpected patch: want: %s; got: %s", want, c.patches[0].patch)
+	}
+	if got := metricValue(t, statusWrites.WithLabelValues("coalesced")) - coalesced; got != 1 {
+		t.Errorf("unexpected coalesced writes: %v", got)
+	}
+	if u.isPending(types.NamespacedName{Namespace: "default", Name: "a"}) {
+		t.Error("status of a is still pending")
+	}
+
+	// A status that's reverted before it's written isn't written.
+	c.patches = nil
+	u.enqueue(statusObj("a", "a3"), statusStatus("a2"))
+	u.enqueue(statusObj("a", "a2"), statusStatus("a3"))
+	u.flush(context.Background(), logr.Discard())
+	if len(c.patches) != 0 {
+		t.Errorf("unexpected writes: %v", patchIDs(c.patches))
+	}
+}
+
+func TestStatusUpdaterEnqueueDuringFlush(t *testing.T) {
+	c := &statusClient{}
+	u := newStatusUpdater(c)
+	u.enqueue(statusObj("a", "a1"), statusStatus("a0"))
+	u.enqueue(statusObj("b", "b1"), statusStatus("b0"))
+
+	// A status enqueued while an older one is written is written by the next
+	// flush, after the older one.
+	c.onPatch = func(name string) {
+		if name == "a" {
+			u.enqueue(statusObj("a", "a2"), statusStatus("a1"))
+		}
+	}
+	u.flush(context.Background(), logr.Discard())
+	c.onPatch = nil
+	u.flush(context.Background(), logr.Discard())
+	if got, want := patchIDs(c.patches), []string{"a:a1", "b:b1", "a:a2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected writes: want: %v; got: %v", want, got)
+	}
+}
+
+func TestStatusUpdaterRetry(t *testing.T) {
+	c := &statusClient{errs: map[string]error{
+		"a": errors.New("unavailable"),
+		"b": apierrors.NewNotFound(schema.GroupResource{Resource: "configmapsecrets"}, "b"),
+	}}
+	u := newStatusUpdater(c)
+	u.enqueue(statusObj("a", "a1"), statusStatus("a0"))
+	u.enqueue(statusObj("b", "b1"), statusStatus("b0"))
+	u.enqueue(statusObj("c", "c1"), statusStatus("c0"))
+
+	// A failed write doesn't block others, and deleted objects are dropped.
+	if failed := u.flush(context.Background(), logr.Discard()); failed != 1 {
+		t.Errorf("unexpected failures: %d", failed)
+	}
+	if got, want := patchIDs(c.patches), []string{"c:c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected writes: want: %v; got: %v", want, got)
+	}
+	if u.isPending(types.NamespacedName{Namespace: "default", Name: "b"}) {
+		t.Error("status of deleted b is pending")
+	}
+
+	// A failed write is retried with the latest status, patched from the
+	// status before the failed write.
+	u.enqueue(statusObj("a", "a2"), statusStatus("a1"))
+	c.patches = nil
+	if failed := u.flush(context.Background(), logr.Discard()); failed != 0 {
+		t.Errorf("unexpected failures: %d", failed)
+	}
+	if got, want := patchIDs(c.patches), []string{"a:a2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected writes: want: %v; got: %v", want, got)
+	}
+
+	// Writes that aren't made before the updater stops are kept.
+	u.enqueue(statusObj("d", "d1"), statusStatus("d0"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if failed := u.flush(ctx, logr.Discard()); failed != 1 {
+		t.Errorf("unexpected failures: %d", failed)
+	}
+	if !u.isPending(types.NamespacedName{Namespace: "default", Name: "d"}) {
+		t.Error("status of d isn't pending")
+	}
+}
+
+func TestSyncStatusBatched(t *testing.T) {
+	c := &statusClient{}
+	r := &ConfigMapSecret{client: c, logger: logr.Discard(), status: newStatusUpdater(c)}
+	cms := statusObj("a", "")
+	srcs := &sources{}
+
+	// The status isn't written until it's flushed.
+	if err := r.syncStatus(context.Background(), logr.Discard(), cms, srcs, "False", "", "", false, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.patches) != 0 {
+		t.Fatalf("unexpected writes: %v", patchIDs(c.patches))
+	}
+	if !r.status.isPending(types.NamespacedName{Namespace: "default", Name: "a"}) {
+		t.Fatal("status isn't pending")
+	}
+
+	// A status that matches the cached one still replaces a pending status.
+	cached := statusObj("a", "")
+	if err := r.syncCleanupStatus(context.Background(), logr.Discard(), cached, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.status.flush(context.Background(), logr.Discard())
+	if len(c.patches) != 0 {
+		t.Errorf("unexpected writes: %v", patchIDs(c.patches))
+	}
+}
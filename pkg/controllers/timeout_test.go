@@ -0,0 +1,96 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// A hungClient gets its ConfigMapSecret and blocks on every other request
+// until the context is done.
+type hungClient struct {
+	client.Client
+	cms    *v1alpha1.ConfigMapSecret
+	status *v1alpha1.ConfigMapSecretStatus
+}
+
+func (c *hungClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if cms, ok := obj.(*v1alpha1.ConfigMapSecret); ok {
+		c.cms.DeepCopyInto(cms)
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *hungClient) Status() client.StatusWriter {
+	return &hungStatusWriter{c}
+}
+
+type hungStatusWriter struct {
+	*hungClient
+}
+
+func (w *hungStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.status = obj.(*v1alpha1.ConfigMapSecret).Status.DeepCopy()
+	return nil
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	c := &hungClient{
+		cms: &v1alpha1.ConfigMapSecret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hung"},
+			Spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{
+					{ConfigMapRef: &v1alpha1.ConfigMapVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+					{ConfigMapSelector: &v1alpha1.ConfigMapSelector{
+						LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "hung"}},
+					}},
+				},
+			},
+			Status: v1alpha1.ConfigMapSecretStatus{
+				Sources: []v1alpha1.ConfigMapSecretSource{
+					{Kind: "ConfigMap", Name: "config", ResourceVersion: "1"},
+					{Kind: "ConfigMap", Name: "selected", ResourceVersion: "2"},
+				},
+			},
+		},
+	}
+	r := &ConfigMapSecret{
+		ReconcileTimeout: 10 * time.Millisecond,
+		client:           c,
+		logger:           logr.Discard(),
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "hung"}}
+	if _, err := r.Reconcile(context.Background(), req); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: want: %v; got: %v", context.DeadlineExceeded, err)
+	}
+	if c.status == nil {
+		t.Fatalf("expected status update")
+	}
+	cond := GetConfigMapSecretCondition(*c.status, v1alpha1.ConfigMapSecretRenderFailure)
+	if cond == nil || cond.Status != corev1.ConditionTrue || cond.Reason != v1alpha1.ReconcileTimeoutReason {
+		t.Errorf("unexpected condition: %+v", cond)
+	}
+	// The sources read before the deadline aren't known, so their statuses
+	// are retained, including those of ConfigMaps selected by label.
+	if diff := cmp.Diff(c.cms.Status.Sources, c.status.Sources); diff != "" {
+		t.Errorf("unexpected sources (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RenderTraceReason is the reason of events containing render traces.
+const RenderTraceReason = "RenderTrace"
+
+// newRenderTrace returns a trace if debugging is enabled for the ConfigMapSecret,
+// or nil otherwise.
+func newRenderTrace(cms *v1alpha1.ConfigMapSecret) *renderer.Trace {
+	if cms.Annotations[v1alpha1.DebugAnnotation] != "true" {
+		return nil
+	}
+	return renderer.NewTrace()
+}
+
+// emitTrace logs the trace and records it as an event of the ConfigMapSecret.
+func (r *ConfigMapSecret) emitTrace(ctx context.Context, log logr.Logger, cms *v1alpha1.ConfigMapSecret, t *renderer.Trace) {
+	if t == nil {
+		return
+	}
+	msg := t.String()
+	log.Info("Render trace", "trace", msg)
+	r.eventf(ctx, cms, corev1.EventTypeNormal, RenderTraceReason, "%s", truncateMessage(msg, MaxConditionMessageLength))
+}
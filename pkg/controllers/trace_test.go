@@ -0,0 +1,23 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderTrace(t *testing.T) {
+	cms := &v1alpha1.ConfigMapSecret{}
+	if trace := newRenderTrace(cms); trace != nil {
+		t.Fatalf("unexpected trace without debug annotation")
+	}
+	cms.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.DebugAnnotation: "true"}}
+	if trace := newRenderTrace(cms); trace == nil {
+		t.Fatalf("expected trace with debug annotation")
+	}
+}
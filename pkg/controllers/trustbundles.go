@@ -0,0 +1,87 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/trustbundle"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=clustertrustbundles,verbs=get;list;watch
+
+// detectClusterTrustBundles reads ClusterTrustBundles from reader if their API
+// is available according to mapper and they aren't disabled.
+func (r *ConfigMapSecret) detectClusterTrustBundles(mapper meta.RESTMapper, reader client.Reader) error {
+	if r.DisableClusterTrustBundles {
+		return nil
+	}
+	ok, err := trustbundle.Available(mapper)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		r.logger.Info("ClusterTrustBundle API isn't available, so vars from ClusterTrustBundles are disabled")
+		return nil
+	}
+	r.trustBundles = reader
+	return nil
+}
+
+// trustBundleSelectors returns the ClusterTrustBundle selectors of vars.
+func trustBundleSelectors(vars []v1alpha1.Var) []v1alpha1.ClusterTrustBundleSelector {
+	var sels []v1alpha1.ClusterTrustBundleSelector
+	for _, v := range vars {
+		if v.ClusterTrustBundleValue != nil {
+			sels = append(sels, *v.ClusterTrustBundleValue)
+		}
+	}
+	return sels
+}
+
+// setTrustBundleSelectors sets the ClusterTrustBundle selectors of the named
+// ConfigMapSecret.
+func (r *ConfigMapSecret) setTrustBundleSelectors(namespace, name string, sels []v1alpha1.ClusterTrustBundleSelector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if len(sels) == 0 {
+		delete(r.trustBundleSels, key)
+		return
+	}
+	if r.trustBundleSels == nil {
+		r.trustBundleSels = make(map[types.NamespacedName][]v1alpha1.ClusterTrustBundleSelector)
+	}
+	r.trustBundleSels[key] = sels
+}
+
+func (r *ConfigMapSecret) trustBundleEventHandler() handler.EventHandler {
+	return enqueueRequestsFromMapFunc(&r.ctx, func(ctx context.Context, obj client.Object) []reconcile.Request {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		var reqs []reconcile.Request
+		for key, sels := range r.trustBundleSels {
+			for _, sel := range sels {
+				if trustbundle.Matches(sel, obj) {
+					delete(r.failures, key)
+					reqs = append(reqs, reconcile.Request{NamespacedName: key})
+					break
+				}
+			}
+		}
+		return reqs
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"context"
+	"encoding/pem"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	"github.com/machinezone/configmapsecrets/pkg/trustbundle"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func trustBundle(name, signer, cert string) *unstructured.Unstructured {
+	obj := trustbundle.New()
+	obj.SetName(name)
+	obj.SetResourceVersion("1")
+	obj.Object["spec"] = map[string]interface{}{
+		"signerName":  signer,
+		"trustBundle": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(cert)})),
+	}
+	return obj
+}
+
+// A trustBundleReader reads ClusterTrustBundles from memory.
+type trustBundleReader struct {
+	client.Reader
+	objs []*unstructured.Unstructured
+}
+
+func (r *trustBundleReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	for _, o := range r.objs {
+		if o.GetName() == key.Name {
+			o.DeepCopyInto(obj.(*unstructured.Unstructured))
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Group: "certificates.k8s.io", Resource: "clustertrustbundles"}, key.Name)
+}
+
+func (r *trustBundleReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	items := list.(*unstructured.UnstructuredList)
+	for _, o := range r.objs {
+		items.Items = append(items.Items, *o.DeepCopy())
+	}
+	return nil
+}
+
+func TestTrustBundleVars(t *testing.T) {
+	reader := &trustBundleReader{objs: []*unstructured.Unstructured{
+		trustBundle("ca-2", "example.com/ca", "new"),
+		trustBundle("ca-1", "example.com/ca", "old"),
+		trustBundle("other", "example.com/other", "other"),
+	}}
+	sel := v1alpha1.ClusterTrustBundleSelector{SignerName: "example.com/ca", LabelSelector: &metav1.LabelSelector{}}
+	cms := build.NewConfigMapSecret("app").
+		WithNamespace("default").
+		WithDataTemplate("ca.pem", "$(CA)").
+		WithClusterTrustBundleVar("CA", sel).
+		ConfigMapSecret()
+	r := &ConfigMapSecret{client: &fixtureClient{}, scheme: scheme, trustBundles: reader}
+
+	// The certificates of the signer's bundles are concatenated by name.
+	srcs := newSources()
+	secret, _, err := r.renderSecret(context.Background(), cms, srcs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("old")})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("new")}))
+	if got := string(secret.Data["ca.pem"]); got != want {
+		t.Errorf("unexpected data; want: %q; got: %q", want, got)
+	}
+	var names []string
+	for _, src := range srcs.statuses(cms, metav1.Now()) {
+		names = append(names, src.Kind+"/"+src.Name)
+	}
+	if diff := cmp.Diff([]string{"ClusterTrustBundle/ca-1", "ClusterTrustBundle/ca-2"}, names); diff != "" {
+		t.Errorf("unexpected sources (-want +got):\n%s", diff)
+	}
+
+	// A missing bundle is a config error unless it's optional.
+	cms.Spec.Vars[0].ClusterTrustBundleValue = &v1alpha1.ClusterTrustBundleSelector{Name: "missing"}
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); !isConfigError(err) {
+		t.Errorf("expected config error; got: %v", err)
+	}
+	optional := true
+	cms.Spec.Vars[0].ClusterTrustBundleValue.Optional = &optional
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Without the API, optional bundles are missing and others are config errors.
+	r.trustBundles = nil
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cms.Spec.Vars[0].ClusterTrustBundleValue.Optional = nil
+	if _, _, err := r.renderSecret(context.Background(), cms, newSources(), nil); !isConfigError(err) {
+		t.Errorf("expected config error; got: %v", err)
+	}
+}
+
+func TestTrustBundleEventHandler(t *testing.T) {
+	r := &ConfigMapSecret{}
+	r.setTrustBundleSelectors("ns", "by-name", []v1alpha1.ClusterTrustBundleSelector{{Name: "ca-1"}})
+	r.setTrustBundleSelectors("ns", "by-signer", []v1alpha1.ClusterTrustBundleSelector{
+		{SignerName: "example.com/ca", LabelSelector: &metav1.LabelSelector{}},
+	})
+	r.setTrustBundleSelectors("ns", "removed", []v1alpha1.ClusterTrustBundleSelector{{Name: "ca-1"}})
+	r.setTrustBundleSelectors("ns", "removed", nil)
+
+	for _, tt := range []struct {
+		obj  *unstructured.Unstructured
+		want int
+	}{
+		{trustBundle("ca-1", "example.com/ca", "1"), 2},
+		{trustBundle("ca-2", "example.com/ca", "2"), 1},
+		{trustBundle("ca-1", "", "1"), 1},
+		{trustBundle("other", "example.com/other", "3"), 0},
+	} {
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		r.trustBundleEventHandler().Create(event.CreateEvent{Object: tt.obj}, q)
+		if n := q.Len(); n != tt.want {
+			t.Errorf("%s of %s: want %d requests; got: %d", tt.obj.GetName(), trustbundle.SignerName(tt.obj), tt.want, n)
+		}
+		q.ShutDown()
+	}
+}
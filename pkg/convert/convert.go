@@ -0,0 +1,215 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package convert converts ConfigMaps with inline credentials to
+// ConfigMapSecrets whose credentials are read from a source Secret,
+// e.g. to adopt ConfigMapSecrets in an existing namespace, and converts
+// ConfigMapSecrets to the resources of other tools, e.g. to evaluate them
+// or migrate to them.
+package convert
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretSuffix is appended to the name of a converted ConfigMap to name the
+// source Secret of its extracted credentials.
+const SecretSuffix = "-credentials"
+
+var (
+	// credentialKey matches the names of keys that hold credentials.
+	credentialKey = regexp.MustCompile(`(?i)(passw(or)?d|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credentials?|auth)$`)
+	// assignment matches a line assigning a value to a key,
+	// e.g. in YAML, TOML, INI, properties, or env files.
+	assignment = regexp.MustCompile(`^(\s*(?:-\s+)?(?:export\s+)?["']?([A-Za-z0-9_.\-]+)["']?\s*[:=]\s*)(.*?)(\s*)$`)
+	// userinfo matches the password of a URL's userinfo, e.g. in a DSN.
+	userinfo = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.\-]*://[^:/@\s]+:)([^@\s]+)(@)`)
+	// invalidVarChars matches the characters that aren't valid in a var name.
+	invalidVarChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// Result is a converted ConfigMap.
+type Result struct {
+	// ConfigMapSecret renders the data of the ConfigMap.
+	ConfigMapSecret *v1alpha1.ConfigMapSecret
+	// Secret contains the extracted credentials, or is nil if none were found.
+	Secret *corev1.Secret
+}
+
+// ConfigMap converts a ConfigMap to a ConfigMapSecret of the same name that
+// renders the same data. Credentials found in its data are extracted to a
+// source Secret and replaced by $(VAR_NAME) references to its keys.
+//
+// A value is a credential if it's assigned to a key that looks like one on
+// a line of the form "key: value" or "key = value", if it's the password of
+// a URL, or if it's the whole value of a data key that looks like one.
+// Existing references in the data are escaped, so they aren't expanded.
+func ConfigMap(cm *corev1.ConfigMap) *Result {
+	c := &converter{vars: make(map[string]string), names: make(map[string]string)}
+	data := make(map[string]string, len(cm.Data))
+	for _, key := range sortedKeys(cm.Data) {
+		data[key] = c.convert(key, cm.Data[key])
+	}
+
+	cms := &v1alpha1.ConfigMapSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "ConfigMapSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cm.Name,
+			Namespace: cm.Namespace,
+			Labels:    cm.Labels,
+		},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Metadata: v1alpha1.EmbeddedObjectMeta{
+					Labels: cm.Labels,
+				},
+				Data:       data,
+				BinaryData: cm.BinaryData,
+			},
+		},
+	}
+	res := &Result{ConfigMapSecret: cms}
+	if len(c.vars) == 0 {
+		return res
+	}
+	name := cm.Name + SecretSuffix
+	cms.Spec.VarsFrom = []v1alpha1.VarsFromSource{{
+		SecretRef: &v1alpha1.SecretVarsSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		},
+	}}
+	res.Secret = &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cm.Namespace,
+			Labels:    cm.Labels,
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: c.vars,
+	}
+	return res
+}
+
+type converter struct {
+	vars  map[string]string // By var name.
+	names map[string]string // Var names by value, to reuse a var for the same credential.
+}
+
+// convert escapes the value of the data key and replaces its credentials with references.
+func (c *converter) convert(key, value string) string {
+	if v := strings.TrimSuffix(value, "\n"); credentialKey.MatchString(key) && !strings.Contains(v, "\n") && isCredential(v) {
+		return c.ref(key, v) + value[len(v):]
+	}
+	lines := strings.SplitAfter(value, "\n")
+	for i, line := range lines {
+		lines[i] = c.convertLine(key, line)
+	}
+	return strings.Join(lines, "")
+}
+
+func (c *converter) convertLine(key, line string) string {
+	body := strings.TrimSuffix(line, "\n")
+	eol := line[len(body):]
+	if m := assignment.FindStringSubmatch(body); m != nil && credentialKey.MatchString(m[2]) {
+		if v, quote, comment := splitValue(m[3]); isCredential(v) {
+			return escape(m[1]) + quote + c.ref(key+"_"+m[2], v) + quote + escape(comment+m[4]) + eol
+		}
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range userinfo.FindAllStringSubmatchIndex(body, -1) {
+		b.WriteString(escape(body[last:m[3]]))
+		b.WriteString(c.ref(key+"_password", body[m[4]:m[5]]))
+		last = m[5]
+	}
+	b.WriteString(escape(body[last:]))
+	return b.String() + eol
+}
+
+// ref returns a reference to a var holding the value, named after the key.
+func (c *converter) ref(key, value string) string {
+	name, ok := c.names[value]
+	if !ok {
+		name = c.varName(key)
+		c.vars[name] = value
+		c.names[value] = name
+	}
+	return "$(" + name + ")"
+}
+
+// varName returns an unused var name for the key.
+func (c *converter) varName(key string) string {
+	base := strings.Trim(invalidVarChars.ReplaceAllString(strings.ToUpper(key), "_"), "_")
+	if base == "" || '0' <= base[0] && base[0] <= '9' {
+		base = "VAR_" + base
+	}
+	name := base
+	for i := 2; ; i++ {
+		if _, ok := c.vars[name]; !ok {
+			return name
+		}
+		name = base + "_" + strconv.Itoa(i)
+	}
+}
+
+// splitValue splits an assigned value into its text, the quote around it,
+// and a trailing comment, if it's unquoted.
+func splitValue(s string) (text, quote, comment string) {
+	for _, q := range []string{`"`, `'`} {
+		if len(s) >= 2 && strings.HasPrefix(s, q) && strings.HasSuffix(s, q) && !strings.Contains(s[1:len(s)-1], q) {
+			return s[1 : len(s)-1], q, ""
+		}
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		text = strings.TrimRight(s[:i], " \t")
+		return text, "", s[len(text):]
+	}
+	return s, "", ""
+}
+
+// isCredential reports whether an assigned value may be a credential,
+// rather than e.g. empty, a boolean, or a reference to one.
+func isCredential(s string) bool {
+	switch strings.ToLower(s) {
+	case "", "~", "null", "none", "true", "false", "yes", "no", "on", "off":
+		return false
+	}
+	return !strings.HasPrefix(s, "$")
+}
+
+// escape escapes the text, so that it isn't expanded.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '$' && i+1 < len(s) && (s[i+1] == '$' || s[i+1] == '(') {
+			b.WriteString("$$")
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
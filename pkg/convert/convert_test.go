@@ -0,0 +1,135 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     map[string]string
+		wantData map[string]string
+		wantVars map[string]string
+	}{
+		{
+			name: "yaml",
+			data: map[string]string{
+				"config.yaml": "db:\n  user: app\n  password: \"hunter2\" \n  token: abc # rotated\n  auth: true\n",
+			},
+			wantData: map[string]string{
+				"config.yaml": "db:\n  user: app\n  password: \"$(CONFIG_YAML_PASSWORD)\" \n  token: $(CONFIG_YAML_TOKEN) # rotated\n  auth: true\n",
+			},
+			wantVars: map[string]string{
+				"CONFIG_YAML_PASSWORD": "hunter2",
+				"CONFIG_YAML_TOKEN":    "abc",
+			},
+		},
+		{
+			name: "ini",
+			data: map[string]string{
+				"app.ini": "[smtp]\nhost = mail\nsmtp_password = s3cret\n",
+			},
+			wantData: map[string]string{
+				"app.ini": "[smtp]\nhost = mail\nsmtp_password = $(APP_INI_SMTP_PASSWORD)\n",
+			},
+			wantVars: map[string]string{
+				"APP_INI_SMTP_PASSWORD": "s3cret",
+			},
+		},
+		{
+			name: "url",
+			data: map[string]string{
+				"dsn": "postgres://app:pa$$word@db:5432/app",
+			},
+			wantData: map[string]string{
+				"dsn": "postgres://app:$(DSN_PASSWORD)@db:5432/app",
+			},
+			wantVars: map[string]string{
+				"DSN_PASSWORD": "pa$$word",
+			},
+		},
+		{
+			name: "whole value",
+			data: map[string]string{
+				"api-key":  "xyz\n",
+				"settings": "key: value\n",
+			},
+			wantData: map[string]string{
+				"api-key":  "$(API_KEY)\n",
+				"settings": "key: value\n",
+			},
+			wantVars: map[string]string{
+				"API_KEY": "xyz",
+			},
+		},
+		{
+			name: "reused",
+			data: map[string]string{
+				"a.env": "DB_PASSWORD=same\n",
+				"b.env": "export PASSWORD=same\nOTHER_PASSWORD=${DB_PASSWORD}\n",
+			},
+			wantData: map[string]string{
+				"a.env": "DB_PASSWORD=$(A_ENV_DB_PASSWORD)\n",
+				"b.env": "export PASSWORD=$(A_ENV_DB_PASSWORD)\nOTHER_PASSWORD=${DB_PASSWORD}\n",
+			},
+			wantVars: map[string]string{
+				"A_ENV_DB_PASSWORD": "same",
+			},
+		},
+		{
+			name: "escaped",
+			data: map[string]string{
+				"run.sh": "echo $(date) $$ $HOME\n",
+			},
+			wantData: map[string]string{
+				"run.sh": "echo $$(date) $$$ $HOME\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", Labels: map[string]string{"app": "web"}},
+			Data:       tt.data,
+		}
+		res := ConfigMap(cm)
+		cms := res.ConfigMapSecret
+		if diff := cmp.Diff(tt.wantData, cms.Spec.Template.Data); diff != "" {
+			t.Errorf("%s: unexpected data (-want +got):\n%s", tt.name, diff)
+		}
+		if tt.wantVars == nil {
+			if res.Secret != nil || cms.Spec.VarsFrom != nil {
+				t.Errorf("%s: unexpected Secret: %v", tt.name, res.Secret)
+			}
+			tt.wantVars = map[string]string{}
+		} else {
+			if res.Secret == nil {
+				t.Fatalf("%s: missing Secret", tt.name)
+			}
+			if want, got := "app"+SecretSuffix, res.Secret.Name; want != got {
+				t.Errorf("%s: unexpected Secret name: want: %q; got: %q", tt.name, want, got)
+			}
+			if want, got := res.Secret.Name, cms.Spec.VarsFrom[0].SecretRef.Name; want != got {
+				t.Errorf("%s: unexpected vars source: want: %q; got: %q", tt.name, want, got)
+			}
+			if diff := cmp.Diff(tt.wantVars, res.Secret.StringData); diff != "" {
+				t.Errorf("%s: unexpected vars (-want +got):\n%s", tt.name, diff)
+			}
+		}
+		// The ConfigMapSecret must render the data of the ConfigMap.
+		for key, want := range tt.data {
+			got := expansion.Expand(cms.Spec.Template.Data[key], expansion.MappingFuncFor(tt.wantVars))
+			if want != got {
+				t.Errorf("%s: unexpected rendered %s: want: %q; got: %q", tt.name, key, want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ExternalSecretAPIVersion is the API version of converted ExternalSecrets.
+const ExternalSecretAPIVersion = "external-secrets.io/v1beta1"
+
+// A SecretStoreRef refers to the store from which a converted ExternalSecret
+// reads its data. It must use the kubernetes provider for the namespace of
+// the ConfigMapSecret, so that it reads the same Secrets.
+type SecretStoreRef struct {
+	Name string
+	Kind string // SecretStore or ClusterSecretStore. If empty, it's SecretStore.
+}
+
+// ExternalSecret converts a ConfigMapSecret to an ExternalSecret of the
+// External Secrets Operator that renders the same Secret, where semantics
+// allow, and returns warnings about what it can't convert.
+//
+// Variables from Secrets become the data of the ExternalSecret, and its v2
+// template references them. Literal variables and built-in variables other
+// than the cluster are inlined. Variables from ConfigMaps and
+// ClusterTrustBundles, Git sources, and key options can't be converted.
+// Templates of v2 are copied as is, but the template functions of the
+// External Secrets Operator differ from the controller's.
+func ExternalSecret(cms *v1alpha1.ConfigMapSecret, store SecretStoreRef) (*unstructured.Unstructured, []string) {
+	c := &esConverter{refs: map[string]string{
+		v1alpha1.NamespaceVar: escapeTemplate(cms.Namespace),
+		v1alpha1.NameVar:      escapeTemplate(cms.Name),
+	}}
+	spec := &cms.Spec
+
+	var data, dataFrom []interface{}
+	if !spec.DisableExpansion {
+		for i, src := range spec.VarsFrom {
+			if src.SecretRef == nil {
+				c.warnf("varsFrom[%d]: only Secrets can be read by the kubernetes provider", i)
+				continue
+			}
+			extract := map[string]interface{}{
+				"extract": map[string]interface{}{"key": src.SecretRef.Name},
+			}
+			if src.Prefix != "" {
+				extract["rewrite"] = []interface{}{map[string]interface{}{
+					"regexp": map[string]interface{}{"source": "(.*)", "target": src.Prefix + "$1"},
+				}}
+			}
+			if src.SecretRef.IsOptional() {
+				c.warnf("varsFrom[%d]: Secret %s is required by the ExternalSecret", i, src.SecretRef.Name)
+			}
+			dataFrom = append(dataFrom, extract)
+			c.dynamic = true
+		}
+		for i, v := range spec.Vars {
+			switch {
+			case v.SecretValue != nil:
+				data = append(data, map[string]interface{}{
+					"secretKey": v.Name,
+					"remoteRef": map[string]interface{}{"key": v.SecretValue.Name, "property": v.SecretValue.Key},
+				})
+				if v.SecretValue.Optional != nil && *v.SecretValue.Optional {
+					c.warnf("vars[%d]: Secret %s is required by the ExternalSecret", i, v.SecretValue.Name)
+				}
+				c.refs[v.Name] = templateRef(v.Name, v.Transforms...)
+			case v.ConfigMapValue != nil:
+				c.warnf("vars[%d]: ConfigMaps can't be read by the kubernetes provider", i)
+				delete(c.refs, v.Name)
+			case v.ClusterTrustBundleValue != nil:
+				c.warnf("vars[%d]: ClusterTrustBundles can't be read by the kubernetes provider", i)
+				delete(c.refs, v.Name)
+			default:
+				c.refs[v.Name] = c.expand("vars["+v.Name+"]", expansion.Default, v.Value)
+				if len(v.Transforms) > 0 {
+					c.warnf("vars[%d]: transforms of values can't be converted", i)
+				}
+			}
+		}
+	}
+	if spec.GitSource != nil {
+		c.warnf("gitSource: templates from Git can't be converted")
+	}
+	for _, opts := range spec.Template.KeyOptions {
+		opts.Verbatim = false // Verbatim keys are escaped.
+		if !reflect.DeepEqual(opts, v1alpha1.KeyOptions{}) {
+			c.warnf("template.keyOptions: key options can't be converted")
+			break
+		}
+	}
+	if spec.TemplateVersion == v1alpha1.TemplateVersionV2 && !spec.DisableExpansion {
+		c.warnf("templateVersion: v2 templates are copied as is, and their functions may differ")
+	}
+
+	var expander expansion.Expander = expansion.Default
+	if d := spec.Template.Delimiters; d != nil {
+		expander = expansion.Delims{Left: d.Left, Right: d.Right}
+	}
+	tmplData := make(map[string]interface{})
+	convert := func(key, text string) {
+		switch {
+		case spec.DisableExpansion, spec.Template.KeyOptions[key].Verbatim:
+			tmplData[key] = escapeTemplate(text)
+		case spec.TemplateVersion == v1alpha1.TemplateVersionV2:
+			tmplData[key] = text
+		default:
+			tmplData[key] = c.expand(key, expander, text)
+		}
+	}
+	for _, key := range sortedKeys(spec.Template.Data) {
+		convert(key, spec.Template.Data[key])
+	}
+	for _, key := range sortedBinaryKeys(spec.Template.BinaryData) {
+		if buf := spec.Template.BinaryData[key]; utf8.Valid(buf) {
+			convert(key, string(buf))
+		} else {
+			c.warnf("template.binaryData[%s]: non-UTF-8 data can't be converted", key)
+		}
+	}
+	for _, key := range sortedKeys(spec.Template.StringData) {
+		convert(key, spec.Template.StringData[key])
+	}
+
+	tmpl := map[string]interface{}{
+		"engineVersion": "v2",
+		"data":          tmplData,
+	}
+	meta := spec.Template.Metadata
+	if len(meta.Labels) > 0 || len(meta.Annotations) > 0 {
+		m := make(map[string]interface{})
+		if len(meta.Labels) > 0 {
+			m["labels"] = stringMap(meta.Labels)
+		}
+		if len(meta.Annotations) > 0 {
+			m["annotations"] = stringMap(meta.Annotations)
+		}
+		tmpl["metadata"] = m
+	}
+	secretName := meta.Name
+	if secretName == "" {
+		secretName = cms.Name
+	}
+	kind := store.Kind
+	if kind == "" {
+		kind = "SecretStore"
+	}
+	esSpec := map[string]interface{}{
+		"secretStoreRef": map[string]interface{}{"name": store.Name, "kind": kind},
+		"target": map[string]interface{}{
+			"name":           secretName,
+			"creationPolicy": "Owner",
+			"template":       tmpl,
+		},
+	}
+	if spec.RefreshInterval != nil {
+		esSpec["refreshInterval"] = spec.RefreshInterval.Duration.String()
+	}
+	if len(data) > 0 {
+		esSpec["data"] = data
+	}
+	if len(dataFrom) > 0 {
+		esSpec["dataFrom"] = dataFrom
+	}
+
+	es := &unstructured.Unstructured{Object: map[string]interface{}{"spec": esSpec}}
+	es.SetAPIVersion(ExternalSecretAPIVersion)
+	es.SetKind("ExternalSecret")
+	es.SetName(cms.Name)
+	es.SetNamespace(cms.Namespace)
+	es.SetLabels(cms.Labels)
+	return es, c.warnings
+}
+
+type esConverter struct {
+	refs     map[string]string // Template text of variable references, by var name.
+	dynamic  bool              // Whether undefined variables may be read from Secrets.
+	warnings []string
+}
+
+func (c *esConverter) warnf(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// placeholder replaces references while the text around them is escaped.
+const placeholder = "\x00"
+
+// expand converts the references of the expander in text to template text,
+// and escapes the rest of it.
+func (c *esConverter) expand(name string, expander expansion.Expander, text string) string {
+	if strings.Contains(text, placeholder) {
+		c.warnf("%s: text with NUL bytes is copied literally", name)
+		return escapeTemplate(text)
+	}
+	unresolved := expander.MappingFuncFor()
+	var refs []string
+	out := expander.Expand(text, func(name string) string {
+		ref, ok := c.refs[name]
+		switch {
+		case ok:
+		case c.dynamic:
+			ref = templateRef(name)
+		default:
+			ref = escapeTemplate(unresolved(name))
+		}
+		refs = append(refs, ref)
+		return placeholder
+	})
+	parts := strings.Split(escapeTemplate(out), placeholder)
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString(refs[i-1])
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// templateRef returns a reference to the variable in a Go template, piped
+// through the functions of the transforms, which are also Sprig functions.
+func templateRef(name string, transforms ...v1alpha1.Transform) string {
+	ref := "." + name
+	if !token.IsIdentifier(name) {
+		ref = fmt.Sprintf("index . %q", name)
+	}
+	for _, t := range transforms {
+		ref += " | " + string(t.Func)
+		if t.Func == v1alpha1.TransformIndent {
+			ref += " " + strconv.Itoa(int(t.Spaces))
+		}
+	}
+	return "{{ " + ref + " }}"
+}
+
+// escapeTemplate escapes the text, so that it's literal in a Go template.
+func escapeTemplate(s string) string {
+	return strings.ReplaceAll(s, "{{", `{{ "{{" }}`)
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func sortedBinaryKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
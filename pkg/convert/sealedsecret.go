@@ -0,0 +1,169 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SealedSecretAPIVersion is the API version of converted SealedSecrets.
+const SealedSecretAPIVersion = "bitnami.com/v1alpha1"
+
+// A SealingScope is the scope in which a SealedSecret can be unsealed.
+type SealingScope string
+
+// Sealing scopes, as in kubeseal.
+const (
+	// StrictScope seals a Secret for its name and namespace.
+	StrictScope SealingScope = "strict"
+	// NamespaceWideScope seals a Secret for any name in its namespace.
+	NamespaceWideScope SealingScope = "namespace-wide"
+	// ClusterWideScope seals a Secret for any name and namespace.
+	ClusterWideScope SealingScope = "cluster-wide"
+)
+
+// sessionKeySize is the size of the AES-256 key of each sealed value.
+const sessionKeySize = 32
+
+// ParseSealingCert returns the public key of a PEM-encoded certificate of
+// the Sealed Secrets controller, e.g. as fetched by kubeseal --fetch-cert.
+func ParseSealingCert(buf []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	return key, nil
+}
+
+// SealedSecret converts a Secret, e.g. one rendered by a ConfigMapSecret, to
+// a Bitnami SealedSecret whose values are sealed with the public key of the
+// Sealed Secrets controller, in the format of kubeseal. Unlike the
+// ConfigMapSecret, it doesn't follow changes of its sources. The
+// annotations and labels set by the controller, other than the
+// KeyOptionsAnnotation, aren't copied.
+func SealedSecret(secret *corev1.Secret, key *rsa.PublicKey, scope SealingScope, rand io.Reader) (*unstructured.Unstructured, error) {
+	var label string
+	annotations := make(map[string]string)
+	switch scope {
+	case StrictScope, "":
+		label = secret.Namespace + "/" + secret.Name
+	case NamespaceWideScope:
+		label = secret.Namespace
+		annotations["sealedsecrets.bitnami.com/namespace-wide"] = "true"
+	case ClusterWideScope:
+		annotations["sealedsecrets.bitnami.com/cluster-wide"] = "true"
+	default:
+		return nil, fmt.Errorf("unsupported scope: %q", scope)
+	}
+
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	for k, v := range secret.StringData {
+		data[k] = []byte(v)
+	}
+	encrypted := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		buf, err := hybridEncrypt(rand, key, v, []byte(label))
+		if err != nil {
+			return nil, fmt.Errorf("unable to seal key %s: %w", k, err)
+		}
+		encrypted[k] = base64.StdEncoding.EncodeToString(buf)
+	}
+
+	meta := make(map[string]interface{})
+	if labels := copiedMetadata(secret.Labels); len(labels) > 0 {
+		meta["labels"] = labels
+	}
+	if annotations := copiedMetadata(secret.Annotations); len(annotations) > 0 {
+		meta["annotations"] = annotations
+	}
+	tmpl := map[string]interface{}{"metadata": meta}
+	if secret.Type != "" {
+		tmpl["type"] = string(secret.Type)
+	}
+
+	ss := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"encryptedData": encrypted,
+			"template":      tmpl,
+		},
+	}}
+	ss.SetAPIVersion(SealedSecretAPIVersion)
+	ss.SetKind("SealedSecret")
+	ss.SetName(secret.Name)
+	ss.SetNamespace(secret.Namespace)
+	if len(annotations) > 0 {
+		ss.SetAnnotations(annotations)
+	}
+	return ss, nil
+}
+
+// copiedMetadata returns the labels or annotations of a Secret without the
+// ones set by the controller, except the KeyOptionsAnnotation.
+func copiedMetadata(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for k, v := range m {
+		if strings.HasPrefix(k, v1alpha1.Prefix) && k != v1alpha1.KeyOptionsAnnotation {
+			continue
+		}
+		if k == corev1.LastAppliedConfigAnnotation {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// hybridEncrypt encrypts the plaintext as kubeseal does: with a random
+// AES-256-GCM session key, which is encrypted with RSA-OAEP and the label,
+// and prefixed to the ciphertext with its 2-byte big-endian length. As the
+// session key is never reused, the nonce is zero.
+func hybridEncrypt(rand io.Reader, key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := io.ReadFull(rand, sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rand, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 2, 2+len(rsaCiphertext)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(rsaCiphertext)))
+	out = append(out, rsaCiphertext...)
+	return aead.Seal(out, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
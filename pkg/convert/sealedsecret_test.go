@@ -0,0 +1,140 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// unseal decrypts a value of a SealedSecret, as the Sealed Secrets
+// controller does.
+func unseal(t *testing.T, key *rsa.PrivateKey, value, label string) string {
+	t.Helper()
+	buf, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n := int(binary.BigEndian.Uint16(buf))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, buf[2:2+n], []byte(label))
+	if err != nil {
+		t.Fatalf("unable to decrypt session key: %v", err)
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), buf[2+n:], nil)
+	if err != nil {
+		t.Fatalf("unable to decrypt value: %v", err)
+	}
+	return string(plaintext)
+}
+
+func TestSealedSecret(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pub, err := ParseSealingCert(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ParseSealingCert([]byte("nope")); err == nil {
+		t.Error("expected error for invalid certificate")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "prod",
+			Labels:    map[string]string{"team": "a", v1alpha1.OwnerUIDLabel: "uid"},
+			Annotations: map[string]string{
+				v1alpha1.KeyOptionsAnnotation:      "{}",
+				v1alpha1.LastAppliedHashAnnotation: "hash",
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+		StringData: map[string]string{"user": "app"},
+	}
+	for _, tt := range []struct {
+		scope       SealingScope
+		label       string
+		annotations map[string]string
+	}{
+		{StrictScope, "prod/app", nil},
+		{NamespaceWideScope, "prod", map[string]string{"sealedsecrets.bitnami.com/namespace-wide": "true"}},
+		{ClusterWideScope, "", map[string]string{"sealedsecrets.bitnami.com/cluster-wide": "true"}},
+	} {
+		ss, err := SealedSecret(secret, pub, tt.scope, rand.Reader)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.scope, err)
+		}
+		if ss.GetAPIVersion() != SealedSecretAPIVersion || ss.GetKind() != "SealedSecret" || ss.GetName() != "app" || ss.GetNamespace() != "prod" {
+			t.Errorf("%s: unexpected object: %s %s %s/%s", tt.scope, ss.GetAPIVersion(), ss.GetKind(), ss.GetNamespace(), ss.GetName())
+		}
+		if diff := cmp.Diff(tt.annotations, ss.GetAnnotations()); diff != "" {
+			t.Errorf("%s: unexpected annotations (-want +got):\n%s", tt.scope, diff)
+		}
+		encrypted, _, _ := unstructured.NestedStringMap(ss.Object, "spec", "encryptedData")
+		got := make(map[string]string)
+		for k, v := range encrypted {
+			got[k] = unseal(t, key, v, tt.label)
+		}
+		if diff := cmp.Diff(map[string]string{"password": "hunter2", "user": "app"}, got); diff != "" {
+			t.Errorf("%s: unexpected data (-want +got):\n%s", tt.scope, diff)
+		}
+	}
+
+	ss, err := SealedSecret(secret, pub, StrictScope, rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{"team": "a"},
+			"annotations": map[string]interface{}{v1alpha1.KeyOptionsAnnotation: "{}"},
+		},
+		"type": "Opaque",
+	}
+	if diff := cmp.Diff(want, ss.Object["spec"].(map[string]interface{})["template"]); diff != "" {
+		t.Errorf("unexpected template (-want +got):\n%s", diff)
+	}
+	if _, err := SealedSecret(secret, pub, "everywhere", rand.Reader); err == nil {
+		t.Error("expected error for unsupported scope")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crds
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
+	"github.com/prometheus/client_golang/prometheus"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var missingFields = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "configmapsecret_controller_crd_missing_fields",
+	Help: "Number of fields of the bundled ConfigMapSecret CRD schema missing from the installed CRD schema, by version. The API server silently prunes them.",
+}, []string{"version"})
+
+func init() {
+	metrics.Registry.MustRegister(missingFields)
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get,resourceNames=configmapsecrets.secrets.mz.com
+
+// Check compares the schema of the installed CRD with that of the bundled CRD
+// and logs the fields of each bundled version that are missing from the
+// installed schema, e.g. because the CRD wasn't upgraded with the controller.
+// The API server silently prunes such fields when objects are written, so the
+// controller never sees them.
+//
+// The number of missing fields is exported as a metric. An error is returned
+// only if the check can't be made.
+func Check(ctx context.Context, c client.Reader, log logr.Logger) error {
+	want, err := schema.CRD()
+	if err != nil {
+		return fmt.Errorf("invalid bundled CRD: %w", err)
+	}
+	got := &apiextensionsv1.CustomResourceDefinition{}
+	if err := c.Get(ctx, types.NamespacedName{Name: want.Name}, got); err != nil {
+		return fmt.Errorf("unable to get CRD: %w", err)
+	}
+	log = log.WithValues("crd", want.Name)
+	for _, v := range want.Spec.Versions {
+		missing := compareVersion(got, v)
+		missingFields.WithLabelValues(v.Name).Set(float64(len(missing)))
+		if len(missing) > 0 {
+			log.Info("Installed CRD schema is older than the controller; missing fields are pruned", "version", v.Name, "missingFields", missing)
+		}
+	}
+	return nil
+}
+
+// compareVersion returns the fields of the schema of the bundled version
+// that are missing from the installed CRD.
+func compareVersion(installed *apiextensionsv1.CustomResourceDefinition, bundled apiextensionsv1.CustomResourceDefinitionVersion) []string {
+	var got *apiextensionsv1.JSONSchemaProps
+	for _, v := range installed.Spec.Versions {
+		if v.Name == bundled.Name && v.Schema != nil {
+			got = v.Schema.OpenAPIV3Schema
+		}
+	}
+	if bundled.Schema == nil {
+		return nil
+	}
+	return compareSchema("", bundled.Schema.OpenAPIV3Schema, got)
+}
+
+// compareSchema returns the paths of the fields of want that are missing from got.
+// Missing fields are reported without their subfields.
+func compareSchema(path string, want, got *apiextensionsv1.JSONSchemaProps) []string {
+	if want == nil {
+		return nil
+	}
+	if got != nil && got.XPreserveUnknownFields != nil && *got.XPreserveUnknownFields {
+		return nil // Nothing is pruned.
+	}
+	if got == nil {
+		got = &apiextensionsv1.JSONSchemaProps{}
+	}
+	var missing []string
+	names := make([]string, 0, len(want.Properties))
+	for name := range want.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := name
+		if path != "" {
+			field = path + "." + name
+		}
+		g, ok := got.Properties[name]
+		if !ok {
+			missing = append(missing, field)
+			continue
+		}
+		w := want.Properties[name]
+		missing = append(missing, compareSchema(field, &w, &g)...)
+	}
+	if want.Items != nil && want.Items.Schema != nil && got.Items != nil {
+		missing = append(missing, compareSchema(path+"[*]", want.Items.Schema, got.Items.Schema)...)
+	}
+	if want.AdditionalProperties != nil && want.AdditionalProperties.Schema != nil && got.AdditionalProperties != nil {
+		missing = append(missing, compareSchema(path+"[*]", want.AdditionalProperties.Schema, got.AdditionalProperties.Schema)...)
+	}
+	return missing
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crds
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
+)
+
+func TestCompareVersion(t *testing.T) {
+	bundled, err := schema.CRD()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	version := bundled.Spec.Versions[0]
+
+	installed := bundled.DeepCopy()
+	if got := compareVersion(installed, version); len(got) != 0 {
+		t.Errorf("unexpected missing fields of an up to date CRD: %v", got)
+	}
+
+	spec := installed.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"]
+	delete(spec.Properties, "templateVersion")
+	template := spec.Properties["template"]
+	delete(template.Properties, "stringData")
+	spec.Properties["template"] = template
+	installed.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"] = spec
+
+	want := []string{"spec.template.stringData", "spec.templateVersion"}
+	if diff := cmp.Diff(want, compareVersion(installed, version)); diff != "" {
+		t.Errorf("unexpected missing fields (-want +got):\n%s", diff)
+	}
+
+	installed.Spec.Versions[0].Name = "v1alpha0"
+	want = []string{"apiVersion", "kind", "metadata", "spec", "status"}
+	if diff := cmp.Diff(want, compareVersion(installed, version)); diff != "" {
+		t.Errorf("unexpected missing fields of a missing version (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	if err := Check(ctx, &crdClient{}, logr.Discard()); err == nil {
+		t.Errorf("expected error for a missing CRD")
+	}
+	c := &crdClient{}
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Check(ctx, c, logr.Discard()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package crds installs, upgrades, and checks the CustomResourceDefinition
+// bundled with the controller.
+package crds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PollInterval is the interval at which an installed CRD is polled until
+// it's established.
+var PollInterval = time.Second
+
+// Install creates the bundled CRD, or updates the installed one if it differs,
+// and waits until it's established.
+//
+// The installed CRD is never downgraded: if it has a version that isn't
+// bundled, e.g. because a newer controller installed it, or if a stored
+// version isn't bundled, an error is returned and it's left unchanged.
+//
+// Replicas may install the CRD concurrently: a write that conflicts with
+// another replica's is retried.
+func Install(ctx context.Context, c client.Client, log logr.Logger) error {
+	want, err := schema.CRD()
+	if err != nil {
+		return fmt.Errorf("invalid bundled CRD: %w", err)
+	}
+	if want.Spec.Conversion == nil { // Defaulted by the server.
+		want.Spec.Conversion = &apiextensionsv1.CustomResourceConversion{Strategy: apiextensionsv1.NoneConverter}
+	}
+	log = log.WithValues("crd", want.Name)
+
+	conflict := func(err error) bool { return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) }
+	if err := retry.OnError(retry.DefaultRetry, conflict, func() error {
+		return apply(ctx, c, log, want.DeepCopy())
+	}); err != nil {
+		return err
+	}
+	return waitEstablished(ctx, c, want.Name)
+}
+
+// apply creates the bundled CRD, or updates the installed one if it differs.
+func apply(ctx context.Context, c client.Client, log logr.Logger, want *apiextensionsv1.CustomResourceDefinition) error {
+	got := &apiextensionsv1.CustomResourceDefinition{}
+	switch err := c.Get(ctx, types.NamespacedName{Name: want.Name}, got); {
+	case apierrors.IsNotFound(err):
+		log.Info("Creating CRD")
+		if err := c.Create(ctx, want); err != nil {
+			return fmt.Errorf("unable to create CRD: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("unable to get CRD: %w", err)
+	default:
+		if err := checkUpgrade(got, want); err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(got.Spec, want.Spec) {
+			log.Info("CRD is up to date")
+			break
+		}
+		log.Info("Updating CRD")
+		got.Spec = want.Spec
+		if err := c.Update(ctx, got); err != nil {
+			return fmt.Errorf("unable to update CRD: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkUpgrade returns an error if replacing the spec of the installed CRD
+// with that of the bundled CRD would remove a version.
+func checkUpgrade(installed, bundled *apiextensionsv1.CustomResourceDefinition) error {
+	versions := make(map[string]bool)
+	for _, v := range bundled.Spec.Versions {
+		versions[v.Name] = true
+	}
+	for _, v := range installed.Spec.Versions {
+		if !versions[v.Name] {
+			return fmt.Errorf("installed CRD %s has version %s, which isn't bundled; refusing to downgrade", installed.Name, v.Name)
+		}
+	}
+	for _, v := range installed.Status.StoredVersions {
+		if !versions[v] {
+			return fmt.Errorf("installed CRD %s has stored version %s, which isn't bundled; refusing to downgrade", installed.Name, v)
+		}
+	}
+	return nil
+}
+
+func waitEstablished(ctx context.Context, c client.Client, name string) error {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, crd); err != nil {
+			return fmt.Errorf("unable to get CRD: %w", err)
+		}
+		for _, cond := range crd.Status.Conditions {
+			if cond.Type == apiextensionsv1.NamesAccepted && cond.Status == apiextensionsv1.ConditionFalse {
+				return fmt.Errorf("CRD %s names not accepted: %s", name, cond.Message)
+			}
+			if cond.Type == apiextensionsv1.Established && cond.Status == apiextensionsv1.ConditionTrue {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("CRD %s not established: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
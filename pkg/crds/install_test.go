@@ -0,0 +1,151 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crds
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/machinezone/configmapsecrets/pkg/schema"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A crdClient stores a single CRD, which is established when it's written.
+type crdClient struct {
+	client.Client
+	crd       *apiextensionsv1.CustomResourceDefinition
+	writes    int
+	pending   bool // Never established.
+	conflicts int  // Number of writes that conflict.
+}
+
+func (c *crdClient) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	if c.crd == nil || c.crd.Name != key.Name {
+		return apierrors.NewNotFound(apiextensionsv1.Resource("customresourcedefinitions"), key.Name)
+	}
+	c.crd.DeepCopyInto(obj.(*apiextensionsv1.CustomResourceDefinition))
+	return nil
+}
+
+func (c *crdClient) write(obj client.Object) {
+	c.writes++
+	c.crd = obj.(*apiextensionsv1.CustomResourceDefinition).DeepCopy()
+	if !c.pending {
+		c.crd.Status.Conditions = []apiextensionsv1.CustomResourceDefinitionCondition{
+			{Type: apiextensionsv1.Established, Status: apiextensionsv1.ConditionTrue},
+		}
+	}
+}
+
+func (c *crdClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		c.write(obj) // Created by another replica.
+		return apierrors.NewAlreadyExists(apiextensionsv1.Resource("customresourcedefinitions"), obj.GetName())
+	}
+	c.write(obj)
+	return nil
+}
+
+func (c *crdClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		return apierrors.NewConflict(apiextensionsv1.Resource("customresourcedefinitions"), obj.GetName(), errors.New("modified"))
+	}
+	c.write(obj)
+	return nil
+}
+
+func TestInstall(t *testing.T) {
+	ctx := context.Background()
+	c := &crdClient{}
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.writes != 1 {
+		t.Errorf("expected CRD to be created")
+	}
+
+	// Unchanged.
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.writes != 1 {
+		t.Errorf("unexpected write of an up to date CRD")
+	}
+
+	// Changed.
+	c.crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Description = "old"
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.writes != 2 {
+		t.Errorf("expected CRD to be updated")
+	}
+	if c.crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Description == "old" {
+		t.Errorf("CRD wasn't updated")
+	}
+
+	// Newer.
+	c.crd.Spec.Versions = append(c.crd.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1beta1"})
+	if err := Install(ctx, c, logr.Discard()); err == nil || !strings.Contains(err.Error(), "downgrade") {
+		t.Errorf("expected downgrade error; got: %v", err)
+	}
+	if c.writes != 2 {
+		t.Errorf("unexpected write of a newer CRD")
+	}
+}
+
+func TestInstallConflict(t *testing.T) {
+	ctx := context.Background()
+	c := &crdClient{conflicts: 1}
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.writes != 1 {
+		t.Errorf("unexpected write of a CRD created by another replica")
+	}
+
+	c.crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Description = "old"
+	c.conflicts = 2
+	if err := Install(ctx, c, logr.Discard()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.writes != 2 || c.crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Description == "old" {
+		t.Errorf("expected CRD to be updated after conflicts")
+	}
+}
+
+func TestCheckUpgrade(t *testing.T) {
+	bundled, err := schema.CRD()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	installed := bundled.DeepCopy()
+	if err := checkUpgrade(installed, bundled); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	installed.Status.StoredVersions = []string{"v1alpha0", "v1alpha1"}
+	if err := checkUpgrade(installed, bundled); err == nil {
+		t.Errorf("expected error for a stored version that isn't bundled")
+	}
+}
+
+func TestInstallNotEstablished(t *testing.T) {
+	defer func(d time.Duration) { PollInterval = d }(PollInterval)
+	PollInterval = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Install(ctx, &crdClient{pending: true}, logr.Discard()); err == nil {
+		t.Errorf("expected error for a CRD that isn't established")
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build faultinject
+// +build faultinject
+
+// Package faultinject wraps a client to fail a fraction of its requests, so
+// that tests can check that a controller converges despite conflicts with other
+// writers, API server timeouts, and objects deleted between requests.
+//
+// It's only built with the faultinject build tag, so that it can't be linked
+// into a release binary by accident.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// A Fault is a kind of injected failure.
+type Fault string
+
+const (
+	// Conflict fails an update as if another writer updated the object first,
+	// or a create as if another writer created it first. The request isn't made.
+	Conflict Fault = "Conflict"
+	// Timeout fails a request with a server timeout. A write is made before
+	// it fails, since the outcome of a write that times out is unknown to the
+	// client, and the harder case is that it was applied.
+	Timeout Fault = "Timeout"
+	// NotFound fails a get as if the object was deleted before it, although
+	// it wasn't, and a delete as if another writer deleted the object first,
+	// which it does.
+	NotFound Fault = "NotFound"
+)
+
+// errInjected is the cause of injected failures.
+var errInjected = errors.New("injected fault")
+
+// Rates are the fractions of eligible requests that fail with each fault. Their
+// sum must be at most 1.
+type Rates struct {
+	Conflict float64
+	Timeout  float64
+	NotFound float64
+}
+
+// A Client is a client whose requests fail at random at the rates of each
+// fault. It's safe for concurrent use.
+type Client struct {
+	client.Client
+
+	mu       sync.Mutex
+	rates    Rates
+	rand     *rand.Rand
+	injected map[Fault]int
+}
+
+// New returns a client that wraps c and fails its requests at the rates. The
+// faults are chosen by a pseudo-random source with the seed, so that a failing
+// sequence of requests can be reproduced.
+func New(c client.Client, rates Rates, seed int64) *Client {
+	return &Client{
+		Client:   c,
+		rates:    rates,
+		rand:     rand.New(rand.NewSource(seed)),
+		injected: make(map[Fault]int),
+	}
+}
+
+// SetRates sets the rates of the faults, e.g. to zero to let the controller
+// converge at the end of a test.
+func (c *Client) SetRates(rates Rates) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rates = rates
+}
+
+// Injected returns the number of injected failures of each fault.
+func (c *Client) Injected() map[Fault]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := make(map[Fault]int, len(c.injected))
+	for f, n := range c.injected {
+		m[f] = n
+	}
+	return m
+}
+
+// fault returns the fault with which a request that's eligible for the faults
+// fails, or an empty fault if it doesn't.
+func (c *Client) fault(faults ...Fault) Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.rand.Float64()
+	for _, f := range faults {
+		var rate float64
+		switch f {
+		case Conflict:
+			rate = c.rates.Conflict
+		case Timeout:
+			rate = c.rates.Timeout
+		case NotFound:
+			rate = c.rates.NotFound
+		}
+		if p < rate {
+			c.injected[f]++
+			return f
+		}
+		p -= rate
+	}
+	return ""
+}
+
+// resource returns the resource of obj for error messages. It's the lowercase
+// plural of its kind, which is correct for the kinds of the controller.
+func (c *Client) resource(obj client.Object) schema.GroupResource {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return schema.GroupResource{}
+	}
+	return schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind) + "s"}
+}
+
+func timeoutError() error {
+	return apierrors.NewTimeoutError(errInjected.Error(), 1)
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	switch c.fault(Timeout, NotFound) {
+	case Timeout:
+		return timeoutError()
+	case NotFound:
+		return apierrors.NewNotFound(c.resource(obj), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if c.fault(Timeout) == Timeout {
+		return timeoutError()
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch c.fault(Conflict, Timeout) {
+	case Conflict:
+		return apierrors.NewAlreadyExists(c.resource(obj), obj.GetName())
+	case Timeout:
+		if err := c.Client.Create(ctx, obj, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	switch c.fault(Conflict, Timeout) {
+	case Conflict:
+		return apierrors.NewConflict(c.resource(obj), obj.GetName(), errInjected)
+	case Timeout:
+		if err := c.Client.Update(ctx, obj, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	switch c.fault(Conflict, Timeout) {
+	case Conflict:
+		return apierrors.NewConflict(c.resource(obj), obj.GetName(), errInjected)
+	case Timeout:
+		if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	switch c.fault(Timeout, NotFound) {
+	case Timeout:
+		if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	case NotFound:
+		if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+			return err
+		}
+		return apierrors.NewNotFound(c.resource(obj), obj.GetName())
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{c: c, w: c.Client.Status()}
+}
+
+// A statusWriter fails updates of the status subresource like those of objects.
+type statusWriter struct {
+	c *Client
+	w client.StatusWriter
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	switch w.c.fault(Conflict, Timeout) {
+	case Conflict:
+		return apierrors.NewConflict(w.c.resource(obj), obj.GetName(), errInjected)
+	case Timeout:
+		if err := w.w.Update(ctx, obj, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	}
+	return w.w.Update(ctx, obj, opts...)
+}
+
+func (w *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	switch w.c.fault(Conflict, Timeout) {
+	case Conflict:
+		return apierrors.NewConflict(w.c.resource(obj), obj.GetName(), errInjected)
+	case Timeout:
+		if err := w.w.Patch(ctx, obj, patch, opts...); err != nil {
+			return err
+		}
+		return timeoutError()
+	}
+	return w.w.Patch(ctx, obj, patch, opts...)
+}
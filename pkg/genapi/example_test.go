@@ -0,0 +1,132 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package genapi_test
+
+import (
+	"fmt"
+	"go/constant"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/genapi"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// This example generates the markdown documentation of the API types.
+// It isn't run by tests, because loading the package depends on the toolchain.
+func ExampleWriteMarkdown() {
+	pkg, err := genapi.ParsePackage("github.com/machinezone/configmapsecrets/pkg/api/v1alpha1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := genapi.WriteMarkdown(os.Stdout, pkg, genapi.WithScheme(scheme)); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// This example describes the constants of a package as JSON.
+func ExampleWriteJSON() {
+	pkg := &genapi.Package{
+		Constants: map[string]genapi.Constant{
+			"TemplateVersion": {
+				Name: "TemplateVersion",
+				Doc:  "TemplateVersion is the version of a template language.",
+				Values: []genapi.Value{{
+					Name:  "TemplateVersionV1",
+					Doc:   "TemplateVersionV1 expands $(VAR_NAME) references.",
+					Value: constant.MakeString("v1"),
+				}},
+			},
+		},
+	}
+	if err := genapi.WriteJSON(os.Stdout, pkg); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// {
+	//   "constants": [
+	//     {
+	//       "name": "TemplateVersion",
+	//       "doc": "TemplateVersion is the version of a template language.",
+	//       "values": [
+	//         {
+	//           "name": "TemplateVersionV1",
+	//           "value": "v1",
+	//           "doc": "TemplateVersionV1 expands $(VAR_NAME) references."
+	//         }
+	//       ]
+	//     }
+	//   ]
+	// }
+}
+
+// This example checks whether a generated file is up to date.
+func ExampleVerify() {
+	dir, err := os.MkdirTemp("", "genapi")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.md")
+	if err := os.WriteFile(path, []byte("# API\n"), 0o644); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	drift, err := genapi.Verify(path, []byte("# API\n"))
+	fmt.Println(drift, err)
+
+	drift, err = genapi.Verify(path, []byte("# API v2\n"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(strings.ReplaceAll(drift.Diff, dir, "dir"))
+	// Output:
+	// <nil> <nil>
+	// --- dir/api.md
+	// +++ dir/api.md (generated)
+	// @@ -1,1 +1,1 @@
+	// -# API
+	// +# API v2
+}
+
+// This example documents the annotation and label keys of a package.
+func ExampleWriteMarkdown_keys() {
+	pkg := &genapi.Package{
+		Keys: []genapi.Value{{
+			Name:  "DebugAnnotation",
+			Doc:   "DebugAnnotation enables render traces.",
+			Value: constant.MakeString(v1alpha1.DebugAnnotation),
+		}},
+	}
+	if err := genapi.WriteMarkdown(os.Stdout, pkg); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// # API
+	//
+	// **Note:** This document is generated from code and comments. Do not edit it directly.
+	//
+	// ## Table of Contents
+	// * [Annotations and Labels](#annotations-and-labels)
+	//
+	// ## Annotations and Labels
+	//
+	// | Name | Key | Description |
+	// | ---- | --- | ----------- |
+	// | DebugAnnotation | secrets.mz.com/debug | DebugAnnotation enables render traces. |
+	//
+	// [Back to TOC](#table-of-contents)
+}
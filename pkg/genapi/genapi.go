@@ -101,14 +101,23 @@ func pkgGroupVersion(pkg *Package, opt *option) (schema.GroupVersion, bool) {
 	return schema.GroupVersion{}, false
 }
 
+// keysSection is the name of the section of annotations and labels.
+const keysSection = "Annotations and Labels"
+
 func printTOC(w io.Writer, pkg *Package) {
 	fmt.Fprintf(w, "\n## Table of Contents\n")
+	if len(pkg.Keys) > 0 {
+		fmt.Fprintf(w, "* %s\n", mdSectionLink(keysSection))
+	}
 	for _, name := range sortedNames(pkg) {
 		fmt.Fprintf(w, "* %s\n", mdSectionLink(name))
 	}
 }
 
 func printTypes(w io.Writer, pkg *Package, opt *option) {
+	if len(pkg.Keys) > 0 {
+		printKeys(w, pkg.Keys)
+	}
 	for _, name := range sortedNames(pkg) {
 		if s, ok := pkg.Structs[name]; ok {
 			printStruct(w, pkg, s, opt)
@@ -150,6 +159,17 @@ func printConst(w io.Writer, pkg *Package, c Constant) {
 	fmt.Fprintln(w, "[Back to TOC](#table-of-contents)")
 }
 
+func printKeys(w io.Writer, keys []Value) {
+	fmt.Fprintf(w, "\n## %s\n\n", keysSection)
+	fmt.Fprintln(w, "| Name | Key | Description |")
+	fmt.Fprintln(w, "| ---- | --- | ----------- |")
+	for _, v := range keys {
+		fmt.Fprintln(w, "|", v.Name, "|", constant.StringVal(v.Value), "|", mdDoc(v.Doc), "|")
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "[Back to TOC](#table-of-contents)")
+}
+
 func sortedNames(pkg *Package) []string {
 	var names []string
 	for name := range pkg.Structs {
@@ -244,6 +264,9 @@ type Package struct {
 
 	Constants map[string]Constant
 	Structs   map[string]Struct
+	// Keys are the exported string constants whose names end in "Annotation"
+	// or "Label", sorted by name.
+	Keys []Value
 }
 
 // ParsePackage parses the package in the given path.
@@ -294,6 +317,7 @@ func ParsePackage(path string) (*Package, error) {
 		DocPkg:    pkg.DocPkg,
 		Constants: constants,
 		Structs:   structs,
+		Keys:      keyValues(scope, pkg.DocPkg.Consts),
 	}, nil
 }
 
@@ -341,6 +365,41 @@ func constValues(scope *types.Scope, consts []*doc.Value) map[*types.Named][]Val
 	return cvs
 }
 
+// keyValues returns the values of the exported string constants whose names
+// end in "Annotation" or "Label", which are annotation and label keys, sorted
+// by name.
+func keyValues(scope *types.Scope, consts []*doc.Value) []Value {
+	var keys []Value
+	for _, v := range consts {
+		docWrap := fmtRawDoc(v.Doc)
+		for _, s := range v.Decl.Specs {
+			spec, ok := s.(*ast.ValueSpec)
+			if !ok {
+				continue
+			}
+			doc := fmtRawDoc(spec.Doc.Text())
+			if doc == "" {
+				doc = docWrap
+			}
+			for _, n := range spec.Names {
+				if !n.IsExported() || !(strings.HasSuffix(n.Name, "Annotation") || strings.HasSuffix(n.Name, "Label")) {
+					continue
+				}
+				obj, ok := scope.Lookup(n.Name).(*types.Const)
+				if !ok || obj.Val().Kind() != constant.String {
+					continue
+				}
+				if _, ok := obj.Type().(*types.Basic); !ok {
+					continue // An enum value, documented with its type.
+				}
+				keys = append(keys, Value{Doc: doc, Name: n.Name, Value: obj.Val()})
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
 // A Struct represents a struct.
 type Struct struct {
 	Name   string
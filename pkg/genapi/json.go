@@ -0,0 +1,113 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package genapi
+
+import (
+	"encoding/json"
+	"go/constant"
+	"go/types"
+	"io"
+)
+
+type jsonAPI struct {
+	GroupVersion string         `json:"groupVersion,omitempty"`
+	Keys         []jsonKey      `json:"keys,omitempty"`
+	Types        []jsonType     `json:"types,omitempty"`
+	Constants    []jsonConstant `json:"constants,omitempty"`
+}
+
+type jsonKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Doc  string `json:"doc,omitempty"`
+}
+
+type jsonType struct {
+	Name   string      `json:"name"`
+	Doc    string      `json:"doc,omitempty"`
+	Kind   string      `json:"kind,omitempty"`
+	Fields []jsonField `json:"fields"`
+}
+
+type jsonField struct {
+	Name     string `json:"name"`
+	Doc      string `json:"doc,omitempty"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+type jsonConstant struct {
+	Name   string      `json:"name"`
+	Doc    string      `json:"doc,omitempty"`
+	Values []jsonValue `json:"values"`
+}
+
+type jsonValue struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	Doc   string      `json:"doc,omitempty"`
+}
+
+// WriteJSON writes the API of pkg as indented JSON to w. It describes the
+// same keys, types, and constants as WriteMarkdown.
+func WriteJSON(w io.Writer, pkg *Package, options ...Option) error {
+	o := &option{}
+	for _, opt := range options {
+		opt.apply(o)
+	}
+	var api jsonAPI
+	if gv, ok := pkgGroupVersion(pkg, o); ok {
+		api.GroupVersion = gv.String()
+	}
+	for _, v := range pkg.Keys {
+		api.Keys = append(api.Keys, jsonKey{
+			Name: v.Name,
+			Key:  constant.StringVal(v.Value),
+			Doc:  v.Doc,
+		})
+	}
+	for _, name := range sortedNames(pkg) {
+		if s, ok := pkg.Structs[name]; ok {
+			t := jsonType{Name: s.Name, Doc: s.Doc, Fields: []jsonField{}}
+			if gvk, ok := o.types[s.Type.String()]; ok {
+				t.Kind = gvk.Kind
+			}
+			for _, f := range s.Fields {
+				t.Fields = append(t.Fields, jsonField{
+					Name:     f.Name,
+					Doc:      f.Doc,
+					Type:     jsonTypeString(pkg, f.Type),
+					Required: f.Required,
+				})
+			}
+			api.Types = append(api.Types, t)
+			continue
+		}
+		c := pkg.Constants[name]
+		jc := jsonConstant{Name: c.Name, Doc: c.Doc, Values: []jsonValue{}}
+		for _, v := range c.Values {
+			jc.Values = append(jc.Values, jsonValue{
+				Name:  v.Name,
+				Value: constant.Val(v.Value),
+				Doc:   v.Doc,
+			})
+		}
+		api.Constants = append(api.Constants, jc)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(api)
+}
+
+// jsonTypeString returns the Go type of typ, with the types of pkg unqualified
+// and those of other packages qualified by their identifiers.
+func jsonTypeString(pkg *Package, typ types.Type) string {
+	return types.TypeString(typ, func(p *types.Package) string {
+		if pkg.Pkg != nil && p.Path() == pkg.Pkg.PkgPath {
+			return ""
+		}
+		return packageIdent(p.Path())
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package genapi
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/machinezone/configmapsecrets/pkg/internal/diff"
+)
+
+// A Drift describes a generated file whose committed contents are stale.
+type Drift struct {
+	// Path of the committed file.
+	Path string `json:"path"`
+	// Unified diff from the committed to the generated contents.
+	Diff string `json:"diff"`
+}
+
+// Verify compares the generated contents with those of the file at path.
+// It returns nil if they're equal. A missing file is treated as empty.
+func Verify(path string, generated []byte) (*Drift, error) {
+	committed, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if bytes.Equal(committed, generated) {
+		return nil, nil
+	}
+	return &Drift{
+		Path: path,
+		Diff: diff.Unified(path, path+" (generated)", string(committed), string(generated)),
+	}, nil
+}
+
+// VerifyMarkdown generates the API of pkg as markdown and compares it with
+// the contents of the file at path. It returns nil if they're equal.
+func VerifyMarkdown(path string, pkg *Package, options ...Option) (*Drift, error) {
+	var buf bytes.Buffer
+	if err := WriteMarkdown(&buf, pkg, options...); err != nil {
+		return nil, err
+	}
+	return Verify(path, buf.Bytes())
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package genflags_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/genflags"
+)
+
+// This example generates the markdown documentation of a flag set.
+func ExampleWriteMarkdown() {
+	fs := flag.NewFlagSet("example", flag.ContinueOnError)
+	fs.String("addr", ":8080", "The `address` to which the server binds, or \"0\" | \"\" to disable it.")
+	fs.Bool("verbose", false, "Log verbosely.")
+	fs.Duration("timeout", time.Minute, "The maximum duration of a request.")
+	fs.String("line-ending", "\n", "The line ending of logs.")
+	if err := genflags.WriteMarkdown(os.Stdout, "Flags", fs); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// # Flags
+	//
+	// **Note:** This document is generated from code and comments. Do not edit it directly.
+	//
+	// | Flag | Description | Type | Default |
+	// | ---- | ----------- | ---- | ------- |
+	// | --addr | The address to which the server binds, or "0" \| "" to disable it. | address | `:8080` |
+	// | --line-ending | The line ending of logs. | string | `"\n"` |
+	// | --timeout | The maximum duration of a request. | duration | `1m0s` |
+	// | --verbose | Log verbosely. | bool | `false` |
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package genflags generates the documentation of a command's flags, like
+// genapi generates the documentation of the API.
+package genflags
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteMarkdown writes a reference table of the flags of fs, sorted by name,
+// as markdown to w.
+func WriteMarkdown(w io.Writer, title string, fs *flag.FlagSet) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#", title)
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "**Note:** This document is generated from code and comments. Do not edit it directly.")
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "| Flag | Description | Type | Default |")
+	fmt.Fprintln(b, "| ---- | ----------- | ---- | ------- |")
+	fs.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		if typ == "" {
+			typ = "bool" // Boolean flags don't take a value.
+		}
+		fmt.Fprintf(b, "| --%s | %s | %s | %s |\n", f.Name, escape(usage), typ, defaultValue(f.DefValue))
+	})
+	return b.Flush()
+}
+
+// defaultValue formats a default value as code, quoted if it has characters
+// that need escaping, e.g. a newline.
+func defaultValue(s string) string {
+	if s == "" {
+		return ""
+	}
+	if q := strconv.Quote(s); q[1:len(q)-1] != s {
+		s = q
+	}
+	return "`" + escape(s) + "`"
+}
+
+var escaper = strings.NewReplacer("|", "\\|", "\n", "<br/>")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
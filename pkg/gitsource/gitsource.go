@@ -0,0 +1,200 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gitsource reads files of Git repositories with the git command,
+// which must be in the PATH.
+package gitsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultProtocols are the transport protocols allowed by a Fetcher without
+// Protocols.
+var DefaultProtocols = []string{"https", "ssh"}
+
+// A Source is a file or directory of a Git repository.
+type Source struct {
+	// Repository is the URL of the repository.
+	Repository string
+	// Ref is the branch, tag, or commit to read. If empty, it's HEAD.
+	Ref string
+	// Path is the path of a file or directory in the repository. If empty,
+	// it's the root.
+	Path string
+	// Auth are the credentials for the repository, if any.
+	Auth Auth
+}
+
+// Auth are credentials for a Git repository.
+type Auth struct {
+	// Username and Password are used for HTTP basic authentication.
+	Username string
+	Password string
+	// PrivateKey is the SSH private key, in PEM or OpenSSH format.
+	PrivateKey []byte
+	// KnownHosts are the known host keys of SSH servers, in the format of
+	// an OpenSSH known_hosts file. They're required with a PrivateKey, since
+	// the host key of an SSH server is always verified.
+	KnownHosts []byte
+}
+
+// A Fetcher reads files of Git repositories.
+type Fetcher struct {
+	// Protocols are the transport protocols that may be used, as in
+	// GIT_ALLOW_PROTOCOL, e.g. "https". If empty, they're DefaultProtocols.
+	Protocols []string
+	// Dir is the directory in which repositories are temporarily fetched.
+	// If empty, it's the default directory for temporary files.
+	Dir string
+}
+
+// Fetch returns the contents of the source's file, or of each file of its
+// directory, by base name, and the commit from which they were read.
+// Subdirectories, symbolic links, and submodules are ignored.
+//
+// Only the commit of the ref is fetched, without history.
+func (f *Fetcher) Fetch(ctx context.Context, src Source) (files map[string][]byte, commit string, err error) {
+	if src.Repository == "" {
+		return nil, "", errors.New("empty repository")
+	}
+	dir, err := os.MkdirTemp(f.Dir, "gitsource-*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	env, err := f.env(dir, src.Auth)
+	if err != nil {
+		return nil, "", err
+	}
+	repo := filepath.Join(dir, "repo")
+	if err := os.Mkdir(repo, 0o700); err != nil {
+		return nil, "", err
+	}
+	git := func(args ...string) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = env
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("git %s: %v: %s", args[0], err, msg)
+			}
+			return nil, fmt.Errorf("git %s: %v", args[0], err)
+		}
+		return stdout.Bytes(), nil
+	}
+
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := git("init", "--quiet", "--bare"); err != nil {
+		return nil, "", err
+	}
+	if _, err := git("fetch", "--quiet", "--depth=1", "--no-tags", "--", src.Repository, ref); err != nil {
+		return nil, "", err
+	}
+	out, err := git("rev-parse", "--verify", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return nil, "", err
+	}
+	commit = strings.TrimSpace(string(out))
+
+	p := strings.Trim(path.Clean("/"+src.Path), "/")
+	obj := commit + ":" + p
+	out, err = git("cat-file", "-t", obj)
+	if err != nil {
+		return nil, "", fmt.Errorf("path %q not found at %s", src.Path, commit)
+	}
+	switch typ := strings.TrimSpace(string(out)); typ {
+	case "blob":
+		data, err := git("cat-file", "blob", obj)
+		if err != nil {
+			return nil, "", err
+		}
+		return map[string][]byte{path.Base(p): data}, commit, nil
+	case "tree":
+	default:
+		return nil, "", fmt.Errorf("path %q is a %s, not a file or directory", src.Path, typ)
+	}
+
+	out, err = git("ls-tree", "-z", obj)
+	if err != nil {
+		return nil, "", err
+	}
+	files = make(map[string][]byte)
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// Each entry is "<mode> SP <type> SP <object> TAB <name>".
+		info, name, ok := strings.Cut(string(entry), "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(info)
+		if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		data, err := git("cat-file", "blob", fields[2])
+		if err != nil {
+			return nil, "", err
+		}
+		files[name] = data
+	}
+	return files, commit, nil
+}
+
+// env returns the environment of git commands in dir, which ignores the
+// configuration of the system and user, never prompts, and authenticates
+// with auth. Credentials are passed in the environment or files in dir,
+// rather than arguments, which are visible to other processes.
+func (f *Fetcher) env(dir string, auth Auth) ([]string, error) {
+	protocols := f.Protocols
+	if len(protocols) == 0 {
+		protocols = DefaultProtocols
+	}
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=" + strings.Join(protocols, ":"),
+	}
+	if auth.Username != "" || auth.Password != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+cred,
+		)
+	}
+	// The host key of an SSH server is verified with the known hosts only,
+	// so that an unknown server is never trusted on first use.
+	if len(auth.PrivateKey) > 0 && len(auth.KnownHosts) == 0 {
+		return nil, errors.New("known hosts are required with an SSH private key")
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, auth.KnownHosts, 0o600); err != nil {
+		return nil, err
+	}
+	ssh := fmt.Sprintf("ssh -F /dev/null -o UserKnownHostsFile=%q -o StrictHostKeyChecking=yes -o BatchMode=yes", knownHosts)
+	if len(auth.PrivateKey) > 0 {
+		key := filepath.Join(dir, "identity")
+		if err := os.WriteFile(key, auth.PrivateKey, 0o600); err != nil {
+			return nil, err
+		}
+		ssh += fmt.Sprintf(" -i %q -o IdentitiesOnly=yes", key)
+	}
+	return append(env, "GIT_SSH_COMMAND="+ssh), nil
+}
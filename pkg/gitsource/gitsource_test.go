@@ -0,0 +1,153 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitsource
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newRepo returns the path of a repository with a commit of the files, tagged v1.
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("app.conf", filepath.Join(dir, "templates", "link.conf")); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestFetch(t *testing.T) {
+	repo := newRepo(t, map[string]string{
+		"README.md":              "readme",
+		"templates/app.conf":     "password=$(PASSWORD)",
+		"templates/db.yaml":      "user: $(USER)",
+		"templates/nested/other": "ignored",
+	})
+	ctx := context.Background()
+	f := &Fetcher{Protocols: []string{"file"}, Dir: t.TempDir()}
+
+	tests := []struct {
+		desc string
+		src  Source
+		want map[string][]byte
+		err  bool
+	}{
+		{
+			desc: "directory",
+			src:  Source{Repository: repo, Path: "templates"},
+			want: map[string][]byte{
+				"app.conf": []byte("password=$(PASSWORD)"),
+				"db.yaml":  []byte("user: $(USER)"),
+			},
+		},
+		{
+			desc: "file at tag",
+			src:  Source{Repository: repo, Ref: "v1", Path: "/templates/db.yaml"},
+			want: map[string][]byte{"db.yaml": []byte("user: $(USER)")},
+		},
+		{
+			desc: "root",
+			src:  Source{Repository: repo},
+			want: map[string][]byte{"README.md": []byte("readme")},
+		},
+		{desc: "missing path", src: Source{Repository: repo, Path: "missing"}, err: true},
+		{desc: "missing ref", src: Source{Repository: repo, Ref: "missing"}, err: true},
+		{desc: "empty repository", src: Source{}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			files, commit, err := f.Fetch(ctx, tt.src)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got files: %v", files)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(commit) != 40 {
+				t.Errorf("unexpected commit: %q", commit)
+			}
+			if diff := cmp.Diff(tt.want, files); diff != "" {
+				t.Errorf("unexpected files (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(f.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected temporary repositories to be removed, found %d", len(entries))
+	}
+
+	// Local repositories use the file protocol, which isn't allowed by default.
+	if _, _, err := (&Fetcher{}).Fetch(ctx, Source{Repository: repo}); err == nil {
+		t.Error("expected error fetching with a disallowed protocol")
+	}
+}
+
+func TestSSHHostKeyChecking(t *testing.T) {
+	f := &Fetcher{}
+	dir := t.TempDir()
+
+	// A private key without known hosts is rejected rather than trusting
+	// the server on first use.
+	if _, err := f.env(dir, Auth{PrivateKey: []byte("key")}); err == nil {
+		t.Error("expected error without known hosts")
+	}
+
+	for _, auth := range []Auth{{}, {PrivateKey: []byte("key"), KnownHosts: []byte("example.com ssh-ed25519 AAAA")}} {
+		env, err := f.env(dir, auth)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var ssh string
+		for _, kv := range env {
+			if strings.HasPrefix(kv, "GIT_SSH_COMMAND=") {
+				ssh = kv
+			}
+		}
+		if !strings.Contains(ssh, "StrictHostKeyChecking=yes") {
+			t.Errorf("host keys aren't verified: %q", ssh)
+		}
+		if got, want := strings.Contains(ssh, "-i "), len(auth.PrivateKey) > 0; got != want {
+			t.Errorf("unexpected identity: %q", ssh)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// The output of an exec plugin is limited, so that it can't exhaust memory.
+const (
+	// maxExecOutput is the maximum size of the Secret written to stdout.
+	maxExecOutput = 4 << 20
+	// maxExecStderr is the maximum size of stderr that's logged.
+	maxExecStderr = 4 << 10
+)
+
+// ExecInput is the JSON object written to an exec plugin's stdin.
+type ExecInput struct {
+	ConfigMapSecret *v1alpha1.ConfigMapSecret `json:"configMapSecret"`
+	Secret          *corev1.Secret            `json:"secret"`
+}
+
+// Exec returns a Hook that runs the executable at path.
+//
+// The plugin is given an ExecInput as JSON on stdin. It must write the
+// resulting Secret as JSON to stdout and exit with a zero status. A non-zero
+// exit status blocks the write and is reported as the reason. The plugin's
+// stderr may contain values of the Secret, so it's only logged at debug level
+// with the logger of the context.
+func Exec(path string) Hook {
+	return &execHook{path: path}
+}
+
+type execHook struct {
+	path string
+}
+
+func (h *execHook) Name() string { return ExecPrefix + h.path }
+
+func (h *execHook) Apply(ctx context.Context, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) error {
+	in, err := json.Marshal(&ExecInput{ConfigMapSecret: cms, Secret: secret})
+	if err != nil {
+		return err
+	}
+	stdout := &limitedBuffer{max: maxExecOutput}
+	stderr := &limitedBuffer{max: maxExecStderr}
+	cmd := exec.CommandContext(ctx, h.path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if stderr.buf.Len() > 0 {
+		log.FromContext(ctx).V(1).Info("Post-render hook stderr", "hook", h.Name(), "stderr", stderr.buf.String(), "truncated", stderr.truncated)
+	}
+	if err != nil {
+		return err
+	}
+	if stdout.truncated {
+		return fmt.Errorf("plugin output exceeds %d bytes", maxExecOutput)
+	}
+	out := &corev1.Secret{}
+	if err := json.Unmarshal(stdout.buf.Bytes(), out); err != nil {
+		return fmt.Errorf("invalid plugin output: %v", err)
+	}
+	*secret = *out
+	return nil
+}
+
+// limitedBuffer is a buffer that discards the bytes written beyond max,
+// rather than failing the writes, so that a plugin doesn't block on its
+// output once it's exceeded. The buffer isn't embedded, since its ReadFrom
+// would bypass Write.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if rem := b.max - b.buf.Len(); n > rem {
+		p = p[:rem]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hooks provides post-render hooks, which are invoked with a rendered
+// Secret before it's written and may mutate it or block the write.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// A Hook is invoked with a rendered Secret before it's written.
+// It may mutate the Secret, or return an error to block the write.
+type Hook interface {
+	// Name returns the name of the hook.
+	Name() string
+
+	// Apply applies the hook to the Secret rendered for the ConfigMapSecret.
+	// The ConfigMapSecret must not be modified.
+	Apply(ctx context.Context, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) error
+}
+
+// Func returns a Hook with the given name that calls fn.
+func Func(name string, fn func(ctx context.Context, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) error) Hook {
+	return &funcHook{name: name, fn: fn}
+}
+
+type funcHook struct {
+	name string
+	fn   func(context.Context, *v1alpha1.ConfigMapSecret, *corev1.Secret) error
+}
+
+func (h *funcHook) Name() string { return h.name }
+
+func (h *funcHook) Apply(ctx context.Context, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) error {
+	return h.fn(ctx, cms, secret)
+}
+
+var registry struct {
+	mu    sync.RWMutex
+	hooks map[string]Hook
+}
+
+// Register registers a compiled-in hook, so that it may be enabled by name.
+// It panics if a hook with the same name is already registered.
+func Register(hook Hook) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	name := hook.Name()
+	if _, ok := registry.hooks[name]; ok {
+		panic(fmt.Sprintf("hooks: duplicate registration of %q", name))
+	}
+	if registry.hooks == nil {
+		registry.hooks = make(map[string]Hook)
+	}
+	registry.hooks[name] = hook
+}
+
+// Registered returns the sorted names of the registered hooks.
+func Registered() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := make([]string, 0, len(registry.hooks))
+	for name := range registry.hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExecPrefix is the prefix of a hook spec that refers to an exec plugin.
+const ExecPrefix = "exec:"
+
+// Load returns the hook described by spec, which is either the name of a
+// registered hook or ExecPrefix followed by the path of an exec plugin.
+func Load(spec string) (Hook, error) {
+	if path := strings.TrimPrefix(spec, ExecPrefix); path != spec {
+		if path == "" {
+			return nil, fmt.Errorf("hooks: missing exec plugin path in %q", spec)
+		}
+		return Exec(path), nil
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	hook, ok := registry.hooks[spec]
+	if !ok {
+		return nil, fmt.Errorf("hooks: unknown hook %q", spec)
+	}
+	return hook, nil
+}
+
+// An Error is returned by Run when a hook fails.
+type Error struct {
+	Hook string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("post-render hook %s failed: %v", e.Hook, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Run applies the hooks in order. Hooks may not change the Secret's name or namespace.
+func Run(ctx context.Context, hooks []Hook, cms *v1alpha1.ConfigMapSecret, secret *corev1.Secret) error {
+	name, namespace := secret.Name, secret.Namespace
+	for _, h := range hooks {
+		if err := h.Apply(ctx, cms, secret); err != nil {
+			return &Error{Hook: h.Name(), Err: err}
+		}
+		if secret.Name != name || secret.Namespace != namespace {
+			return &Error{Hook: h.Name(), Err: fmt.Errorf("changed Secret name from %s/%s to %s/%s",
+				namespace, name, secret.Namespace, secret.Name)}
+		}
+	}
+	return nil
+}
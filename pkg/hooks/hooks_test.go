@@ -0,0 +1,122 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hooks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func newSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+		Data:       map[string][]byte{"key": []byte("value")},
+	}
+}
+
+func TestRun(t *testing.T) {
+	cms := &v1alpha1.ConfigMapSecret{}
+	label := Func("label", func(_ context.Context, _ *v1alpha1.ConfigMapSecret, s *corev1.Secret) error {
+		s.Labels = map[string]string{"cost-center": "123"}
+		return nil
+	})
+	block := Func("block", func(_ context.Context, _ *v1alpha1.ConfigMapSecret, s *corev1.Secret) error {
+		if _, ok := s.Data["key"]; ok {
+			return errors.New("key is not allowed")
+		}
+		return nil
+	})
+	rename := Func("rename", func(_ context.Context, _ *v1alpha1.ConfigMapSecret, s *corev1.Secret) error {
+		s.Name = "other"
+		return nil
+	})
+
+	secret := newSecret()
+	if err := Run(context.Background(), []Hook{label}, cms, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := secret.Labels["cost-center"]; got != "123" {
+		t.Errorf("unexpected label: %q", got)
+	}
+
+	var hookErr *Error
+	if err := Run(context.Background(), []Hook{label, block}, cms, newSecret()); !errors.As(err, &hookErr) || hookErr.Hook != "block" {
+		t.Errorf("expected error from block hook; got: %v", err)
+	}
+	if err := Run(context.Background(), []Hook{rename}, cms, newSecret()); err == nil {
+		t.Errorf("expected error from rename hook")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	Register(Func("test-registered", func(context.Context, *v1alpha1.ConfigMapSecret, *corev1.Secret) error { return nil }))
+	if _, err := Load("test-registered"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := Load("test-unknown"); err == nil {
+		t.Errorf("expected error for unknown hook")
+	}
+	if _, err := Load(ExecPrefix); err == nil {
+		t.Errorf("expected error for missing exec path")
+	}
+	if h, err := Load(ExecPrefix + "/bin/true"); err != nil || h.Name() != "exec:/bin/true" {
+		t.Errorf("unexpected exec hook: %v, %v", h, err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell")
+	}
+	dir := t.TempDir()
+	write := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return path
+	}
+	cms := &v1alpha1.ConfigMapSecret{}
+
+	mutate := write("mutate", "#!/bin/sh\ncat >/dev/null\necho '{\"metadata\":{\"name\":\"secret\",\"namespace\":\"default\",\"labels\":{\"a\":\"b\"}}}'\n")
+	secret := newSecret()
+	if err := Exec(mutate).Apply(context.Background(), cms, secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := secret.Labels["a"]; got != "b" {
+		t.Errorf("unexpected label: %q", got)
+	}
+
+	// Stderr may contain values, so it's only logged at debug level, and the
+	// error is the exit status.
+	reject := write("reject", "#!/bin/sh\necho 'denied: value is hunter2' >&2\nexit 3\n")
+	var logs []string
+	logger := funcr.New(func(prefix, args string) { logs = append(logs, args) }, funcr.Options{Verbosity: 1})
+	ctx := log.IntoContext(context.Background(), logger)
+	if err := Exec(reject).Apply(ctx, cms, newSecret()); err == nil || err.Error() != "exit status 3" {
+		t.Errorf("unexpected error: want: %q; got: %v", "exit status 3", err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], `"stderr"="denied: value is hunter2\n"`) {
+		t.Errorf("unexpected logs: %q", logs)
+	}
+
+	// Output beyond the limit is discarded, rather than blocking the plugin.
+	large := write("large", "#!/bin/sh\ncat >/dev/null\nhead -c 5000000 /dev/zero\nhead -c 5000000 /dev/zero >&2\n")
+	want := "plugin output exceeds 4194304 bytes"
+	if err := Exec(large).Apply(context.Background(), cms, newSecret()); err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want: %q; got: %v", want, err)
+	}
+}
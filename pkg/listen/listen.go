@@ -0,0 +1,90 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package listen creates network listeners from address flags.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listen returns a listener for the address, which is one of:
+//
+//	host:port           TCP on IPv4 and IPv6, e.g. ":9091" or "[::1]:9091"
+//	tcp4://host:port    TCP on IPv4 only
+//	tcp6://host:port    TCP on IPv6 only
+//	unix:///path        Unix domain socket, replacing a stale socket file
+//	systemd:[name]      Socket passed by systemd socket activation, selected by
+//	                    its FileDescriptorName, or the first socket if empty
+//
+// IPv6 literals must be enclosed in square brackets.
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return listenUnix(strings.TrimPrefix(addr, "unix://"))
+	case strings.HasPrefix(addr, "systemd:"):
+		return listenSystemd(strings.TrimPrefix(addr, "systemd:"))
+	case strings.HasPrefix(addr, "tcp4://"):
+		return listenTCP("tcp4", strings.TrimPrefix(addr, "tcp4://"))
+	case strings.HasPrefix(addr, "tcp6://"):
+		return listenTCP("tcp6", strings.TrimPrefix(addr, "tcp6://"))
+	default:
+		return listenTCP("tcp", addr)
+	}
+}
+
+func listenTCP(network, addr string) (net.Listener, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return nil, fmt.Errorf("invalid address %q: IPv6 literals must be enclosed in brackets, e.g. [::1]:9091", addr)
+		}
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return net.Listen(network, addr)
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("invalid address: missing unix socket path")
+	}
+	// Remove a socket left behind by a previous process, but nothing else.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+func listenSystemd(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), fdName)
+		defer f.Close() // FileListener dups the descriptor.
+		return net.FileListener(f)
+	}
+	return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+}
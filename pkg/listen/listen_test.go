@@ -0,0 +1,99 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package listen
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListenTCP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:0", "tcp4://127.0.0.1:0", ":0"} {
+		ln, err := Listen(addr)
+		if err != nil {
+			t.Errorf("Listen(%q): unexpected error: %v", addr, err)
+			continue
+		}
+		ln.Close()
+	}
+
+	for _, addr := range []string{"::1:9091", "localhost", "unix://", "tcp6://::1"} {
+		if ln, err := Listen(addr); err == nil {
+			ln.Close()
+			t.Errorf("Listen(%q): expected error", addr)
+		}
+	}
+	if _, err := Listen("::1:9091"); err == nil || !strings.Contains(err.Error(), "brackets") {
+		t.Errorf("expected IPv6 bracket error; got: %v", err)
+	}
+}
+
+func TestListenIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 unavailable: %v", err)
+	}
+	ln.Close()
+
+	for _, addr := range []string{"[::1]:0", "tcp6://[::1]:0"} {
+		ln, err := Listen(addr)
+		if err != nil {
+			t.Errorf("Listen(%q): unexpected error: %v", addr, err)
+			continue
+		}
+		ln.Close()
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	ln, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.Close()
+
+	// Simulate a socket left behind by a crashed process.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("unexpected error replacing stale socket: %v", err)
+	}
+	ln.Close()
+
+	// Regular files are never replaced.
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ln, err := Listen("unix://" + file); err == nil {
+		ln.Close()
+		t.Errorf("expected error for regular file")
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	if _, err := Listen("systemd:"); err == nil {
+		t.Errorf("expected error without socket activation")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "metrics")
+	if _, err := Listen("systemd:health"); err == nil || !strings.Contains(err.Error(), `"health"`) {
+		t.Errorf("expected missing socket error; got: %v", err)
+	}
+}
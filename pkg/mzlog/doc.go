@@ -0,0 +1,7 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mzlog bridges other logging APIs into the controller's zap pipeline,
+// so that their output is encoded and observed like the rest of the logs.
+package mzlog
@@ -0,0 +1,193 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mzlog
+
+import (
+	"strings"
+	"time"
+
+	"bursavich.dev/zapr"
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Levels is a set of log levels. It implements flag.Value as a
+// comma-separated list of level names.
+type Levels []zapcore.Level
+
+// DefaultSampleLevels are the levels subject to sampling by default.
+// Errors are never sampled away unless explicitly requested.
+var DefaultSampleLevels = Levels{zapcore.InfoLevel}
+
+// Contains returns a value indicating whether the set contains the level.
+func (s Levels) Contains(level zapcore.Level) bool {
+	for _, l := range s {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the comma-separated level names.
+func (s Levels) String() string {
+	names := make([]string, 0, len(s))
+	for _, l := range s {
+		names = append(names, l.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// Set parses the comma-separated level names.
+func (s *Levels) Set(value string) error {
+	var levels Levels
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		levels = append(levels, l)
+	}
+	*s = levels
+	return nil
+}
+
+// NewLogger returns a new Logger and LogSink with the given options,
+// where only entries at the given levels are subject to sampling.
+func NewLogger(sampleLevels Levels, options ...zapr.Option) (logr.Logger, zapr.LogSink) {
+	s := NewLogSink(sampleLevels, options...)
+	return logr.New(s), s
+}
+
+// NewLogSink returns a new LogSink with the given options,
+// where only entries at the given levels are subject to sampling.
+func NewLogSink(sampleLevels Levels, options ...zapr.Option) zapr.LogSink {
+	unsampled := append(options[:len(options):len(options)], zapr.WithSampler(time.Second, 0, 0))
+	return &sampledSink{
+		// Skip the frame added by sampledSink.
+		sampled:   zapr.NewLogSink(options...).WithCallDepth(1).(zapr.LogSink),
+		unsampled: zapr.NewLogSink(unsampled...).WithCallDepth(1).(zapr.LogSink),
+		levels:    sampleLevels,
+	}
+}
+
+type sampledSink struct {
+	sampled   zapr.LogSink
+	unsampled zapr.LogSink
+	levels    Levels
+}
+
+func (s *sampledSink) sink(level zapcore.Level) zapr.LogSink {
+	if s.levels.Contains(level) {
+		return s.sampled
+	}
+	return s.unsampled
+}
+
+func (s *sampledSink) Init(info logr.RuntimeInfo) {
+	s.sampled.Init(info)
+	s.unsampled.Init(info)
+}
+
+func (s *sampledSink) Enabled(level int) bool { return s.sampled.Enabled(level) }
+
+func (s *sampledSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink(zapcore.InfoLevel).Info(level, msg, keysAndValues...)
+}
+
+func (s *sampledSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink(zapcore.ErrorLevel).Error(err, msg, keysAndValues...)
+}
+
+func (s *sampledSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &sampledSink{
+		sampled:   s.sampled.WithValues(keysAndValues...).(zapr.LogSink),
+		unsampled: s.unsampled.WithValues(keysAndValues...).(zapr.LogSink),
+		levels:    s.levels,
+	}
+}
+
+func (s *sampledSink) WithName(name string) logr.LogSink {
+	return &sampledSink{
+		sampled:   s.sampled.WithName(name).(zapr.LogSink),
+		unsampled: s.unsampled.WithName(name).(zapr.LogSink),
+		levels:    s.levels,
+	}
+}
+
+func (s *sampledSink) WithCallDepth(depth int) logr.LogSink {
+	if depth == 0 {
+		return s
+	}
+	return &sampledSink{
+		sampled:   s.sampled.WithCallDepth(depth).(zapr.LogSink),
+		unsampled: s.unsampled.WithCallDepth(depth).(zapr.LogSink),
+		levels:    s.levels,
+	}
+}
+
+// Underlying returns a *zap.Logger whose core routes each entry to the
+// sampled or unsampled core depending on its level.
+func (s *sampledSink) Underlying() *zap.Logger {
+	unsampled := s.unsampled.Underlying().Core()
+	return s.sampled.Underlying().WithOptions(zap.WrapCore(func(sampled zapcore.Core) zapcore.Core {
+		return &levelCore{
+			sampled:   sampled,
+			unsampled: unsampled,
+			levels:    s.levels,
+		}
+	}))
+}
+
+func (s *sampledSink) Flush() error {
+	err := s.sampled.Flush()
+	if uErr := s.unsampled.Flush(); err == nil {
+		err = uErr
+	}
+	return err
+}
+
+type levelCore struct {
+	sampled   zapcore.Core
+	unsampled zapcore.Core
+	levels    Levels
+}
+
+func (c *levelCore) core(level zapcore.Level) zapcore.Core {
+	if c.levels.Contains(level) {
+		return c.sampled
+	}
+	return c.unsampled
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool { return c.core(level).Enabled(level) }
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{
+		sampled:   c.sampled.With(fields),
+		unsampled: c.unsampled.With(fields),
+		levels:    c.levels,
+	}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core(ent.Level).Check(ent, ce)
+}
+
+func (c *levelCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.core(ent.Level).Write(ent, fields)
+}
+
+func (c *levelCore) Sync() error {
+	err := c.sampled.Sync()
+	if uErr := c.unsampled.Sync(); err == nil {
+		err = uErr
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mzlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bursavich.dev/zapr"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSampledSink(t *testing.T) {
+	tests := []struct {
+		name      string
+		levels    string
+		wantInfo  int
+		wantError int
+	}{
+		{name: "default", levels: "info", wantInfo: 1, wantError: 3},
+		{name: "none", levels: "", wantInfo: 3, wantError: 3},
+		{name: "all", levels: "info,error", wantInfo: 1, wantError: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var levels Levels
+			if err := levels.Set(tt.levels); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var buf bytes.Buffer
+			log, _ := NewLogger(levels,
+				zapr.WithWriteSyncer(zapcore.AddSync(&buf)),
+				zapr.WithSampler(time.Minute, 1, 0),
+			)
+			for i := 0; i < 3; i++ {
+				log.Info("info")
+				log.Error(errors.New("oops"), "error")
+			}
+			out := buf.String()
+			if got := strings.Count(out, `"level":"INFO"`); got != tt.wantInfo {
+				t.Errorf("unexpected info count: got %d; want %d", got, tt.wantInfo)
+			}
+			if got := strings.Count(out, `"level":"ERROR"`); got != tt.wantError {
+				t.Errorf("unexpected error count: got %d; want %d", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestLevelsFlag(t *testing.T) {
+	var levels Levels
+	if err := levels.Set("info, error"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := levels.String(), "info,error"; got != want {
+		t.Errorf("unexpected levels: got %q; want %q", got, want)
+	}
+	if err := levels.Set("bogus"); err == nil {
+		t.Errorf("expected error for invalid level")
+	}
+}
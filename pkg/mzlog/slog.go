@@ -0,0 +1,164 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package mzlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+
+	"bursavich.dev/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SetDefault sets the default slog.Logger to one that writes to the sink.
+func SetDefault(sink zapr.LogSink) {
+	slog.SetDefault(slog.New(NewHandler(sink)))
+}
+
+// NewHandler returns a slog.Handler that writes to the sink.
+//
+// Records at or above slog.LevelInfo are written at the matching zap level.
+// Records below slog.LevelInfo are treated as logr verbosity levels, such that
+// slog.LevelDebug is equivalent to V(4), and are only written if the sink is
+// enabled at that verbosity.
+func NewHandler(sink zapr.LogSink) slog.Handler {
+	return &handler{
+		sink:   sink,
+		logger: sink.Underlying(),
+	}
+}
+
+type handler struct {
+	sink   zapr.LogSink
+	logger *zap.Logger
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	if level < slog.LevelInfo {
+		return h.sink.Enabled(verbosity(level))
+	}
+	return h.logger.Core().Enabled(zapLevel(level))
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo && !h.sink.Enabled(verbosity(r.Level)) {
+		return nil
+	}
+	ce := h.logger.Check(zapLevel(r.Level), r.Message)
+	if ce == nil {
+		return nil
+	}
+	ce.Time = r.Time
+	if ce.Caller.Defined && r.PC != 0 { // Replace the handler's caller with the record's.
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		ce.Caller = zapcore.EntryCaller{
+			Defined:  true,
+			PC:       frame.PC,
+			File:     frame.File,
+			Line:     frame.Line,
+			Function: frame.Function,
+		}
+	}
+	fields := make([]zap.Field, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendField(fields, a)
+		return true
+	})
+	ce.Write(fields...)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []zap.Field
+	for _, a := range attrs {
+		fields = appendField(fields, a)
+	}
+	if len(fields) == 0 {
+		return h
+	}
+	v := *h
+	v.logger = h.logger.With(fields...)
+	return &v
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	v := *h
+	v.logger = h.logger.With(zap.Namespace(name))
+	return &v
+}
+
+func appendField(fields []zap.Field, a slog.Attr) []zap.Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	switch v := a.Value; v.Kind() {
+	case slog.KindBool:
+		return append(fields, zap.Bool(a.Key, v.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(a.Key, v.Duration()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(a.Key, v.Float64()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(a.Key, v.Int64()))
+	case slog.KindString:
+		return append(fields, zap.String(a.Key, v.String()))
+	case slog.KindTime:
+		return append(fields, zap.Time(a.Key, v.Time()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(a.Key, v.Uint64()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return fields
+		}
+		if a.Key == "" { // Inline the group's attrs.
+			for _, a := range attrs {
+				fields = appendField(fields, a)
+			}
+			return fields
+		}
+		return append(fields, zap.Object(a.Key, group(attrs)))
+	default:
+		return append(fields, zap.Any(a.Key, v.Any()))
+	}
+}
+
+type group []slog.Attr
+
+func (g group) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var fields []zap.Field
+	for _, a := range g {
+		fields = appendField(fields, a)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return nil
+}
+
+// verbosity converts a slog.Level below slog.LevelInfo to a logr verbosity.
+func verbosity(level slog.Level) int {
+	return int(slog.LevelInfo - level)
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
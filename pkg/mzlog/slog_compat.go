@@ -0,0 +1,15 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.21
+// +build !go1.21
+
+package mzlog
+
+import (
+	"bursavich.dev/zapr"
+)
+
+// SetDefault is a no-op before Go 1.21, which introduced log/slog.
+func SetDefault(sink zapr.LogSink) {}
@@ -0,0 +1,104 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package mzlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"bursavich.dev/zapr"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		log   func(*slog.Logger)
+		want  []map[string]interface{}
+	}{
+		{
+			name: "info",
+			log: func(l *slog.Logger) {
+				l.Info("hello", "foo", "bar", "n", 3)
+			},
+			want: []map[string]interface{}{
+				{"level": "INFO", "message": "hello", "foo": "bar", "n": 3.0},
+			},
+		},
+		{
+			name: "error",
+			log: func(l *slog.Logger) {
+				l.Error("oops", "ok", false)
+			},
+			want: []map[string]interface{}{
+				{"level": "ERROR", "message": "oops", "ok": false},
+			},
+		},
+		{
+			name: "groups",
+			log: func(l *slog.Logger) {
+				l.With("a", 1).WithGroup("g").Info("grouped", slog.Group("h", "b", 2), "c", 3)
+			},
+			want: []map[string]interface{}{
+				{
+					"level":   "INFO",
+					"message": "grouped",
+					"a":       1.0,
+					"g": map[string]interface{}{
+						"h": map[string]interface{}{"b": 2.0},
+						"c": 3.0,
+					},
+				},
+			},
+		},
+		{
+			name: "debug-disabled",
+			log: func(l *slog.Logger) {
+				l.Debug("hidden")
+			},
+		},
+		{
+			name:  "debug-enabled",
+			level: 4,
+			log: func(l *slog.Logger) {
+				l.Debug("shown")
+			},
+			want: []map[string]interface{}{
+				{"level": "INFO", "message": "shown"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			_, sink := zapr.NewLogger(
+				zapr.WithWriteSyncer(zapcore.AddSync(&buf)),
+				zapr.WithLevel(tt.level),
+				zapr.WithTimeKey(""),
+				zapr.WithCallerEnabled(false),
+			)
+			tt.log(slog.New(NewHandler(sink)))
+
+			var got []map[string]interface{}
+			for dec := json.NewDecoder(&buf); dec.More(); {
+				var m map[string]interface{}
+				if err := dec.Decode(&m); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got = append(got, m)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected logs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package policy checks rendered Secrets against a policy of an organization,
+// e.g. that they have the labels that its tooling requires, don't have
+// annotations that leak their data, and fit within its size limits.
+//
+// A policy is a YAML file:
+//
+//	requiredLabels:
+//	  - app.kubernetes.io/name
+//	forbiddenAnnotations:
+//	  - kubectl.kubernetes.io/last-applied-configuration
+//	  - "*.internal.example.com/*"
+//	maxDataSize: 262144
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Checks of a policy, which identify its violations.
+const (
+	CheckRequiredLabel       = "required-label"
+	CheckRequiredAnnotation  = "required-annotation"
+	CheckForbiddenLabel      = "forbidden-label"
+	CheckForbiddenAnnotation = "forbidden-annotation"
+	CheckMaxKeys             = "max-keys"
+	CheckMaxKeySize          = "max-key-size"
+	CheckMaxDataSize         = "max-data-size"
+)
+
+// A Policy is the set of checks that a rendered Secret must pass. Label and
+// annotation keys may be patterns in which * matches any sequence of
+// characters. Zero limits are disabled.
+type Policy struct {
+	// RequiredLabels are the keys of labels that a Secret must have.
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+	// RequiredAnnotations are the keys of annotations that a Secret must have.
+	RequiredAnnotations []string `json:"requiredAnnotations,omitempty"`
+	// ForbiddenLabels are the keys of labels that a Secret must not have.
+	ForbiddenLabels []string `json:"forbiddenLabels,omitempty"`
+	// ForbiddenAnnotations are the keys of annotations that a Secret must not have.
+	ForbiddenAnnotations []string `json:"forbiddenAnnotations,omitempty"`
+	// MaxKeys is the maximum number of keys of the data of a Secret.
+	MaxKeys int `json:"maxKeys,omitempty"`
+	// MaxKeySize is the maximum size in bytes of the value of a key.
+	MaxKeySize int `json:"maxKeySize,omitempty"`
+	// MaxDataSize is the maximum total size in bytes of the keys and values
+	// of the data of a Secret.
+	MaxDataSize int `json:"maxDataSize,omitempty"`
+}
+
+// Load reads a policy from a YAML file.
+func Load(path string) (*Policy, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse parses a YAML policy. Unknown fields are errors, so that a misspelled
+// check isn't silently ignored.
+func Parse(buf []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(buf, p); err != nil {
+		return nil, err
+	}
+	for _, n := range []struct {
+		name string
+		v    int
+	}{{"maxKeys", p.MaxKeys}, {"maxKeySize", p.MaxKeySize}, {"maxDataSize", p.MaxDataSize}} {
+		if n.v < 0 {
+			return nil, fmt.Errorf("%s must not be negative", n.name)
+		}
+	}
+	return p, nil
+}
+
+// A Violation is a failed check of a policy.
+type Violation struct {
+	// Check is the failed check, e.g. CheckRequiredLabel.
+	Check string
+	// Msg describes the violation.
+	Msg string
+}
+
+// An Error is the violations of a policy by a Secret.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Msg
+	}
+	return "policy violation: " + strings.Join(msgs, "; ")
+}
+
+// Check checks the Secret against the policy and returns an *Error of its
+// violations, if any. A nil policy has no checks.
+func (p *Policy) Check(secret *corev1.Secret) error {
+	if p == nil {
+		return nil
+	}
+	var vs []Violation
+	for _, pattern := range p.RequiredLabels {
+		if len(matching(pattern, secret.Labels)) == 0 {
+			vs = append(vs, Violation{CheckRequiredLabel, fmt.Sprintf("missing required label %s", pattern)})
+		}
+	}
+	for _, pattern := range p.RequiredAnnotations {
+		if len(matching(pattern, secret.Annotations)) == 0 {
+			vs = append(vs, Violation{CheckRequiredAnnotation, fmt.Sprintf("missing required annotation %s", pattern)})
+		}
+	}
+	for _, pattern := range p.ForbiddenLabels {
+		for _, k := range matching(pattern, secret.Labels) {
+			vs = append(vs, Violation{CheckForbiddenLabel, fmt.Sprintf("forbidden label %s", k)})
+		}
+	}
+	for _, pattern := range p.ForbiddenAnnotations {
+		for _, k := range matching(pattern, secret.Annotations) {
+			vs = append(vs, Violation{CheckForbiddenAnnotation, fmt.Sprintf("forbidden annotation %s", k)})
+		}
+	}
+	if p.MaxKeys > 0 && len(secret.Data) > p.MaxKeys {
+		vs = append(vs, Violation{CheckMaxKeys, fmt.Sprintf("%d keys exceed the maximum of %d", len(secret.Data), p.MaxKeys)})
+	}
+	size := 0
+	keys := make([]string, 0, len(secret.Data))
+	for k, v := range secret.Data {
+		size += len(k) + len(v)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if p.MaxKeySize > 0 {
+		for _, k := range keys {
+			if n := len(secret.Data[k]); n > p.MaxKeySize {
+				vs = append(vs, Violation{CheckMaxKeySize, fmt.Sprintf("key %s of %d bytes exceeds the maximum of %d", k, n, p.MaxKeySize)})
+			}
+		}
+	}
+	if p.MaxDataSize > 0 && size > p.MaxDataSize {
+		vs = append(vs, Violation{CheckMaxDataSize, fmt.Sprintf("data of %d bytes exceeds the maximum of %d", size, p.MaxDataSize)})
+	}
+	if len(vs) == 0 {
+		return nil
+	}
+	return &Error{Violations: vs}
+}
+
+// matching returns the sorted keys of m that match the pattern.
+func matching(pattern string, m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		if match(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// match returns whether s matches the pattern, in which * matches any
+// sequence of characters, including slashes.
+func match(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
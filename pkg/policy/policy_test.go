@@ -0,0 +1,131 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		text    string
+		wantErr bool
+	}{
+		{text: ""},
+		{text: "requiredLabels: [team]\nmaxDataSize: 1024\n"},
+		{text: "requiredLabel: [team]\n", wantErr: true},
+		{text: "maxKeys: -1\n", wantErr: true},
+		{text: "maxKeys: many\n", wantErr: true},
+	} {
+		if _, err := Parse([]byte(tt.text)); (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error: %v", tt.text, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("maxKeys: 2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.MaxKeys != 2 {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{
+		RequiredLabels:       []string{"team", "app.kubernetes.io/*"},
+		RequiredAnnotations:  []string{"owner"},
+		ForbiddenLabels:      []string{"debug"},
+		ForbiddenAnnotations: []string{"kubectl.kubernetes.io/last-applied-configuration", "*.internal.example.com/*"},
+		MaxKeys:              2,
+		MaxKeySize:           4,
+		MaxDataSize:          10,
+	}
+	valid := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"team": "sre", "app.kubernetes.io/name": "app"},
+			Annotations: map[string]string{"owner": "sre", "example.com/note": "ok"},
+		},
+		Data: map[string][]byte{"a": []byte("1234")},
+	}
+	if err := p.Check(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (*Policy)(nil).Check(&corev1.Secret{}); err != nil {
+		t.Errorf("unexpected error of nil policy: %v", err)
+	}
+
+	invalid := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"debug": "true"},
+			Annotations: map[string]string{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				"vault.internal.example.com/path":                  "secret/app",
+			},
+		},
+		Data: map[string][]byte{"a": []byte("12345"), "b": nil, "c": nil},
+	}
+	err := p.Check(invalid)
+	var policyErr *Error
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Violation{
+		{CheckRequiredLabel, "missing required label team"},
+		{CheckRequiredLabel, "missing required label app.kubernetes.io/*"},
+		{CheckRequiredAnnotation, "missing required annotation owner"},
+		{CheckForbiddenLabel, "forbidden label debug"},
+		{CheckForbiddenAnnotation, "forbidden annotation kubectl.kubernetes.io/last-applied-configuration"},
+		{CheckForbiddenAnnotation, "forbidden annotation vault.internal.example.com/path"},
+		{CheckMaxKeys, "3 keys exceed the maximum of 2"},
+		{CheckMaxKeySize, "key a of 5 bytes exceeds the maximum of 4"},
+	}
+	if !reflect.DeepEqual(policyErr.Violations, want) {
+		t.Errorf("unexpected violations:\nwant: %+v\ngot:  %+v", want, policyErr.Violations)
+	}
+
+	valid.Data["bb"] = []byte("1234")
+	if err := p.Check(valid); err == nil || err.Error() != "policy violation: data of 11 bytes exceeds the maximum of 10" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"a", "a", true},
+		{"a", "ab", false},
+		{"*", "", true},
+		{"a/*", "a/b/c", true},
+		{"*.example.com/*", "x.example.com/y", true},
+		{"*.example.com/*", "example.com/y", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axbyc", true},
+		{"a*b*c", "axbycd", false},
+		{"ab*ba", "aba", false},
+	} {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q): want: %t; got: %t", tt.pattern, tt.s, tt.want, got)
+		}
+	}
+}
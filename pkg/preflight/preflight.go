@@ -0,0 +1,216 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package preflight checks that the controller has been granted the
+// permissions it needs, so that missing RBAC is reported at startup instead
+// of by obscure failures mid-reconcile.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var missingPermissions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "configmapsecret_controller_missing_permissions",
+	Help: "Whether a permission required by the controller is missing, by namespace, group, resource, name, and verb.",
+}, []string{"namespace", "group", "resource", "name", "verb"})
+
+func init() {
+	metrics.Registry.MustRegister(missingPermissions)
+}
+
+// A Permission is a verb on a resource that the controller requires.
+type Permission struct {
+	// Namespace is the namespace of the resource, or empty for all namespaces
+	// or a cluster-scoped resource.
+	Namespace string
+	// Group is the API group of the resource.
+	Group string
+	// Resource is the resource, with its subresource if any, e.g. "configmapsecrets/status".
+	Resource string
+	// Name is the name of the resource, or empty for all names.
+	Name string
+	// Verb is the API verb, e.g. "get".
+	Verb string
+}
+
+// String returns a description of the permission, e.g.
+// "get secrets.mz.com/configmapsecrets in namespace default".
+func (p Permission) String() string {
+	var b strings.Builder
+	b.WriteString(p.Verb)
+	b.WriteByte(' ')
+	if p.Group != "" {
+		b.WriteString(p.Group)
+		b.WriteByte('/')
+	}
+	b.WriteString(p.Resource)
+	if p.Name != "" {
+		b.WriteByte(' ')
+		b.WriteString(p.Name)
+	}
+	if p.Namespace != "" {
+		b.WriteString(" in namespace ")
+		b.WriteString(p.Namespace)
+	} else {
+		b.WriteString(" in all namespaces")
+	}
+	return b.String()
+}
+
+func (p Permission) attributes() *authorizationv1.ResourceAttributes {
+	resource, subresource, _ := strings.Cut(p.Resource, "/")
+	return &authorizationv1.ResourceAttributes{
+		Namespace:   p.Namespace,
+		Verb:        p.Verb,
+		Group:       p.Group,
+		Resource:    resource,
+		Subresource: subresource,
+		Name:        p.Name,
+	}
+}
+
+// Options are the configuration of the controller that determines its
+// required permissions.
+type Options struct {
+	// Namespace is the namespace managed by the controller, or empty for all namespaces.
+	Namespace string
+	// LeaderElectionNamespace is the namespace of the leader election object,
+	// or empty if leader election is disabled.
+	LeaderElectionNamespace string
+	// LeaderElectionID is the name of the leader election object.
+	LeaderElectionID string
+	// CRDName is the name of the CRD checked at startup, or empty if it isn't checked.
+	CRDName string
+	// Render is whether the render API, which reviews the access of its
+	// callers, is served.
+	Render bool
+}
+
+// Required returns the permissions required by the controller configured by opts.
+// They mirror the RBAC markers of the controller.
+func Required(opts Options) []Permission {
+	var perms []Permission
+	add := func(namespace, group, resource, name string, verbs ...string) {
+		for _, verb := range verbs {
+			perms = append(perms, Permission{
+				Namespace: namespace,
+				Group:     group,
+				Resource:  resource,
+				Name:      name,
+				Verb:      verb,
+			})
+		}
+	}
+	ns := opts.Namespace
+	add(ns, "", "events", "", "create", "update")
+	add(ns, "", "configmaps", "", "get", "list", "watch", "create", "update", "delete")
+	add(ns, "", "secrets", "", "get", "list", "watch", "create", "update", "patch", "delete")
+	add(ns, "secrets.mz.com", "configmapsecrets", "", "get", "list", "watch", "update", "patch", "delete")
+	add(ns, "secrets.mz.com", "configmapsecrets/status", "", "get", "update", "patch")
+	add(ns, "secrets.mz.com", "configmapsecrets/finalizers", "", "get", "update", "patch")
+	if election := opts.LeaderElectionNamespace; election != "" {
+		add(election, "", "configmaps", "", "create")
+		add(election, "", "configmaps", opts.LeaderElectionID, "get", "update")
+		add(election, "coordination.k8s.io", "leases", "", "create")
+		add(election, "coordination.k8s.io", "leases", opts.LeaderElectionID, "get", "update")
+	}
+	if opts.CRDName != "" {
+		add("", "apiextensions.k8s.io", "customresourcedefinitions", opts.CRDName, "get")
+	}
+	if opts.Render {
+		add("", "authentication.k8s.io", "tokenreviews", "", "create")
+		add("", "authorization.k8s.io", "subjectaccessreviews", "", "create")
+	}
+	return perms
+}
+
+// A Checker checks the required permissions of the controller with
+// SelfSubjectAccessReviews, which every authenticated user may create.
+type Checker struct {
+	// Client creates the SelfSubjectAccessReviews.
+	Client client.Client
+	// Permissions are the required permissions.
+	Permissions []Permission
+	// Log logs missing permissions.
+	Log logr.Logger
+
+	mu     sync.Mutex
+	passed bool
+}
+
+// Run checks the permissions, logs each that is missing, exports them as a
+// metric, and returns them. An error is returned only if the check can't be
+// made.
+func (c *Checker) Run(ctx context.Context) ([]Permission, error) {
+	missing, err := c.check(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range missing {
+		c.Log.Info("Missing required permission", "permission", p.String(),
+			"namespace", p.Namespace, "group", p.Group, "resource", p.Resource, "name", p.Name, "verb", p.Verb)
+	}
+	if len(missing) > 0 {
+		c.Log.Info("Controller is missing required permissions; check its Role or ClusterRole", "missing", len(missing))
+	}
+	return missing, nil
+}
+
+// Healthz is a healthz.Checker that fails while permissions are missing.
+// Until they've all been granted, each call checks them again, so the
+// controller becomes ready without a restart once its RBAC is fixed.
+func (c *Checker) Healthz(req *http.Request) error {
+	c.mu.Lock()
+	passed := c.passed
+	c.mu.Unlock()
+	if passed {
+		return nil
+	}
+	missing, err := c.check(req.Context())
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		descs := make([]string, 0, len(missing))
+		for _, p := range missing {
+			descs = append(descs, p.String())
+		}
+		return fmt.Errorf("missing permissions: %s", strings.Join(descs, ", "))
+	}
+	return nil
+}
+
+// check returns the missing permissions and updates the metric.
+func (c *Checker) check(ctx context.Context) ([]Permission, error) {
+	var missing []Permission
+	for _, p := range c.Permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: p.attributes()},
+		}
+		if err := c.Client.Create(ctx, review); err != nil {
+			return nil, fmt.Errorf("unable to review access to %s: %w", p, err)
+		}
+		value := 0.0
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+			value = 1
+		}
+		missingPermissions.WithLabelValues(p.Namespace, p.Group, p.Resource, p.Name, p.Verb).Set(value)
+	}
+	c.mu.Lock()
+	c.passed = len(missing) == 0
+	c.mu.Unlock()
+	return missing, nil
+}
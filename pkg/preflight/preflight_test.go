@@ -0,0 +1,103 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preflight
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A reviewClient allows every access except that which is denied.
+type reviewClient struct {
+	client.Client
+	denied  map[authorizationv1.ResourceAttributes]bool
+	reviews int
+	err     error
+}
+
+func (c *reviewClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.reviews++
+	review := obj.(*authorizationv1.SelfSubjectAccessReview)
+	review.Status.Allowed = !c.denied[*review.Spec.ResourceAttributes]
+	return nil
+}
+
+func TestRequired(t *testing.T) {
+	perms := Required(Options{Namespace: "tenant"})
+	for _, p := range perms {
+		if p.Namespace != "tenant" {
+			t.Errorf("unexpected permission outside of the namespace: %s", p)
+		}
+	}
+
+	perms = Required(Options{
+		LeaderElectionNamespace: "kube-system",
+		LeaderElectionID:        "leader",
+		CRDName:                 "configmapsecrets.secrets.mz.com",
+		Render:                  true,
+	})
+	want := map[string]bool{
+		"watch secrets in all namespaces":                                                                      true,
+		"patch secrets.mz.com/configmapsecrets/status in all namespaces":                                       true,
+		"update coordination.k8s.io/leases leader in namespace kube-system":                                    true,
+		"get apiextensions.k8s.io/customresourcedefinitions configmapsecrets.secrets.mz.com in all namespaces": true,
+		"create authorization.k8s.io/subjectaccessreviews in all namespaces":                                   true,
+	}
+	for _, p := range perms {
+		delete(want, p.String())
+	}
+	for s := range want {
+		t.Errorf("missing required permission: %s", s)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+	perms := Required(Options{Namespace: "default"})
+	c := &reviewClient{denied: map[authorizationv1.ResourceAttributes]bool{
+		{Namespace: "default", Verb: "update", Group: "secrets.mz.com", Resource: "configmapsecrets", Subresource: "status"}: true,
+	}}
+	checker := &Checker{Client: c, Permissions: perms, Log: logr.Discard()}
+
+	missing, err := checker.Run(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Permission{{Namespace: "default", Group: "secrets.mz.com", Resource: "configmapsecrets/status", Verb: "update"}}
+	if diff := cmp.Diff(want, missing); diff != "" {
+		t.Errorf("unexpected missing permissions (-want +got):\n%s", diff)
+	}
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	if err := checker.Healthz(req); err == nil {
+		t.Errorf("expected readiness error for missing permissions")
+	}
+
+	c.denied = nil // Granted.
+	if err := checker.Healthz(req); err != nil {
+		t.Errorf("unexpected readiness error: %v", err)
+	}
+	reviews := c.reviews
+	if err := checker.Healthz(req); err != nil {
+		t.Errorf("unexpected readiness error: %v", err)
+	}
+	if c.reviews != reviews {
+		t.Errorf("unexpected reviews after permissions were granted: %d", c.reviews-reviews)
+	}
+
+	c.err = errors.New("unavailable")
+	if _, err := (&Checker{Client: c, Permissions: perms, Log: logr.Discard()}).Run(ctx); err == nil {
+		t.Errorf("expected error when access can't be reviewed")
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preview
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+)
+
+// A KeyExplanation describes the variables used by the template of a key.
+type KeyExplanation struct {
+	// Key is the key of the template data.
+	Key string
+	// Vars are the variables referenced by the template, sorted by name.
+	Vars []VarExplanation
+	// AllVars indicates that the template may reference any variable,
+	// e.g. with Go templates, so Vars are all of the variables.
+	AllVars bool
+}
+
+// A VarExplanation describes where a variable was resolved from, without
+// its value.
+type VarExplanation struct {
+	// Name is the name of the variable.
+	Name string
+	// Source is where the variable was resolved from, e.g. "Secret/name[key]",
+	// "value", or "builtin", or empty if it's unresolved.
+	Source string
+	// Length is the length of its value in bytes.
+	Length int
+}
+
+// Explain renders the ConfigMapSecret with r in trace mode, and describes
+// the variables used by the template of each key, sorted by key. Keys read
+// from a GitSource are included.
+func Explain(ctx context.Context, r *renderer.Renderer, cms *v1alpha1.ConfigMapSecret) ([]KeyExplanation, error) {
+	trace := renderer.NewTrace()
+	if _, _, err := r.Render(ctx, cms, renderer.NewSources(), trace); err != nil {
+		return nil, err
+	}
+	vars := trace.Vars()
+	byKey := make(map[string]KeyExplanation)
+	for _, e := range trace.Expansions() {
+		k := strings.TrimPrefix(e.Target, "key ")
+		if k == e.Target {
+			continue // A var.
+		}
+		names := append(append([]string(nil), e.Resolved...), e.Unresolved...)
+		if e.All {
+			names = make([]string, 0, len(vars))
+			for name := range vars {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		x := KeyExplanation{Key: k, AllVars: e.All}
+		for _, name := range names {
+			v := vars[name]
+			x.Vars = append(x.Vars, VarExplanation{Name: name, Source: v.Source, Length: v.Length})
+		}
+		byKey[k] = x // Later sections take precedence, as in rendering.
+	}
+	list := make([]KeyExplanation, 0, len(byKey))
+	for _, x := range byKey {
+		list = append(list, x)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preview
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// MaxRequestSize is the maximum size of a request body served by Handler.
+const MaxRequestSize = 2 << 20
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Handler returns an HTTP handler that renders the ConfigMapSecret in the
+// JSON or YAML body of a POST request with rr. It responds with the rendered
+// keys as text, in which sensitive values are redacted. If namespace isn't
+// empty, only ConfigMapSecrets in it are rendered.
+//
+// A render reveals the values of ConfigMaps and how Secrets are used, so
+// callers are authenticated by the bearer token of the request with a
+// TokenReview, and must be allowed to get Secrets in the namespace of the
+// ConfigMapSecret by a SubjectAccessReview, both created with c. Errors name
+// the key that failed and the reason, but not their details, which may
+// include values.
+func Handler(rr *renderer.Renderer, c client.Client, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user, ok, err := authenticate(r.Context(), c, r)
+		if err != nil {
+			http.Error(w, "unable to authenticate", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		buf, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestSize))
+		if isTooLarge(err) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "unable to read request body", http.StatusBadRequest)
+			return
+		}
+		cms := &v1alpha1.ConfigMapSecret{}
+		if err := yaml.UnmarshalStrict(buf, cms); err != nil {
+			http.Error(w, "invalid ConfigMapSecret: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cms.Namespace == "" {
+			http.Error(w, "invalid ConfigMapSecret: namespace is required", http.StatusBadRequest)
+			return
+		}
+		if namespace != "" && cms.Namespace != namespace {
+			http.Error(w, "namespace "+cms.Namespace+" is not managed", http.StatusForbidden)
+			return
+		}
+		allowed, err := authorize(r.Context(), c, user, cms.Namespace)
+		if err != nil {
+			http.Error(w, "unable to authorize", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "forbidden: rendering requires get secrets in namespace "+cms.Namespace, http.StatusForbidden)
+			return
+		}
+		doc, _, reason, err := document(r.Context(), rr, cms)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if renderer.IsConfigError(err) {
+				code = http.StatusUnprocessableEntity
+			}
+			http.Error(w, failure(reason, err), code)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, doc)
+	})
+}
+
+// authenticate returns the user of the bearer token of the request, and
+// whether it's authenticated.
+func authenticate(ctx context.Context, c client.Client, r *http.Request) (authenticationv1.UserInfo, bool, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return authenticationv1.UserInfo{}, false, nil
+	}
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return authenticationv1.UserInfo{}, false, err
+	}
+	return review.Status.User, review.Status.Authenticated, nil
+}
+
+// authorize reports whether the user may get Secrets in the namespace.
+func authorize(ctx context.Context, c client.Client, user authenticationv1.UserInfo, namespace string) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Resource:  "secrets",
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package preview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A reviewer authenticates the token "admin-token" as the user admin, who
+// may get Secrets in the default namespace, and "viewer-token" as the user
+// viewer, who may not.
+type reviewer struct {
+	client.Client
+}
+
+func (reviewer) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	switch obj := obj.(type) {
+	case *authenticationv1.TokenReview:
+		user := strings.TrimSuffix(obj.Spec.Token, "-token")
+		ok := user != obj.Spec.Token
+		obj.Status = authenticationv1.TokenReviewStatus{
+			Authenticated: ok && (user == "admin" || user == "viewer"),
+			User:          authenticationv1.UserInfo{Username: user},
+		}
+	case *authorizationv1.SubjectAccessReview:
+		attrs := obj.Spec.ResourceAttributes
+		obj.Status.Allowed = obj.Spec.User == "admin" && attrs.Namespace == "default" && attrs.Verb == "get" && attrs.Resource == "secrets"
+	default:
+		panic("unexpected type")
+	}
+	return nil
+}
+
+const testBody = `
+apiVersion: secrets.mz.com/v1alpha1
+kind: ConfigMapSecret
+metadata:
+  name: app
+  namespace: default
+spec:
+  template:
+    data:
+      host: $(HOST)
+      password: $(PASSWORD)
+  varsFrom:
+  - configMapRef:
+      name: config
+  - secretRef:
+      name: creds
+`
+
+func TestHandler(t *testing.T) {
+	h := Handler(NewRenderer(testReader), reviewer{}, "")
+	for _, tt := range []struct {
+		method   string
+		token    string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     testBody,
+			wantCode: http.StatusOK,
+			wantBody: "# host\ndb.example.com\n# password (redacted: 1 line)\n",
+		},
+		{
+			method:   http.MethodPost,
+			body:     testBody,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			method:   http.MethodPost,
+			token:    "unknown",
+			body:     testBody,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			method:   http.MethodPost,
+			token:    "viewer-token",
+			body:     testBody,
+			wantCode: http.StatusForbidden,
+			wantBody: "forbidden: rendering requires get secrets in namespace default\n",
+		},
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     `{"metadata": {"name": "app"}}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "invalid ConfigMapSecret: namespace is required\n",
+		},
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     `{"metadata": {"name": "app", "namespace": "default"}, "spec": {"varsFrom": [{"secretRef": {"name": "missing"}}]}}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "unable to render: CreateVariablesError\n",
+		},
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     `{"metadata": {"name": "app", "namespace": "default"}, "spec": {"templateVersion": "v2", "varsFrom": [{"secretRef": {"name": "creds"}}], "template": {"data": {"password": "{{ .PASSWORD | fail }}"}}}}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "unable to render key password: TemplateError\n",
+		},
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     `{"spec": {"unknown": true}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			method:   http.MethodPost,
+			token:    "admin-token",
+			body:     strings.Repeat(" ", MaxRequestSize+1),
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: "request body too large\n",
+		},
+		{
+			method:   http.MethodGet,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		req := httptest.NewRequest(tt.method, "/render", strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s %q: unexpected code: want: %d; got: %d: %s", tt.method, tt.body, tt.wantCode, rec.Code, rec.Body)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s %q: unexpected body: want: %q; got: %q", tt.method, tt.body, tt.wantBody, rec.Body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/render", iotest.ErrReader(errors.New("connection reset")))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if want := "unable to read request body\n"; rec.Code != http.StatusBadRequest || rec.Body.String() != want {
+		t.Errorf("unexpected response to a failed read: want: %d %q; got: %d %q", http.StatusBadRequest, want, rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/render", strings.NewReader(`{"metadata": {"name": "app", "namespace": "other"}}`))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec = httptest.NewRecorder()
+	Handler(NewRenderer(testReader), reviewer{}, "default").ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("unexpected code for an unmanaged namespace: want: %d; got: %d", http.StatusForbidden, rec.Code)
+	}
+}
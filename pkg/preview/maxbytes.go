@@ -0,0 +1,20 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package preview
+
+import (
+	"errors"
+	"net/http"
+)
+
+// isTooLarge reports whether err is the error of reading past the limit of
+// an http.MaxBytesReader.
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
@@ -0,0 +1,14 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !go1.19
+// +build !go1.19
+
+package preview
+
+// isTooLarge reports whether err is the error of reading past the limit of
+// an http.MaxBytesReader, which has no type before Go 1.19.
+func isTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
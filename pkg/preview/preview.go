@@ -0,0 +1,196 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package preview renders ConfigMapSecrets outside of the controller and
+// produces redacted diffs of their output, e.g. for GitOps pull requests.
+package preview
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/gitsource"
+	"github.com/machinezone/configmapsecrets/pkg/internal/diff"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/pkg/renderer"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A Value is the rendered value of a key.
+type Value struct {
+	// Data is the rendered data.
+	Data []byte
+	// Sensitive indicates whether the data depends on the contents of a Secret.
+	Sensitive bool
+	// Decompressed is the rendered data before it was compressed, if the
+	// key's value is compressed.
+	Decompressed []byte
+}
+
+// NewRenderer returns a renderer that reads sources from c, with the default
+// configuration of the controller. GitSources are fetched with the default
+// protocols.
+func NewRenderer(c client.Reader) *renderer.Renderer {
+	f := &gitsource.Fetcher{}
+	return &renderer.Renderer{
+		Client:       c,
+		TrustBundles: c,
+		Limits:       render.DefaultLimits,
+		Git: func(ctx context.Context, _ *v1alpha1.ConfigMapSecret, src gitsource.Source) (map[string][]byte, string, error) {
+			return f.Fetch(ctx, src)
+		},
+	}
+}
+
+// Render renders the data of the ConfigMapSecret with r, in the same way as
+// the controller.
+func Render(ctx context.Context, r *renderer.Renderer, cms *v1alpha1.ConfigMapSecret) (map[string]Value, error) {
+	data, _, err := renderValues(ctx, r, cms)
+	return data, err
+}
+
+// renderValues renders the data of the ConfigMapSecret with r. If rendering
+// fails, the reason is returned with the error.
+func renderValues(ctx context.Context, r *renderer.Renderer, cms *v1alpha1.ConfigMapSecret) (map[string]Value, v1alpha1.ConfigMapSecretConditionReason, error) {
+	srcs := renderer.NewSources()
+	trace := renderer.NewTrace()
+	secret, reason, err := r.Render(ctx, cms, srcs, trace)
+	if err != nil {
+		return nil, reason, err
+	}
+	sensitive := keySensitivity(cms, srcs, trace)
+	compressed := make(map[string]v1alpha1.Compression)
+	for k, opts := range cms.Spec.Template.KeyOptions {
+		if out := opts.Compress.Key(k); out != k {
+			compressed[out] = opts.Compress
+		}
+	}
+	data := make(map[string]Value, len(secret.Data))
+	for k, buf := range secret.Data {
+		val := Value{Data: buf, Sensitive: true}
+		if s, ok := sensitive[k]; ok {
+			val.Sensitive = s
+		}
+		if c, ok := compressed[k]; ok {
+			if val.Decompressed, err = render.Decompress(c, buf); err != nil {
+				return nil, v1alpha1.InternalErrorReason, fmt.Errorf("key %s: %v", k, err)
+			}
+		}
+		data[k] = val
+	}
+	return data, "", nil
+}
+
+// keySensitivity returns whether the template of each key of the rendered
+// data references a variable that depends on a Secret, by output key.
+// Keys that weren't rendered from the template, e.g. added by hooks, are
+// omitted, so that they're treated as sensitive.
+func keySensitivity(cms *v1alpha1.ConfigMapSecret, srcs *renderer.Sources, trace *renderer.Trace) map[string]bool {
+	sensitive := make(map[string]bool)
+	for _, e := range trace.Expansions() {
+		k := strings.TrimPrefix(e.Target, "key ")
+		if k == e.Target {
+			continue
+		}
+		s := e.All && len(srcs.Sensitive) > 0
+		for _, name := range e.Resolved {
+			s = s || srcs.Sensitive[name]
+		}
+		sensitive[cms.Spec.Template.KeyOptions[k].Compress.Key(k)] = s
+	}
+	return sensitive
+}
+
+// Diff renders both versions of the ConfigMapSecret with r and returns a unified diff
+// of their output, or an empty string if they're equal. Either version may be
+// nil, e.g. when it's being created or deleted. If the old version fails to
+// render, e.g. since its sources changed, its output is the failure, so that a
+// fix can be reviewed.
+//
+// The contents of sensitive values are redacted, such that only their keys
+// and line counts are included, and failures only name their key and reason.
+func Diff(ctx context.Context, r *renderer.Renderer, old, new *v1alpha1.ConfigMapSecret) (string, error) {
+	a, aName, reason, err := document(ctx, r, old)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		a = "# " + failure(reason, err) + "\n"
+		aName = types.NamespacedName{Namespace: old.Namespace, Name: old.Name}.String()
+	}
+	b, bName, _, err := document(ctx, r, new)
+	if err != nil {
+		return "", err
+	}
+	if aName == "" {
+		aName = bName
+	}
+	if bName == "" {
+		bName = aName
+	}
+	return diff.Unified("a/"+aName, "b/"+bName, a, b), nil
+}
+
+// document returns the rendered output of cms as text and its name. If
+// rendering fails, the reason is returned with the error.
+func document(ctx context.Context, r *renderer.Renderer, cms *v1alpha1.ConfigMapSecret) (doc, name string, reason v1alpha1.ConfigMapSecretConditionReason, err error) {
+	if cms == nil {
+		return "", "", "", nil
+	}
+	data, reason, err := renderValues(ctx, r, cms)
+	if err != nil {
+		return "", "", reason, err
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		v := data[k]
+		// Compressed values are compared by their decompressed text.
+		if v.Decompressed != nil {
+			v.Data = v.Decompressed
+			k += " (decompressed)"
+		}
+		if v.Sensitive {
+			n, unit := countLines(v.Data), "lines"
+			if n == 1 {
+				unit = "line"
+			}
+			fmt.Fprintf(&sb, "# %s (redacted: %d %s)\n", k, n, unit)
+			continue
+		}
+		fmt.Fprintf(&sb, "# %s\n", k)
+		sb.Write(v.Data)
+		if len(v.Data) > 0 && v.Data[len(v.Data)-1] != '\n' {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}.String(), "", nil
+}
+
+// failure returns a description of a render failure with the reason, which
+// names the key that failed, but not the details of err, which may include
+// values.
+func failure(reason v1alpha1.ConfigMapSecretConditionReason, err error) string {
+	if key, ok := renderer.ErrorKey(err); ok {
+		return "unable to render key " + key + ": " + string(reason)
+	}
+	return "unable to render: " + string(reason)
+}
+
+func countLines(b []byte) int {
+	n := strings.Count(string(b), "\n")
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+// Compress returns the data compressed with the algorithm. An empty algorithm
+// returns the data unchanged.
+//
+// The output is deterministic, so that the same rendered value doesn't
+// update a Secret: the gzip header has no name or modification time.
+func Compress(c v1alpha1.Compression, data []byte) ([]byte, error) {
+	switch c {
+	case "":
+		return data, nil
+	case v1alpha1.CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", c)
+	}
+}
+
+// Decompress returns the data decompressed with the algorithm. An empty
+// algorithm returns the data unchanged.
+func Decompress(c v1alpha1.Compression, data []byte) ([]byte, error) {
+	switch c {
+	case "":
+		return data, nil
+	case v1alpha1.CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", c)
+	}
+}
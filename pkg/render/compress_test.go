@@ -0,0 +1,67 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("host: db.example.com\n"), 100)
+
+	got, err := Compress("", data)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected data unchanged without compression, got: %q, %v", got, err)
+	}
+
+	got, err = Compress(v1alpha1.CompressionGzip, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) >= len(data) {
+		t.Errorf("expected compressed size less than %d, got: %d", len(data), len(got))
+	}
+	again, err := Compress(v1alpha1.CompressionGzip, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, again) {
+		t.Error("expected compression to be deterministic")
+	}
+	r, err := gzip.NewReader(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("unexpected decompressed data: %q", decompressed)
+	}
+	if decompressed, err = Decompress(v1alpha1.CompressionGzip, got); err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("unexpected decompressed data: %q, %v", decompressed, err)
+	}
+
+	if _, err := Compress("zstd", data); err == nil {
+		t.Error("expected error for unsupported compression")
+	}
+}
+
+func TestCompressedLimits(t *testing.T) {
+	l := Limits{MaxOutputSize: 8, MaxDecompressedSize: 64}
+	if got := l.Compressed().MaxOutputSize; got != 64 {
+		t.Errorf("unexpected compressed output limit: %d", got)
+	}
+	l.MaxDecompressedSize = 0
+	if got := l.Compressed().MaxOutputSize; got != 8 {
+		t.Errorf("unexpected compressed output limit without a decompressed limit: %d", got)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+)
+
+func ExampleForVersion() {
+	vars := map[string]string{"USER": "app", "PASSWORD": "hunter2"}
+	for _, tt := range []struct {
+		version v1alpha1.TemplateVersion
+		text    string
+	}{
+		{v1alpha1.TemplateVersionV1, "postgres://$(USER):$(PASSWORD)@db/app $$(USER)"},
+		{v1alpha1.TemplateVersionV2, `{{ .USER }}:{{ .PASSWORD | printf "%q" }}`},
+	} {
+		engine, err := render.ForVersion(tt.version, render.DefaultLimits)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		out, err := engine.Render(context.Background(), "dsn", tt.text, vars)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("%s: %s\n", tt.version, out)
+	}
+	// Output:
+	// v1: postgres://app:hunter2@db/app $(USER)
+	// v2: app:"hunter2"
+}
+
+func ExampleForSpec() {
+	spec := &v1alpha1.ConfigMapSecretSpec{DisableExpansion: true}
+	engine, err := render.ForSpec(spec, render.DefaultLimits)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	out, err := engine.Render(context.Background(), "key", "$(USER)", map[string]string{"USER": "app"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(out)
+	// Output: $(USER)
+}
+
+func ExampleEngine_Refs() {
+	engine, err := render.ForVersion(v1alpha1.TemplateVersionV2, render.DefaultLimits)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	names, all := engine.Refs("key", `{{ .USER }}{{ if .DEBUG }} debug{{ end }}`)
+	fmt.Println(names, all)
+	// Output: [DEBUG USER] false
+}
+
+func ExampleLimits() {
+	engine, err := render.ForVersion(v1alpha1.TemplateVersionV1, render.Limits{MaxOutputSize: 8})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = engine.Render(context.Background(), "key", "$(A)$(A)", map[string]string{"A": "hello"})
+	fmt.Println(render.IsLimitError(err), err)
+	// Output: true output exceeds 8 bytes
+}
+
+func ExampleValidate() {
+	fmt.Println(render.Validate(v1alpha1.ContentTypeJSON, "{\n  \"a\": 1,\n}\n"))
+	fmt.Println(render.Validate(v1alpha1.ContentTypeJSON, `{"a": 1}`))
+	// Output:
+	// invalid json at line 3, column 1: invalid character looking for beginning of object key string
+	// <nil>
+}
+
+// This example renders a structured config file with a loop and a conditional,
+// using some of the functions of Go templates.
+func ExampleForVersion_functions() {
+	vars := map[string]string{
+		"HOSTS":    "db-0.example.com,db-1.example.com",
+		"PASSWORD": "hunter2",
+		"TLS":      "true",
+	}
+	text := `hosts:
+{{- range splitList "," .HOSTS }}
+  - {{ . | quote }}
+{{- end }}
+password: {{ .PASSWORD | b64enc }}
+tls: {{ ternary "verify-full" "disable" (eq .TLS "true") }}
+timeout: {{ index . "TIMEOUT" | default "30s" }}
+`
+	engine, err := render.ForVersion(v1alpha1.TemplateVersionV2, render.DefaultLimits)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	out, err := engine.Render(context.Background(), "config.yaml", text, vars)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Print(out)
+	// Output:
+	// hosts:
+	//   - "db-0.example.com"
+	//   - "db-1.example.com"
+	// password: aHVudGVyMg==
+	// tls: verify-full
+	// timeout: 30s
+}
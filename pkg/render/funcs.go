@@ -0,0 +1,300 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// funcs are the functions of Go templates. They're a subset of the Sprig
+// library (https://masterminds.github.io/sprig/) with the same names and
+// arguments, so that templates are portable to and from other tools. Functions
+// whose results aren't a function of their arguments, such as now, uuidv4, and
+// env, are omitted, since a render must only depend on the template and its
+// variables. Unlike Sprig, invalid input fails the render rather than rendering
+// an empty or error string, and keys returns sorted keys. Errors never contain
+// the arguments of a function, since they're reported in conditions and events,
+// and functions whose results are built before they're written fail if they'd
+// exceed maxFuncOutput.
+var funcs = template.FuncMap{
+	// Strings.
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    replace,
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"repeat":     repeat,
+	"quote":      quote,
+	"squote":     squote,
+	"indent":     indent,
+	"nindent":    nindent,
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"toString":   strval,
+	"atoi":       atoi,
+
+	// Defaults.
+	"default":  dfault,
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+
+	// Lists and dictionaries.
+	"list":      func(v ...interface{}) []interface{} { return v },
+	"dict":      dict,
+	"hasKey":    func(d map[string]interface{}, key string) bool { _, ok := d[key]; return ok },
+	"keys":      keys,
+	"sortAlpha": sortAlpha,
+
+	// Encodings.
+	"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":       b64dec,
+	"toJson":       toJSON,
+	"toPrettyJson": toPrettyJSON,
+	"fromJson":     fromJSON,
+	"sha256sum":    func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+}
+
+// maxFuncOutput is the maximum output size of functions such as repeat, which
+// can't be limited by the output writer, since their results are built before
+// they're written.
+const maxFuncOutput = 1 << 20
+
+func repeat(count int, s string) (string, error) {
+	if count < 0 || (count > 0 && len(s) > maxFuncOutput/count) {
+		return "", fmt.Errorf("repeat count %d of %d bytes exceeds %d bytes", count, len(s), maxFuncOutput)
+	}
+	return strings.Repeat(s, count), nil
+}
+
+func replace(old, new, s string) (string, error) {
+	// An empty old string matches before every rune and at the end.
+	if n := strings.Count(s, old); n > 0 && len(new) > len(old) && len(new)-len(old) > (maxFuncOutput-len(s))/n {
+		return "", fmt.Errorf("replace of %d matches in %d bytes exceeds %d bytes", n, len(s), maxFuncOutput)
+	}
+	return strings.ReplaceAll(s, old, new), nil
+}
+
+func quote(v ...interface{}) string {
+	var out []string
+	for _, s := range v {
+		if s != nil {
+			out = append(out, strconv.Quote(strval(s)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func squote(v ...interface{}) string {
+	var out []string
+	for _, s := range v {
+		if s != nil {
+			out = append(out, "'"+strval(s)+"'")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func indent(spaces int, s string) (string, error) {
+	lines := strings.Count(s, "\n") + 1
+	if spaces < 0 || (spaces > 0 && lines > (maxFuncOutput-len(s))/spaces) {
+		return "", fmt.Errorf("indent of %d spaces of %d lines exceeds %d bytes", spaces, lines, maxFuncOutput)
+	}
+	return indentLines(spaces, s), nil
+}
+
+func nindent(spaces int, s string) (string, error) {
+	s, err := indent(spaces, s)
+	return "\n" + s, err
+}
+
+// indentLines indents each line of s by the number of spaces.
+func indentLines(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// atoi is strconv.Atoi with errors that don't contain s.
+func atoi(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return 0, numErr.Err
+	}
+	return n, err
+}
+
+func join(sep string, v interface{}) string {
+	return strings.Join(strslice(v), sep)
+}
+
+// strval returns v as a string.
+func strval(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// strslice returns the elements of a slice or array as strings, omitting
+// nils, or v as a string if it isn't one.
+func strslice(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case nil:
+		return nil
+	}
+	val := reflect.ValueOf(v)
+	if k := val.Kind(); k != reflect.Slice && k != reflect.Array {
+		return []string{strval(v)}
+	}
+	var out []string
+	for i := 0; i < val.Len(); i++ {
+		if e := val.Index(i).Interface(); e != nil {
+			out = append(out, strval(e))
+		}
+	}
+	return out
+}
+
+// empty returns whether v is nil or the zero value of its type, or an empty
+// slice, map, or array.
+func empty(v interface{}) bool {
+	val := reflect.ValueOf(v)
+	if !val.IsValid() {
+		return true
+	}
+	switch val.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return val.Len() == 0
+	case reflect.Struct:
+		return false
+	default:
+		return val.IsZero()
+	}
+}
+
+func dfault(d interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return d
+	}
+	return given[0]
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, e := range v {
+		if !empty(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+func ternary(t, f interface{}, cond bool) interface{} {
+	if cond {
+		return t
+	}
+	return f
+}
+
+func dict(v ...interface{}) map[string]interface{} {
+	d := make(map[string]interface{}, (len(v)+1)/2)
+	for i := 0; i < len(v); i += 2 {
+		key := strval(v[i])
+		if i+1 >= len(v) {
+			d[key] = ""
+			break
+		}
+		d[key] = v[i+1]
+	}
+	return d
+}
+
+func keys(dicts ...map[string]interface{}) []string {
+	var out []string
+	for _, d := range dicts {
+		for k := range d {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortAlpha(v interface{}) []string {
+	out := append([]string(nil), strslice(v)...)
+	sort.Strings(out)
+	return out
+}
+
+func b64dec(s string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	return string(buf), jsonError(err)
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	return string(buf), jsonError(err)
+}
+
+func fromJSON(s string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, jsonError(err)
+	}
+	return m, nil
+}
+
+// jsonError returns err without the values it may contain, i.e. the invalid
+// character of a syntax error, the number of a type error, or an unsupported
+// value.
+func jsonError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var valueErr *json.UnsupportedValueError
+	switch {
+	case errors.As(err, &syntaxErr):
+		msg := jsonInvalidChar.ReplaceAllString(syntaxErr.Error(), "invalid character")
+		return fmt.Errorf("json: %s at offset %d", msg, syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		kind := strings.SplitN(typeErr.Value, " ", 2)[0] // E.g. "number 1e999".
+		return fmt.Errorf("json: cannot unmarshal %s into a value of type %s", kind, typeErr.Type)
+	case errors.As(err, &valueErr):
+		return errors.New("json: unsupported value")
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestFuncs(t *testing.T) {
+	vars := map[string]string{
+		"USER":  "admin",
+		"HOSTS": "a.example.com,b.example.com",
+		"JSON":  `{"b":2,"a":"x"}`,
+		"EMPTY": "",
+	}
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: `{{ .USER | upper }} {{ "ADMIN" | lower }}`, want: "ADMIN admin"},
+		{text: `{{ trim "  x  " }}|{{ trimAll "$" "$5$" }}|{{ trimPrefix "a." "a.b" }}|{{ trimSuffix ".b" "a.b" }}`, want: "x|5|b|a"},
+		{text: `{{ replace "." "-" "a.b.c" }}`, want: "a-b-c"},
+		{text: `{{ contains "dm" .USER }} {{ hasPrefix "ad" .USER }} {{ hasSuffix "x" .USER }}`, want: "true true false"},
+		{text: `{{ repeat 3 "ab" }}`, want: "ababab"},
+		{text: `{{ repeat 1048576 "ab" }}`, wantErr: true},
+		{text: `{{ quote .USER 1 }} {{ squote .USER }}`, want: `"admin" "1" 'admin'`},
+		{text: "a:{{ \"b: 1\\nc: 2\" | nindent 2 }}", want: "a:\n  b: 1\n  c: 2"},
+		{text: `{{ range splitList "," .HOSTS }}[{{ . }}]{{ end }}`, want: "[a.example.com][b.example.com]"},
+		{text: `{{ splitList "," .HOSTS | join ";" }}`, want: "a.example.com;b.example.com"},
+		{text: `{{ list 1 "b" nil | join "," }}`, want: "1,b"},
+		{text: `{{ atoi "42" | toString }}`, want: "42"},
+		{text: `{{ atoi "x" }}`, wantErr: true},
+		{text: `{{ default "none" .EMPTY }} {{ default "none" .USER }} {{ index . "MISSING" | default "none" }}`, want: "none admin none"},
+		{text: `{{ empty .EMPTY }} {{ empty .USER }} {{ empty list }} {{ empty 0 }}`, want: "true false true true"},
+		{text: `{{ coalesce .EMPTY "" .USER }}`, want: "admin"},
+		{text: `{{ ternary "yes" "no" (eq .USER "admin") }}`, want: "yes"},
+		{text: `{{ $d := dict "user" .USER "port" 5432 }}{{ hasKey $d "user" }} {{ keys $d | join "," }} {{ toJson $d }}`, want: `true port,user {"port":5432,"user":"admin"}`},
+		{text: `{{ list "b" "c" "a" | sortAlpha | toJson }}`, want: `["a","b","c"]`},
+		{text: `{{ $j := fromJson .JSON }}{{ $j.a }} {{ keys $j | join "," }}`, want: "x a,b"},
+		{text: `{{ fromJson "[" }}`, wantErr: true},
+		{text: `{{ toPrettyJson (dict "a" 1) }}`, want: "{\n  \"a\": 1\n}"},
+		{text: `{{ b64enc .USER }} {{ b64enc .USER | b64dec }}`, want: "YWRtaW4= admin"},
+		{text: `{{ b64dec "!" }}`, wantErr: true},
+		{text: `{{ sha256sum "" }}`, want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{text: `{{ replace "" "-" "ab" }}`, want: "-a-b-"},
+		{text: `{{ repeat 65536 "ab" | replace "" "0123456789" }}`, wantErr: true},
+		{text: `{{ indent 1048576 "a" }}`, wantErr: true},
+		{text: `{{ repeat 1024 "\n" | nindent 1024 }}`, wantErr: true},
+		{text: `{{ indent -1 "a" }}`, wantErr: true},
+	}
+	e, err := ForVersion(v1alpha1.TemplateVersionV2, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range tests {
+		got, err := e.Render(context.Background(), "key", tt.text, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: unexpected error: %v", tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: unexpected output: want: %q; got: %q", tt.text, tt.want, got)
+		}
+	}
+}
+
+func TestFuncErrorsOmitArguments(t *testing.T) {
+	vars := map[string]string{
+		"PASSWORD": "hunter2",
+		"JSON":     `{"password": 1e999}`,
+	}
+	e, err := ForVersion(v1alpha1.TemplateVersionV2, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, text := range []string{
+		`{{ atoi .PASSWORD }}`,
+		`{{ fromJson .PASSWORD }}`,
+		`{{ b64dec .PASSWORD }}`,
+		`{{ fromJson .JSON }}`,
+	} {
+		_, err := e.Render(context.Background(), "key", text, vars)
+		if err == nil {
+			t.Errorf("%q: expected an error", text)
+			continue
+		}
+		for _, s := range []string{"hunter2", "'h'", "1e999"} {
+			if strings.Contains(err.Error(), s) {
+				t.Errorf("%q: error contains %q: %v", text, s, err)
+			}
+		}
+	}
+}
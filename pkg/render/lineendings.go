@@ -0,0 +1,44 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+// ConvertLineEndings returns the text with its line endings converted. An
+// empty line ending returns the text unchanged.
+//
+// Converting to CRLF leaves existing CRLF line endings unchanged, rather than
+// doubling their CR, so text with mixed line endings is converted consistently
+// and converting twice is the same as converting once. A CR that isn't followed
+// by LF isn't a line ending and is never changed.
+func ConvertLineEndings(le v1alpha1.LineEnding, text string) (string, error) {
+	switch le {
+	case "":
+		return text, nil
+	case v1alpha1.LineEndingLF:
+		return strings.ReplaceAll(text, "\r\n", "\n"), nil
+	case v1alpha1.LineEndingCRLF:
+		n := strings.Count(text, "\n") - strings.Count(text, "\r\n")
+		if n == 0 {
+			return text, nil
+		}
+		var sb strings.Builder
+		sb.Grow(len(text) + n)
+		for i := 0; i < len(text); i++ {
+			if text[i] == '\n' && (i == 0 || text[i-1] != '\r') {
+				sb.WriteByte('\r')
+			}
+			sb.WriteByte(text[i])
+		}
+		return sb.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported line ending: %q", le)
+	}
+}
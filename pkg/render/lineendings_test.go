@@ -0,0 +1,45 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestConvertLineEndings(t *testing.T) {
+	tests := []struct {
+		le   v1alpha1.LineEnding
+		text string
+		want string
+	}{
+		{le: "", text: "a\nb\r\nc", want: "a\nb\r\nc"},
+		{le: v1alpha1.LineEndingLF, text: "a\r\nb\r\n", want: "a\nb\n"},
+		{le: v1alpha1.LineEndingLF, text: "a\nb\r\nc\rd", want: "a\nb\nc\rd"},
+		{le: v1alpha1.LineEndingCRLF, text: "a\nb\n", want: "a\r\nb\r\n"},
+		{le: v1alpha1.LineEndingCRLF, text: "\na\r\nb\nc\rd", want: "\r\na\r\nb\r\nc\rd"},
+		{le: v1alpha1.LineEndingCRLF, text: "a\r\nb\r\n", want: "a\r\nb\r\n"},
+		{le: v1alpha1.LineEndingCRLF, text: "a\r\r\n", want: "a\r\r\n"},
+		{le: v1alpha1.LineEndingCRLF, text: "", want: ""},
+	}
+	for _, tt := range tests {
+		got, err := ConvertLineEndings(tt.le, tt.text)
+		if err != nil {
+			t.Errorf("%s %q: unexpected error: %v", tt.le, tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %q: want: %q; got: %q", tt.le, tt.text, tt.want, got)
+		}
+		// Converting is idempotent.
+		if again, _ := ConvertLineEndings(tt.le, got); again != got {
+			t.Errorf("%s %q: converted again: want: %q; got: %q", tt.le, tt.text, got, again)
+		}
+	}
+	if _, err := ConvertLineEndings("CR", "a\n"); err == nil {
+		t.Error("expected error for unsupported line ending")
+	}
+}
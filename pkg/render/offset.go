@@ -0,0 +1,83 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// An OffsetError is an error located at a byte offset of a value, rather than
+// at a line and column, which are meaningless in binary data.
+type OffsetError struct {
+	// Problem is the kind of problem, e.g. "template error" or "invalid json".
+	Problem string
+	// Offset is the 0-based byte offset of the problem.
+	Offset int
+	// Msg describes the problem, without its location.
+	Msg string
+	// Err is the located error.
+	Err error
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("%s at byte %d: %s", e.Problem, e.Offset, e.Msg)
+}
+
+func (e *OffsetError) Unwrap() error { return e.Err }
+
+// templateErrPos matches the position of an error of a Go template, which is
+// a line and, for execution errors, a 0-based byte column.
+var templateErrPos = regexp.MustCompile(`^template: (.*?):(\d+)(?::(\d+))?: (.*)$`)
+
+// AtOffset returns err located at a byte offset: a *SyntaxError of the rendered
+// value, or an error of the template named name whose text is a template with
+// the position of the problem. Other errors, and errors whose position isn't
+// known, are returned unchanged.
+func AtOffset(name, text string, err error) error {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		if syntaxErr.Line == 0 {
+			return err
+		}
+		offset := lineOffset(text, syntaxErr.Line)
+		for col := 1; col < syntaxErr.Column && offset < len(text); col++ {
+			_, size := utf8.DecodeRuneInString(text[offset:])
+			offset += size
+		}
+		return &OffsetError{Problem: "invalid " + string(syntaxErr.Type), Offset: offset, Msg: syntaxErr.Msg, Err: err}
+	}
+	m := templateErrPos.FindStringSubmatch(err.Error())
+	if m == nil || m[1] != name {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	offset := lineOffset(text, line)
+	if m[3] != "" {
+		col, _ := strconv.Atoi(m[3])
+		if offset += col; offset > len(text) {
+			offset = len(text)
+		}
+	}
+	return &OffsetError{Problem: "template error", Offset: offset, Msg: m[4], Err: err}
+}
+
+// lineOffset returns the byte offset of the start of the 1-based line of
+// text, or its length if it has fewer lines.
+func lineOffset(text string, line int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	return offset
+}
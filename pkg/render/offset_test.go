@@ -0,0 +1,91 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestAtOffset(t *testing.T) {
+	engine, err := ForVersion(v1alpha1.TemplateVersionV2, Limits{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	templateErr := func(text string) error {
+		_, err := engine.Render(context.Background(), "key blob", text, nil)
+		return err
+	}
+	other := errors.New("other")
+
+	tests := []struct {
+		name   string
+		target string // The name of the template, if not "key blob".
+		text   string
+		err    error
+		want   string
+	}{
+		{
+			name: "exec error",
+			text: "\x00\n\xff{{ .X }}",
+			err:  templateErr("\x00\n\xff{{ .X }}"),
+			want: `template error at byte 6: executing "key blob" at <.X>: map has no entry for key "X"`,
+		},
+		{
+			name: "parse error",
+			text: "\x00\n\xff{{ if }}",
+			err:  templateErr("\x00\n\xff{{ if }}"),
+			want: "template error at byte 2: missing value for if",
+		},
+		{
+			name: "json",
+			text: "\xff\n[1,]",
+			err:  Validate(v1alpha1.ContentTypeJSON, "\xff\n[1,]"),
+			want: "invalid json at byte 0: invalid character looking for beginning of value",
+		},
+		{
+			name: "json column",
+			text: "[1,\n\xff 2]",
+			err:  Validate(v1alpha1.ContentTypeJSON, "[1,\n\xff 2]"),
+			want: "invalid json at byte 4: invalid character looking for beginning of value",
+		},
+		{
+			name: "yaml line",
+			text: "a: b\n  c: d\n",
+			err:  Validate(v1alpha1.ContentTypeYAML, "a: b\n  c: d\n"),
+			want: "invalid yaml at byte 5: mapping values are not allowed in this context",
+		},
+		{
+			name:   "other template",
+			target: "key other",
+			text:   "{{ .X }}",
+			err:    templateErr("{{ .X }}"),
+			want:   `template: key blob:1:3: executing "key blob" at <.X>: map has no entry for key "X"`,
+		},
+		{
+			name: "unlocated",
+			err:  other,
+			want: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "key blob"
+			}
+			err := AtOffset(target, tt.text, tt.err)
+			if got := err.Error(); got != tt.want {
+				t.Errorf("unexpected error: want: %q; got: %q", tt.want, got)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error doesn't wrap %v", tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package render implements the template languages of ConfigMapSecrets.
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+)
+
+// An Engine renders template data using variables.
+type Engine interface {
+	// Parse returns an error if the template text is invalid.
+	Parse(name, text string) error
+	// Render renders the template text with the variables. If rendering
+	// exceeds the engine's limits, a *LimitError is returned.
+	Render(ctx context.Context, name, text string, vars map[string]string) (string, error)
+	// Refs returns the sorted names of the variables referenced by the template
+	// text, and whether it may reference every variable, e.g. dynamically.
+	Refs(name, text string) (names []string, all bool)
+}
+
+// Limits are the resources with which a template may be rendered,
+// so a pathological template can't stall the controller. A zero limit is disabled.
+type Limits struct {
+	// Timeout is the maximum duration of rendering a template.
+	Timeout time.Duration
+	// MaxOutputSize is the maximum size of the output of a template, in bytes.
+	MaxOutputSize int
+	// MaxDecompressedSize is the maximum size of the output of a template
+	// whose value is compressed, in bytes. If zero, it's MaxOutputSize.
+	MaxDecompressedSize int
+}
+
+// DefaultLimits are the default limits.
+var DefaultLimits = Limits{
+	Timeout:             10 * time.Second,
+	MaxOutputSize:       1 << 20, // The maximum size of a Secret.
+	MaxDecompressedSize: 16 << 20,
+}
+
+// Compressed returns the limits of a template whose value is compressed.
+func (l Limits) Compressed() Limits {
+	if l.MaxDecompressedSize != 0 {
+		l.MaxOutputSize = l.MaxDecompressedSize
+	}
+	return l
+}
+
+// A LimitError is returned when rendering a template exceeds its limits
+// or panics.
+type LimitError struct {
+	msg string
+}
+
+func (e *LimitError) Error() string { return e.msg }
+
+// IsLimitError reports whether err is or wraps a *LimitError.
+func IsLimitError(err error) bool {
+	var limitErr *LimitError
+	return errors.As(err, &limitErr)
+}
+
+// ForVersion returns the engine of the template version, which renders
+// templates within the limits.
+func ForVersion(version v1alpha1.TemplateVersion, limits Limits) (Engine, error) {
+	switch version {
+	case "", v1alpha1.TemplateVersionV1: // Default.
+		return ForExpander(expansion.Default, limits), nil
+	case v1alpha1.TemplateVersionV2:
+		return goEngine{limits}, nil
+	}
+	return nil, fmt.Errorf("unsupported template version: %q", version)
+}
+
+// ForSpec returns the engine of the ConfigMapSecret spec, which renders
+// templates within the limits. If expansion is disabled, it's Literal.
+func ForSpec(spec *v1alpha1.ConfigMapSecretSpec, limits Limits) (Engine, error) {
+	if spec.DisableExpansion {
+		return Literal, nil
+	}
+	engine, err := ForVersion(spec.TemplateVersion, limits)
+	if err != nil || spec.Template.Delimiters == nil {
+		return engine, err
+	}
+	if _, ok := engine.(expansionEngine); !ok {
+		return nil, fmt.Errorf("delimiters are only supported by template version %q", v1alpha1.TemplateVersionV1)
+	}
+	d := spec.Template.Delimiters
+	if d.Left == "" || d.Right == "" {
+		return nil, errors.New("delimiters must not be empty")
+	}
+	return ForExpander(expansion.Delims{Left: d.Left, Right: d.Right}, limits), nil
+}
+
+// ForExpander returns an engine that expands variable references with the
+// expander, which renders templates within the limits. References to
+// undefined variables are unchanged, and UnresolvedRefs reports them.
+func ForExpander(expander expansion.Expander, limits Limits) Engine {
+	return expansionEngine{limits, expander}
+}
+
+// ForKey returns the engine with which the key of the template is rendered:
+// Literal if the key is verbatim, or else engine.
+func ForKey(engine Engine, tmpl *v1alpha1.ConfigMapTemplate, key string) Engine {
+	if tmpl.KeyOptions[key].Verbatim {
+		return Literal
+	}
+	return engine
+}
+
+// Literal is an engine that renders template text unchanged.
+var Literal Engine = literalEngine{}
+
+type literalEngine struct{}
+
+func (literalEngine) Parse(name, text string) error { return nil }
+
+func (literalEngine) Render(ctx context.Context, name, text string, vars map[string]string) (string, error) {
+	return text, nil
+}
+
+func (literalEngine) Refs(name, text string) ([]string, bool) { return nil, false }
+
+// expansionEngine expands variable references with an expander, e.g.
+// $(VAR_NAME) references like container env vars. References to undefined
+// variables are unchanged. Expansion takes time linear in its output, so
+// only the output size is limited.
+type expansionEngine struct {
+	limits   Limits
+	expander expansion.Expander
+}
+
+func (expansionEngine) Parse(name, text string) error { return nil }
+
+func (e expansionEngine) Render(ctx context.Context, name, text string, vars map[string]string) (string, error) {
+	out := e.expander.Expand(text, e.expander.MappingFuncFor(vars))
+	if max := e.limits.MaxOutputSize; max > 0 && len(out) > max {
+		return "", &LimitError{fmt.Sprintf("output exceeds %d bytes", max)}
+	}
+	return out, nil
+}
+
+func (e expansionEngine) Refs(name, text string) ([]string, bool) {
+	refs := make(map[string]bool)
+	e.expander.Expand(text, func(name string) string {
+		refs[name] = true
+		return ""
+	})
+	return sortedNames(refs), false
+}
+
+// UnresolvedRefs returns the number of references to each variable in text
+// that the engine leaves unchanged because it isn't defined by vars. Only the
+// engine of v1 templates leaves references unresolved, so for other engines
+// it's nil.
+func UnresolvedRefs(engine Engine, text string, vars map[string]string) map[string]int {
+	e, ok := engine.(expansionEngine)
+	if !ok {
+		return nil
+	}
+	var refs map[string]int
+	e.expander.Expand(text, func(name string) string {
+		if _, ok := vars[name]; !ok {
+			if refs == nil {
+				refs = make(map[string]int)
+			}
+			refs[name]++
+		}
+		return ""
+	})
+	return refs
+}
+
+// goEngine renders Go templates, in which variables are fields of dot,
+// e.g. {{ .VAR_NAME }}. References to undefined variables are errors.
+type goEngine struct {
+	limits Limits
+}
+
+// maxIterations is the maximum number of range iterations of rendering a Go
+// template, which bounds the work of loops that don't write any output.
+const maxIterations = 1 << 20
+
+// iterateFunc is the name of the function that each range iteration of a Go
+// template calls, so that its work is bounded by an iterationLimit.
+const iterateFunc = "_iterate"
+
+func (goEngine) parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).
+		Funcs(funcs).
+		Funcs(template.FuncMap{iterateFunc: (*iterationLimit)(nil).iterate}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			instrumentRanges(t.Tree, t.Tree.Root)
+		}
+	}
+	return tmpl, nil
+}
+
+// instrumentRanges prepends a call of iterateFunc to the body of each range
+// of the tree, since execution can't otherwise be interrupted.
+func instrumentRanges(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			instrumentRanges(tree, c)
+		}
+	case *parse.IfNode:
+		instrumentRanges(tree, n.List)
+		instrumentRanges(tree, n.ElseList)
+	case *parse.WithNode:
+		instrumentRanges(tree, n.List)
+		instrumentRanges(tree, n.ElseList)
+	case *parse.RangeNode:
+		instrumentRanges(tree, n.List)
+		instrumentRanges(tree, n.ElseList)
+		call := &parse.ActionNode{
+			NodeType: parse.NodeAction,
+			Pos:      n.Pos,
+			Line:     n.Line,
+			Pipe: &parse.PipeNode{
+				NodeType: parse.NodePipe,
+				Pos:      n.Pos,
+				Line:     n.Line,
+				Cmds: []*parse.CommandNode{{
+					NodeType: parse.NodeCommand,
+					Pos:      n.Pos,
+					Args:     []parse.Node{parse.NewIdentifier(iterateFunc).SetTree(tree).SetPos(n.Pos)},
+				}},
+			},
+		}
+		n.List.Nodes = append([]parse.Node{call}, n.List.Nodes...)
+	}
+}
+
+func (e goEngine) Parse(name, text string) error {
+	_, err := e.parse(name, text)
+	return err
+}
+
+func (e goEngine) Render(ctx context.Context, name, text string, vars map[string]string) (string, error) {
+	tmpl, err := e.parse(name, text)
+	if err != nil {
+		return "", err
+	}
+	renderCtx := ctx
+	if e.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		renderCtx, cancel = context.WithTimeout(ctx, e.limits.Timeout)
+		defer cancel()
+	}
+	w := &limitWriter{ctx: renderCtx, max: e.limits.MaxOutputSize}
+	iter := &iterationLimit{ctx: renderCtx, max: maxIterations}
+	tmpl.Funcs(template.FuncMap{iterateFunc: iter.iterate})
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- &LimitError{fmt.Sprintf("template panicked: %v", v)}
+			}
+		}()
+		done <- tmpl.Execute(w, vars)
+	}()
+	select {
+	case err = <-done:
+	case <-renderCtx.Done():
+		// Execution can't be interrupted, but it fails at its next write or
+		// range iteration, and functions are bounded by maxFuncOutput.
+	}
+	switch {
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case renderCtx.Err() != nil:
+		return "", &LimitError{fmt.Sprintf("rendering exceeds %v", e.limits.Timeout)}
+	case err != nil && w.exceeded:
+		return "", &LimitError{fmt.Sprintf("output exceeds %d bytes", w.max)}
+	case err != nil && iter.exceeded:
+		return "", &LimitError{fmt.Sprintf("rendering exceeds %d iterations", iter.max)}
+	case err != nil:
+		return "", err
+	}
+	return w.sb.String(), nil
+}
+
+// limitWriter is the output of a template, which fails writes once its
+// context is done or its size would exceed max, if max is positive.
+type limitWriter struct {
+	ctx      context.Context
+	sb       strings.Builder
+	max      int
+	exceeded bool
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.max > 0 && w.sb.Len()+len(p) > w.max {
+		w.exceeded = true
+		return 0, errors.New("output size limit exceeded")
+	}
+	return w.sb.Write(p)
+}
+
+// iterationLimit counts the range iterations of a template, and fails them
+// once its context is done or their number would exceed max.
+type iterationLimit struct {
+	ctx      context.Context
+	n        int
+	max      int
+	exceeded bool
+}
+
+func (l *iterationLimit) iterate() (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	if err := l.ctx.Err(); err != nil {
+		return "", err
+	}
+	if l.n++; l.n > l.max {
+		l.exceeded = true
+		return "", errors.New("iteration limit exceeded")
+	}
+	return "", nil
+}
+
+func (e goEngine) Refs(name, text string) ([]string, bool) {
+	tmpl, err := e.parse(name, text)
+	if err != nil {
+		return nil, true
+	}
+	w := &refWalker{refs: make(map[string]bool)}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			w.walk(t.Tree.Root)
+		}
+	}
+	return sortedNames(w.refs), w.all
+}
+
+// refWalker finds the fields of dot referenced by a template. Since the
+// variables are the root of dot, any other use of dot may reference all of them.
+// Fields of a dot rebound by range or with are conservatively included.
+type refWalker struct {
+	refs map[string]bool
+	all  bool
+}
+
+func (w *refWalker) walk(node parse.Node) {
+	switch n := node.(type) {
+	case nil:
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			w.walk(c)
+		}
+	case *parse.ActionNode:
+		w.walk(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			w.walk(c)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			w.walk(c)
+		}
+	case *parse.FieldNode:
+		w.refs[n.Ident[0]] = true
+	case *parse.ChainNode:
+		w.walk(n.Node)
+	case *parse.DotNode, *parse.VariableNode:
+		w.all = true
+	case *parse.IfNode:
+		w.walkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		w.walkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		w.walkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		w.walk(n.Pipe)
+	}
+}
+
+func (w *refWalker) walkBranch(n *parse.BranchNode) {
+	w.walk(n.Pipe)
+	w.walk(n.List)
+	w.walk(n.ElseList)
+}
+
+func sortedNames(m map[string]bool) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
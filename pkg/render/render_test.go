@@ -0,0 +1,352 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+)
+
+func TestRender(t *testing.T) {
+	vars := map[string]string{"USER": "admin", "PASS": "hunter2"}
+	tests := []struct {
+		version  v1alpha1.TemplateVersion
+		text     string
+		want     string
+		wantErr  bool
+		wantRefs []string
+		wantAll  bool
+	}{
+		{
+			text:     "$(USER):$(PASS)@$(HOST) $$(USER)",
+			want:     "admin:hunter2@$(HOST) $(USER)",
+			wantRefs: []string{"HOST", "PASS", "USER"},
+		},
+		{
+			version:  v1alpha1.TemplateVersionV1,
+			text:     "{{ .USER }}",
+			want:     "{{ .USER }}",
+			wantRefs: []string{},
+		},
+		{
+			version:  v1alpha1.TemplateVersionV2,
+			text:     `{{ .USER }}:{{ if .PASS }}{{ .PASS }}{{ end }} $(USER)`,
+			want:     "admin:hunter2 $(USER)",
+			wantRefs: []string{"PASS", "USER"},
+		},
+		{
+			version:  v1alpha1.TemplateVersionV2,
+			text:     `{{ index . "USER" }}`,
+			want:     "admin",
+			wantRefs: []string{},
+			wantAll:  true,
+		},
+		{
+			version:  v1alpha1.TemplateVersionV2,
+			text:     "{{ .HOST }}",
+			wantErr:  true,
+			wantRefs: []string{"HOST"},
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			text:    "{{ .USER",
+			wantErr: true,
+			wantAll: true,
+		},
+	}
+	for _, tt := range tests {
+		e, err := ForVersion(tt.version, DefaultLimits)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := e.Render(context.Background(), "key", tt.text, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: unexpected error: %v", tt.version, tt.text, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %q: unexpected output: want: %q; got: %q", tt.version, tt.text, tt.want, got)
+		}
+		refs, all := e.Refs("key", tt.text)
+		if diff := cmp.Diff(tt.wantRefs, refs); diff != "" {
+			t.Errorf("%s %q: unexpected refs (-want +got):\n%s", tt.version, tt.text, diff)
+		}
+		if all != tt.wantAll {
+			t.Errorf("%s %q: unexpected all: want: %v; got: %v", tt.version, tt.text, tt.wantAll, all)
+		}
+	}
+}
+
+func TestRenderBytes(t *testing.T) {
+	// Text without references is rendered byte for byte, including line
+	// endings, lone CRs, NULs, and bytes that aren't valid UTF-8, e.g. of
+	// BinaryData.
+	text := "a\r\nb\nc\rd\x00\xff\xfe\r\n"
+	vars := map[string]string{"USER": "admin"}
+	engines := map[string]Engine{"literal": Literal}
+	for _, version := range []v1alpha1.TemplateVersion{v1alpha1.TemplateVersionV1, v1alpha1.TemplateVersionV2} {
+		e, err := ForVersion(version, DefaultLimits)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		engines[string(version)] = e
+	}
+	for name, e := range engines {
+		got, err := e.Render(context.Background(), "key", text, vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if got != text {
+			t.Errorf("%s: want: %q; got: %q", name, text, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for version, wantErr := range map[v1alpha1.TemplateVersion]bool{
+		v1alpha1.TemplateVersionV1: false,
+		v1alpha1.TemplateVersionV2: true,
+	} {
+		e, err := ForVersion(version, Limits{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := e.Parse("key", "{{ .USER"); (err != nil) != wantErr {
+			t.Errorf("%s: unexpected error: %v", version, err)
+		}
+	}
+	if _, err := ForVersion("v0", Limits{}); err == nil {
+		t.Errorf("expected unsupported version error")
+	}
+}
+
+func TestForSpec(t *testing.T) {
+	spec := &v1alpha1.ConfigMapSecretSpec{
+		TemplateVersion:  v1alpha1.TemplateVersionV2,
+		DisableExpansion: true,
+	}
+	e, err := ForSpec(spec, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const text = "$(USER) {{ .USER"
+	if err := e.Parse("key", text); err != nil {
+		t.Errorf("unexpected parse error: %v", err)
+	}
+	got, err := e.Render(context.Background(), "key", text, map[string]string{"USER": "admin"})
+	if err != nil {
+		t.Errorf("unexpected render error: %v", err)
+	}
+	if got != text {
+		t.Errorf("unexpected output: want: %q; got: %q", text, got)
+	}
+	if refs, all := e.Refs("key", text); len(refs) != 0 || all {
+		t.Errorf("unexpected refs: %v, %v", refs, all)
+	}
+
+	spec.DisableExpansion = false
+	if e, err := ForSpec(spec, DefaultLimits); err != nil || e == Literal {
+		t.Errorf("unexpected engine: %T, %v", e, err)
+	}
+}
+
+func TestDelimiters(t *testing.T) {
+	spec := &v1alpha1.ConfigMapSecretSpec{
+		Template: v1alpha1.ConfigMapTemplate{Delimiters: &v1alpha1.Delimiters{Left: "%{", Right: "}%"}},
+	}
+	e, err := ForSpec(spec, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vars := map[string]string{"USER": "admin"}
+	const text = "echo $(whoami) $$ %{USER}% %{PASSWORD}% %%{USER}%"
+	got, err := e.Render(context.Background(), "key", text, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "echo $(whoami) $$ admin %{PASSWORD}% %{USER}%"; got != want {
+		t.Errorf("unexpected output: want: %q; got: %q", want, got)
+	}
+	if refs, all := e.Refs("key", text); !cmp.Equal(refs, []string{"PASSWORD", "USER"}) || all {
+		t.Errorf("unexpected refs: %v, %v", refs, all)
+	}
+	if diff := cmp.Diff(map[string]int{"PASSWORD": 1}, UnresolvedRefs(e, text, vars)); diff != "" {
+		t.Errorf("unexpected unresolved refs (-want +got):\n%s", diff)
+	}
+
+	// Delimiters are only supported by v1 templates and must not be empty.
+	spec.Template.Delimiters.Right = ""
+	if _, err := ForSpec(spec, DefaultLimits); err == nil {
+		t.Error("expected error for empty delimiter")
+	}
+	spec.Template.Delimiters.Right = "}%"
+	spec.TemplateVersion = v1alpha1.TemplateVersionV2
+	if _, err := ForSpec(spec, DefaultLimits); err == nil {
+		t.Error("expected error for v2 template")
+	}
+	spec.DisableExpansion = true
+	if e, err := ForSpec(spec, DefaultLimits); err != nil || e != Literal {
+		t.Errorf("unexpected engine: %T, %v", e, err)
+	}
+}
+
+func TestForExpander(t *testing.T) {
+	e := ForExpander(expansion.Delims{Left: "<<", Right: ">>"}, Limits{MaxOutputSize: 20})
+	vars := map[string]string{"USER": "admin"}
+	got, err := e.Render(context.Background(), "key", "<<USER>>:<<PASSWORD>>", vars)
+	if err != nil || got != "admin:<<PASSWORD>>" {
+		t.Errorf("unexpected output: %q, %v", got, err)
+	}
+	if _, err := e.Render(context.Background(), "key", strings.Repeat("<<USER>>", 5), vars); !IsLimitError(err) {
+		t.Errorf("expected limit error; got: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{"PASSWORD": 1}, UnresolvedRefs(e, "<<USER>>:<<PASSWORD>>", vars)); diff != "" {
+		t.Errorf("unexpected unresolved refs (-want +got):\n%s", diff)
+	}
+}
+
+func TestForKey(t *testing.T) {
+	engine, err := ForVersion(v1alpha1.TemplateVersionV1, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmpl := &v1alpha1.ConfigMapTemplate{
+		KeyOptions: map[string]v1alpha1.KeyOptions{
+			"vendor.sh": {Verbatim: true},
+			"app.ini":   {LineEndings: v1alpha1.LineEndingCRLF},
+		},
+	}
+	vars := map[string]string{"USER": "admin"}
+	for _, tt := range []struct {
+		key, want string
+	}{
+		{"vendor.sh", "$(USER) $$(USER)"},
+		{"app.ini", "admin $(USER)"},
+		{"other", "admin $(USER)"},
+	} {
+		e := ForKey(engine, tmpl, tt.key)
+		got, err := e.Render(context.Background(), tt.key, "$(USER) $$(USER)", vars)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: want: %q; got: %q, %v", tt.key, tt.want, got, err)
+		}
+	}
+	if got := UnresolvedRefs(ForKey(engine, tmpl, "vendor.sh"), "$(MISSING)", vars); got != nil {
+		t.Errorf("unexpected unresolved refs: %v", got)
+	}
+}
+
+func TestUnresolvedRefs(t *testing.T) {
+	vars := map[string]string{"USER": "admin", "EMPTY": ""}
+	const text = "$(USER):$(PASSWORD)@$(HOST)/$(PASSWORD) $(EMPTY) $$(ESCAPED)"
+	v1, err := ForVersion(v1alpha1.TemplateVersionV1, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]int{"PASSWORD": 2, "HOST": 1}
+	if diff := cmp.Diff(want, UnresolvedRefs(v1, text, vars)); diff != "" {
+		t.Errorf("unexpected unresolved refs (-want +got):\n%s", diff)
+	}
+	if got := UnresolvedRefs(v1, "$(USER)", vars); got != nil {
+		t.Errorf("unexpected unresolved refs: %v", got)
+	}
+	v2, err := ForVersion(v1alpha1.TemplateVersionV2, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range []Engine{v2, Literal} {
+		if got := UnresolvedRefs(e, text, vars); got != nil {
+			t.Errorf("%T: unexpected unresolved refs: %v", e, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	vars := map[string]string{"USER": "admin"}
+	tests := []struct {
+		version v1alpha1.TemplateVersion
+		limits  Limits
+		text    string
+		wantErr string
+	}{
+		{
+			version: v1alpha1.TemplateVersionV1,
+			limits:  Limits{MaxOutputSize: 8},
+			text:    "$(USER)$(USER)",
+			wantErr: "output exceeds 8 bytes",
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			limits:  Limits{MaxOutputSize: 100},
+			text:    "{{ range 1000 }}{{ $.USER }}{{ end }}",
+			wantErr: "output exceeds 100 bytes",
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			limits:  Limits{Timeout: 10 * time.Millisecond},
+			text:    "{{ range 1000000000 }}{{ $.USER }}{{ end }}",
+			wantErr: "rendering exceeds 10ms",
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			text:    "{{ range 1000000000 }}{{ end }}",
+			wantErr: "rendering exceeds 1048576 iterations",
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			text:    `{{ define "loop" }}{{ range 1024 }}{{ end }}{{ end }}{{ range 1024 }}{{ template "loop" }}{{ end }}`,
+			wantErr: "rendering exceeds 1048576 iterations",
+		},
+		{
+			version: v1alpha1.TemplateVersionV2,
+			limits:  Limits{MaxOutputSize: 100, Timeout: time.Second},
+			text:    "{{ range 10 }}{{ $.USER }}{{ end }}",
+		},
+	}
+	for _, tt := range tests {
+		e, err := ForVersion(tt.version, tt.limits)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := e.Render(context.Background(), "key", tt.text, vars)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s %q: unexpected error: %v", tt.version, tt.text, err)
+			}
+			if want := strings.Repeat("admin", 10); got != want {
+				t.Errorf("%s %q: unexpected output: want: %q; got: %q", tt.version, tt.text, want, got)
+			}
+			continue
+		}
+		if !IsLimitError(err) || err.Error() != tt.wantErr {
+			t.Errorf("%s %q: unexpected error: want: %q; got: %v", tt.version, tt.text, tt.wantErr, err)
+		}
+	}
+}
+
+func TestTimeoutStopsRendering(t *testing.T) {
+	before := runtime.NumGoroutine()
+	e, err := ForVersion(v1alpha1.TemplateVersionV2, Limits{Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A loop that doesn't write, within the iteration limit, whose iterations
+	// would take minutes.
+	text := `{{ range 1000000 }}{{ $s := repeat 1000000 "x" }}{{ end }}`
+	if _, err := e.Render(context.Background(), "key", text, nil); !IsLimitError(err) || err.Error() != "rendering exceeds 10ms" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("rendering didn't stop after the timeout")
+		}
+	}
+}
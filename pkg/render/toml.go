@@ -0,0 +1,411 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+// validateTOML checks the syntax of a TOML v1.0.0 document.
+// It doesn't check for duplicate keys or tables.
+func validateTOML(text string) error {
+	p := &tomlParser{s: text}
+	return p.document()
+}
+
+type tomlParser struct {
+	s string
+	i int
+}
+
+func (p *tomlParser) errorf(offset int, msg string) error {
+	return syntaxErrorAt(v1alpha1.ContentTypeTOML, p.s, offset, msg)
+}
+
+func (p *tomlParser) eof() bool { return p.i >= len(p.s) }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *tomlParser) hasPrefix(prefix string) bool { return strings.HasPrefix(p.s[p.i:], prefix) }
+
+func (p *tomlParser) skipWS() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// newline consumes a newline and reports whether there was one.
+func (p *tomlParser) newline() bool {
+	switch {
+	case p.hasPrefix("\n"):
+		p.i++
+	case p.hasPrefix("\r\n"):
+		p.i += 2
+	default:
+		return false
+	}
+	return true
+}
+
+func (p *tomlParser) comment() {
+	if p.peek() != '#' {
+		return
+	}
+	for !p.eof() && p.s[p.i] != '\n' && !p.hasPrefix("\r\n") {
+		p.i++
+	}
+}
+
+// skipWSCommentNewlines skips whitespace, comments, and newlines, e.g. within an array.
+func (p *tomlParser) skipWSCommentNewlines() {
+	for {
+		p.skipWS()
+		p.comment()
+		if !p.newline() {
+			return
+		}
+	}
+}
+
+func (p *tomlParser) document() error {
+	for {
+		p.skipWS()
+		if p.eof() {
+			return nil
+		}
+		switch c := p.peek(); {
+		case c == '#' || c == '\n' || c == '\r':
+		case c == '[':
+			if err := p.table(); err != nil {
+				return err
+			}
+		default:
+			if err := p.keyval(); err != nil {
+				return err
+			}
+		}
+		p.skipWS()
+		p.comment()
+		if !p.eof() && !p.newline() {
+			return p.errorf(p.i, "expected a newline")
+		}
+	}
+}
+
+func (p *tomlParser) table() error {
+	start := p.i
+	p.i++
+	array := p.peek() == '['
+	if array {
+		p.i++
+	}
+	p.skipWS()
+	if err := p.key(); err != nil {
+		return err
+	}
+	p.skipWS()
+	end := "]"
+	if array {
+		end = "]]"
+	}
+	if !p.hasPrefix(end) {
+		return p.errorf(start, "unterminated table header")
+	}
+	p.i += len(end)
+	return nil
+}
+
+func (p *tomlParser) keyval() error {
+	if err := p.key(); err != nil {
+		return err
+	}
+	p.skipWS()
+	if p.peek() != '=' {
+		return p.errorf(p.i, "expected '=' after key")
+	}
+	p.i++
+	p.skipWS()
+	return p.value()
+}
+
+func (p *tomlParser) key() error {
+	for {
+		if err := p.simpleKey(); err != nil {
+			return err
+		}
+		i := p.i
+		p.skipWS()
+		if p.peek() != '.' {
+			p.i = i
+			return nil
+		}
+		p.i++
+		p.skipWS()
+	}
+}
+
+func (p *tomlParser) simpleKey() error {
+	switch p.peek() {
+	case '"':
+		return p.basicString()
+	case '\'':
+		return p.literalString()
+	}
+	start := p.i
+	for !p.eof() && isBareKeyChar(p.s[p.i]) {
+		p.i++
+	}
+	if p.i == start {
+		return p.errorf(p.i, "expected a key")
+	}
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() error {
+	switch {
+	case p.hasPrefix(`"""`):
+		return p.multilineBasicString()
+	case p.hasPrefix(`'''`):
+		return p.multilineLiteralString()
+	case p.peek() == '"':
+		return p.basicString()
+	case p.peek() == '\'':
+		return p.literalString()
+	case p.peek() == '[':
+		return p.array()
+	case p.peek() == '{':
+		return p.inlineTable()
+	}
+	return p.scalar()
+}
+
+func (p *tomlParser) basicString() error {
+	start := p.i
+	p.i++
+	for {
+		switch {
+		case p.eof() || p.peek() == '\n' || p.hasPrefix("\r\n"):
+			return p.errorf(start, "unterminated string")
+		case p.peek() == '"':
+			p.i++
+			return nil
+		case p.peek() == '\\':
+			if err := p.escape(); err != nil {
+				return err
+			}
+		default:
+			p.i++
+		}
+	}
+}
+
+func (p *tomlParser) multilineBasicString() error {
+	start := p.i
+	p.i += 3
+	for {
+		switch {
+		case p.eof():
+			return p.errorf(start, "unterminated string")
+		case p.hasPrefix(`"""`):
+			p.i += 3
+			for n := 0; n < 2 && p.peek() == '"'; n++ { // Up to two quotes may end the content.
+				p.i++
+			}
+			return nil
+		case p.peek() == '\\':
+			i := p.i
+			p.i++
+			p.skipWS()
+			if p.newline() { // A line ending backslash trims the following whitespace.
+				p.skipWSNewlines()
+				continue
+			}
+			p.i = i
+			if err := p.escape(); err != nil {
+				return err
+			}
+		default:
+			p.i++
+		}
+	}
+}
+
+func (p *tomlParser) skipWSNewlines() {
+	for {
+		p.skipWS()
+		if !p.newline() {
+			return
+		}
+	}
+}
+
+func (p *tomlParser) escape() error {
+	start := p.i
+	p.i++
+	n := 0
+	switch p.peek() {
+	case 'b', 't', 'n', 'f', 'r', '"', '\\':
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		return p.errorf(start, "invalid escape sequence")
+	}
+	p.i++
+	for ; n > 0; n-- {
+		if !isHex(p.peek()) {
+			return p.errorf(start, "invalid unicode escape sequence")
+		}
+		p.i++
+	}
+	return nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func (p *tomlParser) literalString() error {
+	start := p.i
+	p.i++
+	for {
+		switch {
+		case p.eof() || p.peek() == '\n' || p.hasPrefix("\r\n"):
+			return p.errorf(start, "unterminated string")
+		case p.peek() == '\'':
+			p.i++
+			return nil
+		default:
+			p.i++
+		}
+	}
+}
+
+func (p *tomlParser) multilineLiteralString() error {
+	start := p.i
+	end := strings.Index(p.s[p.i+3:], `'''`)
+	if end < 0 {
+		return p.errorf(start, "unterminated string")
+	}
+	p.i += 3 + end + 3
+	for n := 0; n < 2 && p.peek() == '\''; n++ { // Up to two quotes may end the content.
+		p.i++
+	}
+	return nil
+}
+
+func (p *tomlParser) array() error {
+	start := p.i
+	p.i++
+	for {
+		p.skipWSCommentNewlines()
+		if p.peek() == ']' {
+			p.i++
+			return nil
+		}
+		if p.eof() {
+			return p.errorf(start, "unterminated array")
+		}
+		if err := p.value(); err != nil {
+			return err
+		}
+		p.skipWSCommentNewlines()
+		switch p.peek() {
+		case ',':
+			p.i++
+		case ']':
+			p.i++
+			return nil
+		default:
+			if p.eof() {
+				return p.errorf(start, "unterminated array")
+			}
+			return p.errorf(p.i, "expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() error {
+	p.i++
+	p.skipWS()
+	if p.peek() == '}' {
+		p.i++
+		return nil
+	}
+	for {
+		if err := p.keyval(); err != nil {
+			return err
+		}
+		p.skipWS()
+		switch p.peek() {
+		case ',':
+			p.i++
+			p.skipWS()
+		case '}':
+			p.i++
+			return nil
+		default:
+			return p.errorf(p.i, "expected ',' or '}' in inline table")
+		}
+	}
+}
+
+var (
+	tomlScalars = []*regexp.Regexp{
+		regexp.MustCompile(`^(true|false)$`),
+		regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`),
+		regexp.MustCompile(`^0x[0-9a-fA-F](_?[0-9a-fA-F])*$`),
+		regexp.MustCompile(`^0o[0-7](_?[0-7])*$`),
+		regexp.MustCompile(`^0b[01](_?[01])*$`),
+		regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`),
+		regexp.MustCompile(`^[+-]?(inf|nan)$`),
+		regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}([Tt ][0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?([Zz]|[+-][0-9]{2}:[0-9]{2})?)?$`),
+		regexp.MustCompile(`^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?$`),
+	}
+	tomlDate = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+)
+
+// scalar consumes a boolean, number, or date-time.
+func (p *tomlParser) scalar() error {
+	start := p.i
+	p.scalarToken()
+	if tomlDate.MatchString(p.s[start:p.i]) && p.peek() == ' ' && p.i+1 < len(p.s) && '0' <= p.s[p.i+1] && p.s[p.i+1] <= '9' {
+		p.i++ // A date-time may separate its date and time with a space.
+		p.scalarToken()
+	}
+	tok := p.s[start:p.i]
+	if tok == "" {
+		return p.errorf(start, "expected a value")
+	}
+	for _, re := range tomlScalars {
+		if re.MatchString(tok) {
+			return nil
+		}
+	}
+	return p.errorf(start, "invalid value")
+}
+
+func (p *tomlParser) scalarToken() {
+	for !p.eof() {
+		c := p.s[p.i]
+		if !isBareKeyChar(c) && c != '+' && c != '.' && c != ':' {
+			return
+		}
+		p.i++
+	}
+}
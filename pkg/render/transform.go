@@ -0,0 +1,53 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+// Transform returns the value transformed by each of the transforms in order,
+// with the functions of v2 templates of the same names. If a transformed value
+// exceeds the MaxOutputSize of the limits, a *LimitError is returned.
+func Transform(transforms []v1alpha1.Transform, val string, limits Limits) (string, error) {
+	for i, t := range transforms {
+		var err error
+		switch t.Func {
+		case v1alpha1.TransformB64Enc:
+			val = base64.StdEncoding.EncodeToString([]byte(val))
+		case v1alpha1.TransformB64Dec:
+			val, err = b64dec(val)
+		case v1alpha1.TransformToJSON:
+			val, err = toJSON(val)
+		case v1alpha1.TransformIndent:
+			// Check the size before indenting, which multiplies it.
+			size := len(val) + (strings.Count(val, "\n")+1)*int(t.Spaces)
+			if max := limits.MaxOutputSize; max > 0 && size > max {
+				return "", &LimitError{fmt.Sprintf("transform %d (%s): output exceeds %d bytes", i, t.Func, max)}
+			}
+			val = indentLines(int(t.Spaces), val)
+		case v1alpha1.TransformTrim:
+			val = strings.TrimSpace(val)
+		case v1alpha1.TransformSHA256Sum:
+			sum := sha256.Sum256([]byte(val))
+			val = hex.EncodeToString(sum[:])
+		default:
+			err = fmt.Errorf("unsupported function: %q", t.Func)
+		}
+		if err != nil {
+			return "", fmt.Errorf("transform %d (%s): %v", i, t.Func, err)
+		}
+		if max := limits.MaxOutputSize; max > 0 && len(val) > max {
+			return "", &LimitError{fmt.Sprintf("transform %d (%s): output exceeds %d bytes", i, t.Func, max)}
+		}
+	}
+	return val, nil
+}
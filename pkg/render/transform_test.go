@@ -0,0 +1,83 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestTransform(t *testing.T) {
+	var (
+		b64enc    = v1alpha1.Transform{Func: v1alpha1.TransformB64Enc}
+		b64dec    = v1alpha1.Transform{Func: v1alpha1.TransformB64Dec}
+		toJSON    = v1alpha1.Transform{Func: v1alpha1.TransformToJSON}
+		indent    = v1alpha1.Transform{Func: v1alpha1.TransformIndent, Spaces: 2}
+		trim      = v1alpha1.Transform{Func: v1alpha1.TransformTrim}
+		sha256sum = v1alpha1.Transform{Func: v1alpha1.TransformSHA256Sum}
+	)
+	tests := []struct {
+		transforms []v1alpha1.Transform
+		val        string
+		want       string
+	}{
+		{val: "a", want: "a"},
+		{transforms: []v1alpha1.Transform{b64enc}, val: "hunter2", want: "aHVudGVyMg=="},
+		{transforms: []v1alpha1.Transform{b64dec}, val: "aHVudGVyMg==", want: "hunter2"},
+		{transforms: []v1alpha1.Transform{toJSON}, val: "a \"b\"\n", want: `"a \"b\"\n"`},
+		{transforms: []v1alpha1.Transform{indent}, val: "a:\n  b: c", want: "  a:\n    b: c"},
+		{transforms: []v1alpha1.Transform{trim}, val: " \ta\n", want: "a"},
+		{transforms: []v1alpha1.Transform{sha256sum}, val: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{transforms: []v1alpha1.Transform{b64dec, trim, toJSON}, val: "IGEKCg==", want: `"a"`},
+	}
+	for _, tt := range tests {
+		got, err := Transform(tt.transforms, tt.val, DefaultLimits)
+		if err != nil {
+			t.Errorf("%v %q: unexpected error: %v", tt.transforms, tt.val, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v %q: want: %q; got: %q", tt.transforms, tt.val, tt.want, got)
+		}
+	}
+
+	// Each transform has the result of the template function.
+	engine, err := ForVersion(v1alpha1.TemplateVersionV2, DefaultLimits)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vars := map[string]string{"A": " a\nb "}
+	for _, tr := range []v1alpha1.Transform{b64enc, toJSON, indent, trim, sha256sum} {
+		text := "{{ .A | " + string(tr.Func) + " }}"
+		if tr.Func == v1alpha1.TransformIndent {
+			text = "{{ .A | indent 2 }}"
+		}
+		want, err := engine.Render(context.Background(), "key", text, vars)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tr.Func, err)
+		}
+		if got, _ := Transform([]v1alpha1.Transform{tr}, vars["A"], DefaultLimits); got != want {
+			t.Errorf("%s: want: %q; got: %q", tr.Func, want, got)
+		}
+	}
+
+	if _, err := Transform([]v1alpha1.Transform{b64dec}, "not base64", DefaultLimits); err == nil || IsLimitError(err) {
+		t.Errorf("expected decoding error; got: %v", err)
+	}
+	if _, err := Transform([]v1alpha1.Transform{{Func: "rot13"}}, "a", DefaultLimits); err == nil {
+		t.Errorf("expected error for unsupported function")
+	}
+	limits := Limits{MaxOutputSize: 16}
+	if _, err := Transform([]v1alpha1.Transform{b64enc}, strings.Repeat("a", 15), limits); !IsLimitError(err) {
+		t.Errorf("expected limit error; got: %v", err)
+	}
+	big := v1alpha1.Transform{Func: v1alpha1.TransformIndent, Spaces: 64}
+	if _, err := Transform([]v1alpha1.Transform{big}, strings.Repeat("\n", 8), limits); !IsLimitError(err) {
+		t.Errorf("expected limit error; got: %v", err)
+	}
+}
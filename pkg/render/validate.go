@@ -0,0 +1,179 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"gopkg.in/yaml.v3"
+)
+
+// A SyntaxError describes a rendered value that isn't valid in its content type.
+// Its message describes the problem without quoting the value, which may be secret.
+type SyntaxError struct {
+	// Type is the content type of the value.
+	Type v1alpha1.ContentType
+	// Line is the 1-based line of the problem, or zero if it's unknown.
+	Line int
+	// Column is the 1-based column of the problem in runes, or zero if it's unknown.
+	Column int
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	switch {
+	case e.Column > 0:
+		return fmt.Sprintf("invalid %s at line %d, column %d: %s", e.Type, e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("invalid %s at line %d: %s", e.Type, e.Line, e.Msg)
+	default:
+		return fmt.Sprintf("invalid %s: %s", e.Type, e.Msg)
+	}
+}
+
+var validators = map[v1alpha1.ContentType]func(text string) error{
+	v1alpha1.ContentTypeJSON: validateJSON,
+	v1alpha1.ContentTypeYAML: validateYAML,
+	v1alpha1.ContentTypeTOML: validateTOML,
+	v1alpha1.ContentTypeINI:  validateINI,
+	v1alpha1.ContentTypePEM:  validatePEM,
+}
+
+// Validate returns a *SyntaxError if the rendered text isn't syntactically
+// valid in the content type. An empty content type accepts any text.
+func Validate(typ v1alpha1.ContentType, text string) error {
+	if typ == "" {
+		return nil
+	}
+	validate, ok := validators[typ]
+	if !ok {
+		return fmt.Errorf("unsupported content type: %q", typ)
+	}
+	return validate(text)
+}
+
+// syntaxErrorAt returns a *SyntaxError at the byte offset of text. The CR of
+// a CRLF line ending is part of the line ending, so an offset at its LF is at
+// the same column as with an LF line ending.
+func syntaxErrorAt(typ v1alpha1.ContentType, text string, offset int, msg string) *SyntaxError {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	line := strings.Count(text[:offset], "\n") + 1
+	end := offset
+	if end > 0 && text[end-1] == '\r' && end < len(text) && text[end] == '\n' {
+		end--
+	}
+	col := utf8.RuneCountInString(text[strings.LastIndexByte(text[:offset], '\n')+1:end]) + 1
+	return &SyntaxError{Type: typ, Line: line, Column: col, Msg: msg}
+}
+
+// jsonInvalidChar matches the quoted character of a JSON syntax error.
+var jsonInvalidChar = regexp.MustCompile(`^invalid character '(?:\\.|[^'\\])*'`)
+
+func validateJSON(text string) error {
+	var v interface{}
+	err := json.Unmarshal([]byte(text), &v)
+	if err == nil {
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset := int(syntaxErr.Offset) - 1 // Offset is after the invalid byte.
+		if offset < 0 {
+			offset = 0
+		}
+		msg := jsonInvalidChar.ReplaceAllString(syntaxErr.Error(), "invalid character")
+		return syntaxErrorAt(v1alpha1.ContentTypeJSON, text, offset, msg)
+	}
+	return &SyntaxError{Type: v1alpha1.ContentTypeJSON, Msg: err.Error()}
+}
+
+var yamlLineErr = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func validateYAML(text string) error {
+	dec := yaml.NewDecoder(strings.NewReader(text))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			msg := err.Error()
+			if m := yamlLineErr.FindStringSubmatch(msg); m != nil {
+				line, _ := strconv.Atoi(m[1])
+				return &SyntaxError{Type: v1alpha1.ContentTypeYAML, Line: line, Msg: m[2]}
+			}
+			return &SyntaxError{Type: v1alpha1.ContentTypeYAML, Msg: strings.TrimPrefix(msg, "yaml: ")}
+		}
+	}
+}
+
+// validateINI accepts blank lines, comments starting with ';' or '#',
+// [section] headers, and key-value pairs separated by '=' or ':'.
+func validateINI(text string) error {
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		start := offset
+		offset += len(line)
+		trimmed := strings.TrimSpace(line)
+		indent := strings.Index(line, trimmed)
+		switch {
+		case trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#':
+		case trimmed[0] == '[':
+			if !strings.HasSuffix(trimmed, "]") {
+				return syntaxErrorAt(v1alpha1.ContentTypeINI, text, start+indent, "unterminated section header")
+			}
+			if strings.TrimSpace(trimmed[1:len(trimmed)-1]) == "" {
+				return syntaxErrorAt(v1alpha1.ContentTypeINI, text, start+indent, "empty section name")
+			}
+		default:
+			i := strings.IndexAny(trimmed, "=:")
+			if i < 0 {
+				return syntaxErrorAt(v1alpha1.ContentTypeINI, text, start+indent, "expected '=' or ':' after key")
+			}
+			if strings.TrimSpace(trimmed[:i]) == "" {
+				return syntaxErrorAt(v1alpha1.ContentTypeINI, text, start+indent, "empty key")
+			}
+		}
+	}
+	return nil
+}
+
+// validatePEM accepts one or more PEM blocks separated by whitespace.
+func validatePEM(text string) error {
+	rest := []byte(text)
+	for n := 0; ; n++ {
+		trimmed := bytes.TrimLeft(rest, " \t\r\n")
+		offset := len(text) - len(trimmed)
+		if len(trimmed) == 0 {
+			if n == 0 {
+				return syntaxErrorAt(v1alpha1.ContentTypePEM, text, offset, "no PEM blocks")
+			}
+			return nil
+		}
+		if !bytes.HasPrefix(trimmed, []byte("-----BEGIN ")) {
+			return syntaxErrorAt(v1alpha1.ContentTypePEM, text, offset, "unexpected data outside of a PEM block")
+		}
+		var block *pem.Block
+		block, rest = pem.Decode(trimmed)
+		// Decode skips a malformed block to decode the next one.
+		if block == nil || bytes.Count(trimmed[:len(trimmed)-len(rest)], []byte("-----BEGIN ")) > 1 {
+			return syntaxErrorAt(v1alpha1.ContentTypePEM, text, offset, "malformed PEM block")
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+const testPEM = `-----BEGIN CERTIFICATE-----
+MIIBszCCAVmgAwIBAgIUQ7Jd
+-----END CERTIFICATE-----
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		typ     v1alpha1.ContentType
+		text    string
+		wantErr string
+	}{
+		{typ: "", text: "{"},
+		{typ: v1alpha1.ContentTypeJSON, text: `{"user": "admin", "ports": [80, 443]}`},
+		{
+			typ:     v1alpha1.ContentTypeJSON,
+			text:    "{\n  \"user\": \"admin\",\n  \"pass\": hunter2\n}",
+			wantErr: "invalid json at line 3, column 11: invalid character looking for beginning of value",
+		},
+		{
+			typ:     v1alpha1.ContentTypeJSON,
+			text:    `{"user": "admin"`,
+			wantErr: "invalid json at line 1, column 16: unexpected end of JSON input",
+		},
+		{typ: v1alpha1.ContentTypeYAML, text: "user: admin\nports:\n- 80\n---\nfoo: bar\n"},
+		{
+			typ:     v1alpha1.ContentTypeYAML,
+			text:    "user: admin\npass: \"hunter2\nport: 80\n",
+			wantErr: "invalid yaml at line 2: found unexpected end of stream",
+		},
+		{
+			typ:     v1alpha1.ContentTypeYAML,
+			text:    "user: admin\n  pass: hunter2\n",
+			wantErr: "invalid yaml at line 2: mapping values are not allowed in this context",
+		},
+		{
+			typ: v1alpha1.ContentTypeTOML,
+			text: `# Comment
+title = "TOML \"example\"" # Comment
+"quoted key" = 'literal'
+site."google.com" = true
+
+[owner]
+name = """
+Tom \
+  Preston-Werner"""
+dob = 1979-05-27 07:32:00-08:00
+time = 07:32:00.999
+
+[database]
+ports = [ 8000, 8001, 0x1F_40, ]
+data = [ ["delta", "phi"], [3.14, -1e10, +inf] ]
+temp = { cpu = 79.5, case = 72.0 }
+regex = '''I [dw]on't need \d{2} apples'''
+
+[[products]]
+sku = 738_594_937
+`,
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "[server]\nhost = \"db.example.com\nport = 5432\n",
+			wantErr: "invalid toml at line 2, column 8: unterminated string",
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "[server\nhost = 1\n",
+			wantErr: "invalid toml at line 1, column 1: unterminated table header",
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "host: \"db\"\n",
+			wantErr: "invalid toml at line 1, column 5: expected '=' after key",
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "port = 05432\n",
+			wantErr: "invalid toml at line 1, column 8: invalid value",
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "ports = [80 443]\n",
+			wantErr: "invalid toml at line 1, column 13: expected ',' or ']' in array",
+		},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "a = 1 b = 2\n",
+			wantErr: "invalid toml at line 1, column 7: expected a newline",
+		},
+		{typ: v1alpha1.ContentTypeINI, text: "; Comment\n[server]\nhost = db.example.com\nport: 5432\n\n# Comment\n"},
+		{
+			typ:     v1alpha1.ContentTypeINI,
+			text:    "[server]\n  host db.example.com\n",
+			wantErr: "invalid ini at line 2, column 3: expected '=' or ':' after key",
+		},
+		{
+			typ:     v1alpha1.ContentTypeINI,
+			text:    "[server\nhost = db\n",
+			wantErr: "invalid ini at line 1, column 1: unterminated section header",
+		},
+		{typ: v1alpha1.ContentTypePEM, text: testPEM + "\n" + testPEM},
+		{
+			typ:     v1alpha1.ContentTypePEM,
+			text:    testPEM + "garbage\n",
+			wantErr: "invalid pem at line 4, column 1: unexpected data outside of a PEM block",
+		},
+		{
+			typ:     v1alpha1.ContentTypePEM,
+			text:    "-----BEGIN CERTIFICATE-----\nMIIB\n" + testPEM,
+			wantErr: "invalid pem at line 1, column 1: malformed PEM block",
+		},
+		{
+			typ:     v1alpha1.ContentTypePEM,
+			text:    "\n",
+			wantErr: "invalid pem at line 2, column 1: no PEM blocks",
+		},
+		// CRLF line endings are line endings in every content type, at the
+		// same positions as LF line endings.
+		{typ: v1alpha1.ContentTypeJSON, text: "{\r\n  \"user\": \"admin\"\r\n}\r\n"},
+		{
+			typ:     v1alpha1.ContentTypeJSON,
+			text:    "{\r\n  \"user\": \"admin\",\r\n  \"pass\": hunter2\r\n}",
+			wantErr: "invalid json at line 3, column 11: invalid character looking for beginning of value",
+		},
+		{typ: v1alpha1.ContentTypeYAML, text: "user: admin\r\nports:\r\n- 80\r\n---\r\nfoo: bar\r\n"},
+		{typ: v1alpha1.ContentTypeTOML, text: "[server]\r\nhost = \"db\"\r\nbio = \"\"\"\r\nline \\\r\n  continued\"\"\"\r\n"},
+		{
+			typ:     v1alpha1.ContentTypeTOML,
+			text:    "a = 1 b = 2\r\n",
+			wantErr: "invalid toml at line 1, column 7: expected a newline",
+		},
+		{typ: v1alpha1.ContentTypeINI, text: "; Comment\r\n[server]\r\nhost = db.example.com\r\n\r\n"},
+		{
+			typ:     v1alpha1.ContentTypeINI,
+			text:    "[server\r\nhost = db\r\n",
+			wantErr: "invalid ini at line 1, column 1: unterminated section header",
+		},
+		{typ: v1alpha1.ContentTypePEM, text: strings.ReplaceAll(testPEM+"\n"+testPEM, "\n", "\r\n")},
+		{
+			typ:     "xml",
+			text:    "<xml/>",
+			wantErr: `unsupported content type: "xml"`,
+		},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.typ, tt.text)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s %q: unexpected error: %v", tt.typ, tt.text, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("%s %q: unexpected error: want: %q; got: %v", tt.typ, tt.text, tt.wantErr, err)
+		}
+	}
+}
+
+func TestSyntaxErrorAtCRLF(t *testing.T) {
+	// The end of a line is at the same column with either line ending.
+	lf := syntaxErrorAt(v1alpha1.ContentTypeINI, "ab\ncd", 2, "msg")
+	crlf := syntaxErrorAt(v1alpha1.ContentTypeINI, "ab\r\ncd", 3, "msg")
+	if *lf != *crlf || crlf.Line != 1 || crlf.Column != 3 {
+		t.Errorf("unexpected errors: LF: %v; CRLF: %v", lf, crlf)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+)
+
+// A VarGraph detects cycles of variables whose values reference each other.
+// Variables are expanded in order, so a reference to a variable defined later
+// is unchanged, and a cycle expands partially rather than failing.
+// References to previous values of a variable, e.g. PATH=$(PATH):/bin, aren't
+// cycles. The zero value is an empty graph.
+type VarGraph struct {
+	refs    map[string][]string        // Resolved references, by var name.
+	pending map[string]map[string]bool // Vars with an unresolved reference, by referenced name.
+}
+
+// Add adds the variable with the value, which is expanded with the defined
+// variables, and returns the names of the variables of a cycle through it,
+// starting and ending with it, or nil if there's none. Variables whose values
+// aren't expanded are added with an empty value.
+func (g *VarGraph) Add(name, value string, defined map[string]string) []string {
+	if g.refs == nil {
+		g.refs = make(map[string][]string)
+		g.pending = make(map[string]map[string]bool)
+	}
+	// The variable's previous value, if any, is replaced.
+	delete(g.refs, name)
+	for _, vars := range g.pending {
+		delete(vars, name)
+	}
+
+	var resolved []string
+	selfRef := false
+	expansion.Expand(value, func(ref string) string {
+		switch _, ok := defined[ref]; {
+		case ok:
+			resolved = append(resolved, ref)
+		case ref == name:
+			selfRef = true
+		default:
+			if g.pending[ref] == nil {
+				g.pending[ref] = make(map[string]bool)
+			}
+			g.pending[ref][name] = true
+		}
+		return ""
+	})
+	if selfRef {
+		return []string{name, name}
+	}
+	g.refs[name] = resolved
+	for _, from := range sortedNames(g.pending[name]) {
+		if path := g.path(name, from, make(map[string]bool)); path != nil {
+			return append(path, name)
+		}
+	}
+	return nil
+}
+
+// path returns the names of the variables of a path of resolved references
+// from one variable to another, or nil if there's none.
+func (g *VarGraph) path(from, to string, seen map[string]bool) []string {
+	if from == to {
+		return []string{to}
+	}
+	if seen[from] {
+		return nil
+	}
+	seen[from] = true
+	for _, ref := range g.refs[from] {
+		if p := g.path(ref, to, seen); p != nil {
+			return append([]string{from}, p...)
+		}
+	}
+	return nil
+}
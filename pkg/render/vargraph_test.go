@@ -0,0 +1,77 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package render
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVarGraph(t *testing.T) {
+	type v struct{ name, value string }
+	tests := []struct {
+		name    string
+		defined map[string]string
+		vars    []v
+		want    []string
+	}{
+		{
+			name: "acyclic",
+			vars: []v{{"A", "a"}, {"B", "$(A)"}, {"C", "$(A)$(B)$(UNDEFINED)"}},
+		},
+		{
+			name: "self",
+			vars: []v{{"A", "$(A)"}},
+			want: []string{"A", "A"},
+		},
+		{
+			name:    "previous value",
+			defined: map[string]string{"PATH": "/bin"},
+			vars:    []v{{"PATH", "$(PATH):/usr/bin"}},
+		},
+		{
+			name: "escaped",
+			vars: []v{{"A", "$$(B)"}, {"B", "$(A)"}},
+		},
+		{
+			name: "cycle",
+			vars: []v{{"A", "$(B)"}, {"C", "$(A)"}, {"B", "$(C)"}},
+			want: []string{"B", "C", "A", "B"},
+		},
+		{
+			name:    "defined before",
+			defined: map[string]string{"B": "b"},
+			vars:    []v{{"A", "$(B)"}, {"B", "$(A)"}},
+		},
+		{
+			name: "redefined",
+			vars: []v{{"A", "$(B)"}, {"A", "a"}, {"B", "$(A)"}},
+		},
+		{
+			name: "without value",
+			vars: []v{{"A", "$(B)"}, {"B", ""}, {"C", "$(A)$(B)"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defined := make(map[string]string)
+			for k, v := range tt.defined {
+				defined[k] = v
+			}
+			var g VarGraph
+			var got []string
+			for _, v := range tt.vars {
+				if got = g.Add(v.name, v.value, defined); got != nil {
+					break
+				}
+				defined[v.name] = v.value
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected cycle (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
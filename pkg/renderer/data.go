@@ -0,0 +1,117 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+)
+
+// renderData renders the template data of the ConfigMapSecret with the
+// variables, and converts the line endings of, validates, and compresses the
+// content of its keys.
+func (r *Renderer) renderData(ctx context.Context, cms *v1alpha1.ConfigMapSecret, engine render.Engine, vars map[string]string, trace *Trace) (map[string][]byte, v1alpha1.ConfigMapSecretConditionReason, error) {
+	// Render keys in order, so the first failure is reported consistently.
+	data := make(map[string][]byte)
+	tmpl := cms.Spec.Template
+	var compressedEngine render.Engine
+	for i, section := range []map[string]string{tmpl.Data, BinaryDataStrings(tmpl.BinaryData), tmpl.StringData} {
+		binary := i == 1
+		for _, k := range sortedDataKeys(section) {
+			keyEngine := engine
+			if tmpl.KeyOptions[k].Compress != "" {
+				if compressedEngine == nil {
+					var err error
+					// Compressed values are limited by their decompressed size.
+					if compressedEngine, err = render.ForSpec(&cms.Spec, r.Limits.Compressed()); err != nil {
+						return nil, v1alpha1.TemplateErrorReason, &configError{err}
+					}
+				}
+				keyEngine = compressedEngine
+			}
+			out, val, reason, err := r.renderKey(ctx, &tmpl, keyEngine, vars, trace, binary, k, section[k])
+			if err != nil {
+				return nil, reason, &keyRenderError{key: k, err: err}
+			}
+			data[out] = val
+		}
+	}
+	return data, "", nil
+}
+
+// renderKey renders the template text of the key with engine, and returns
+// the key to which its content is written and the content.
+func (r *Renderer) renderKey(ctx context.Context, tmpl *v1alpha1.ConfigMapTemplate, engine render.Engine, vars map[string]string, trace *Trace, binary bool, k, text string) (string, []byte, v1alpha1.ConfigMapSecretConditionReason, error) {
+	opts := tmpl.KeyOptions[k]
+	limits := r.Limits
+	if opts.Compress != "" {
+		limits = limits.Compressed()
+	}
+	val, err := trace.render(ctx, "key "+k, render.ForKey(engine, tmpl, k), text, vars)
+	if render.IsLimitError(err) {
+		return "", nil, v1alpha1.RenderLimitExceededReason, keyError(binary, k, text, err)
+	}
+	if err != nil {
+		return "", nil, v1alpha1.TemplateErrorReason, keyError(binary, k, text, err)
+	}
+	if val, err = render.Transform(opts.Transforms, val, limits); render.IsLimitError(err) {
+		return "", nil, v1alpha1.RenderLimitExceededReason, newConfigError("key %s: %v", k, err)
+	} else if err != nil {
+		return "", nil, v1alpha1.TransformErrorReason, newConfigError("key %s: %v", k, err)
+	}
+	if opts.LineEndings != "" {
+		if val, err = render.ConvertLineEndings(opts.LineEndings, val); err != nil {
+			return "", nil, v1alpha1.InternalErrorReason, fmt.Errorf("key %s: %v", k, err)
+		}
+		// Converting to CRLF may grow the output past the limit.
+		if max := limits.MaxOutputSize; max > 0 && len(val) > max {
+			return "", nil, v1alpha1.RenderLimitExceededReason, newConfigError("key %s: output exceeds %d bytes with %s line endings", k, max, opts.LineEndings)
+		}
+	}
+	if err := render.Validate(opts.Validate, val); err != nil {
+		return "", nil, v1alpha1.InvalidContentReason, keyError(binary, k, val, err)
+	}
+	out := opts.Compress.Key(k)
+	if out != k && tmpl.HasKey(out) {
+		return "", nil, v1alpha1.TemplateErrorReason, newConfigError("key %s: compressed key %s is already a key", k, out)
+	}
+	buf, err := render.Compress(opts.Compress, []byte(val))
+	if err != nil {
+		return "", nil, v1alpha1.InternalErrorReason, fmt.Errorf("key %s: %v", k, err)
+	}
+	return out, buf, "", nil
+}
+
+// keyError returns the config error of rendering the key. Lines and columns
+// are meaningless in binary data, so its errors are located by their byte
+// offset in text, the template or rendered value in which they occurred.
+func keyError(binary bool, k, text string, err error) *configError {
+	if binary {
+		return newConfigError("binaryData key %s: %v", k, render.AtOffset("key "+k, text, err))
+	}
+	return newConfigError("key %s: %v", k, err)
+}
+
+// BinaryDataStrings returns the binary data of a template as strings.
+func BinaryDataStrings(m map[string][]byte) map[string]string {
+	s := make(map[string]string, len(m))
+	for k, v := range m {
+		s[k] = string(v)
+	}
+	return s
+}
+
+func sortedDataKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
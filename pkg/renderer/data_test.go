@@ -0,0 +1,75 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBinaryDataErrors(t *testing.T) {
+	r := &Renderer{}
+	tests := []struct {
+		name       string
+		template   v1alpha1.ConfigMapTemplate
+		version    v1alpha1.TemplateVersion
+		wantReason v1alpha1.ConfigMapSecretConditionReason
+		wantErr    string
+	}{
+		{
+			name: "template",
+			template: v1alpha1.ConfigMapTemplate{
+				BinaryData: map[string][]byte{"blob": []byte("\x00\x01\n\xff{{ .MISSING }}")},
+			},
+			version:    v1alpha1.TemplateVersionV2,
+			wantReason: v1alpha1.TemplateErrorReason,
+			wantErr:    `binaryData key blob: template error at byte 7: executing "key blob" at <.MISSING>: map has no entry for key "MISSING"`,
+		},
+		{
+			name: "content",
+			template: v1alpha1.ConfigMapTemplate{
+				BinaryData: map[string][]byte{"blob.json": []byte("{\"a\":\n\xff}")},
+				KeyOptions: map[string]v1alpha1.KeyOptions{"blob.json": {Validate: v1alpha1.ContentTypeJSON}},
+			},
+			wantReason: v1alpha1.InvalidContentReason,
+			wantErr:    "binaryData key blob.json: invalid json at byte 6: invalid character looking for beginning of value",
+		},
+		{
+			name: "data",
+			template: v1alpha1.ConfigMapTemplate{
+				Data:       map[string]string{"blob.json": "{\"a\":\nb}"},
+				KeyOptions: map[string]v1alpha1.KeyOptions{"blob.json": {Validate: v1alpha1.ContentTypeJSON}},
+			},
+			wantReason: v1alpha1.InvalidContentReason,
+			wantErr:    "key blob.json: invalid json at line 2, column 1: invalid character looking for beginning of value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cms := &v1alpha1.ConfigMapSecret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "binary"},
+				Spec:       v1alpha1.ConfigMapSecretSpec{Template: tt.template, TemplateVersion: tt.version},
+			}
+			engine, err := render.ForSpec(&cms.Spec, render.Limits{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, reason, err := r.renderData(context.Background(), cms, engine, map[string]string{}, nil)
+			if !IsConfigError(err) {
+				t.Fatalf("expected config error; got: %v", err)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("unexpected reason; want: %q; got: %q", tt.wantReason, reason)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("unexpected error; want: %q; got: %q", tt.wantErr, err)
+			}
+		})
+	}
+}
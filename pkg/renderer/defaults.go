@@ -0,0 +1,67 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// The keys of a defaults ConfigMap.
+const (
+	// DefaultsLabelsKey is the key of a YAML map of labels.
+	DefaultsLabelsKey = "labels"
+	// DefaultsAnnotationsKey is the key of a YAML map of annotations.
+	DefaultsAnnotationsKey = "annotations"
+	// DefaultsTypeKey is the key of a Secret type.
+	DefaultsTypeKey = "type"
+)
+
+// secretDefaults are the defaults of the Secrets rendered in a namespace.
+type secretDefaults struct {
+	labels      map[string]string
+	annotations map[string]string
+	typ         corev1.SecretType
+}
+
+// defaults returns the defaults of the Secrets rendered in the namespace,
+// read from its defaults ConfigMap, if any.
+func (r *Renderer) defaults(ctx context.Context, srcs *Sources, namespace string) (*secretDefaults, error) {
+	d := &secretDefaults{typ: corev1.SecretTypeOpaque}
+	if r.DefaultsConfigMap == "" {
+		return d, nil
+	}
+	optional := true
+	cm, err := r.configMap(ctx, srcs, namespace, v1alpha1.ConfigMapVarsSource{
+		LocalObjectReference: corev1.LocalObjectReference{Name: r.DefaultsConfigMap},
+		Optional:             &optional,
+	})
+	if cm == nil || err != nil {
+		return d, err
+	}
+	for _, m := range []struct {
+		key string
+		dst *map[string]string
+	}{
+		{key: DefaultsLabelsKey, dst: &d.labels},
+		{key: DefaultsAnnotationsKey, dst: &d.annotations},
+	} {
+		if err := yaml.Unmarshal([]byte(cm.Data[m.key]), m.dst); err != nil {
+			return nil, newConfigError("Invalid %s in defaults ConfigMap %s/%s: %v", m.key, namespace, cm.Name, err)
+		}
+	}
+	if typ := cm.Data[DefaultsTypeKey]; typ != "" {
+		d.typ = corev1.SecretType(typ)
+	}
+	return d, nil
+}
+
+// apply returns the labels and annotations of the Secret, merged over the defaults.
+func (d *secretDefaults) apply(labels, annotations map[string]string) (map[string]string, map[string]string) {
+	return MergeStrings(d.labels, labels), MergeStrings(d.annotations, annotations)
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDefaults(t *testing.T) {
+	key := types.NamespacedName{Namespace: "ns", Name: "defaults"}
+	c := &testReader{configMaps: map[types.NamespacedName]*corev1.ConfigMap{
+		key: {
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string]string{DefaultsLabelsKey: "- not a map"},
+		},
+	}}
+	ctx := context.Background()
+
+	r := &Renderer{Client: c}
+	d, err := r.defaults(ctx, NewSources(), "ns")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.typ != corev1.SecretTypeOpaque || d.labels != nil || d.annotations != nil {
+		t.Errorf("unexpected defaults when disabled: %+v", d)
+	}
+
+	r.DefaultsConfigMap = "missing"
+	if _, err := r.defaults(ctx, NewSources(), "ns"); err != nil {
+		t.Errorf("unexpected error for missing ConfigMap: %v", err)
+	}
+
+	r.DefaultsConfigMap = key.Name
+	if _, err := r.defaults(ctx, NewSources(), "ns"); !IsConfigError(err) {
+		t.Errorf("expected config error for invalid labels; got: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/gitsource"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// gitFetchTimeout bounds a fetch of a GitSource, so that an unresponsive
+// server doesn't block rendering indefinitely.
+const gitFetchTimeout = time.Minute
+
+// gitFiles returns the files of the ConfigMapSecret's GitSource, whose
+// references are expanded with the variables, by key.
+func (r *Renderer) gitFiles(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *Sources, vars map[string]string) (map[string][]byte, error) {
+	gs := cms.Spec.GitSource
+	if r.Git == nil {
+		return nil, newConfigError("GitSources are disabled by the controller")
+	}
+	mappingFn := expansion.MappingFuncFor(vars)
+	src := gitsource.Source{
+		Repository: expansion.Expand(gs.Repository, mappingFn),
+		Ref:        expansion.Expand(gs.Ref, mappingFn),
+		Path:       expansion.Expand(gs.Path, mappingFn),
+	}
+	if gs.SecretRef != nil {
+		ref := v1alpha1.SecretVarsSource{LocalObjectReference: *gs.SecretRef}
+		secret, err := r.secret(ctx, srcs, cms.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		src.Auth = gitsource.Auth{
+			Username:   string(secret.Data["username"]),
+			Password:   string(secret.Data["password"]),
+			PrivateKey: secret.Data["ssh-privatekey"],
+			KnownHosts: secret.Data["known_hosts"],
+		}
+	}
+	repository := redactURL(src.Repository)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, gitFetchTimeout)
+	defer cancel()
+	files, commit, err := r.Git(fetchCtx, cms, src)
+	if err != nil {
+		// The credentials are passed in headers, rather than the URL, but a
+		// URL may embed them anyway.
+		msg := strings.ReplaceAll(err.Error(), src.Repository, repository)
+		return nil, fmt.Errorf("GitSource %s: %s", repository, msg)
+	}
+	for name := range files {
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			return nil, newConfigError("GitSource %s: invalid key %q: %s", repository, name, strings.Join(errs, "; "))
+		}
+	}
+	srcs.Git = &GitRead{Repository: repository, Commit: commit}
+	return files, nil
+}
+
+// withGitFiles returns a copy of the ConfigMapSecret whose template has the
+// files as keys, except those that are already keys of its template. Files
+// that aren't valid UTF-8 are binary data.
+func withGitFiles(cms *v1alpha1.ConfigMapSecret, files map[string][]byte) *v1alpha1.ConfigMapSecret {
+	cms = cms.DeepCopy()
+	tmpl := &cms.Spec.Template
+	for name, data := range files {
+		if _, ok := tmpl.Data[name]; ok {
+			continue
+		}
+		if _, ok := tmpl.BinaryData[name]; ok {
+			continue
+		}
+		if _, ok := tmpl.StringData[name]; ok {
+			continue
+		}
+		if !utf8.Valid(data) {
+			if tmpl.BinaryData == nil {
+				tmpl.BinaryData = make(map[string][]byte)
+			}
+			tmpl.BinaryData[name] = data
+			continue
+		}
+		if tmpl.Data == nil {
+			tmpl.Data = make(map[string]string)
+		}
+		tmpl.Data[name] = string(data)
+	}
+	return cms
+}
+
+// redactURL returns the URL with the password of its user, if any, redacted.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
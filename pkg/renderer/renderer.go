@@ -0,0 +1,410 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package renderer renders the Secrets of ConfigMapSecrets from their sources.
+// It's shared by the controller and previews, so that a preview renders the
+// same Secret that the controller writes.
+package renderer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/gitsource"
+	"github.com/machinezone/configmapsecrets/pkg/hooks"
+	"github.com/machinezone/configmapsecrets/pkg/policy"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A Renderer renders the Secrets of ConfigMapSecrets. Its optional functions
+// let the controller cache, measure, and report what's rendered, without
+// changing the rendered Secret.
+type Renderer struct {
+	// Client reads the Secrets and ConfigMaps from which variables are resolved.
+	Client client.Reader
+	// TrustBundles reads ClusterTrustBundles, or is nil if they aren't available.
+	TrustBundles client.Reader
+	// Limits are the limits within which templates are rendered. Zero limits
+	// are disabled.
+	Limits render.Limits
+	// ClusterName, if set, is the value of the v1alpha1.ClusterVar built-in
+	// template variable.
+	ClusterName string
+	// TenantLabel, if set, is the key of the label that identifies the tenant
+	// of ConfigMapSecrets and their sources. A ConfigMapSecret may only read
+	// Secrets and ConfigMaps whose value of the label equals its own.
+	TenantLabel string
+	// DefaultsConfigMap, if set, is the name of the ConfigMap in each namespace
+	// whose labels, annotations, and type are the defaults of the Secrets
+	// rendered in that namespace. See the Defaults*Key constants.
+	DefaultsConfigMap string
+	// SecretNamePrefix and SecretNameSuffix are added to the name of every
+	// rendered Secret.
+	SecretNamePrefix string
+	SecretNameSuffix string
+	// BackupExclusionLabel and BackupExclusionValue are the label set on the
+	// Secrets of ConfigMapSecrets whose BackupPolicy is Exclude. If
+	// BackupExclusionLabel is empty, they default to
+	// v1alpha1.VeleroExcludeFromBackupLabel and "true".
+	BackupExclusionLabel string
+	BackupExclusionValue string
+	// Hooks are applied in order to each rendered Secret.
+	Hooks []hooks.Hook
+	// Policy, if set, is checked against each rendered Secret after the Hooks
+	// are applied.
+	Policy *policy.Policy
+	// Git fetches the files of a GitSource, or is nil if GitSources are disabled.
+	Git func(ctx context.Context, cms *v1alpha1.ConfigMapSecret, src gitsource.Source) (files map[string][]byte, commit string, err error)
+	// Cache, if set, holds rendered data, so that data whose inputs haven't
+	// changed isn't rendered again.
+	Cache Cache
+	// Lookup, if set, is called for each lookup of a source with its kind and
+	// result: a "hit" of the Sources or a "fetch" from the client.
+	Lookup func(kind, result string)
+	// Eventf, if set, records events of the ConfigMapSecret, e.g. of keys of its
+	// sources that aren't valid variable names.
+	Eventf func(ctx context.Context, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{})
+}
+
+// A Cache holds the data rendered for ConfigMapSecrets by the Hash of its inputs.
+type Cache interface {
+	// Get returns the data rendered for the ConfigMapSecret, if it was
+	// rendered from inputs with the hash.
+	Get(key types.NamespacedName, hash Hash) (map[string][]byte, bool)
+	// Put records the data rendered for the ConfigMapSecret from inputs with the hash.
+	Put(key types.NamespacedName, hash Hash, data map[string][]byte)
+}
+
+// A Hash is a hash of the inputs of rendering a ConfigMapSecret's data.
+type Hash [sha256.Size]byte
+
+// NewHash returns the hash of the spec's template and the values of the
+// variables with which it's rendered.
+func NewHash(spec *v1alpha1.ConfigMapSecretSpec, vars map[string]string) (Hash, error) {
+	// Maps are encoded with sorted keys, so the encoding is deterministic.
+	buf, err := json.Marshal(struct {
+		Template         v1alpha1.ConfigMapTemplate
+		TemplateVersion  v1alpha1.TemplateVersion
+		DisableExpansion bool
+		Vars             map[string]string
+	}{spec.Template, spec.TemplateVersion, spec.DisableExpansion, vars})
+	if err != nil {
+		return Hash{}, err
+	}
+	return sha256.Sum256(buf), nil
+}
+
+// SecretName returns the name of the Secret rendered from the ConfigMapSecret,
+// with the SecretNamePrefix and SecretNameSuffix.
+func (r *Renderer) SecretName(cms *v1alpha1.ConfigMapSecret) string {
+	return SecretName(r.SecretNamePrefix, r.SecretNameSuffix, cms)
+}
+
+// SecretName returns the name of the Secret rendered from the ConfigMapSecret,
+// with the prefix and suffix.
+func SecretName(prefix, suffix string, cms *v1alpha1.ConfigMapSecret) string {
+	name := cms.Spec.Template.Metadata.Name
+	if name == "" {
+		name = cms.Name
+	}
+	return prefix + name + suffix
+}
+
+// Render renders the Secret of the ConfigMapSecret, caching the sources it
+// reads in srcs and recording how it's resolved in trace, which may be nil.
+// If rendering fails, the reason is returned with the error. The Secret
+// doesn't have an owner.
+func (r *Renderer) Render(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *Sources, trace *Trace) (*corev1.Secret, v1alpha1.ConfigMapSecretConditionReason, error) {
+	name := r.SecretName(cms)
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return nil, v1alpha1.InvalidSecretNameReason, newConfigError("invalid Secret name %q: %s", name, strings.Join(errs, "; "))
+	}
+	if err := r.checkTenant(ctx, cms, srcs); err != nil {
+		return nil, v1alpha1.TenantViolationReason, err
+	}
+	vars, reason, err := r.variables(ctx, cms, srcs, trace)
+	if err != nil {
+		return nil, reason, err
+	}
+	if cms.Spec.GitSource != nil {
+		files, err := r.gitFiles(ctx, cms, srcs, vars)
+		if err != nil {
+			return nil, v1alpha1.GitSourceErrorReason, err
+		}
+		cms = withGitFiles(cms, files)
+	}
+	engine, err := render.ForSpec(&cms.Spec, r.Limits)
+	if err != nil {
+		return nil, v1alpha1.TemplateErrorReason, &configError{err}
+	}
+
+	// Skip rendering if the inputs haven't changed, unless it's traced or
+	// periodically refreshed.
+	var data map[string][]byte
+	var hash Hash
+	key := types.NamespacedName{Namespace: cms.Namespace, Name: cms.Name}
+	if r.Cache != nil {
+		if hash, err = NewHash(&cms.Spec, vars); err != nil {
+			return nil, v1alpha1.InternalErrorReason, err
+		}
+		if trace == nil && cms.Spec.RefreshInterval == nil {
+			data, _ = r.Cache.Get(key, hash)
+		}
+	}
+	if data == nil {
+		if data, reason, err = r.renderData(ctx, cms, engine, vars, trace); err != nil {
+			return nil, reason, err
+		}
+		if r.Cache != nil {
+			r.Cache.Put(key, hash, data)
+		}
+	}
+	srcs.setUnresolved(engine, cms.Spec.Template, vars)
+	if cms.Spec.Provenance {
+		srcs.setKeySources(engine, cms.Spec.Template)
+	}
+
+	defaults, err := r.defaults(ctx, srcs, cms.Namespace)
+	if err != nil {
+		return nil, v1alpha1.DefaultsErrorReason, err
+	}
+	meta := cms.Spec.Template.Metadata
+	labels, annotations := defaults.apply(MergeStrings(inheritedLabels(cms), meta.Labels), meta.Annotations)
+	labels = r.backupLabels(cms.Spec.BackupPolicy, labels)
+	annotations, err = keyOptionsAnnotations(annotations, cms.Spec.Template.KeyOptions, data)
+	if err != nil {
+		return nil, v1alpha1.InternalErrorReason, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   cms.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Data: data,
+		Type: defaults.typ,
+	}
+	if err := hooks.Run(ctx, r.Hooks, cms, secret); err != nil {
+		return nil, v1alpha1.PostRenderHookErrorReason, err
+	}
+	if err := r.Policy.Check(secret); err != nil {
+		return nil, v1alpha1.PolicyViolationReason, &configError{err}
+	}
+	return secret, "", nil
+}
+
+// variables resolves the variables of the ConfigMapSecret, caching the sources
+// it reads in srcs and recording them in trace, which may be nil. If expansion
+// is disabled, there are no variables. If resolving them fails, the reason is
+// returned with the error.
+func (r *Renderer) variables(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *Sources, trace *Trace) (map[string]string, v1alpha1.ConfigMapSecretConditionReason, error) {
+	srcs.Collisions = make(map[string][]string)
+	srcs.Missing = make(map[string]bool)
+	if cms.Spec.DisableExpansion {
+		return nil, "", nil
+	}
+	vars, err := r.makeVariables(ctx, cms, srcs, trace)
+	if cycle := (*varCycleError)(nil); errors.As(err, &cycle) {
+		return nil, v1alpha1.VariableCycleReason, err
+	}
+	if transformErr := (*transformError)(nil); errors.As(err, &transformErr) {
+		return nil, v1alpha1.TransformErrorReason, err
+	}
+	if err != nil {
+		return nil, v1alpha1.CreateVariablesErrorReason, err
+	}
+	return vars, "", nil
+}
+
+// backupLabels returns the labels with the backup exclusion label set or
+// removed by the policy. The labels aren't modified.
+func (r *Renderer) backupLabels(policy v1alpha1.BackupPolicy, labels map[string]string) map[string]string {
+	key, value := r.BackupExclusionLabel, r.BackupExclusionValue
+	if key == "" {
+		key, value = v1alpha1.VeleroExcludeFromBackupLabel, "true"
+	}
+	switch policy {
+	case v1alpha1.BackupPolicyExclude:
+		m := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			m[k] = v
+		}
+		m[key] = value
+		return m
+	case v1alpha1.BackupPolicyInclude:
+		if _, ok := labels[key]; !ok {
+			return labels
+		}
+		m := make(map[string]string, len(labels))
+		for k, v := range labels {
+			if k != key {
+				m[k] = v
+			}
+		}
+		return m
+	default:
+		return labels
+	}
+}
+
+// inheritedLabels returns the labels of the ConfigMapSecret that are selected
+// by the InheritLabels of its template.
+func inheritedLabels(cms *v1alpha1.ConfigMapSecret) map[string]string {
+	var labels map[string]string
+	for _, key := range cms.Spec.Template.Metadata.InheritLabels {
+		if key == "*" {
+			return cms.Labels
+		}
+		if v, ok := cms.Labels[key]; ok {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[key] = v
+		}
+	}
+	return labels
+}
+
+// keyOptionsAnnotations returns a copy of annotations with the KeyOptionsAnnotation
+// set to the options of the keys in data. If there are no such options,
+// annotations is returned unchanged.
+func keyOptionsAnnotations(annotations map[string]string, opts map[string]v1alpha1.KeyOptions, data map[string][]byte) (map[string]string, error) {
+	keyOpts := make(map[string]v1alpha1.KeyOptions)
+	for k, v := range opts {
+		// Options apply to the key to which a compressed value is written.
+		k = v.Compress.Key(k)
+		if _, ok := data[k]; ok && (v.Mode != nil || v.Owner != nil || v.Compress != "") {
+			v.Validate, v.Verbatim, v.Transforms = "", false, nil // Not hints for consumers.
+			keyOpts[k] = v
+		}
+	}
+	if len(keyOpts) == 0 {
+		return annotations, nil
+	}
+	b, err := json.Marshal(keyOpts)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		m[k] = v
+	}
+	m[v1alpha1.KeyOptionsAnnotation] = string(b)
+	return m, nil
+}
+
+// MergeStrings returns the union of the maps, with the values of m taking
+// precedence over those of defaults. If defaults is empty, m is returned.
+func MergeStrings(defaults, m map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return m
+	}
+	merged := make(map[string]string, len(defaults)+len(m))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range m {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (r *Renderer) lookup(kind, result string) {
+	if r.Lookup != nil {
+		r.Lookup(kind, result)
+	}
+}
+
+func (r *Renderer) eventf(ctx context.Context, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Eventf != nil {
+		r.Eventf(ctx, obj, eventType, reason, messageFmt, args...)
+	}
+}
+
+// A configError is an error in the configuration of a ConfigMapSecret or its
+// sources, e.g. a missing source or an invalid template, rather than a
+// transient error.
+type configError struct {
+	err error
+}
+
+func newConfigError(format string, v ...interface{}) *configError {
+	if len(v) == 0 {
+		return &configError{errors.New(format)}
+	}
+	return &configError{fmt.Errorf(format, v...)}
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+
+func (e *configError) Unwrap() error { return e.err }
+
+func (*configError) IsConfigError() bool { return true }
+
+// A varCycleError indicates that vars reference each other in a cycle, so
+// they can't be fully expanded.
+type varCycleError struct {
+	cycle []string
+}
+
+func (e *varCycleError) Error() string {
+	return "Vars reference each other in a cycle: " + strings.Join(e.cycle, " -> ")
+}
+
+func (*varCycleError) IsConfigError() bool { return true }
+
+// A transformError indicates that the transforms of a var failed.
+type transformError struct {
+	err error
+}
+
+func (e *transformError) Error() string { return e.err.Error() }
+
+func (e *transformError) Unwrap() error { return e.err }
+
+func (*transformError) IsConfigError() bool { return true }
+
+// A keyRenderError is an error rendering a key of the template.
+type keyRenderError struct {
+	key string
+	err error
+}
+
+func (e *keyRenderError) Error() string { return e.err.Error() }
+
+func (e *keyRenderError) Unwrap() error { return e.err }
+
+func (e *keyRenderError) IsConfigError() bool { return IsConfigError(e.err) }
+
+// ErrorKey returns the key of the template that failed to render with err, if
+// it failed rendering a key.
+func ErrorKey(err error) (key string, ok bool) {
+	var keyErr *keyRenderError
+	if errors.As(err, &keyErr) {
+		return keyErr.key, true
+	}
+	return "", false
+}
+
+// IsConfigError reports whether err is an error in the configuration of a
+// ConfigMapSecret or its sources, which is retried when they change rather
+// than with backoff.
+func IsConfigError(err error) bool {
+	v, ok := err.(interface {
+		IsConfigError() bool
+	})
+	return ok && v.IsConfigError()
+}
@@ -0,0 +1,240 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// A testReader reads ConfigMaps and Secrets from maps.
+type testReader struct {
+	client.Reader
+	configMaps map[types.NamespacedName]*corev1.ConfigMap
+	secrets    map[types.NamespacedName]*corev1.Secret
+}
+
+func (c *testReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		src, ok := c.configMaps[key]
+		if !ok {
+			return apierrors.NewNotFound(corev1.Resource("configmaps"), key.Name)
+		}
+		src.DeepCopyInto(obj)
+	case *corev1.Secret:
+		src, ok := c.secrets[key]
+		if !ok {
+			return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+		}
+		src.DeepCopyInto(obj)
+	default:
+		panic("unexpected object type")
+	}
+	return nil
+}
+
+func (c *testReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	switch list := list.(type) {
+	case *corev1.ConfigMapList:
+		list.Items = nil
+		for key, src := range c.configMaps {
+			if listOpts.Namespace != "" && key.Namespace != listOpts.Namespace {
+				continue
+			}
+			if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(src.Labels)) {
+				continue
+			}
+			list.Items = append(list.Items, *src.DeepCopy())
+		}
+	default:
+		panic("unexpected list type")
+	}
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	c := &testReader{
+		configMaps: map[types.NamespacedName]*corev1.ConfigMap{
+			{Namespace: "ns", Name: "config"}: {
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "config"},
+				Data:       map[string]string{"HOST": "db.example.com"},
+			},
+		},
+		secrets: map[types.NamespacedName]*corev1.Secret{
+			{Namespace: "ns", Name: "creds"}: {
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "creds"},
+				Data:       map[string][]byte{"password": []byte("hunter2")},
+			},
+		},
+	}
+	cms := &v1alpha1.ConfigMapSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"},
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Metadata: v1alpha1.EmbeddedObjectMeta{Labels: map[string]string{"app": "db"}},
+				Data: map[string]string{
+					"dsn":  "$(PASSWORD)@$(HOST)",
+					"host": "$(HOST)",
+				},
+			},
+			VarsFrom: []v1alpha1.VarsFromSource{{
+				ConfigMapRef: &v1alpha1.ConfigMapVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}},
+			}},
+			Vars: []v1alpha1.Var{{
+				Name:        "PASSWORD",
+				SecretValue: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}, Key: "password"},
+			}},
+		},
+	}
+	r := &Renderer{Client: c, SecretNameSuffix: "-secret"}
+	srcs := NewSources()
+	trace := NewTrace()
+	secret, reason, err := r.Render(context.Background(), cms, srcs, trace)
+	if err != nil {
+		t.Fatalf("unexpected error: %s: %v", reason, err)
+	}
+	want := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-secret", Labels: map[string]string{"app": "db"}},
+		Data: map[string][]byte{
+			"dsn":  []byte("hunter2@db.example.com"),
+			"host": []byte("db.example.com"),
+		},
+		Type: corev1.SecretTypeOpaque,
+	}
+	if diff := cmp.Diff(want, secret); diff != "" {
+		t.Errorf("unexpected Secret (-want +got):\n%s", diff)
+	}
+	if want := map[string]bool{"PASSWORD": true}; !cmp.Equal(want, srcs.Sensitive) {
+		t.Errorf("unexpected sensitive vars: want: %v; got: %v", want, srcs.Sensitive)
+	}
+	if got := trace.Vars()["PASSWORD"]; got != (TraceVar{Source: "Secret/creds[password]", Length: 7}) {
+		t.Errorf("unexpected trace of PASSWORD: %+v", got)
+	}
+	if got := trace.String(); strings.Contains(got, "hunter2") {
+		t.Errorf("trace contains a secret value: %s", got)
+	}
+
+	cms.Name = "Invalid"
+	if _, reason, err := r.Render(context.Background(), cms, NewSources(), nil); reason != v1alpha1.InvalidSecretNameReason || !IsConfigError(err) {
+		t.Errorf("unexpected result for invalid name: %s: %v", reason, err)
+	}
+}
+
+func TestNewHash(t *testing.T) {
+	spec := &v1alpha1.ConfigMapSecretSpec{
+		Template: v1alpha1.ConfigMapTemplate{
+			Data: map[string]string{"a": "$(A)", "b": "$(B)"},
+		},
+	}
+	vars := map[string]string{"A": "1", "B": "2"}
+	hash, err := NewHash(spec, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		h, err := NewHash(spec, map[string]string{"B": "2", "A": "1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h != hash {
+			t.Fatalf("unexpected hash of equal inputs")
+		}
+	}
+	for name, h := range map[string]func() (Hash, error){
+		"var": func() (Hash, error) {
+			return NewHash(spec, map[string]string{"A": "1", "B": "3"})
+		},
+		"version": func() (Hash, error) {
+			s := spec.DeepCopy()
+			s.TemplateVersion = v1alpha1.TemplateVersionV2
+			return NewHash(s, vars)
+		},
+		"template": func() (Hash, error) {
+			s := spec.DeepCopy()
+			s.Template.Data["a"] = "$(B)"
+			return NewHash(s, vars)
+		},
+	} {
+		got, err := h()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if got == hash {
+			t.Errorf("%s: unexpected hash of changed inputs", name)
+		}
+	}
+}
+
+func TestBackupLabels(t *testing.T) {
+	excluded := map[string]string{"app": "db", v1alpha1.VeleroExcludeFromBackupLabel: "true"}
+	tests := []struct {
+		name   string
+		label  string
+		value  string
+		policy v1alpha1.BackupPolicy
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "unset",
+			labels: excluded,
+			want:   excluded,
+		},
+		{
+			name:   "exclude",
+			policy: v1alpha1.BackupPolicyExclude,
+			labels: map[string]string{"app": "db"},
+			want:   excluded,
+		},
+		{
+			name:   "exclude nil",
+			policy: v1alpha1.BackupPolicyExclude,
+			want:   map[string]string{v1alpha1.VeleroExcludeFromBackupLabel: "true"},
+		},
+		{
+			name:   "include",
+			policy: v1alpha1.BackupPolicyInclude,
+			labels: excluded,
+			want:   map[string]string{"app": "db"},
+		},
+		{
+			name:   "custom label",
+			label:  "backup.example.com/skip",
+			value:  "yes",
+			policy: v1alpha1.BackupPolicyExclude,
+			labels: map[string]string{"app": "db"},
+			want:   map[string]string{"app": "db", "backup.example.com/skip": "yes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := make(map[string]string, len(tt.labels))
+			for k, v := range tt.labels {
+				orig[k] = v
+			}
+			r := &Renderer{BackupExclusionLabel: tt.label, BackupExclusionValue: tt.value}
+			got := r.backupLabels(tt.policy, tt.labels)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected labels (-want +got):\n%s", diff)
+			}
+			if len(tt.labels) > 0 && !cmp.Equal(orig, tt.labels) {
+				t.Errorf("labels were modified: %v", tt.labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"sort"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Sources caches the sources read while rendering a ConfigMapSecret, by name,
+// and records how its variables were resolved from them. A nil value
+// indicates that an optional source doesn't exist.
+type Sources struct {
+	ConfigMaps map[string]*corev1.ConfigMap
+	Secrets    map[string]*corev1.Secret
+	// Selected are the names of the ConfigMaps selected by label.
+	Selected map[string]bool
+	// TrustBundles are the ClusterTrustBundles that were read, or nil if
+	// none were.
+	TrustBundles map[string]metav1.Object
+
+	// Collisions are the sources of variables defined by more than one
+	// VarsFrom source, in spec order, or nil if variables weren't made.
+	Collisions map[string][]string
+	// Missing are the optional sources, or keys of them, that didn't exist,
+	// or nil if variables weren't made.
+	Missing map[string]bool
+	// Git is the read of the GitSource, or nil if it wasn't read.
+	Git *GitRead
+	// Unresolved are the numbers of references to undefined variables left
+	// unchanged in the template, by variable, or nil if it wasn't rendered.
+	Unresolved map[string]int
+
+	// VarSources are the sources of each variable, e.g. "Secret/db[PASSWORD]".
+	// Variables without sources, e.g. built-in ones, are omitted.
+	VarSources map[string][]string
+	// KeySources are the sources of the variables used by each key of the
+	// template, or nil if provenance is disabled or it wasn't rendered.
+	KeySources map[string][]string
+	// Sensitive are the variables whose values depend on a Secret.
+	Sensitive map[string]bool
+}
+
+// NewSources returns empty sources.
+func NewSources() *Sources {
+	return &Sources{
+		ConfigMaps: make(map[string]*corev1.ConfigMap),
+		Secrets:    make(map[string]*corev1.Secret),
+		Selected:   make(map[string]bool),
+		VarSources: make(map[string][]string),
+		Sensitive:  make(map[string]bool),
+	}
+}
+
+// A GitRead is the read of a GitSource.
+type GitRead struct {
+	Repository string // Without credentials.
+	Commit     string
+}
+
+// collide records that the variable defined by the source prev is redefined
+// by the source cur.
+func (s *Sources) collide(name, prev, cur string) {
+	if s.Collisions[name] == nil {
+		s.Collisions[name] = []string{prev}
+	}
+	s.Collisions[name] = append(s.Collisions[name], cur)
+}
+
+// setVarSources sets the sources of the variable, replacing any previous ones.
+func (s *Sources) setVarSources(name string, srcs ...string) {
+	if len(srcs) == 0 {
+		delete(s.VarSources, name)
+		return
+	}
+	s.VarSources[name] = srcs
+}
+
+// refSources returns the sources of the variables referenced by the
+// $(VAR_NAME) expansion of value, sorted.
+func (s *Sources) refSources(value string) []string {
+	set := make(map[string]bool)
+	expansion.Expand(value, func(name string) string {
+		for _, src := range s.VarSources[name] {
+			set[src] = true
+		}
+		return ""
+	})
+	return sortedKeys(set)
+}
+
+// refSensitive reports whether the $(VAR_NAME) expansion of value references
+// a sensitive variable.
+func (s *Sources) refSensitive(value string) bool {
+	sensitive := false
+	expansion.Expand(value, func(name string) string {
+		sensitive = sensitive || s.Sensitive[name]
+		return ""
+	})
+	return sensitive
+}
+
+// setSensitive records whether the value of the variable depends on a Secret.
+func (s *Sources) setSensitive(name string, sensitive bool) {
+	if s.Sensitive == nil {
+		s.Sensitive = make(map[string]bool)
+	}
+	if sensitive {
+		s.Sensitive[name] = true
+	} else {
+		delete(s.Sensitive, name)
+	}
+}
+
+// setUnresolved records the references of the template to variables that
+// aren't defined, which the engine leaves unchanged.
+func (s *Sources) setUnresolved(engine render.Engine, tmpl v1alpha1.ConfigMapTemplate, vars map[string]string) {
+	s.Unresolved = make(map[string]int)
+	for _, section := range []map[string]string{tmpl.Data, BinaryDataStrings(tmpl.BinaryData), tmpl.StringData} {
+		for k, text := range section {
+			for name, n := range render.UnresolvedRefs(render.ForKey(engine, &tmpl, k), text, vars) {
+				s.Unresolved[name] += n
+			}
+		}
+	}
+}
+
+// setKeySources sets the sources of the variables used by each key of the
+// template, as rendered by the engine.
+func (s *Sources) setKeySources(engine render.Engine, tmpl v1alpha1.ConfigMapTemplate) {
+	s.KeySources = make(map[string][]string)
+	for _, section := range []map[string]string{tmpl.Data, BinaryDataStrings(tmpl.BinaryData), tmpl.StringData} {
+		for k, v := range section {
+			names, all := render.ForKey(engine, &tmpl, k).Refs(k, v)
+			if all {
+				names = make([]string, 0, len(s.VarSources))
+				for name := range s.VarSources {
+					names = append(names, name)
+				}
+			}
+			set := make(map[string]bool)
+			for _, name := range names {
+				for _, src := range s.VarSources[name] {
+					set[src] = true
+				}
+			}
+			s.KeySources[tmpl.KeyOptions[k].Compress.Key(k)] = sortedKeys(set)
+		}
+	}
+}
+
+// keySource returns the source of a key of a Secret or ConfigMap, e.g.
+// "Secret/db[PASSWORD]".
+func keySource(kind, name, key string) string {
+	return kind + "/" + name + "[" + key + "]"
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	s := make([]string, 0, len(set))
+	for k := range set {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
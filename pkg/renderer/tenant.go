@@ -0,0 +1,46 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/tenant"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkTenant returns a config error if the TenantLabel is set and the
+// ConfigMapSecret references a Secret or ConfigMap of another tenant by name.
+// It's checked before any values are read from sources, so that those of
+// other tenants don't reach variables, errors, or events. The sources are
+// cached in srcs; missing ones aren't checked, since the error is returned
+// when they're used.
+func (r *Renderer) checkTenant(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *Sources) error {
+	if r.TenantLabel == "" {
+		return nil
+	}
+	for _, ref := range tenant.Refs(cms) {
+		// Optional refs aren't checked as such, so that a source that's
+		// missing isn't cached as an optional one.
+		obj := corev1.LocalObjectReference{Name: ref.Name}
+		var src metav1.Object
+		switch ref.Kind {
+		case "Secret":
+			if secret, _ := r.secret(ctx, srcs, cms.Namespace, v1alpha1.SecretVarsSource{LocalObjectReference: obj}); secret != nil {
+				src = secret
+			}
+		case "ConfigMap":
+			if configMap, _ := r.configMap(ctx, srcs, cms.Namespace, v1alpha1.ConfigMapVarsSource{LocalObjectReference: obj}); configMap != nil {
+				src = configMap
+			}
+		}
+		if src != nil && !tenant.Matches(r.TenantLabel, cms, src) {
+			return newConfigError("%s", tenant.Message(r.TenantLabel, cms, ref))
+		}
+	}
+	return nil
+}
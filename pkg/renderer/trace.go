@@ -0,0 +1,142 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+)
+
+// A Trace records how the variables and data of a ConfigMapSecret are
+// resolved, without their values. A nil *Trace records nothing.
+type Trace struct {
+	vars       map[string]TraceVar
+	expansions []Expansion
+}
+
+// A TraceVar records where a variable was resolved from.
+type TraceVar struct {
+	// Source is where the variable was resolved from, e.g. "Secret/name[key]",
+	// "value", or "builtin".
+	Source string
+	// Length is the length of its value in bytes.
+	Length int
+}
+
+// An Expansion records the variables referenced by the value of a var or the
+// template of a key.
+type Expansion struct {
+	// Target is what was expanded, e.g. "var NAME" or "key name".
+	Target string
+	// Resolved and Unresolved are the sorted names of the referenced
+	// variables that were and weren't defined.
+	Resolved   []string
+	Unresolved []string
+	// All indicates that the template may reference every variable.
+	All bool
+}
+
+// NewTrace returns an empty trace.
+func NewTrace() *Trace {
+	return &Trace{vars: make(map[string]TraceVar)}
+}
+
+// Vars returns the variables that were set, by name.
+func (t *Trace) Vars() map[string]TraceVar {
+	return t.vars
+}
+
+// Expansions returns the expansions of vars and keys, in order.
+func (t *Trace) Expansions() []Expansion {
+	return t.expansions
+}
+
+// setVar records that the variable was set to the value from the source.
+func (t *Trace) setVar(name, source, value string) {
+	if t != nil {
+		t.vars[name] = TraceVar{Source: source, Length: len(value)}
+	}
+}
+
+// expand expands input using mapping, recording the variables referenced by
+// target and whether they were resolved from vars.
+func (t *Trace) expand(target, input string, vars map[string]string, mapping func(string) string) string {
+	if t == nil {
+		return expansion.Expand(input, mapping)
+	}
+	resolved := make(map[string]bool)
+	unresolved := make(map[string]bool)
+	out := expansion.Expand(input, func(name string) string {
+		if _, ok := vars[name]; ok {
+			resolved[name] = true
+		} else {
+			unresolved[name] = true
+		}
+		return mapping(name)
+	})
+	t.expansions = append(t.expansions, Expansion{
+		Target:     target,
+		Resolved:   sortedKeys(resolved),
+		Unresolved: sortedKeys(unresolved),
+	})
+	return out
+}
+
+// render renders the template text with engine, recording the variables
+// referenced by target and whether they were resolved from vars.
+func (t *Trace) render(ctx context.Context, target string, engine render.Engine, text string, vars map[string]string) (string, error) {
+	if t != nil {
+		names, all := engine.Refs(target, text)
+		resolved := make(map[string]bool)
+		unresolved := make(map[string]bool)
+		for _, name := range names {
+			if _, ok := vars[name]; ok {
+				resolved[name] = true
+			} else {
+				unresolved[name] = true
+			}
+		}
+		t.expansions = append(t.expansions, Expansion{
+			Target:     target,
+			Resolved:   sortedKeys(resolved),
+			Unresolved: sortedKeys(unresolved),
+			All:        all,
+		})
+	}
+	return engine.Render(ctx, target, text, vars)
+}
+
+// String returns the sources of the variables and the expansions that
+// reference any. A template that may reference every variable is recorded as
+// resolving "*".
+func (t *Trace) String() string {
+	names := make([]string, 0, len(t.vars))
+	for name := range t.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]string, len(names))
+	for i, name := range names {
+		vars[i] = name + "=" + t.vars[name].Source
+	}
+	var expansions []string
+	for _, e := range t.expansions {
+		resolved := e.Resolved
+		if e.All {
+			resolved = append([]string{"*"}, resolved...)
+		}
+		if len(resolved)+len(e.Unresolved) == 0 {
+			continue
+		}
+		expansions = append(expansions, fmt.Sprintf("%s resolved [%s] unresolved [%s]",
+			e.Target, strings.Join(resolved, " "), strings.Join(e.Unresolved, " ")))
+	}
+	return fmt.Sprintf("Variables: [%s]. Expansions: [%s].", strings.Join(vars, ", "), strings.Join(expansions, "; "))
+}
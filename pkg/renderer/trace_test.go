@@ -0,0 +1,50 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+)
+
+func TestTrace(t *testing.T) {
+	trace := NewTrace()
+	vars := map[string]string{"HOST": "db.example.com", "PASSWORD": "hunter2"}
+	trace.setVar("HOST", "ConfigMap/config", vars["HOST"])
+	trace.setVar("PASSWORD", "Secret/creds[password]", vars["PASSWORD"])
+	out := trace.expand("key dsn", "$(PASSWORD)@$(HOST):$(PORT)", vars, expansion.MappingFuncFor(vars))
+	if want := "hunter2@db.example.com:$(PORT)"; out != want {
+		t.Errorf("unexpected expansion: want: %q; got: %q", want, out)
+	}
+	engine, _ := render.ForVersion(v1alpha1.TemplateVersionV2, render.DefaultLimits)
+	out, err := trace.render(context.Background(), "key url", engine, `{{ .HOST }}{{ if false }}/{{ .PATH }}{{ end }}{{ if . }}{{ end }}`, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "db.example.com"; out != want {
+		t.Errorf("unexpected render: want: %q; got: %q", want, out)
+	}
+
+	got := trace.String()
+	want := "Variables: [HOST=ConfigMap/config, PASSWORD=Secret/creds[password]]. " +
+		"Expansions: [key dsn resolved [HOST PASSWORD] unresolved [PORT]; key url resolved [* HOST] unresolved [PATH]]."
+	if got != want {
+		t.Errorf("unexpected trace:\nwant: %s\ngot:  %s", want, got)
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("trace contains a secret value: %s", got)
+	}
+
+	var nilTrace *Trace
+	nilTrace.setVar("HOST", "ConfigMap/config", "db.example.com")
+	if out := nilTrace.expand("key host", "$(HOST)", vars, expansion.MappingFuncFor(vars)); out != "db.example.com" {
+		t.Errorf("unexpected expansion: %q", out)
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2019 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/pkg/tenant"
+	"github.com/machinezone/configmapsecrets/pkg/trustbundle"
+	"github.com/machinezone/configmapsecrets/third_party/kubernetes/forked/golang/expansion"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Same logic as container env vars: Kubelet.makeEnvironmentVariables
+// https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go
+func (r *Renderer) makeVariables(ctx context.Context, cms *v1alpha1.ConfigMapSecret, srcs *Sources, trace *Trace) (vars map[string]string, err error) {
+	vars = v1alpha1.BuiltinVars(cms, r.ClusterName)
+	for k, v := range vars {
+		trace.setVar(k, "builtin", v)
+	}
+	mappingFn := expansion.MappingFuncFor(vars)
+
+	// Later sources take precedence, as with container env vars.
+	from := make(map[string]string)
+	setVars := func(kind, name string, srcVars, srcKeys map[string]string, invalidKeys []string) error {
+		if len(invalidKeys) > 0 && cms.Spec.InvalidKeyPolicy == v1alpha1.InvalidKeyPolicyError {
+			return newConfigError("Keys [%s] from the VarsFrom %s %s/%s are invalid template variable names",
+				strings.Join(invalidKeys, ", "), kind, cms.Namespace, name)
+		}
+		source := kind + "/" + name
+		if srcVars == nil {
+			srcs.Missing[source] = true
+		}
+		for k, v := range srcVars {
+			if prev, ok := from[k]; ok {
+				srcs.collide(k, prev, source)
+			}
+			from[k] = source
+			vars[k] = v
+			trace.setVar(k, keySource(kind, name, srcKeys[k]), v)
+			srcs.setVarSources(k, keySource(kind, name, srcKeys[k]))
+			srcs.setSensitive(k, kind == "Secret")
+		}
+		if len(invalidKeys) > 0 {
+			action := "skipped"
+			if cms.Spec.InvalidKeyPolicy == v1alpha1.InvalidKeyPolicySanitize {
+				action = "sanitized"
+			}
+			r.eventf(
+				ctx,
+				cms,
+				corev1.EventTypeWarning,
+				"InvalidTemplateVariableNames",
+				"Keys [%s] from the VarsFrom %s %s/%s were %s since they are considered invalid template variable names.",
+				strings.Join(invalidKeys, ", "),
+				kind,
+				cms.Namespace,
+				name,
+				action,
+			)
+		}
+		return nil
+	}
+	for _, v := range cms.Spec.VarsFrom {
+		var (
+			kind, name  string
+			invalidKeys []string
+			srcVars     map[string]string
+			srcKeys     map[string]string
+		)
+		switch {
+		case v.SecretRef != nil:
+			kind = "Secret"
+			name = v.SecretRef.Name
+			srcVars, srcKeys, invalidKeys, err = r.secretValues(ctx, srcs, cms.Namespace, v.Prefix, cms.Spec.InvalidKeyPolicy, *v.SecretRef)
+		case v.ConfigMapRef != nil:
+			kind = "ConfigMap"
+			name = v.ConfigMapRef.Name
+			srcVars, srcKeys, invalidKeys, err = r.configMapValues(ctx, srcs, cms.Namespace, v.Prefix, cms.Spec.InvalidKeyPolicy, *v.ConfigMapRef)
+		case v.ConfigMapSelector != nil:
+			if err := r.setSelectedVars(ctx, cms, srcs, v.Prefix, *v.ConfigMapSelector, setVars); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := setVars(kind, name, srcVars, srcKeys, invalidKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	var graph render.VarGraph
+	for _, v := range cms.Spec.Vars {
+		val := v.Value
+		found := true
+		source := "value"
+		sensitive := false
+		var varSources []string
+
+		switch {
+		case val != "":
+			if cycle := graph.Add(v.Name, val, vars); cycle != nil {
+				return nil, &varCycleError{cycle}
+			}
+			varSources = srcs.refSources(val)
+			sensitive = srcs.refSensitive(val)
+			val = trace.expand("var "+v.Name, val, vars, mappingFn)
+		case v.SecretValue != nil:
+			source = keySource("Secret", v.SecretValue.Name, v.SecretValue.Key)
+			varSources = []string{source}
+			sensitive = true
+			val, found, err = r.secretValue(ctx, srcs, cms.Namespace, *v.SecretValue)
+		case v.ConfigMapValue != nil:
+			source = keySource("ConfigMap", v.ConfigMapValue.Name, v.ConfigMapValue.Key)
+			varSources = []string{source}
+			val, found, err = r.configMapValue(ctx, srcs, cms.Namespace, *v.ConfigMapValue)
+		case v.ClusterTrustBundleValue != nil:
+			source = trustbundle.Source(*v.ClusterTrustBundleValue)
+			varSources = []string{source}
+			val, found, err = r.trustBundleValue(ctx, srcs, *v.ClusterTrustBundleValue)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			srcs.Missing[source] = true
+			continue
+		}
+
+		if v.Value == "" {
+			graph.Add(v.Name, "", vars)
+		}
+		if len(v.Transforms) > 0 {
+			if val, err = render.Transform(v.Transforms, val, r.Limits); err != nil {
+				return nil, &transformError{fmt.Errorf("var %s: %w", v.Name, err)}
+			}
+		}
+		vars[v.Name] = val
+		trace.setVar(v.Name, source, val)
+		srcs.setVarSources(v.Name, varSources...)
+		srcs.setSensitive(v.Name, sensitive)
+	}
+
+	return vars, nil
+}
+
+func (r *Renderer) secret(ctx context.Context, srcs *Sources, namespace string, ref v1alpha1.SecretVarsSource) (secret *corev1.Secret, err error) {
+	name := ref.Name
+	secret, found := srcs.Secrets[name]
+	if found {
+		r.lookup("Secret", "hit")
+		return secret, nil
+	}
+	r.lookup("Secret", "fetch")
+	secret = &corev1.Secret{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			if ref.IsOptional() {
+				srcs.Secrets[name] = nil
+				return nil, nil
+			}
+			return nil, &configError{err}
+		}
+		return nil, err
+	}
+	srcs.Secrets[name] = secret
+	return secret, nil
+}
+
+func (r *Renderer) secretValues(ctx context.Context, srcs *Sources, namespace, prefix string, policy v1alpha1.InvalidKeyPolicy, ref v1alpha1.SecretVarsSource) (values, srcKeys map[string]string, invalidKeys []string, err error) {
+	secret, err := r.secret(ctx, srcs, namespace, ref)
+	if secret == nil || err != nil {
+		return nil, nil, nil, err
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	values, srcKeys, invalidKeys = prefixedValues(prefix, policy, data)
+	return values, srcKeys, invalidKeys, nil
+}
+
+func (r *Renderer) secretValue(ctx context.Context, srcs *Sources, namespace string, ref corev1.SecretKeySelector) (value string, found bool, err error) {
+	key := ref.Key
+	src := v1alpha1.SecretVarsSource{
+		LocalObjectReference: ref.LocalObjectReference,
+		Optional:             ref.Optional,
+	}
+	secret, err := r.secret(ctx, srcs, namespace, src)
+	if secret == nil || err != nil {
+		return "", false, err
+	}
+	if buf, found := secret.Data[key]; found {
+		return string(buf), true, nil
+	}
+	if src.IsOptional() {
+		return "", false, nil
+	}
+	return "", false, newConfigError("Couldn't find key %s in Secret %s/%s", key, namespace, ref.Name)
+}
+
+func (r *Renderer) configMap(ctx context.Context, srcs *Sources, namespace string, ref v1alpha1.ConfigMapVarsSource) (configMap *corev1.ConfigMap, err error) {
+	name := ref.Name
+	configMap, found := srcs.ConfigMaps[name]
+	if found {
+		r.lookup("ConfigMap", "hit")
+		return configMap, nil
+	}
+	r.lookup("ConfigMap", "fetch")
+	configMap = &corev1.ConfigMap{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configMap)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			if ref.IsOptional() {
+				srcs.ConfigMaps[name] = nil
+				return nil, nil
+			}
+			return nil, &configError{err}
+		}
+		return nil, err
+	}
+	srcs.ConfigMaps[name] = configMap
+	return configMap, nil
+}
+
+func (r *Renderer) configMapValues(ctx context.Context, srcs *Sources, namespace, prefix string, policy v1alpha1.InvalidKeyPolicy, ref v1alpha1.ConfigMapVarsSource) (values, srcKeys map[string]string, invalidKeys []string, err error) {
+	configMap, err := r.configMap(ctx, srcs, namespace, ref)
+	if configMap == nil || err != nil {
+		return nil, nil, nil, err
+	}
+	values, srcKeys, invalidKeys = prefixedValues(prefix, policy, configMapData(configMap))
+	return values, srcKeys, invalidKeys, nil
+}
+
+// configMapData returns the data and binary data of the ConfigMap as strings.
+func configMapData(configMap *corev1.ConfigMap) map[string]string {
+	data := make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.Data {
+		data[k] = v
+	}
+	for k, v := range configMap.BinaryData {
+		data[k] = string(v)
+	}
+	return data
+}
+
+func (r *Renderer) configMapValue(ctx context.Context, srcs *Sources, namespace string, ref corev1.ConfigMapKeySelector) (value string, found bool, err error) {
+	key := ref.Key
+	src := v1alpha1.ConfigMapVarsSource{
+		LocalObjectReference: ref.LocalObjectReference,
+		Optional:             ref.Optional,
+	}
+	configMap, err := r.configMap(ctx, srcs, namespace, src)
+	if configMap == nil || err != nil {
+		return "", false, err
+	}
+	if str, found := configMap.Data[key]; found {
+		return str, true, nil
+	}
+	if buf, found := configMap.BinaryData[key]; found {
+		return string(buf), true, nil
+	}
+	if src.IsOptional() {
+		return "", false, nil
+	}
+	return "", false, newConfigError("Couldn't find key %s in ConfigMap %s/%s", key, namespace, ref.Name)
+}
+
+// LabelSelector returns the label selector of sel, which must be valid and
+// not empty, since an empty selector would select every ConfigMap.
+func LabelSelector(sel v1alpha1.ConfigMapSelector) (labels.Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&sel.LabelSelector)
+	if err != nil {
+		return nil, newConfigError("Invalid ConfigMap selector: %v", err)
+	}
+	if selector.Empty() {
+		return nil, newConfigError("ConfigMap selector must not be empty")
+	}
+	return selector, nil
+}
+
+// selectConfigMaps returns the ConfigMaps in the namespace of the
+// ConfigMapSecret selected by sel, sorted by name, and caches them in srcs.
+// ConfigMaps of other tenants aren't selected.
+func (r *Renderer) selectConfigMaps(ctx context.Context, srcs *Sources, cms *v1alpha1.ConfigMapSecret, sel v1alpha1.ConfigMapSelector) ([]*corev1.ConfigMap, error) {
+	selector, err := LabelSelector(sel)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ConfigMapList{}
+	if err := r.Client.List(ctx, list, client.InNamespace(cms.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	configMaps := make([]*corev1.ConfigMap, 0, len(list.Items))
+	for i := range list.Items {
+		configMap := &list.Items[i]
+		if r.TenantLabel != "" && !tenant.Matches(r.TenantLabel, cms, configMap) {
+			continue
+		}
+		srcs.ConfigMaps[configMap.Name] = configMap
+		srcs.Selected[configMap.Name] = true
+		configMaps = append(configMaps, configMap)
+	}
+	return configMaps, nil
+}
+
+// setSelectedVars sets the variables of the ConfigMaps selected by sel with
+// setVars, in order of name, so that later ConfigMaps take precedence unless
+// the conflict policy makes conflicting keys an error.
+func (r *Renderer) setSelectedVars(
+	ctx context.Context,
+	cms *v1alpha1.ConfigMapSecret,
+	srcs *Sources,
+	prefix string,
+	sel v1alpha1.ConfigMapSelector,
+	setVars func(kind, name string, srcVars, srcKeys map[string]string, invalidKeys []string) error,
+) error {
+	configMaps, err := r.selectConfigMaps(ctx, srcs, cms, sel)
+	if err != nil {
+		return err
+	}
+	definedBy := make(map[string]string)
+	for _, configMap := range configMaps {
+		srcVars, srcKeys, invalidKeys := prefixedValues(prefix, cms.Spec.InvalidKeyPolicy, configMapData(configMap))
+		if sel.ConflictPolicy == v1alpha1.ConflictPolicyError {
+			for _, k := range sortedDataKeys(srcVars) {
+				if prev, ok := definedBy[k]; ok {
+					return newConfigError("Variable %s is defined by both ConfigMaps %s/%s and %s/%s selected by the VarsFrom selector",
+						k, cms.Namespace, prev, cms.Namespace, configMap.Name)
+				}
+				definedBy[k] = configMap.Name
+			}
+		}
+		if err := setVars("ConfigMap", configMap.Name, srcVars, srcKeys, invalidKeys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trustBundleValue returns the PEM certificates of the ClusterTrustBundles
+// selected by sel, and caches them in srcs.
+func (r *Renderer) trustBundleValue(ctx context.Context, srcs *Sources, sel v1alpha1.ClusterTrustBundleSelector) (value string, found bool, err error) {
+	if r.TrustBundles == nil {
+		if sel.IsOptional() {
+			return "", false, nil
+		}
+		return "", false, &configError{trustbundle.ErrUnavailable}
+	}
+	r.lookup("ClusterTrustBundle", "fetch")
+	objs, err := trustbundle.Select(ctx, r.TrustBundles, sel)
+	if meta.IsNoMatchError(err) {
+		// The API isn't available, but TrustBundles wasn't nil, e.g. for a preview.
+		if sel.IsOptional() {
+			return "", false, nil
+		}
+		return "", false, &configError{trustbundle.ErrUnavailable}
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", false, err
+	}
+	if srcs.TrustBundles == nil {
+		srcs.TrustBundles = make(map[string]metav1.Object)
+	}
+	switch {
+	case err != nil:
+		if sel.IsOptional() {
+			return "", false, nil
+		}
+		return "", false, &configError{err}
+	case len(objs) == 0:
+		if sel.IsOptional() {
+			return "", false, nil
+		}
+		return "", false, newConfigError("No ClusterTrustBundles of signer %s match the selector", sel.SignerName)
+	}
+	for _, obj := range objs {
+		srcs.TrustBundles[obj.GetName()] = obj
+	}
+	buf, err := trustbundle.PEM(objs)
+	if err != nil {
+		return "", false, &configError{err}
+	}
+	return string(buf), true, nil
+}
+
+// prefixedValues returns the values of data by their prefixed keys, the keys
+// of data by their prefixed keys, and the prefixed keys that aren't valid
+// variable names, sorted. Invalid keys are skipped, unless the policy
+// sanitizes them.
+func prefixedValues(prefix string, policy v1alpha1.InvalidKeyPolicy, data map[string]string) (values, srcKeys map[string]string, invalidKeys []string) {
+	values = make(map[string]string, len(data))
+	srcKeys = make(map[string]string, len(data))
+	for _, srcKey := range sortedDataKeys(data) {
+		v := data[srcKey]
+		k, valid := validPrefixedKey(prefix, srcKey)
+		if valid {
+			values[k] = v
+			srcKeys[k] = srcKey
+			continue
+		}
+		invalidKeys = append(invalidKeys, k)
+		if policy != v1alpha1.InvalidKeyPolicySanitize {
+			continue
+		}
+		// Valid keys, and sanitized keys earlier in sorted order, take precedence.
+		k = sanitizeKey(k)
+		if _, ok := values[k]; !ok {
+			values[k] = v
+			srcKeys[k] = srcKey
+		}
+	}
+	return values, srcKeys, invalidKeys
+}
+
+// sanitizeKey makes key a valid variable name by replacing invalid characters
+// with '_' and prepending '_' to a leading digit.
+func sanitizeKey(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c == '-' || c == '.' || c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || '0' <= b[0] && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+	return string(b)
+}
+
+func validPrefixedKey(prefix, key string) (string, bool) {
+	if prefix != "" {
+		key = prefix + key
+	}
+	if errMsgs := validation.IsEnvVarName(key); len(errMsgs) > 0 {
+		return key, false
+	}
+	return key, true
+}
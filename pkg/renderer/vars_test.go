@@ -0,0 +1,60 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package renderer
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+)
+
+func TestPrefixedValues(t *testing.T) {
+	data := map[string]string{
+		"host":      "a",
+		"log level": "b",
+		"log_level": "c",
+		"db:host":   "d",
+		"1st":       "e",
+	}
+	tests := []struct {
+		prefix string
+		policy v1alpha1.InvalidKeyPolicy
+		want   map[string]string
+	}{
+		{
+			prefix: "X_",
+			policy: v1alpha1.InvalidKeyPolicySkip,
+			want:   map[string]string{"X_host": "a", "X_log_level": "c", "X_1st": "e"},
+		},
+		{
+			prefix: "X_",
+			policy: v1alpha1.InvalidKeyPolicySanitize,
+			want:   map[string]string{"X_host": "a", "X_log_level": "c", "X_db_host": "d", "X_1st": "e"},
+		},
+		{
+			policy: v1alpha1.InvalidKeyPolicySanitize,
+			want:   map[string]string{"host": "a", "log_level": "c", "db_host": "d", "_1st": "e"},
+		},
+	}
+	for _, tt := range tests {
+		got, _, _ := prefixedValues(tt.prefix, tt.policy, data)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s %s: unexpected values (-want +got):\n%s", tt.prefix, tt.policy, diff)
+		}
+	}
+
+	// Sanitized keys are applied in sorted order.
+	got, srcKeys, invalid := prefixedValues("", v1alpha1.InvalidKeyPolicySanitize, map[string]string{"a b": "a", "a:b": "b"})
+	if want := map[string]string{"a_b": "a"}; !cmp.Equal(want, got) {
+		t.Errorf("unexpected sanitized values; want: %v; got: %v", want, got)
+	}
+	if want := map[string]string{"a_b": "a b"}; !cmp.Equal(want, srcKeys) {
+		t.Errorf("unexpected source keys; want: %v; got: %v", want, srcKeys)
+	}
+	if want := []string{"a b", "a:b"}; !cmp.Equal(want, invalid) {
+		t.Errorf("unexpected invalid keys; want: %q; got: %q", want, invalid)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package schema provides the schema of the ConfigMapSecret API supported by
+// this build, so tools can introspect a running controller rather than the
+// CustomResourceDefinition installed in the cluster.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/machinezone/configmapsecrets/manifest"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// crd is the CRD embedded in the controller, which serves it for tooling.
+var crd = manifest.CRD
+
+// jsonSchemaDraft is the JSON Schema dialect of the JSON Schema artifact.
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// CRD returns the ConfigMapSecret CustomResourceDefinition supported by this build.
+func CRD() (*apiextensionsv1.CustomResourceDefinition, error) {
+	obj := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(crd, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// OpenAPI returns the JSON-encoded OpenAPI v3 schema of the given version
+// of the ConfigMapSecret CustomResourceDefinition.
+func OpenAPI(version string) ([]byte, error) {
+	return openAPI(crd, version)
+}
+
+// JSONSchema returns the JSON Schema artifact of the given version
+// of the ConfigMapSecret CustomResourceDefinition.
+func JSONSchema(version string) ([]byte, error) {
+	return FromCRD(crd, version)
+}
+
+// FromCRD returns the JSON Schema artifact of the given version of the
+// YAML-encoded CustomResourceDefinition.
+//
+// It's the OpenAPI v3 schema without Kubernetes extensions, which are
+// meaningless to generic JSON Schema validators.
+func FromCRD(crdYAML []byte, version string) ([]byte, error) {
+	buf, err := openAPI(crdYAML, version)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(buf, &schema); err != nil {
+		return nil, err
+	}
+	stripExtensions(schema)
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "ConfigMapSecret " + version
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func openAPI(crdYAML []byte, version string) ([]byte, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(crdYAML, crd); err != nil {
+		return nil, err
+	}
+	for _, v := range crd.Spec.Versions {
+		if v.Name != version {
+			continue
+		}
+		if v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+			return nil, fmt.Errorf("version %q has no schema", version)
+		}
+		return json.Marshal(v.Schema.OpenAPIV3Schema)
+	}
+	return nil, fmt.Errorf("unknown version: %q", version)
+}
+
+func stripExtensions(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if strings.HasPrefix(k, "x-kubernetes-") {
+				delete(v, k)
+				continue
+			}
+			stripExtensions(val)
+		}
+	case []interface{}:
+		for _, val := range v {
+			stripExtensions(val)
+		}
+	}
+}
+
+// OpenAPIHandler returns a handler that serves the OpenAPI v3 schema
+// of the version supported by this build.
+func OpenAPIHandler() http.Handler {
+	return jsonHandler(OpenAPI)
+}
+
+// JSONSchemaHandler returns a handler that serves the JSON Schema artifact
+// of the version supported by this build.
+func JSONSchemaHandler() http.Handler {
+	return jsonHandler(JSONSchema)
+}
+
+func jsonHandler(fn func(version string) ([]byte, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := fn(v1alpha1.GroupVersion.Version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf)
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONSchemaArtifact(t *testing.T) {
+	want, err := os.ReadFile("../../docs/configmapsecret.schema.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := JSONSchema("v1alpha1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(string(want), string(got)+"\n"); diff != "" {
+		t.Errorf("JSON Schema artifact is stale, run: mage generate (-want +got):\n%s", diff)
+	}
+	if bytes.Contains(got, []byte("x-kubernetes-")) {
+		t.Errorf("JSON Schema contains Kubernetes extensions")
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	if _, err := OpenAPI("v1"); err == nil {
+		t.Errorf("expected error for unknown version")
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	for _, tt := range []struct {
+		handler http.Handler
+		want    string // A property of the served schema.
+	}{
+		{handler: OpenAPIHandler(), want: "x-kubernetes-validations"},
+		{handler: JSONSchemaHandler(), want: "$schema"},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type: %q", ct)
+		}
+		body := rec.Body.String()
+		if !json.Valid([]byte(body)) {
+			t.Errorf("invalid JSON: %s", body)
+		}
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("expected %q in schema", tt.want)
+		}
+	}
+}
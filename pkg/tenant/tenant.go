@@ -0,0 +1,65 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tenant restricts the sources of ConfigMapSecrets to the Secrets and
+// ConfigMaps of their tenant, identified by the value of a label, so that a
+// template in a namespace shared by teams can't read another team's Secrets.
+package tenant
+
+import (
+	"fmt"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Matches reports whether the source belongs to the tenant of the
+// ConfigMapSecret, i.e. whether their values of the label are equal. Objects
+// without the label belong to the tenant without one.
+func Matches(label string, cms *v1alpha1.ConfigMapSecret, src metav1.Object) bool {
+	return src.GetLabels()[label] == cms.Labels[label]
+}
+
+// A Ref is a reference of a ConfigMapSecret to a source by name.
+type Ref struct {
+	Kind string // Secret or ConfigMap.
+	Name string
+	Path *field.Path
+}
+
+// Refs returns the references of the ConfigMapSecret to Secrets and
+// ConfigMaps by name. ConfigMaps selected by label aren't referenced by name.
+func Refs(cms *v1alpha1.ConfigMapSecret) []Ref {
+	var refs []Ref
+	spec := field.NewPath("spec")
+	for i, v := range cms.Spec.VarsFrom {
+		path := spec.Child("varsFrom").Index(i)
+		switch {
+		case v.SecretRef != nil:
+			refs = append(refs, Ref{"Secret", v.SecretRef.Name, path.Child("secretRef")})
+		case v.ConfigMapRef != nil:
+			refs = append(refs, Ref{"ConfigMap", v.ConfigMapRef.Name, path.Child("configMapRef")})
+		}
+	}
+	for i, v := range cms.Spec.Vars {
+		path := spec.Child("vars").Index(i)
+		switch {
+		case v.SecretValue != nil:
+			refs = append(refs, Ref{"Secret", v.SecretValue.Name, path.Child("secretValue")})
+		case v.ConfigMapValue != nil:
+			refs = append(refs, Ref{"ConfigMap", v.ConfigMapValue.Name, path.Child("configMapValue")})
+		}
+	}
+	if gs := cms.Spec.GitSource; gs != nil && gs.SecretRef != nil {
+		refs = append(refs, Ref{"Secret", gs.SecretRef.Name, spec.Child("gitSource", "secretRef")})
+	}
+	return refs
+}
+
+// Message returns the message of a reference to a source of another tenant.
+func Message(label string, cms *v1alpha1.ConfigMapSecret, ref Ref) string {
+	return fmt.Sprintf("%s %s/%s doesn't have the tenant label %s=%q of the ConfigMapSecret",
+		ref.Kind, cms.Namespace, ref.Name, label, cms.Labels[label])
+}
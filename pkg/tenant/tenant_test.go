@@ -0,0 +1,66 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tenant
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/build"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMatches(t *testing.T) {
+	const label = "example.com/tenant"
+	cms := build.NewConfigMapSecret("app").WithLabel(label, "a").ConfigMapSecret()
+	for _, tt := range []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{label: "a"}, true},
+		{map[string]string{label: "b"}, false},
+		{map[string]string{label: ""}, false},
+		{nil, false},
+	} {
+		src := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Labels: tt.labels}}
+		if got := Matches(label, cms, src); got != tt.want {
+			t.Errorf("%v: want: %t; got: %t", tt.labels, tt.want, got)
+		}
+	}
+
+	// Sources without the label belong to ConfigMapSecrets without it.
+	cms = build.NewConfigMapSecret("app").ConfigMapSecret()
+	if !Matches(label, cms, &corev1.Secret{}) {
+		t.Errorf("sources without the label don't match")
+	}
+}
+
+func TestRefs(t *testing.T) {
+	cms := build.NewConfigMapSecret("app").
+		WithSecretVarsFrom("secret-vars", "").
+		WithConfigMapVarsFrom("config-vars", "").
+		WithVar("LITERAL", "value").
+		WithSecretVar("PASSWORD", "secret", "password").
+		WithConfigMapVar("HOST", "config", "host").
+		ConfigMapSecret()
+	cms.Spec.GitSource = &v1alpha1.GitSource{SecretRef: &corev1.LocalObjectReference{Name: "git"}}
+
+	var got []string
+	for _, ref := range Refs(cms) {
+		got = append(got, ref.Kind+" "+ref.Name+" "+ref.Path.String())
+	}
+	want := []string{
+		"Secret secret-vars spec.varsFrom[0].secretRef",
+		"ConfigMap config-vars spec.varsFrom[1].configMapRef",
+		"Secret secret spec.vars[1].secretValue",
+		"ConfigMap config spec.vars[2].configMapValue",
+		"Secret git spec.gitSource.secretRef",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected refs (-want +got):\n%s", diff)
+	}
+}
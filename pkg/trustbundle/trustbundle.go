@@ -0,0 +1,151 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package trustbundle selects ClusterTrustBundles (certificates.k8s.io/v1alpha1)
+// and merges their PEM certificates, as the kubelet does for the
+// clusterTrustBundle projected volume source.
+//
+// ClusterTrustBundles are read as unstructured objects, since their API types
+// postdate the client libraries of the controller.
+package trustbundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GVK is the group, version, and kind of ClusterTrustBundles.
+var GVK = schema.GroupVersionKind{Group: "certificates.k8s.io", Version: "v1alpha1", Kind: "ClusterTrustBundle"}
+
+// ErrUnavailable is returned when the ClusterTrustBundle API isn't available.
+var ErrUnavailable = errors.New("the ClusterTrustBundle API (certificates.k8s.io/v1alpha1) isn't available")
+
+// Available returns whether the ClusterTrustBundle API is served, according
+// to the mapper.
+func Available(mapper meta.RESTMapper) (bool, error) {
+	_, err := mapper.RESTMapping(GVK.GroupKind(), GVK.Version)
+	switch {
+	case err == nil:
+		return true, nil
+	case meta.IsNoMatchError(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// New returns an empty ClusterTrustBundle, e.g. to watch them.
+func New() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GVK)
+	return obj
+}
+
+// SignerName returns the signer of the ClusterTrustBundle, if any.
+func SignerName(obj *unstructured.Unstructured) string {
+	s, _, _ := unstructured.NestedString(obj.Object, "spec", "signerName")
+	return s
+}
+
+// Matches returns whether the selector selects the ClusterTrustBundle.
+func Matches(sel v1alpha1.ClusterTrustBundleSelector, obj client.Object) bool {
+	if sel.Name != "" {
+		return obj.GetName() == sel.Name
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || SignerName(u) != sel.SignerName || sel.LabelSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sel.LabelSelector)
+	return err == nil && selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// Source returns the source of a variable from the ClusterTrustBundles
+// selected by sel, e.g. "ClusterTrustBundle/ca".
+func Source(sel v1alpha1.ClusterTrustBundleSelector) string {
+	if sel.Name != "" {
+		return "ClusterTrustBundle/" + sel.Name
+	}
+	return "ClusterTrustBundle[signerName=" + sel.SignerName + "]"
+}
+
+// Select returns the ClusterTrustBundles selected by sel, sorted by name. It
+// returns an apierrors.IsNotFound error if a bundle selected by name doesn't
+// exist, and no bundles and no error if none of a signer match.
+func Select(ctx context.Context, c client.Reader, sel v1alpha1.ClusterTrustBundleSelector) ([]*unstructured.Unstructured, error) {
+	if sel.Name != "" {
+		obj := New()
+		if err := c.Get(ctx, types.NamespacedName{Name: sel.Name}, obj); err != nil {
+			return nil, err
+		}
+		return []*unstructured.Unstructured{obj}, nil
+	}
+	if sel.SignerName == "" {
+		return nil, errors.New("ClusterTrustBundle selector must have a name or signerName")
+	}
+	if sel.LabelSelector == nil {
+		return nil, nil // As in projected volumes, a nil selector matches nothing.
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sel.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClusterTrustBundle selector: %w", err)
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(GVK.GroupVersion().WithKind(GVK.Kind + "List"))
+	if err := c.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var objs []*unstructured.Unstructured
+	for i := range list.Items {
+		if obj := &list.Items[i]; SignerName(obj) == sel.SignerName {
+			objs = append(objs, obj)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].GetName() < objs[j].GetName() })
+	return objs, nil
+}
+
+// PEM returns the certificates of the ClusterTrustBundles, in order and
+// without duplicates, as PEM blocks.
+func PEM(objs []*unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	seen := make(map[string]bool)
+	for _, obj := range objs {
+		bundle, _, err := unstructured.NestedString(obj.Object, "spec", "trustBundle")
+		if err != nil {
+			return nil, fmt.Errorf("ClusterTrustBundle %s: %w", obj.GetName(), err)
+		}
+		rest := []byte(bundle)
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("ClusterTrustBundle %s: unexpected PEM block of type %q", obj.GetName(), block.Type)
+			}
+			if seen[string(block.Bytes)] {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			if err := pem.Encode(&buf, &pem.Block{Type: block.Type, Bytes: block.Bytes}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trustbundle
+
+import (
+	"context"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func bundle(name, signer string, lbls map[string]string, certs ...string) *unstructured.Unstructured {
+	obj := New()
+	obj.SetName(name)
+	obj.SetLabels(lbls)
+	var b strings.Builder
+	for _, c := range certs {
+		b.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(c)}))
+	}
+	spec := map[string]interface{}{"trustBundle": b.String()}
+	if signer != "" {
+		spec["signerName"] = signer
+	}
+	obj.Object["spec"] = spec
+	return obj
+}
+
+// A bundleReader reads ClusterTrustBundles from memory.
+type bundleReader struct {
+	client.Reader
+	objs []*unstructured.Unstructured
+}
+
+func (r *bundleReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	for _, o := range r.objs {
+		if o.GetName() == key.Name {
+			o.DeepCopyInto(obj.(*unstructured.Unstructured))
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{Group: GVK.Group, Resource: "clustertrustbundles"}, key.Name)
+}
+
+func (r *bundleReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	items := list.(*unstructured.UnstructuredList)
+	for i := len(r.objs) - 1; i >= 0; i-- { // Out of order.
+		if o := r.objs[i]; listOpts.LabelSelector.Matches(labels.Set(o.GetLabels())) {
+			items.Items = append(items.Items, *o.DeepCopy())
+		}
+	}
+	return nil
+}
+
+func names(objs []*unstructured.Unstructured) string {
+	var s []string
+	for _, o := range objs {
+		s = append(s, o.GetName())
+	}
+	return strings.Join(s, ",")
+}
+
+func TestSelect(t *testing.T) {
+	r := &bundleReader{objs: []*unstructured.Unstructured{
+		bundle("a", "example.com/ca", map[string]string{"env": "prod"}, "1"),
+		bundle("b", "example.com/ca", map[string]string{"env": "dev"}, "2"),
+		bundle("c", "example.com/ca", map[string]string{"env": "prod"}, "3"),
+		bundle("d", "example.com/other", map[string]string{"env": "prod"}, "4"),
+		bundle("e", "", nil, "5"),
+	}}
+	ctx := context.Background()
+	for _, tt := range []struct {
+		sel  v1alpha1.ClusterTrustBundleSelector
+		want string
+	}{
+		{v1alpha1.ClusterTrustBundleSelector{Name: "e"}, "e"},
+		{v1alpha1.ClusterTrustBundleSelector{SignerName: "example.com/ca"}, ""},
+		{v1alpha1.ClusterTrustBundleSelector{SignerName: "example.com/ca", LabelSelector: &metav1.LabelSelector{}}, "a,b,c"},
+		{v1alpha1.ClusterTrustBundleSelector{
+			SignerName:    "example.com/ca",
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		}, "a,c"},
+	} {
+		objs, err := Select(ctx, r, tt.sel)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", Source(tt.sel), err)
+		}
+		if got := names(objs); got != tt.want {
+			t.Errorf("%s: want: %q; got: %q", Source(tt.sel), tt.want, got)
+		}
+		for _, obj := range objs {
+			if !Matches(tt.sel, obj) {
+				t.Errorf("%s: selected bundle %s doesn't match", Source(tt.sel), obj.GetName())
+			}
+		}
+	}
+	if _, err := Select(ctx, r, v1alpha1.ClusterTrustBundleSelector{Name: "missing"}); !apierrors.IsNotFound(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := Select(ctx, r, v1alpha1.ClusterTrustBundleSelector{}); err == nil {
+		t.Error("expected error for empty selector")
+	}
+	if Matches(v1alpha1.ClusterTrustBundleSelector{SignerName: "example.com/ca"}, r.objs[0]) {
+		t.Error("selector without a label selector matches")
+	}
+}
+
+func TestPEM(t *testing.T) {
+	buf, err := PEM([]*unstructured.Unstructured{
+		bundle("a", "", nil, "1", "2"),
+		bundle("b", "", nil, "2", "3"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for rest := buf; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		got = append(got, string(block.Bytes))
+	}
+	if want := "1,2,3"; strings.Join(got, ",") != want {
+		t.Errorf("unexpected certificates; want: %s; got: %v", want, got)
+	}
+
+	key := bundle("key", "", nil)
+	key.Object["spec"].(map[string]interface{})["trustBundle"] = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("k")}))
+	if _, err := PEM([]*unstructured.Unstructured{key}); err == nil {
+		t.Error("expected error for non-certificate block")
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	if ok, err := Available(mapper); ok || err != nil {
+		t.Errorf("unexpected availability: %t, %v", ok, err)
+	}
+	mapper.Add(GVK, meta.RESTScopeRoot)
+	if ok, err := Available(mapper); !ok || err != nil {
+		t.Errorf("unexpected availability: %t, %v", ok, err)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package validation provides an admission webhook that limits the size of
+// ConfigMapSecrets, to protect etcd and the controller from huge objects, and
+// rejects templates that cannot be parsed and, optionally, references to the
+// sources of other tenants.
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	"github.com/machinezone/configmapsecrets/pkg/render"
+	"github.com/machinezone/configmapsecrets/pkg/tenant"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Limits are the maximum sizes of a ConfigMapSecret. A zero limit is disabled.
+type Limits struct {
+	// MaxVars is the maximum number of Vars.
+	MaxVars int
+	// MaxVarValueSize is the maximum size of the Value of a Var, in bytes.
+	MaxVarValueSize int
+	// MaxSpecSize is the maximum size of the JSON-encoded spec, in bytes.
+	MaxSpecSize int
+}
+
+// DefaultLimits are the default limits.
+var DefaultLimits = Limits{
+	MaxVars:         v1alpha1.MaxVars,
+	MaxVarValueSize: v1alpha1.MaxVarValueLength,
+	MaxSpecSize:     1 << 20,
+}
+
+// Validate returns the errors of the ConfigMapSecret that exceed the limits.
+func (l Limits) Validate(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	if n := len(cms.Spec.Vars); l.MaxVars > 0 && n > l.MaxVars {
+		errs = append(errs, field.TooMany(spec.Child("vars"), n, l.MaxVars))
+	}
+	if l.MaxVarValueSize > 0 {
+		for i, v := range cms.Spec.Vars {
+			if len(v.Value) > l.MaxVarValueSize {
+				errs = append(errs, field.TooLong(spec.Child("vars").Index(i).Child("value"), "", l.MaxVarValueSize))
+			}
+		}
+	}
+	if l.MaxSpecSize > 0 {
+		buf, err := json.Marshal(&cms.Spec)
+		if err != nil {
+			errs = append(errs, field.InternalError(spec, err))
+		} else if len(buf) > l.MaxSpecSize {
+			errs = append(errs, field.TooLong(spec, "", l.MaxSpecSize))
+		}
+	}
+	return errs
+}
+
+// ValidateTemplate returns the errors of the ConfigMapSecret's template version
+// and of the template data that cannot be parsed in that version. If expansion
+// is disabled, it returns the errors of variables, which aren't allowed.
+func ValidateTemplate(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	spec := field.NewPath("spec")
+	if cms.Spec.DisableExpansion {
+		var errs field.ErrorList
+		if len(cms.Spec.VarsFrom) > 0 {
+			errs = append(errs, field.Forbidden(spec.Child("varsFrom"), "may not be set when disableExpansion is true"))
+		}
+		if len(cms.Spec.Vars) > 0 {
+			errs = append(errs, field.Forbidden(spec.Child("vars"), "may not be set when disableExpansion is true"))
+		}
+		return errs
+	}
+	if _, err := render.ForVersion(cms.Spec.TemplateVersion, render.Limits{}); err != nil {
+		supported := []string{string(v1alpha1.TemplateVersionV1), string(v1alpha1.TemplateVersionV2)}
+		return field.ErrorList{field.NotSupported(spec.Child("templateVersion"), cms.Spec.TemplateVersion, supported)}
+	}
+	tmpl := spec.Child("template")
+	engine, err := render.ForSpec(&cms.Spec, render.Limits{})
+	if err != nil {
+		return field.ErrorList{field.Invalid(tmpl.Child("delimiters"), cms.Spec.Template.Delimiters, err.Error())}
+	}
+	var errs field.ErrorList
+	parse := func(path *field.Path, data map[string]string) {
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := render.ForKey(engine, &cms.Spec.Template, k).Parse(k, data[k]); err != nil {
+				errs = append(errs, field.Invalid(path.Key(k), "", err.Error()))
+			}
+		}
+	}
+	binaryData := make(map[string]string, len(cms.Spec.Template.BinaryData))
+	for k, v := range cms.Spec.Template.BinaryData {
+		binaryData[k] = string(v)
+	}
+	parse(tmpl.Child("data"), cms.Spec.Template.Data)
+	parse(tmpl.Child("binaryData"), binaryData)
+	parse(tmpl.Child("stringData"), cms.Spec.Template.StringData)
+	return errs
+}
+
+// ValidateMetadata returns the errors of the ConfigMapSecret's template metadata.
+func ValidateMetadata(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	var errs field.ErrorList
+	path := field.NewPath("spec", "template", "metadata", "inheritLabels")
+	for i, key := range cms.Spec.Template.Metadata.InheritLabels {
+		if key == "*" {
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path.Index(i), key, msg))
+		}
+	}
+	return errs
+}
+
+// ValidateRefreshInterval returns the errors of the ConfigMapSecret's refresh interval.
+func ValidateRefreshInterval(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	d := cms.Spec.RefreshInterval
+	if d == nil || d.Duration >= v1alpha1.MinRefreshInterval {
+		return nil
+	}
+	path := field.NewPath("spec", "refreshInterval")
+	msg := fmt.Sprintf("must be at least %v", v1alpha1.MinRefreshInterval)
+	return field.ErrorList{field.Invalid(path, d.Duration.String(), msg)}
+}
+
+// ValidateGitSource returns the errors of the ConfigMapSecret's GitSource.
+func ValidateGitSource(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	gs := cms.Spec.GitSource
+	if gs == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	path := field.NewPath("spec", "gitSource")
+	if gs.Repository == "" {
+		errs = append(errs, field.Required(path.Child("repository"), ""))
+	}
+	if d := gs.Interval; d != nil && d.Duration < v1alpha1.MinRefreshInterval {
+		msg := fmt.Sprintf("must be at least %v", v1alpha1.MinRefreshInterval)
+		errs = append(errs, field.Invalid(path.Child("interval"), d.Duration.String(), msg))
+	}
+	return errs
+}
+
+// ValidateKeyOptions returns the errors of the ConfigMapSecret's key options
+// whose compressed values would be written to keys already in its template.
+func ValidateKeyOptions(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	tmpl := &cms.Spec.Template
+	keys := make([]string, 0, len(tmpl.KeyOptions))
+	for k := range tmpl.KeyOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs field.ErrorList
+	path := field.NewPath("spec", "template", "keyOptions")
+	for _, k := range keys {
+		c := tmpl.KeyOptions[k].Compress
+		if out := c.Key(k); out != k && tmpl.HasKey(k) && tmpl.HasKey(out) {
+			msg := fmt.Sprintf("compressed key %s is already a key", out)
+			errs = append(errs, field.Invalid(path.Key(k).Child("compress"), c, msg))
+		}
+	}
+	return errs
+}
+
+// ValidateTransforms returns the errors of the transforms of the
+// ConfigMapSecret's vars and key options.
+func ValidateTransforms(cms *v1alpha1.ConfigMapSecret) field.ErrorList {
+	var errs field.ErrorList
+	validate := func(path *field.Path, transforms []v1alpha1.Transform) {
+		for i, t := range transforms {
+			if t.Spaces != 0 && t.Func != v1alpha1.TransformIndent {
+				errs = append(errs, field.Forbidden(path.Index(i).Child("spaces"), "may only be set for indent"))
+			}
+		}
+	}
+	for i, v := range cms.Spec.Vars {
+		validate(field.NewPath("spec", "vars").Index(i).Child("transforms"), v.Transforms)
+	}
+	tmpl := &cms.Spec.Template
+	keys := make([]string, 0, len(tmpl.KeyOptions))
+	for k := range tmpl.KeyOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	path := field.NewPath("spec", "template", "keyOptions")
+	for _, k := range keys {
+		validate(path.Key(k).Child("transforms"), tmpl.KeyOptions[k].Transforms)
+	}
+	return errs
+}
+
+// ValidateTenant returns the errors of the ConfigMapSecret's references to
+// Secrets and ConfigMaps that don't have its value of the tenant label, and,
+// if old isn't nil, of a change of that value. Missing sources aren't
+// checked; the controller checks them when they're created.
+func ValidateTenant(ctx context.Context, reader client.Reader, label string, cms, old *v1alpha1.ConfigMapSecret) field.ErrorList {
+	var errs field.ErrorList
+	if old != nil && old.Labels[label] != cms.Labels[label] {
+		path := field.NewPath("metadata", "labels").Key(label)
+		errs = append(errs, field.Forbidden(path, "the tenant label may not be changed"))
+	}
+	for _, ref := range tenant.Refs(cms) {
+		var src client.Object = &corev1.Secret{}
+		if ref.Kind == "ConfigMap" {
+			src = &corev1.ConfigMap{}
+		}
+		key := types.NamespacedName{Namespace: cms.Namespace, Name: ref.Name}
+		if err := reader.Get(ctx, key, src); err != nil {
+			if !apierrors.IsNotFound(err) {
+				errs = append(errs, field.InternalError(ref.Path, err))
+			}
+			continue
+		}
+		if !tenant.Matches(label, cms, src) {
+			errs = append(errs, field.Forbidden(ref.Path, tenant.Message(label, cms, ref)))
+		}
+	}
+	return errs
+}
+
+// +kubebuilder:webhook:path=/validate-secrets-mz-com-v1alpha1-configmapsecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=secrets.mz.com,resources=configmapsecrets,verbs=create;update,versions=v1alpha1,name=vconfigmapsecret.secrets.mz.com,admissionReviewVersions=v1
+
+// A Validator is an admission webhook that validates ConfigMapSecrets.
+type Validator struct {
+	Limits Limits
+
+	// TenantLabel is the label whose value must be equal on ConfigMapSecrets
+	// and the Secrets and ConfigMaps they reference by name, read with the
+	// Reader. If it's empty, references aren't checked.
+	TenantLabel string
+	Reader      client.Reader
+}
+
+// SetupWebhookWithManager registers the webhook with the manager.
+func (v *Validator) SetupWebhookWithManager(manager manager.Manager) error {
+	return builder.WebhookManagedBy(manager).
+		For(&v1alpha1.ConfigMapSecret{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate validates a ConfigMapSecret that's being created.
+func (v *Validator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(ctx, obj, nil)
+}
+
+// ValidateUpdate validates a ConfigMapSecret that's being updated.
+func (v *Validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	old, ok := oldObj.(*v1alpha1.ConfigMapSecret)
+	if !ok {
+		return fmt.Errorf("unexpected object type: %T", oldObj)
+	}
+	return v.validate(ctx, newObj, old)
+}
+
+// ValidateDelete allows every ConfigMapSecret to be deleted.
+func (v *Validator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *Validator) validate(ctx context.Context, obj runtime.Object, old *v1alpha1.ConfigMapSecret) error {
+	cms, ok := obj.(*v1alpha1.ConfigMapSecret)
+	if !ok {
+		return fmt.Errorf("unexpected object type: %T", obj)
+	}
+	errs := v.Limits.Validate(cms)
+	errs = append(errs, ValidateTemplate(cms)...)
+	errs = append(errs, ValidateMetadata(cms)...)
+	errs = append(errs, ValidateRefreshInterval(cms)...)
+	errs = append(errs, ValidateGitSource(cms)...)
+	errs = append(errs, ValidateKeyOptions(cms)...)
+	errs = append(errs, ValidateTransforms(cms)...)
+	if v.TenantLabel != "" && v.Reader != nil {
+		errs = append(errs, ValidateTenant(ctx, v.Reader, v.TenantLabel, cms, old)...)
+	}
+	if len(errs) > 0 {
+		gk := v1alpha1.GroupVersion.WithKind("ConfigMapSecret").GroupKind()
+		return apierrors.NewInvalid(gk, cms.Name, errs)
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package validation
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/machinezone/configmapsecrets/pkg/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxVars: 2, MaxVarValueSize: 4, MaxSpecSize: 128}
+	tests := []struct {
+		name string
+		spec v1alpha1.ConfigMapSecretSpec
+		want []string
+	}{
+		{
+			name: "valid",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Vars: []v1alpha1.Var{{Name: "A", Value: "abcd"}, {Name: "B"}},
+			},
+		},
+		{
+			name: "too many vars",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Vars: []v1alpha1.Var{{Name: "A"}, {Name: "B"}, {Name: "C"}},
+			},
+			want: []string{"spec.vars"},
+		},
+		{
+			name: "value too long",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Vars: []v1alpha1.Var{{Name: "A"}, {Name: "B", Value: "abcde"}},
+			},
+			want: []string{"spec.vars[1].value"},
+		},
+		{
+			name: "spec too large",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{
+					Data: map[string]string{"config.yaml": strings.Repeat("x", 128)},
+				},
+			},
+			want: []string{"spec"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range limits.Validate(&v1alpha1.ConfigMapSecret{Spec: tt.spec}) {
+				got = append(got, err.Field)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected errors (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if errs := (Limits{}).Validate(&v1alpha1.ConfigMapSecret{Spec: tests[1].spec}); len(errs) > 0 {
+		t.Errorf("unexpected errors with disabled limits: %v", errs)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec v1alpha1.ConfigMapSecretSpec
+		want []string
+	}{
+		{
+			name: "v1",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{
+					Data: map[string]string{"a": "$(A) {{ .A"},
+				},
+			},
+		},
+		{
+			name: "v2",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				TemplateVersion: v1alpha1.TemplateVersionV2,
+				Template: v1alpha1.ConfigMapTemplate{
+					Data:       map[string]string{"a": "{{ .A }}", "b": "{{ .B"},
+					BinaryData: map[string][]byte{"c": []byte("{{ end }}")},
+					StringData: map[string]string{"d": "{{ .D | nope }}"},
+				},
+			},
+			want: []string{"spec.template.data[b]", "spec.template.binaryData[c]", "spec.template.stringData[d]"},
+		},
+		{
+			name: "v2 verbatim",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				TemplateVersion: v1alpha1.TemplateVersionV2,
+				Template: v1alpha1.ConfigMapTemplate{
+					Data:       map[string]string{"a": "{{ .A", "b": "{{ .B"},
+					KeyOptions: map[string]v1alpha1.KeyOptions{"a": {Verbatim: true}},
+				},
+			},
+			want: []string{"spec.template.data[b]"},
+		},
+		{
+			name: "unsupported",
+			spec: v1alpha1.ConfigMapSecretSpec{TemplateVersion: "v0"},
+			want: []string{"spec.templateVersion"},
+		},
+		{
+			name: "delimiters",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				Template: v1alpha1.ConfigMapTemplate{
+					Data:       map[string]string{"a": "%{A}% $(B"},
+					Delimiters: &v1alpha1.Delimiters{Left: "%{", Right: "}%"},
+				},
+			},
+		},
+		{
+			name: "v2 delimiters",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				TemplateVersion: v1alpha1.TemplateVersionV2,
+				Template: v1alpha1.ConfigMapTemplate{
+					Delimiters: &v1alpha1.Delimiters{Left: "%{", Right: "}%"},
+				},
+			},
+			want: []string{"spec.template.delimiters"},
+		},
+		{
+			name: "disable expansion",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				TemplateVersion:  "v0",
+				DisableExpansion: true,
+				Template: v1alpha1.ConfigMapTemplate{
+					Data: map[string]string{"a": "{{ .A"},
+				},
+			},
+		},
+		{
+			name: "disable expansion with vars",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				DisableExpansion: true,
+				VarsFrom:         []v1alpha1.VarsFromSource{{ConfigMapRef: &v1alpha1.ConfigMapVarsSource{}}},
+				Vars:             []v1alpha1.Var{{Name: "A"}},
+			},
+			want: []string{"spec.varsFrom", "spec.vars"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range ValidateTemplate(&v1alpha1.ConfigMapSecret{Spec: tt.spec}) {
+				got = append(got, err.Field)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected errors (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	cms := &v1alpha1.ConfigMapSecret{
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				Metadata: v1alpha1.EmbeddedObjectMeta{
+					InheritLabels: []string{"*", "app", "example.com/team", "not a key"},
+				},
+			},
+		},
+	}
+	var got []string
+	for _, err := range ValidateMetadata(cms) {
+		got = append(got, err.Field)
+	}
+	if diff := cmp.Diff([]string{"spec.template.metadata.inheritLabels[3]"}, got); diff != "" {
+		t.Errorf("unexpected errors (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateRefreshInterval(t *testing.T) {
+	for _, tt := range []struct {
+		interval *metav1.Duration
+		valid    bool
+	}{
+		{nil, true},
+		{&metav1.Duration{Duration: time.Hour}, true},
+		{&metav1.Duration{Duration: v1alpha1.MinRefreshInterval}, true},
+		{&metav1.Duration{Duration: time.Second}, false},
+		{&metav1.Duration{Duration: -time.Hour}, false},
+	} {
+		cms := &v1alpha1.ConfigMapSecret{
+			Spec: v1alpha1.ConfigMapSecretSpec{RefreshInterval: tt.interval},
+		}
+		if errs := ValidateRefreshInterval(cms); (len(errs) == 0) != tt.valid {
+			t.Errorf("%v: unexpected errors: %v", tt.interval, errs)
+		}
+	}
+}
+
+func TestValidateGitSource(t *testing.T) {
+	repo := "https://example.com/config.git"
+	for _, tt := range []struct {
+		src   *v1alpha1.GitSource
+		valid bool
+	}{
+		{nil, true},
+		{&v1alpha1.GitSource{Repository: repo}, true},
+		{&v1alpha1.GitSource{Repository: repo, Interval: &metav1.Duration{Duration: time.Hour}}, true},
+		{&v1alpha1.GitSource{Repository: repo, Interval: &metav1.Duration{Duration: time.Second}}, false},
+		{&v1alpha1.GitSource{}, false},
+	} {
+		cms := &v1alpha1.ConfigMapSecret{
+			Spec: v1alpha1.ConfigMapSecretSpec{GitSource: tt.src},
+		}
+		if errs := ValidateGitSource(cms); (len(errs) == 0) != tt.valid {
+			t.Errorf("%+v: unexpected errors: %v", tt.src, errs)
+		}
+	}
+}
+
+func TestValidateKeyOptions(t *testing.T) {
+	gzip := v1alpha1.KeyOptions{Compress: v1alpha1.CompressionGzip}
+	for _, tt := range []struct {
+		desc  string
+		tmpl  v1alpha1.ConfigMapTemplate
+		valid bool
+	}{
+		{
+			desc:  "compressed",
+			tmpl:  v1alpha1.ConfigMapTemplate{Data: map[string]string{"a": ""}, KeyOptions: map[string]v1alpha1.KeyOptions{"a": gzip}},
+			valid: true,
+		},
+		{
+			desc:  "compressed key missing",
+			tmpl:  v1alpha1.ConfigMapTemplate{Data: map[string]string{"a.gz": ""}, KeyOptions: map[string]v1alpha1.KeyOptions{"a": gzip}},
+			valid: true,
+		},
+		{
+			desc: "compressed key in data",
+			tmpl: v1alpha1.ConfigMapTemplate{Data: map[string]string{"a": "", "a.gz": ""}, KeyOptions: map[string]v1alpha1.KeyOptions{"a": gzip}},
+		},
+		{
+			desc: "compressed key in string data",
+			tmpl: v1alpha1.ConfigMapTemplate{Data: map[string]string{"a": ""}, StringData: map[string]string{"a.gz": ""}, KeyOptions: map[string]v1alpha1.KeyOptions{"a": gzip}},
+		},
+	} {
+		cms := &v1alpha1.ConfigMapSecret{
+			Spec: v1alpha1.ConfigMapSecretSpec{Template: tt.tmpl},
+		}
+		if errs := ValidateKeyOptions(cms); (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: unexpected errors: %v", tt.desc, errs)
+		}
+	}
+}
+
+func TestValidateTransforms(t *testing.T) {
+	indent := v1alpha1.Transform{Func: v1alpha1.TransformIndent, Spaces: 4}
+	trim := v1alpha1.Transform{Func: v1alpha1.TransformTrim}
+	cms := &v1alpha1.ConfigMapSecret{
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Template: v1alpha1.ConfigMapTemplate{
+				KeyOptions: map[string]v1alpha1.KeyOptions{
+					"a": {Transforms: []v1alpha1.Transform{trim, indent}},
+					"b": {Transforms: []v1alpha1.Transform{{Func: v1alpha1.TransformB64Enc, Spaces: 2}}},
+				},
+			},
+			Vars: []v1alpha1.Var{
+				{Name: "A", Transforms: []v1alpha1.Transform{indent, trim}},
+				{Name: "B", Transforms: []v1alpha1.Transform{trim, {Func: v1alpha1.TransformTrim, Spaces: 2}}},
+			},
+		},
+	}
+	var got []string
+	for _, err := range ValidateTransforms(cms) {
+		got = append(got, err.Field)
+	}
+	want := []string{"spec.vars[1].transforms[1].spaces", "spec.template.keyOptions[b].transforms[0].spaces"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected errors (-want +got):\n%s", diff)
+	}
+}
+
+type fakeReader struct {
+	client.Reader
+	secrets    map[string]*corev1.Secret
+	configMaps map[string]*corev1.ConfigMap
+}
+
+func (r *fakeReader) Get(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+	switch obj := obj.(type) {
+	case *corev1.Secret:
+		if s, ok := r.secrets[key.Name]; ok {
+			s.DeepCopyInto(obj)
+			return nil
+		}
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	case *corev1.ConfigMap:
+		if c, ok := r.configMaps[key.Name]; ok {
+			c.DeepCopyInto(obj)
+			return nil
+		}
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+	}
+	return apierrors.NewBadRequest("unexpected type")
+}
+
+func TestValidateTenant(t *testing.T) {
+	const label = "example.com/tenant"
+	meta := func(name, tenant string) metav1.ObjectMeta {
+		m := metav1.ObjectMeta{Name: name, Namespace: "ns"}
+		if tenant != "" {
+			m.Labels = map[string]string{label: tenant}
+		}
+		return m
+	}
+	reader := &fakeReader{
+		secrets: map[string]*corev1.Secret{
+			"a-secret":  {ObjectMeta: meta("a-secret", "a")},
+			"b-secret":  {ObjectMeta: meta("b-secret", "b")},
+			"no-tenant": {ObjectMeta: meta("no-tenant", "")},
+		},
+		configMaps: map[string]*corev1.ConfigMap{
+			"a-config": {ObjectMeta: meta("a-config", "a")},
+			"b-config": {ObjectMeta: meta("b-config", "b")},
+		},
+	}
+	secretRef := func(name string) *v1alpha1.SecretVarsSource {
+		return &v1alpha1.SecretVarsSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}}
+	}
+	for _, tt := range []struct {
+		desc   string
+		tenant string
+		old    string
+		spec   v1alpha1.ConfigMapSecretSpec
+		want   []string
+	}{
+		{
+			desc:   "same tenant",
+			tenant: "a",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{{SecretRef: secretRef("a-secret")}},
+				Vars: []v1alpha1.Var{{Name: "A", ConfigMapValue: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "a-config"}, Key: "a",
+				}}},
+			},
+		},
+		{
+			desc:   "missing sources",
+			tenant: "a",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{{SecretRef: secretRef("missing")}},
+			},
+		},
+		{
+			desc: "no tenant",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{{SecretRef: secretRef("no-tenant")}, {SecretRef: secretRef("a-secret")}},
+			},
+			want: []string{"spec.varsFrom[1].secretRef"},
+		},
+		{
+			desc:   "other tenant",
+			tenant: "a",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{{ConfigMapRef: &v1alpha1.ConfigMapVarsSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "b-config"},
+				}}},
+				Vars: []v1alpha1.Var{{Name: "B", SecretValue: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "b-secret"}, Key: "b",
+				}}},
+				GitSource: &v1alpha1.GitSource{
+					Repository: "https://example.com/config.git",
+					SecretRef:  &corev1.LocalObjectReference{Name: "b-secret"},
+				},
+			},
+			want: []string{"spec.varsFrom[0].configMapRef", "spec.vars[0].secretValue", "spec.gitSource.secretRef"},
+		},
+		{
+			desc:   "changed tenant",
+			tenant: "b",
+			old:    "a",
+			spec: v1alpha1.ConfigMapSecretSpec{
+				VarsFrom: []v1alpha1.VarsFromSource{{SecretRef: secretRef("b-secret")}},
+			},
+			want: []string{"metadata.labels[example.com/tenant]"},
+		},
+	} {
+		cms := &v1alpha1.ConfigMapSecret{ObjectMeta: meta("cms", tt.tenant), Spec: tt.spec}
+		var old *v1alpha1.ConfigMapSecret
+		if tt.old != "" {
+			old = &v1alpha1.ConfigMapSecret{ObjectMeta: meta("cms", tt.old)}
+		}
+		var got []string
+		for _, err := range ValidateTenant(context.Background(), reader, label, cms, old) {
+			got = append(got, err.Field)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: unexpected errors (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	v := &Validator{Limits: Limits{MaxVars: 1}}
+	cms := &v1alpha1.ConfigMapSecret{
+		Spec: v1alpha1.ConfigMapSecretSpec{
+			Vars: []v1alpha1.Var{{Name: "A"}, {Name: "B"}},
+		},
+	}
+	if err := v.ValidateCreate(context.Background(), cms); !apierrors.IsInvalid(err) {
+		t.Errorf("expected invalid error; got: %v", err)
+	}
+	if err := v.ValidateUpdate(context.Background(), &v1alpha1.ConfigMapSecret{}, cms); !apierrors.IsInvalid(err) {
+		t.Errorf("expected invalid error; got: %v", err)
+	}
+	if err := v.ValidateDelete(context.Background(), cms); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+	buf, err := os.ReadFile("../../manifest/customresourcedefinition.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(buf, crd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vars := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].Properties["vars"]
+	value := vars.Items.Schema.Properties["value"]
+
+	// The rules of the CRD must match the default limits.
+	for _, tt := range []struct {
+		rules apiextensionsv1.ValidationRules
+		limit int
+	}{
+		{rules: vars.XValidations, limit: v1alpha1.MaxVars},
+		{rules: value.XValidations, limit: v1alpha1.MaxVarValueLength},
+	} {
+		want := "size(self) <= " + strconv.Itoa(tt.limit)
+		if len(tt.rules) != 1 || tt.rules[0].Rule != want {
+			t.Errorf("unexpected rules: want: %q; got: %v", want, tt.rules)
+		}
+	}
+}
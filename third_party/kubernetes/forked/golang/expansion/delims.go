@@ -0,0 +1,68 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package expansion
+
+import (
+	"strings"
+)
+
+// Delims are custom delimiters of variable references, e.g. "%{" and "}%"
+// for references of the form %{VAR_NAME}%, for input that contains the
+// $(VAR_NAME) syntax literally. The zero value is invalid.
+type Delims struct {
+	Left, Right string
+}
+
+// MappingFuncFor is like the package-level MappingFuncFor, but it returns the
+// input string wrapped in the delimiters of d if no mapping for the input is
+// found.
+func (d Delims) MappingFuncFor(context ...map[string]string) func(string) string {
+	return func(input string) string {
+		for _, vars := range context {
+			if val, ok := vars[input]; ok {
+				return val
+			}
+		}
+		return d.Left + input + d.Right
+	}
+}
+
+// Expand is like the package-level Expand, but with references delimited
+// by d. As $$ escapes the operator, the left delimiter preceded by its first
+// byte is escaped, e.g. %%{ expands to %{. Other bytes are copied unchanged.
+func (d Delims) Expand(input string, mapping func(string) string) string {
+	if d.Left == "" || d.Right == "" {
+		return input
+	}
+	escaped := d.Left[:1] + d.Left
+	var buf strings.Builder
+	for {
+		i := strings.IndexByte(input, d.Left[0])
+		if i < 0 {
+			buf.WriteString(input)
+			return buf.String()
+		}
+		buf.WriteString(input[:i])
+		input = input[i:]
+		switch {
+		case strings.HasPrefix(input, escaped):
+			buf.WriteString(d.Left)
+			input = input[len(escaped):]
+		case strings.HasPrefix(input, d.Left):
+			rest := input[len(d.Left):]
+			j := strings.Index(rest, d.Right)
+			if j < 0 {
+				// Incomplete reference; copy the rest.
+				buf.WriteString(input)
+				return buf.String()
+			}
+			buf.WriteString(mapping(rest[:j]))
+			input = rest[j+len(d.Right):]
+		default:
+			buf.WriteByte(input[0])
+			input = input[1:]
+		}
+	}
+}
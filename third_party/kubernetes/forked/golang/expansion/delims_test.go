@@ -0,0 +1,37 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package expansion
+
+import (
+	"testing"
+)
+
+func TestDelims(t *testing.T) {
+	d := Delims{Left: "%{", Right: "}%"}
+	mapping := d.MappingFuncFor(map[string]string{"FOO": "foo", "BAR": "bar"})
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{"%{FOO}%", "foo"},
+		{"%{FOO}%-%{BAR}%", "foo-bar"},
+		{"echo $(FOO) $$ %{FOO}%", "echo $(FOO) $$ foo"},
+		{"%{UNDEFINED}%", "%{UNDEFINED}%"},
+		{"%%{FOO}%", "%{FOO}%"},
+		{"%%%{FOO}%", "%%{FOO}%"},
+		{"100% %}", "100% %}"},
+		{"%{FOO", "%{FOO"},
+		{"%{FOO}", "%{FOO}"},
+		{"%{}%", "%{}%"},
+		{"%", "%"},
+		{"", ""},
+	} {
+		if got := d.Expand(tt.input, mapping); got != tt.want {
+			t.Errorf("Expand(%q): want: %q; got: %q", tt.input, tt.want, got)
+		}
+	}
+	if got, want := (Delims{}).Expand("$(FOO)", mapping), "$(FOO)"; got != want {
+		t.Errorf("zero Delims: want: %q; got: %q", want, got)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Machine Zone, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package expansion expands variable references in strings, as in the
+// commands and env vars of Kubernetes containers. It's forked from
+// k8s.io/kubernetes/third_party/forked/golang/expansion.
+package expansion
+
+// An Expander expands variable references with a particular syntax.
+type Expander interface {
+	// Expand replaces the variable references in the input string using
+	// the mapping function to resolve the values of variables.
+	Expand(input string, mapping func(string) string) string
+	// MappingFuncFor returns a mapping function for use with Expand that
+	// returns the reference unchanged if no mapping for a variable is found.
+	MappingFuncFor(context ...map[string]string) func(string) string
+}
+
+// Default is the Expander of the $(VAR_NAME) syntax.
+var Default Expander = defaultExpander{}
+
+var (
+	_ Expander = defaultExpander{}
+	_ Expander = Delims{}
+)
+
+type defaultExpander struct{}
+
+func (defaultExpander) Expand(input string, mapping func(string) string) string {
+	return Expand(input, mapping)
+}
+
+func (defaultExpander) MappingFuncFor(context ...map[string]string) func(string) string {
+	return MappingFuncFor(context...)
+}